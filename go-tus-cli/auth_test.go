@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/urfave/cli/v2"
+)
+
+// newTokenServer issues the tokens t1, t2, ... to client "tusc"
+func newTokenServer(t *testing.T, issued *atomic.Int32) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+		if r.FormValue("grant_type") != "client_credentials" || id != "tusc" || secret != "s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+			return
+		}
+		n := issued.Add(1)
+		json.NewEncoder(w).Encode(map[string]any{
+			"access_token": fmt.Sprintf("t%d", n),
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+		})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestOAuth2RefreshReplaysPatch(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+
+	var issued atomic.Int32
+	tokenServer := newTokenServer(t, &issued)
+
+	// The server revokes the first token after the first chunk
+	mockServer := NewStatefulTUSServer()
+	defer mockServer.Close()
+	mockServer.Token = "t1"
+	mockServer.Patched = func(offset int64) { mockServer.Token = "t2" }
+
+	path, data := createRandomFile(t, 2*MinChunkSize)
+	auth := NewOAuth2Auth(tokenServer.URL, "tusc", "s3cret", "upload", nil)
+	config := &Config{
+		Endpoint:   mockServer.URL(),
+		ChunkSize:  MinChunkSize,
+		Retries:    3,
+		Headers:    make(map[string]string),
+		HTTPClient: authHTTPClient(newHTTPClient(1, nil), auth),
+	}
+	if _, err := uploadFile(config, UploadTarget{Path: path}); err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+
+	if n := issued.Load(); n != 2 {
+		t.Errorf("Expected 2 tokens to be issued, got %d", n)
+	}
+	// The rejected PATCH is replayed right away, not retried
+	if patches := mockServer.PatchCount(); patches != 3 {
+		t.Errorf("Expected 3 PATCH requests, got %d", patches)
+	}
+	for _, upload := range mockServer.Uploads() {
+		if !bytes.Equal(upload.Data, data) {
+			t.Error("Uploaded data doesn't match")
+		}
+	}
+}
+
+func TestOAuth2TokenError(t *testing.T) {
+	var issued atomic.Int32
+	tokenServer := newTokenServer(t, &issued)
+
+	auth := NewOAuth2Auth(tokenServer.URL, "tusc", "wrong", "", nil)
+	req, _ := http.NewRequest(http.MethodHead, "http://example.com/files/1", nil)
+	if err := auth.Authorize(req); err == nil || !strings.Contains(err.Error(), "invalid_client") {
+		t.Errorf("Expected the token error to be reported, got %v", err)
+	}
+}
+
+func TestStaticAuthIsNotReplayed(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if user, password, _ := r.BasicAuth(); user != "alice" || password != "pa:ss" {
+			t.Errorf("Unexpected credentials %q, %q", user, password)
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	client := authHTTPClient(&http.Client{}, &BasicAuth{Username: "alice", Password: "pa:ss"})
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized || requests.Load() != 1 {
+		t.Errorf("Expected one rejected request, got %d with %d requests", resp.StatusCode, requests.Load())
+	}
+}
+
+func TestParseAuth(t *testing.T) {
+	tests := []struct {
+		args    []string
+		want    string
+		wantErr bool
+	}{
+		{args: nil, want: "<nil>"},
+		{args: []string{"--bearer-token", "abc"}, want: "*main.BearerAuth"},
+		{args: []string{"--user", "alice:pa:ss"}, want: "*main.BasicAuth"},
+		{args: []string{"--oauth2-token-url", "https://auth.example.com/token", "--oauth2-client-id", "tusc"}, want: "*main.OAuth2Auth"},
+		{args: []string{"--oauth2-token-url", "https://auth.example.com/token"}, wantErr: true},
+		{args: []string{"--bearer-token", "abc", "--user", "alice:secret"}, wantErr: true},
+	}
+
+	for _, test := range tests {
+		app := &cli.App{
+			Flags: []cli.Flag{
+				&cli.StringFlag{Name: "bearer-token"},
+				&cli.StringFlag{Name: "user"},
+				&cli.StringFlag{Name: "oauth2-token-url"},
+				&cli.StringFlag{Name: "oauth2-client-id"},
+				&cli.StringFlag{Name: "oauth2-client-secret"},
+				&cli.StringFlag{Name: "oauth2-scope"},
+			},
+			Action: func(c *cli.Context) error {
+				auth, err := parseAuth(c, nil)
+				if (err != nil) != test.wantErr {
+					t.Errorf("parseAuth(%q) error = %v, wantErr %v", test.args, err, test.wantErr)
+				}
+				if got := fmt.Sprintf("%T", auth); err == nil && got != test.want {
+					t.Errorf("parseAuth(%q) = %s, expected %s", test.args, got, test.want)
+				}
+				if basic, ok := auth.(*BasicAuth); ok && (basic.Username != "alice" || basic.Password != "pa:ss") {
+					t.Errorf("Unexpected basic auth %+v", basic)
+				}
+				return nil
+			},
+		}
+		app.Run(append([]string{"tusc"}, test.args...))
+	}
+}
+
+func TestProfileAuthYieldsToFlags(t *testing.T) {
+	writeConfigFile(t, "profiles:\n  p:\n    auth:\n      bearer_token: from-profile\n")
+
+	app := &cli.App{
+		Flags: []cli.Flag{
+			&cli.StringFlag{Name: "profile"},
+			&cli.StringFlag{Name: "config"},
+			&cli.StringFlag{Name: "bearer-token"},
+			&cli.StringFlag{Name: "user"},
+			&cli.StringFlag{Name: "oauth2-token-url"},
+			&cli.StringFlag{Name: "oauth2-client-id"},
+			&cli.StringFlag{Name: "oauth2-client-secret"},
+			&cli.StringFlag{Name: "oauth2-scope"},
+		},
+		Action: func(c *cli.Context) error {
+			profile, err := selectProfile(c)
+			if err != nil {
+				return err
+			}
+			if err := applyProfile(c, profile); err != nil {
+				return err
+			}
+			auth, err := parseAuth(c, nil)
+			if err != nil {
+				return err
+			}
+			if _, ok := auth.(*BasicAuth); !ok {
+				t.Errorf("Expected the basic auth of the flag, got %T", auth)
+			}
+			return nil
+		},
+	}
+	if err := app.Run([]string{"tusc", "--profile", "p", "--user", "alice:secret"}); err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+}
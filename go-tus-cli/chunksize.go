@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+// TargetPatchDuration is how long an adaptive PATCH should take: long enough
+// that the round trip doesn't matter, short enough that a failure loses little
+const TargetPatchDuration = 3 * time.Second
+
+// errChunkTooLarge marks a PATCH rejected with 413 Request Entity Too Large,
+// usually by a proxy with a body size limit
+var errChunkTooLarge = errors.New("chunk rejected with 413 Request Entity Too Large")
+
+// ChunkSizer picks the size of the next PATCH of an upload. A fixed sizer
+// keeps --chunk-size, an adaptive one aims for PATCHes of TargetPatchDuration
+// at the measured throughput and halves the size after a failure. Both back
+// off on 413 and never again exceed a size that was rejected.
+type ChunkSizer struct {
+	size     int64
+	ceiling  int64 // largest size not known to be rejected
+	adaptive bool
+}
+
+func NewChunkSizer(config *Config) *ChunkSizer {
+	size := config.ChunkSize
+	if size <= 0 {
+		size = DefaultChunkSize
+	}
+
+	return &ChunkSizer{
+		size:     size,
+		ceiling:  max(size, MaxChunkSize),
+		adaptive: config.AdaptiveChunks,
+	}
+}
+
+// Size returns the size of the next PATCH
+func (s *ChunkSizer) Size() int64 {
+	return s.size
+}
+
+// Success records a PATCH of n bytes that was acknowledged after elapsed
+func (s *ChunkSizer) Success(n int64, elapsed time.Duration) {
+	// A short last chunk says more about latency than throughput
+	if !s.adaptive || n < s.size || elapsed <= 0 {
+		return
+	}
+
+	throughput := float64(n) / elapsed.Seconds()
+	target := int64(throughput * TargetPatchDuration.Seconds())
+
+	// Move at most by a factor of two per PATCH so one outlier can't swing it
+	s.resize(min(max(target, s.size/2), s.size*2))
+}
+
+// Failure records a failed PATCH and reports whether the chunk got smaller
+func (s *ChunkSizer) Failure(tooLarge bool) bool {
+	previous := s.size
+	switch {
+	case tooLarge:
+		s.ceiling = max(s.size/2, MinChunkSize)
+		s.resize(s.ceiling)
+	case s.adaptive:
+		s.resize(s.size / 2)
+	}
+	return s.size < previous
+}
+
+// resize sets the size, clamped to MinChunkSize and the ceiling and rounded
+// down to a multiple of MinChunkSize
+func (s *ChunkSizer) resize(size int64) {
+	size = min(max(size, MinChunkSize), s.ceiling)
+	s.size = size / MinChunkSize * MinChunkSize
+}
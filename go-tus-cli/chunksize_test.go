@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestChunkSizerAdaptive(t *testing.T) {
+	sizer := NewChunkSizer(&Config{ChunkSize: DefaultChunkSize, AdaptiveChunks: true})
+
+	// A fast link grows the chunk, but at most twice per PATCH
+	sizer.Success(DefaultChunkSize, 10*time.Millisecond)
+	if sizer.Size() != 2*DefaultChunkSize {
+		t.Errorf("Expected the chunk to double, got %s", formatBytes(sizer.Size()))
+	}
+	for i := 0; i < 10; i++ {
+		sizer.Success(sizer.Size(), 10*time.Millisecond)
+	}
+	if sizer.Size() != MaxChunkSize {
+		t.Errorf("Expected the chunk to stop at the maximum, got %s", formatBytes(sizer.Size()))
+	}
+
+	// 8MB/s aims for 3s worth of data
+	sizer.Success(MaxChunkSize, 4*time.Second)
+	if sizer.Size() != 24*1024*1024 {
+		t.Errorf("Expected 24MB chunks at 8MB/s, got %s", formatBytes(sizer.Size()))
+	}
+
+	// A short last chunk doesn't count
+	sizer.Success(1024, time.Second)
+	if sizer.Size() != 24*1024*1024 {
+		t.Errorf("A short chunk must not change the size, got %s", formatBytes(sizer.Size()))
+	}
+
+	if !sizer.Failure(false) || sizer.Size() != 12*1024*1024 {
+		t.Errorf("Expected a failure to halve the chunk, got %s", formatBytes(sizer.Size()))
+	}
+
+	// A slow link never goes below the minimum
+	for i := 0; i < 20; i++ {
+		sizer.Success(sizer.Size(), time.Minute)
+	}
+	if sizer.Size() != MinChunkSize {
+		t.Errorf("Expected the chunk to stop at the minimum, got %s", formatBytes(sizer.Size()))
+	}
+	if sizer.Failure(false) {
+		t.Error("A chunk at the minimum cannot shrink")
+	}
+}
+
+func TestChunkSizerTooLarge(t *testing.T) {
+	sizer := NewChunkSizer(&Config{ChunkSize: 8 * 1024 * 1024, AdaptiveChunks: true})
+
+	if !sizer.Failure(true) || sizer.Size() != 4*1024*1024 {
+		t.Fatalf("Expected a 413 to halve the chunk, got %s", formatBytes(sizer.Size()))
+	}
+
+	// The rejected size is never tried again, however fast the link
+	for i := 0; i < 5; i++ {
+		sizer.Success(sizer.Size(), time.Millisecond)
+	}
+	if sizer.Size() != 4*1024*1024 {
+		t.Errorf("Expected the chunk to stay below the rejected size, got %s", formatBytes(sizer.Size()))
+	}
+}
+
+func TestChunkSizerFixed(t *testing.T) {
+	sizer := NewChunkSizer(&Config{ChunkSize: DefaultChunkSize})
+
+	sizer.Success(DefaultChunkSize, time.Millisecond)
+	if sizer.Failure(false) || sizer.Size() != DefaultChunkSize {
+		t.Errorf("A fixed chunk size must not adapt, got %s", formatBytes(sizer.Size()))
+	}
+
+	// A 413 can't be worked around otherwise, so a fixed size backs off too
+	if !sizer.Failure(true) || sizer.Size() != DefaultChunkSize/2 {
+		t.Errorf("Expected a 413 to halve a fixed chunk, got %s", formatBytes(sizer.Size()))
+	}
+}
+
+func TestUploadUsesChunkSize(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+
+	mockServer := NewStatefulTUSServer()
+	defer mockServer.Close()
+
+	path := filepath.Join(t.TempDir(), "chunked.bin")
+	data := make([]byte, 3*MinChunkSize+100)
+	rand.Read(data)
+	os.WriteFile(path, data, 0644)
+
+	config := &Config{Endpoint: mockServer.URL(), ChunkSize: MinChunkSize, Headers: make(map[string]string)}
+	if _, err := uploadFile(config, UploadTarget{Path: path}); err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+
+	sizes := mockServer.AcceptedPatchSizes()
+	expected := []int64{MinChunkSize, MinChunkSize, MinChunkSize, 100}
+	if len(sizes) != len(expected) {
+		t.Fatalf("Expected PATCHes of %v, got %v", expected, sizes)
+	}
+	for i := range expected {
+		if sizes[i] != expected[i] {
+			t.Errorf("Expected PATCHes of %v, got %v", expected, sizes)
+			break
+		}
+	}
+}
+
+func TestUploadBacksOffOn413(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+
+	mockServer := NewStatefulTUSServer()
+	mockServer.MaxPatchSize = 300 * 1024
+	defer mockServer.Close()
+
+	path := filepath.Join(t.TempDir(), "proxied.bin")
+	data := make([]byte, 1536*1024)
+	rand.Read(data)
+	os.WriteFile(path, data, 0644)
+
+	// No retries are needed, a 413 is answered with a smaller chunk right away
+	config := &Config{Endpoint: mockServer.URL(), ChunkSize: 1024 * 1024, Headers: make(map[string]string), AdaptiveChunks: true}
+	start := time.Now()
+	if _, err := uploadFile(config, UploadTarget{Path: path}); err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Backing off from 413 must not wait, took %v", elapsed)
+	}
+
+	for _, size := range mockServer.AcceptedPatchSizes() {
+		if size > mockServer.MaxPatchSize {
+			t.Errorf("PATCH of %d bytes exceeds the proxy limit", size)
+		}
+	}
+	for _, upload := range mockServer.Uploads() {
+		if !bytes.Equal(upload.Data, data) {
+			t.Error("Uploaded data doesn't match")
+		}
+	}
+}
+
+func TestUploadStreamBacksOffOn413(t *testing.T) {
+	mockServer := NewStatefulTUSServer()
+	mockServer.MaxPatchSize = 100 * 1024
+	defer mockServer.Close()
+
+	data := make([]byte, 512*1024)
+	rand.Read(data)
+
+	config := &Config{Endpoint: mockServer.URL(), ChunkSize: 256 * 1024, Headers: make(map[string]string)}
+	if _, err := uploadStream(config, bytes.NewReader(data), "proxied.bin"); err != nil {
+		t.Fatalf("Stream upload failed: %v", err)
+	}
+
+	for _, upload := range mockServer.Uploads() {
+		if upload.Size != int64(len(data)) || !bytes.Equal(upload.Data, data) {
+			t.Errorf("Expected %d bytes with a known length, got %d of %d", len(data), len(upload.Data), upload.Size)
+		}
+	}
+
+	// The last chunk size that was rejected is never tried again
+	sizes := mockServer.AcceptedPatchSizes()
+	for _, size := range sizes {
+		if size > 64*1024 {
+			t.Errorf("Expected 64KB PATCHes after backing off, got %v", sizes)
+			break
+		}
+	}
+}
+
+func TestUploadStreamAdaptiveStartsFromChunkSize(t *testing.T) {
+	mockServer := NewStatefulTUSServer()
+	defer mockServer.Close()
+
+	data := make([]byte, 4*MinChunkSize)
+	rand.Read(data)
+
+	config := &Config{Endpoint: mockServer.URL(), ChunkSize: MinChunkSize, Headers: make(map[string]string), AdaptiveChunks: true}
+	if _, err := uploadStream(config, bytes.NewReader(data), "adaptive.bin"); err != nil {
+		t.Fatalf("Stream upload failed: %v", err)
+	}
+
+	// The first PATCH has the requested size, later ones at most double
+	sizes := mockServer.AcceptedPatchSizes()
+	if len(sizes) < 2 || sizes[0] != MinChunkSize {
+		t.Fatalf("Expected the first PATCH to have %d bytes, got %v", MinChunkSize, sizes)
+	}
+	for i := 1; i < len(sizes); i++ {
+		if sizes[i] > 2*sizes[i-1] {
+			t.Errorf("PATCH %d grew from %d to %d bytes", i+1, sizes[i-1], sizes[i])
+		}
+	}
+	for _, upload := range mockServer.Uploads() {
+		if !bytes.Equal(upload.Data, data) {
+			t.Error("Uploaded data doesn't match")
+		}
+	}
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/bdragon300/tusgo"
+)
+
+const (
+	DefaultParallel = 1  // Parallel single-file uploads are disabled by default
+	MaxParallel     = 16 // Maximum number of partial uploads per file
+)
+
+// PartState is one partial upload of a file that is uploaded in parallel
+type PartState struct {
+	Offset    int64      `json:"offset"` // Position of the part in the local file
+	Size      int64      `json:"size"`
+	UploadURL string     `json:"upload_url"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// UploadOffset is the last offset the server acknowledged for this part
+	UploadOffset int64 `json:"upload_offset,omitempty"`
+}
+
+// serverSupports reports whether the server advertises a tus extension
+func serverSupports(tusClient *tusgo.Client, extension string) bool {
+	if tusClient.Capabilities == nil {
+		if _, err := tusClient.UpdateCapabilities(); err != nil {
+			return false
+		}
+	}
+
+	for _, e := range tusClient.Capabilities.Extensions {
+		if e == extension {
+			return true
+		}
+	}
+	return false
+}
+
+// splitParts divides size bytes into at most n parts of at least MinChunkSize
+// bytes each. The last part takes the remainder.
+func splitParts(size int64, n int) []PartState {
+	if maxParts := int(size / MinChunkSize); n > maxParts {
+		n = maxParts
+	}
+	if n < 1 {
+		n = 1
+	}
+
+	partSize := size / int64(n)
+	parts := make([]PartState, n)
+	for i := range parts {
+		parts[i].Offset = int64(i) * partSize
+		parts[i].Size = partSize
+	}
+	parts[n-1].Size = size - parts[n-1].Offset
+
+	return parts
+}
+
+// parallelUploadState decides whether a file takes the concatenation path.
+// A valid existing state is always resumed the way it was started, so an
+// upload begun with --parallel keeps its parts even without the flag.
+func parallelUploadState(config *Config, tusClient *tusgo.Client, existingState *UploadState, fileID, filePath string, fileInfo os.FileInfo) (*UploadState, bool) {
+	if existingState != nil {
+		return existingState, len(existingState.Parts) > 0
+	}
+
+	if config.Parallel < 2 {
+		return nil, false
+	}
+
+	parts := splitParts(fileInfo.Size(), config.Parallel)
+	if len(parts) < 2 {
+		if config.Verbose {
+			fmt.Fprintf(config.output(), "File too small to split, uploading in a single stream\n")
+		}
+		return nil, false
+	}
+
+	if !serverSupports(tusClient, "concatenation") {
+		fmt.Fprintf(config.output(), "Warning: server does not support the concatenation extension, uploading in a single stream\n")
+		return nil, false
+	}
+
+	return &UploadState{
+		FileID:      fileID,
+		FilePath:    absPath(filePath),
+		FileSize:    fileInfo.Size(),
+		FileModTime: fileInfo.ModTime(),
+		Endpoint:    config.Endpoint,
+		CreatedAt:   time.Now(),
+		Parts:       parts,
+	}, true
+}
+
+// createPartialUpload creates the server side upload for a part, with the
+// metadata of the file like a single upload
+func createPartialUpload(tusClient *tusgo.Client, part *PartState, metadata map[string]string) error {
+	var upload tusgo.Upload
+	if resp, err := tusClient.CreateUpload(&upload, part.Size, true, metadata); err != nil {
+		return fmt.Errorf("failed to create partial upload: %w", wrapResponseError(PhaseCreate, resp, err))
+	}
+
+	part.UploadURL = upload.Location
+	part.ExpiresAt = upload.UploadExpired
+	return nil
+}
+
+// uploadFileParallel uploads a file as several partial uploads at once and
+// concatenates them into the final upload. Part URLs are kept in the state
+// file, so every part resumes on its own after an interruption.
+func uploadFileParallel(config *Config, tusClient *tusgo.Client, file *os.File, state *UploadState) (string, error) {
+	name := filepath.Base(state.FilePath)
+
+	// The final upload already exists, only the state cleanup was missed
+	if state.UploadURL != "" {
+		return state.UploadURL, nil
+	}
+
+	// Also loads the capabilities before the parts share the client
+	if !serverSupports(tusClient, "concatenation") {
+		return "", fmt.Errorf("server does not support the concatenation extension")
+	}
+
+	var mu sync.Mutex
+	saveState := func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if err := saveUploadState(state); err != nil && config.Verbose {
+			fmt.Fprintf(config.output(), "Warning: failed to save upload state: %v\n", err)
+		}
+	}
+
+	for i := range state.Parts {
+		if state.Parts[i].UploadURL != "" {
+			continue
+		}
+		part := &state.Parts[i]
+		if err := withRetry(config, func() error { return createPartialUpload(tusClient, part, state.Metadata) }); err != nil {
+			return "", err
+		}
+		config.emit(Event{Event: "created", Part: i + 1, UploadURL: part.UploadURL, Size: part.Size})
+		if config.Verbose {
+			fmt.Fprintf(config.output(), "Part %d/%d created: %s\n", i+1, len(state.Parts), state.Parts[i].UploadURL)
+		}
+	}
+	saveState()
+
+	// Parts report through an aggregate status line, or through the one
+	// already running when this file is part of a --jobs batch
+	partConfig := *config
+	var stop chan struct{}
+	var done sync.WaitGroup
+	if config.progress == nil {
+		partConfig.progress = NewAggregateProgress(len(state.Parts), state.FileSize)
+		partConfig.progress.unit = "parts"
+		partConfig.progress.out = config.output()
+		if config.events != nil {
+			partConfig.progress.emit = config.emit
+		}
+		stop = make(chan struct{})
+		done.Add(1)
+		go func() {
+			defer done.Done()
+			partConfig.progress.Run(stop)
+		}()
+
+		fmt.Fprintf(config.output(), "Uploading %s in %d parallel parts...\n", name, len(state.Parts))
+	}
+
+	start := time.Now()
+	errs := make([]error, len(state.Parts))
+	var wg sync.WaitGroup
+	for i := range state.Parts {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			// Events of the part name it
+			partConfig := partConfig
+			partConfig.part = i + 1
+
+			errs[i] = uploadPart(&partConfig, tusClient, file, state, &state.Parts[i], &mu, saveState)
+			if stop != nil {
+				partConfig.progress.FileDone(UploadResult{
+					Target: UploadTarget{Path: fmt.Sprintf("%s [part %d/%d]", name, i+1, len(state.Parts))},
+					Size:   state.Parts[i].Size,
+					Err:    errs[i],
+				})
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if stop != nil {
+		close(stop)
+		done.Wait()
+	}
+
+	if slices.ContainsFunc(errs, func(err error) bool { return errors.Is(err, errInterrupted) }) {
+		checkpointUpload(config, tusClient, state)
+		return "", errInterrupted
+	}
+
+	for i, err := range errs {
+		if err != nil {
+			return "", fmt.Errorf("part %d/%d failed: %v", i+1, len(state.Parts), err)
+		}
+	}
+
+	partials := make([]tusgo.Upload, len(state.Parts))
+	for i, part := range state.Parts {
+		partials[i] = tusgo.Upload{Location: part.UploadURL, RemoteSize: part.Size, Partial: true}
+	}
+
+	// Losing the concatenation to a transient error would waste every part
+	var final tusgo.Upload
+	err := withRetry(config, func() error {
+		resp, err := tusClient.ConcatenateUploads(&final, partials, state.Metadata)
+		return wrapResponseError(PhaseCreate, resp, err)
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to concatenate partial uploads: %w", err)
+	}
+
+	state.UploadURL = final.Location
+	saveState()
+
+	if config.progress == nil {
+		fmt.Fprintf(config.output(), "✓ Upload completed: %s (%s) in %v\n",
+			name,
+			formatBytes(state.FileSize),
+			time.Since(start).Round(time.Second))
+	}
+
+	return final.Location, nil
+}
+
+// uploadPart uploads one part through the regular retry logic, recreating the
+// partial upload if the server no longer knows it, before or during the
+// transfer
+func uploadPart(config *Config, tusClient *tusgo.Client, file *os.File, state *UploadState, part *PartState, mu *sync.Mutex, saveState func()) error {
+	mu.Lock()
+	upload := tusgo.Upload{Location: part.UploadURL, RemoteSize: part.Size, Partial: true}
+	mu.Unlock()
+
+	// recreate replaces the lost partial upload with a new one, the part
+	// starts over
+	recreate := func() (*tusgo.UploadStream, error) {
+		if config.Verbose {
+			fmt.Fprintf(config.output(), "Partial upload %s is gone, recreating it\n", part.UploadURL)
+		}
+
+		mu.Lock()
+		err := createPartialUpload(tusClient, part, state.Metadata)
+		part.UploadOffset = 0
+		upload = tusgo.Upload{Location: part.UploadURL, RemoteSize: part.Size, Partial: true}
+		mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+		saveState()
+
+		return tusgo.NewUploadStream(tusClient, &upload), nil
+	}
+
+	stream := tusgo.NewUploadStream(tusClient, &upload)
+	if _, err := stream.Sync(); errors.Is(err, tusgo.ErrUploadDoesNotExist) {
+		if stream, err = recreate(); err != nil {
+			return err
+		}
+	}
+
+	journaled := 0
+	onPatch := func() {
+		mu.Lock()
+		part.UploadOffset = upload.RemoteOffset
+		if err := appendJournal(state.FileID, upload.Location, upload.RemoteOffset); err != nil && config.Verbose {
+			fmt.Fprintf(config.output(), "Warning: failed to journal offset: %v\n", err)
+		}
+		journaled++
+		changed := refreshExpiry(&part.ExpiresAt, upload.UploadExpired) || journaled%JournalCompactInterval == 0
+		mu.Unlock()
+		if changed {
+			saveState()
+		}
+	}
+
+	section := io.NewSectionReader(file, part.Offset, part.Size)
+	err := uploadReaderWithRetry(stream, section, part.Size, file.Name(), config, onPatch)
+	if err == nil || retryAction(err) != Recreate {
+		return err
+	}
+
+	// Lost during the transfer, the other parts don't have to start over
+	if stream, err = recreate(); err != nil {
+		return err
+	}
+	return uploadReaderWithRetry(stream, section, part.Size, file.Name(), config, onPatch)
+}
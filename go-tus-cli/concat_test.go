@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bdragon300/tusgo"
+)
+
+func TestSplitParts(t *testing.T) {
+	tests := []struct {
+		size     int64
+		n        int
+		expected int
+	}{
+		{10 * MinChunkSize, 4, 4},
+		{10*MinChunkSize + 7, 3, 3},
+		{3 * MinChunkSize, 8, 3},
+		{MinChunkSize - 1, 4, 1},
+	}
+
+	for _, test := range tests {
+		parts := splitParts(test.size, test.n)
+		if len(parts) != test.expected {
+			t.Errorf("splitParts(%d, %d) returned %d parts, expected %d", test.size, test.n, len(parts), test.expected)
+			continue
+		}
+
+		var next int64
+		for i, part := range parts {
+			if part.Offset != next {
+				t.Errorf("splitParts(%d, %d): part %d starts at %d, expected %d", test.size, test.n, i, part.Offset, next)
+			}
+			next += part.Size
+		}
+		if next != test.size {
+			t.Errorf("splitParts(%d, %d): parts cover %d bytes, expected %d", test.size, test.n, next, test.size)
+		}
+	}
+}
+
+// createRandomFile writes size random bytes to a temporary file
+func createRandomFile(t *testing.T, size int) (string, []byte) {
+	data := make([]byte, size)
+	rand.Read(data)
+
+	path := filepath.Join(t.TempDir(), "parallel.bin")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	return path, data
+}
+
+func TestUploadFileParallel(t *testing.T) {
+	mockServer := NewStatefulTUSServer()
+	defer mockServer.Close()
+	mockServer.FailConcats = 1 // Retried instead of wasting the parts
+
+	path, data := createRandomFile(t, 5*MinChunkSize+123)
+
+	wd, _ := os.Getwd()
+	os.Chdir(t.TempDir())
+	defer os.Chdir(wd)
+
+	config := &Config{
+		Endpoint:  mockServer.URL(),
+		ChunkSize: DefaultChunkSize,
+		Headers:   make(map[string]string),
+		Parallel:  4,
+		Retries:   3,
+		Retry:     &RetryPolicy{MaxDelay: 10 * time.Millisecond},
+	}
+
+	uploadURL, err := uploadFile(config, UploadTarget{Path: path})
+	if err != nil {
+		t.Fatalf("Parallel upload failed: %v", err)
+	}
+
+	partials, finals := 0, 0
+	var final *StatefulUpload
+	for id, upload := range mockServer.Uploads() {
+		switch {
+		case upload.Partial:
+			partials++
+			if upload.Metadata["filename"] != "parallel.bin" {
+				t.Errorf("Expected filename metadata on partial upload, got %v", upload.Metadata)
+			}
+		case upload.Final:
+			finals++
+			final = upload
+			if uploadURL != mockServer.URL()+"/"+id {
+				t.Errorf("Expected final upload URL, got %s", uploadURL)
+			}
+		}
+	}
+
+	if partials != 4 || finals != 1 {
+		t.Fatalf("Expected 4 partial and 1 final upload, got %d and %d", partials, finals)
+	}
+	if !bytes.Equal(final.Data, data) {
+		t.Error("Concatenated data doesn't match original content")
+	}
+	if final.Metadata["filename"] != "parallel.bin" {
+		t.Errorf("Expected filename metadata on final upload, got %v", final.Metadata)
+	}
+
+	matches, _ := filepath.Glob(getStateFilePath("*"))
+	if len(matches) != 0 {
+		t.Errorf("State file should have been removed, found %v", matches)
+	}
+}
+
+func TestUploadFileParallelResume(t *testing.T) {
+	mockServer := NewStatefulTUSServer()
+	defer mockServer.Close()
+
+	path, data := createRandomFile(t, 4*MinChunkSize)
+	fileInfo, _ := os.Stat(path)
+
+	wd, _ := os.Getwd()
+	os.Chdir(t.TempDir())
+	defer os.Chdir(wd)
+
+	baseURL, _ := url.Parse(mockServer.URL())
+	tusClient := tusgo.NewClient(nil, baseURL)
+
+	// Simulate an interrupted run: two parts exist, the first one is finished
+	parts := splitParts(fileInfo.Size(), 2)
+	for i := range parts {
+		if err := createPartialUpload(tusClient, &parts[i], nil); err != nil {
+			t.Fatalf("Failed to create partial upload: %v", err)
+		}
+	}
+	upload := tusgo.Upload{Location: parts[0].UploadURL, RemoteSize: parts[0].Size, Partial: true}
+	if _, err := tusgo.NewUploadStream(tusClient, &upload).Write(data[:parts[0].Size]); err != nil {
+		t.Fatalf("Failed to upload first part: %v", err)
+	}
+
+	fileID := generateFileID(path, fileInfo)
+	err := saveUploadState(&UploadState{
+		FileID:      fileID,
+		FilePath:    path,
+		FileSize:    fileInfo.Size(),
+		FileModTime: fileInfo.ModTime(),
+		Endpoint:    mockServer.URL(),
+		Parts:       parts,
+	})
+	if err != nil {
+		t.Fatalf("Failed to save state: %v", err)
+	}
+
+	// Resuming must reuse the parts even though --parallel is not given
+	config := &Config{
+		Endpoint:  mockServer.URL(),
+		ChunkSize: DefaultChunkSize,
+		Headers:   make(map[string]string),
+	}
+	if _, err := uploadFile(config, UploadTarget{Path: path}); err != nil {
+		t.Fatalf("Resumed parallel upload failed: %v", err)
+	}
+
+	uploads := mockServer.Uploads()
+	if len(uploads) != 3 {
+		t.Fatalf("Expected 2 partial and 1 final upload, got %d uploads", len(uploads))
+	}
+	for _, upload := range uploads {
+		if upload.Final && !bytes.Equal(upload.Data, data) {
+			t.Error("Concatenated data doesn't match original content after resume")
+		}
+	}
+}
+
+func TestUploadFileParallelRecreatesLostPart(t *testing.T) {
+	mockServer := NewStatefulTUSServer()
+	defer mockServer.Close()
+
+	path, data := createRandomFile(t, 4*MinChunkSize)
+
+	wd, _ := os.Getwd()
+	os.Chdir(t.TempDir())
+	defer os.Chdir(wd)
+
+	// The server loses the first partial upload that got data, before its
+	// second PATCH, which is answered with 404
+	var mu sync.Mutex
+	var lost string
+	mockServer.Verify = func(r *http.Request) error {
+		if r.Method != http.MethodPatch {
+			return nil
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		id := strings.TrimPrefix(r.URL.Path, "/files/")
+		if lost == "" && r.Header.Get("Upload-Offset") != "0" {
+			lost = id
+			mockServer.mu.Lock()
+			delete(mockServer.uploads, id)
+			mockServer.mu.Unlock()
+		}
+		return nil
+	}
+
+	config := &Config{
+		Endpoint:  mockServer.URL(),
+		ChunkSize: MinChunkSize,
+		Headers:   make(map[string]string),
+		Parallel:  2,
+		Retry:     &RetryPolicy{MaxDelay: 10 * time.Millisecond},
+	}
+	if _, err := uploadFile(config, UploadTarget{Path: path}); err != nil {
+		t.Fatalf("Parallel upload with a lost part failed: %v", err)
+	}
+	if lost == "" {
+		t.Fatal("Expected a partial upload to be lost during the transfer")
+	}
+
+	partials, finals := 0, 0
+	for _, upload := range mockServer.Uploads() {
+		switch {
+		case upload.Partial:
+			partials++
+		case upload.Final:
+			finals++
+			if !bytes.Equal(upload.Data, data) {
+				t.Error("Concatenated data doesn't match original content")
+			}
+		}
+	}
+	if partials != 2 || finals != 1 {
+		t.Errorf("Expected 2 partial uploads, one of them recreated, and 1 final upload, got %d and %d", partials, finals)
+	}
+}
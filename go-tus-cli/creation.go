@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/bdragon300/tusgo"
+)
+
+// createUpload creates the upload on the server. If the server supports
+// creation-with-upload, the first chunk (or the whole file if it fits) is sent
+// in the creation request, and upload.RemoteOffset tells how much of it the
+// server stored.
+func createUpload(config *Config, tusClient *tusgo.Client, file *os.File, upload *tusgo.Upload, size int64, metadata map[string]string) error {
+	if size == 0 || !serverSupports(tusClient, "creation-with-upload") {
+		resp, err := tusClient.CreateUpload(upload, size, false, metadata)
+		return wrapResponseError(PhaseCreate, resp, err)
+	}
+
+	chunkSize := config.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+	if size < chunkSize {
+		chunkSize = size
+	}
+
+	data := make([]byte, chunkSize)
+	if _, err := file.ReadAt(data, 0); err != nil {
+		return fmt.Errorf("failed to read file: %v", err)
+	}
+
+	_, resp, err := tusClient.CreateUploadWithData(upload, data, size, false, metadata)
+	return wrapResponseError(PhaseCreate, resp, err)
+}
+
+// reportCreatedWithUpload reports a file that was uploaded completely by the
+// creation request
+func reportCreatedWithUpload(config *Config, name string, size int64, start time.Time) {
+	// The aggregate status line reports completed files itself
+	if config.progress != nil {
+		config.progress.Sent(size)
+		config.progress.Acknowledge(config.progressKey(), size)
+		return
+	}
+
+	fmt.Fprintf(config.output(), "✓ Upload completed: %s (%s) in %v\n",
+		filepath.Base(name),
+		formatBytes(size),
+		time.Since(start).Round(time.Second))
+}
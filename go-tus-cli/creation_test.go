@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCreationWithUploadSmallFile(t *testing.T) {
+	mockServer := NewStatefulTUSServer()
+	defer mockServer.Close()
+	mockServer.CreationWithUpload = true
+
+	path := filepath.Join(createTestTree(t, map[string]string{"small.txt": "sent with the creation request"}), "small.txt")
+
+	wd, _ := os.Getwd()
+	os.Chdir(t.TempDir())
+	defer os.Chdir(wd)
+
+	config := &Config{
+		Endpoint:  mockServer.URL(),
+		ChunkSize: DefaultChunkSize,
+		Headers:   make(map[string]string),
+	}
+
+	uploadURL, err := uploadFile(config, UploadTarget{Path: path})
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+
+	if n := mockServer.PatchCount(); n != 0 {
+		t.Errorf("Expected no PATCH requests, got %d", n)
+	}
+
+	for id, upload := range mockServer.Uploads() {
+		if mockServer.URL()+"/"+id != uploadURL {
+			t.Errorf("Unexpected upload %s", id)
+		}
+		if string(upload.Data) != "sent with the creation request" || upload.Offset != upload.Size {
+			t.Errorf("Unexpected upload content: %q (offset %d)", upload.Data, upload.Offset)
+		}
+		if upload.Metadata["filename"] != "small.txt" {
+			t.Errorf("Expected filename metadata, got %v", upload.Metadata)
+		}
+	}
+}
+
+func TestCreationWithUploadFirstChunk(t *testing.T) {
+	mockServer := NewStatefulTUSServer()
+	defer mockServer.Close()
+	mockServer.CreationWithUpload = true
+
+	path, data := createRandomFile(t, 3*MinChunkSize+17)
+
+	wd, _ := os.Getwd()
+	os.Chdir(t.TempDir())
+	defer os.Chdir(wd)
+
+	config := &Config{
+		Endpoint:  mockServer.URL(),
+		ChunkSize: MinChunkSize,
+		Headers:   make(map[string]string),
+	}
+
+	if _, err := uploadFile(config, UploadTarget{Path: path}); err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+
+	uploads := mockServer.Uploads()
+	if len(uploads) != 1 {
+		t.Fatalf("Expected 1 upload, got %d", len(uploads))
+	}
+	for _, upload := range uploads {
+		if !bytes.Equal(upload.Data, data) {
+			t.Error("Uploaded data doesn't match original content")
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/urfave/cli/v2"
+)
+
+const testNetrc = `# uploads
+machine tus.example.com login alice password s3cret
+macdef init
+machine tus.example.com login mallory password macro
+
+machine localhost:8080
+  login bob
+  password hunter2
+default login anonymous password guest
+`
+
+func TestNetrcCredentials(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".netrc")
+	os.WriteFile(path, []byte(testNetrc), 0600)
+
+	tests := []struct {
+		endpoint string
+		username string
+		password string
+	}{
+		{"https://tus.example.com/files/", "alice", "s3cret"},
+		{"https://TUS.example.com:8443/files/", "alice", "s3cret"},
+		{"http://localhost:8080/files/", "bob", "hunter2"},
+		{"http://other.example.com/files/", "anonymous", "guest"},
+	}
+
+	for _, test := range tests {
+		u, _ := url.Parse(test.endpoint)
+		username, password, err := netrcCredentials(path, u)
+		if err != nil || username != test.username || password != test.password {
+			t.Errorf("netrcCredentials(%s) = %q, %q, %v, expected %q, %q", test.endpoint, username, password, err, test.username, test.password)
+		}
+	}
+
+	if _, _, err := netrcCredentials(filepath.Join(t.TempDir(), "missing"), &url.URL{Host: "x"}); err == nil {
+		t.Error("Expected a missing netrc file to be reported")
+	}
+}
+
+func TestHelperCredentials(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the test helper is a shell script")
+	}
+
+	// The helper answers with the host it was asked for as password
+	helper := filepath.Join(t.TempDir(), "helper")
+	script := "#!/bin/sh\n[ \"$1\" = get ] || exit 1\nread protocol\nread host\necho username=alice\necho \"password=$protocol,$host\"\necho\necho password=ignored\n"
+	os.WriteFile(helper, []byte(script), 0700)
+
+	u, _ := url.Parse("https://tus.example.com:8443/files/")
+	username, password, err := helperCredentials(helper+" get", u)
+	if err != nil || username != "alice" || password != "protocol=https,host=tus.example.com:8443" {
+		t.Errorf("Unexpected helper credentials %q, %q, %v", username, password, err)
+	}
+
+	if _, _, err := helperCredentials(helper+" erase", u); err == nil {
+		t.Error("Expected a failing helper to be reported")
+	}
+}
+
+func TestCredentialAuthOnlyForEndpointHost(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".netrc")
+	os.WriteFile(path, []byte("machine 127.0.0.1 login alice password s3cret\n"), 0600)
+
+	var authorized []bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _, ok := r.BasicAuth()
+		authorized = append(authorized, ok)
+	}))
+	defer server.Close()
+
+	auth, err := credentialAuth(server.URL+"/files/", "", path)
+	if err != nil || auth == nil {
+		t.Fatalf("Expected credentials for the endpoint, got %v", err)
+	}
+	client := authHTTPClient(&http.Client{}, auth)
+
+	// localhost is the same server under another host name
+	other, _ := url.Parse(server.URL)
+	other.Host = "localhost:" + other.Port()
+	for _, target := range []string{server.URL + "/files/1", other.String() + "/files/1"} {
+		resp, err := client.Get(target)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+	}
+	if len(authorized) != 2 || !authorized[0] || authorized[1] {
+		t.Errorf("Expected credentials only for the endpoint host, got %v", authorized)
+	}
+
+	if auth, err := credentialAuth(server.URL, "", ""); auth != nil || err != nil {
+		t.Errorf("Expected no credentials without helper or netrc, got %v, %v", auth, err)
+	}
+}
+
+func TestNetrcYieldsToAuthorizationHeader(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+
+	mockServer := NewStatefulTUSServer()
+	defer mockServer.Close()
+	var authorization []string
+	mockServer.Verify = func(r *http.Request) error {
+		if r.Method == http.MethodPatch {
+			authorization = append(authorization, r.Header.Get("Authorization"))
+		}
+		return nil
+	}
+
+	netrcFile := filepath.Join(t.TempDir(), ".netrc")
+	os.WriteFile(netrcFile, []byte("machine 127.0.0.1 login alice password s3cret\n"), 0600)
+
+	upload := func(args ...string) {
+		app := &cli.App{
+			Flags: []cli.Flag{
+				&cli.StringFlag{Name: "endpoint"},
+				&cli.StringFlag{Name: "netrc-file"},
+				&cli.StringSliceFlag{Name: "header", Aliases: []string{"H"}},
+			},
+			Action: func(c *cli.Context) error {
+				config, err := parseConfig(c)
+				if err != nil {
+					return err
+				}
+				path, _ := createRandomFile(t, MinChunkSize)
+				_, err = uploadFile(config, UploadTarget{Path: path})
+				return err
+			},
+		}
+		if err := app.Run(append([]string{"tusc", "--endpoint", mockServer.URL(), "--netrc-file", netrcFile}, args...)); err != nil {
+			t.Fatalf("Upload failed: %v", err)
+		}
+	}
+
+	upload("-H", "Authorization: Bearer from-header")
+	upload()
+	basic := "Basic " + base64.StdEncoding.EncodeToString([]byte("alice:s3cret"))
+	if len(authorization) != 2 || authorization[0] != "Bearer from-header" || authorization[1] != basic {
+		t.Errorf("Expected the header, then the netrc credentials, got %q", authorization)
+	}
+}
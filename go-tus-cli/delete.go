@@ -0,0 +1,159 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/bdragon300/tusgo"
+	"github.com/urfave/cli/v2"
+)
+
+// isUploadURL reports whether the argument is an upload URL rather than a local path
+func isUploadURL(arg string) bool {
+	return strings.HasPrefix(arg, "http://") || strings.HasPrefix(arg, "https://")
+}
+
+// stateUploadURLs returns every server side upload referenced by a state:
+// the final upload and, for parallel uploads, all partial uploads
+func stateUploadURLs(state *UploadState) []string {
+	var urls []string
+	if state.UploadURL != "" {
+		urls = append(urls, state.UploadURL)
+	}
+	for _, part := range state.Parts {
+		if part.UploadURL != "" {
+			urls = append(urls, part.UploadURL)
+		}
+	}
+	return urls
+}
+
+// findUploadStateByURL scans the state files for one referencing uploadURL
+func findUploadStateByURL(uploadURL string) (*UploadState, error) {
+	states, err := loadUploadStates()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, state := range states {
+		for _, u := range stateUploadURLs(state) {
+			if u == uploadURL {
+				return state, nil
+			}
+		}
+	}
+
+	return nil, nil
+}
+
+// lookupUploadState finds the state for a local file or an upload URL
+func lookupUploadState(arg string) (*UploadState, error) {
+	if isUploadURL(arg) {
+		return findUploadStateByURL(arg)
+	}
+
+	fileInfo, err := os.Stat(arg)
+	if err != nil {
+		return nil, fmt.Errorf("file not found: %s", arg)
+	}
+
+	fileID := generateFileID(arg, fileInfo)
+	migrateLegacyState(arg, fileInfo, fileID)
+	return loadUploadState(fileID)
+}
+
+func deleteCommand(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return cli.NewExitError("Please provide exactly one upload URL or file", 1)
+	}
+
+	config, err := parseClientConfig(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	if err := deleteUpload(config, c.Args().Get(0)); err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	return nil
+}
+
+// deleteUpload terminates an upload on the server using the termination
+// extension and removes the matching local state file. The argument is either
+// an upload URL or the local file the upload was started for.
+func deleteUpload(config *Config, arg string) error {
+	state, err := lookupUploadState(arg)
+	if err != nil {
+		return err
+	}
+
+	// Never delete an upload another process is still sending
+	if state != nil {
+		lock, err := lockUpload(config, state.FileID, state.FilePath)
+		if err != nil {
+			return err
+		}
+		defer lock.Unlock()
+
+		// The other process may have finished while we waited
+		if state, err = loadUploadState(state.FileID); err != nil {
+			return err
+		}
+		if state == nil {
+			return fmt.Errorf("upload of %s finished while waiting, nothing to delete", arg)
+		}
+	}
+
+	var urls []string
+	switch {
+	case state != nil:
+		urls = stateUploadURLs(state)
+	case isUploadURL(arg):
+		urls = []string{arg}
+	default:
+		return fmt.Errorf("no upload state found for %s", arg)
+	}
+
+	// Upload URLs are absolute, the endpoint of the state only has to stand
+	// in when --endpoint isn't given
+	endpoint := config.Endpoint
+	if endpoint == "" && state != nil {
+		endpoint = state.Endpoint
+	}
+	if endpoint == "" {
+		endpoint = urls[0]
+	}
+	baseURL, err := url.Parse(endpoint)
+	if err != nil {
+		return fmt.Errorf("invalid endpoint URL: %v", err)
+	}
+
+	httpClient := config.httpClient()
+	tusClient := tusgo.NewClient(httpClient, baseURL)
+
+	for _, uploadURL := range urls {
+		_, err := tusClient.DeleteUpload(tusgo.Upload{Location: uploadURL})
+		switch {
+		case errors.Is(err, tusgo.ErrUploadDoesNotExist):
+			fmt.Fprintf(config.output(), "Upload already gone: %s\n", uploadURL)
+		case err != nil:
+			return fmt.Errorf("failed to delete upload %s: %v", uploadURL, err)
+		default:
+			fmt.Fprintf(config.output(), "✓ Deleted upload: %s\n", uploadURL)
+		}
+	}
+
+	if state != nil {
+		if err := removeUploadState(state.FileID); err != nil {
+			return err
+		}
+		if config.Verbose {
+			fmt.Fprintf(config.output(), "Removed state file: %s\n", getStateFilePath(state.FileID))
+		}
+	}
+
+	return nil
+}
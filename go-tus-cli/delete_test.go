@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/bdragon300/tusgo"
+)
+
+// createServerUpload creates an empty upload on the mock server
+func createServerUpload(t *testing.T, mockServer *StatefulTUSServer, size int64) string {
+	baseURL, _ := url.Parse(mockServer.URL())
+	tusClient := tusgo.NewClient(nil, baseURL)
+
+	var upload tusgo.Upload
+	if _, err := tusClient.CreateUpload(&upload, size, false, nil); err != nil {
+		t.Fatalf("Failed to create upload: %v", err)
+	}
+	return upload.Location
+}
+
+// saveTestState stores an upload state for path pointing at uploadURL
+func saveTestState(t *testing.T, path, endpoint, uploadURL string) string {
+	fileInfo, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Failed to stat %s: %v", path, err)
+	}
+
+	fileID := generateFileID(path, fileInfo)
+	err = saveUploadState(&UploadState{
+		FileID:      fileID,
+		FilePath:    path,
+		FileSize:    fileInfo.Size(),
+		FileModTime: fileInfo.ModTime(),
+		UploadURL:   uploadURL,
+		Endpoint:    endpoint,
+	})
+	if err != nil {
+		t.Fatalf("Failed to save state: %v", err)
+	}
+	return fileID
+}
+
+func TestDeleteUploadByFile(t *testing.T) {
+	mockServer := NewStatefulTUSServer()
+	defer mockServer.Close()
+
+	wd, _ := os.Getwd()
+	os.Chdir(t.TempDir())
+	defer os.Chdir(wd)
+
+	testFile := createTestFile(t, "abandoned upload")
+	defer os.Remove(testFile)
+
+	uploadURL := createServerUpload(t, mockServer, 16)
+	fileID := saveTestState(t, testFile, mockServer.URL(), uploadURL)
+
+	// Without --endpoint the one of the state is used
+	config := &Config{Headers: make(map[string]string)}
+	if err := deleteUpload(config, testFile); err != nil {
+		t.Fatalf("deleteUpload failed: %v", err)
+	}
+
+	if len(mockServer.Uploads()) != 0 {
+		t.Error("Upload should have been deleted on the server")
+	}
+	if _, err := os.Stat(getStateFilePath(fileID)); !os.IsNotExist(err) {
+		t.Error("State file should have been removed")
+	}
+}
+
+func TestDeleteUploadByURL(t *testing.T) {
+	mockServer := NewStatefulTUSServer()
+	defer mockServer.Close()
+
+	wd, _ := os.Getwd()
+	os.Chdir(t.TempDir())
+	defer os.Chdir(wd)
+
+	testFile := createTestFile(t, "abandoned upload")
+	defer os.Remove(testFile)
+
+	uploadURL := createServerUpload(t, mockServer, 16)
+	fileID := saveTestState(t, testFile, mockServer.URL(), uploadURL)
+
+	config := &Config{Endpoint: mockServer.URL(), Headers: make(map[string]string)}
+	if err := deleteUpload(config, uploadURL); err != nil {
+		t.Fatalf("deleteUpload failed: %v", err)
+	}
+
+	if len(mockServer.Uploads()) != 0 {
+		t.Error("Upload should have been deleted on the server")
+	}
+	if _, err := os.Stat(getStateFilePath(fileID)); !os.IsNotExist(err) {
+		t.Error("State file found by URL should have been removed")
+	}
+
+	// Deleting again is not an error, the upload is simply gone
+	if err := deleteUpload(config, uploadURL); err != nil {
+		t.Errorf("Deleting a missing upload should succeed, got: %v", err)
+	}
+}
+
+func TestDeleteUploadWithoutState(t *testing.T) {
+	wd, _ := os.Getwd()
+	os.Chdir(t.TempDir())
+	defer os.Chdir(wd)
+
+	testFile := createTestFile(t, "never uploaded")
+	defer os.Remove(testFile)
+
+	config := &Config{Endpoint: "http://localhost:1/files", Headers: make(map[string]string)}
+	if err := deleteUpload(config, testFile); err == nil {
+		t.Error("Expected error for a file without upload state")
+	}
+}
+
+func TestDeleteUploadSendsHeaders(t *testing.T) {
+	mockServer := NewStatefulTUSServer()
+	defer mockServer.Close()
+
+	uploadURL := createServerUpload(t, mockServer, 16)
+
+	// Like a gateway authenticating with an -H or profile header
+	mockServer.Verify = func(r *http.Request) error {
+		if r.Method == http.MethodDelete && r.Header.Get("X-Api-Key") != "s3cret" {
+			return fmt.Errorf("missing X-Api-Key")
+		}
+		return nil
+	}
+
+	config := &Config{Endpoint: mockServer.URL(), Headers: map[string]string{"X-Api-Key": "s3cret"}}
+	if err := deleteUpload(config, uploadURL); err != nil {
+		t.Fatalf("deleteUpload failed: %v", err)
+	}
+	if len(mockServer.Uploads()) != 0 {
+		t.Error("Upload should have been deleted on the server")
+	}
+}
@@ -0,0 +1,406 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bdragon300/tusgo"
+	"github.com/urfave/cli/v2"
+)
+
+// DownloadInfo describes a remote file before it is downloaded
+type DownloadInfo struct {
+	Filename     string
+	Size         int64     // -1 if the server did not tell
+	ETag         string    // Empty if the server did not tell
+	LastModified time.Time // Zero if the server did not tell
+}
+
+// hookFileInfo is the part of the hook service's /files/{key} response
+// tusc needs
+type hookFileInfo struct {
+	Filename string `json:"filename"`
+	Size     int64  `json:"size"`
+}
+
+func downloadCommand(c *cli.Context) error {
+	if c.NArg() < 1 || c.NArg() > 2 {
+		return cli.NewExitError("Please provide a download URL and an optional destination", 1)
+	}
+
+	// Download URLs are absolute, no endpoint is needed
+	config, err := parseClientConfig(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	defer watchSignals(config)()
+
+	if _, err := downloadFile(config, c.Args().Get(0), c.Args().Get(1)); errors.Is(err, errInterrupted) {
+		return cli.NewExitError("Download interrupted", ExitInterrupted)
+	} else if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	return nil
+}
+
+// newDownloadRequest creates a request that is cancelled with the run
+func newDownloadRequest(config *Config, method, rawURL string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(config.runContext(), method, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// isHookDownloadURL reports whether rawURL is a hook service
+// /api/v1/files/{key}/download URL rather than a tus upload URL
+func isHookDownloadURL(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	return err == nil && strings.HasSuffix(u.Path, "/download")
+}
+
+// probeDownload looks up the original filename and size of a download. tus
+// upload URLs answer HEAD with Upload-Metadata and Upload-Length, the hook
+// service describes the file at the URL without the /download suffix.
+func probeDownload(config *Config, httpClient *http.Client, rawURL string) (DownloadInfo, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return DownloadInfo{}, fmt.Errorf("invalid download URL: %v", err)
+	}
+
+	info := DownloadInfo{Size: -1}
+	if isHookDownloadURL(rawURL) {
+		info.Filename = path.Base(strings.TrimSuffix(u.Path, "/download"))
+		if hookInfo, err := fetchHookFileInfo(config, httpClient, strings.TrimSuffix(rawURL, "/download")); err == nil {
+			info.Filename = hookInfo.Filename
+			info.Size = hookInfo.Size
+		} else if config.Verbose {
+			fmt.Fprintf(config.output(), "Failed to get file info: %v\n", err)
+		}
+		return info, nil
+	}
+
+	info.Filename = path.Base(u.Path)
+
+	req, err := newDownloadRequest(config, "HEAD", rawURL)
+	if err != nil {
+		return info, err
+	}
+	req.Header.Set("Tus-Resumable", "1.0.0")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return info, err
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return info, fmt.Errorf("upload not found: %s", rawURL)
+	case resp.StatusCode != http.StatusOK:
+		// Not every server answers HEAD, the GET will tell
+		return info, nil
+	}
+
+	setValidators(&info, resp.Header)
+	if metadata, err := tusgo.DecodeMetadata(resp.Header.Get("Upload-Metadata")); err == nil {
+		if name := metadata["filename"]; name != "" {
+			info.Filename = name
+		} else if name := metadata["name"]; name != "" {
+			info.Filename = name
+		}
+	}
+
+	if length := resp.Header.Get("Upload-Length"); length != "" {
+		info.Size, _ = strconv.ParseInt(length, 10, 64)
+		offset, _ := strconv.ParseInt(resp.Header.Get("Upload-Offset"), 10, 64)
+		if offset < info.Size {
+			return info, fmt.Errorf("upload is not finished yet (%s of %s)", formatBytes(offset), formatBytes(info.Size))
+		}
+	} else if resp.ContentLength >= 0 {
+		info.Size = resp.ContentLength
+	}
+
+	return info, nil
+}
+
+// fetchHookFileInfo reads the file description from the hook service
+func fetchHookFileInfo(config *Config, httpClient *http.Client, infoURL string) (*hookFileInfo, error) {
+	req, err := newDownloadRequest(config, "GET", infoURL)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, newHTTPError(PhaseDownload, resp, nil)
+	}
+
+	var info hookFileInfo
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, fmt.Errorf("invalid file info: %v", err)
+	}
+	return &info, nil
+}
+
+// downloadPath picks the local path: dest itself, or the original filename in
+// dest (if it is a directory) or in the working directory
+func downloadPath(dest, filename string) string {
+	// Never let a remote filename escape the target directory
+	filename = filepath.Base(filepath.FromSlash(filename))
+	if filename == "." || filename == ".." || filename == string(filepath.Separator) {
+		filename = "download"
+	}
+
+	if dest == "" {
+		return filename
+	}
+	if info, err := os.Stat(dest); err == nil && info.IsDir() {
+		return filepath.Join(dest, filename)
+	}
+	return dest
+}
+
+// downloadFile downloads rawURL to dest and returns the local path. Data is
+// written to "<path>.part" first, so an interrupted download resumes with a
+// Range request and only a verified, complete file gets the final name.
+func downloadFile(config *Config, rawURL, dest string) (string, error) {
+	httpClient := config.httpClient()
+
+	info, err := probeDownload(config, httpClient, rawURL)
+	if err != nil {
+		return "", err
+	}
+
+	localPath := downloadPath(dest, info.Filename)
+	partPath := localPath + ".part"
+
+	if isDownloaded(localPath, info) {
+		fmt.Fprintf(config.output(), "✓ Already downloaded: %s (%s)\n", localPath, formatBytes(info.Size))
+		return localPath, nil
+	}
+
+	if config.Verbose {
+		fmt.Fprintf(config.output(), "URL: %s\n", rawURL)
+		fmt.Fprintf(config.output(), "Destination: %s\n", localPath)
+		if info.Size >= 0 {
+			fmt.Fprintf(config.output(), "Size: %s\n", formatBytes(info.Size))
+		}
+	}
+
+	// Events of this download are about the local file
+	fileConfig := *config
+	fileConfig.file = localPath
+	config = &fileConfig
+
+	start := time.Now()
+	err = downloadRange(config, httpClient, rawURL, partPath, &info)
+
+	// Retry with the same policy as uploads, each attempt resumes the part file
+	ctx := config.runContext()
+	retrier := newRetrier(config)
+	for err != nil {
+		if ctx.Err() != nil {
+			return "", errInterrupted
+		}
+
+		// Only transient failures are worth another request, a download has
+		// no offset to re-sync and nothing to recreate
+		if retryAction(err) != Backoff {
+			return "", fmt.Errorf("download failed with permanent error: %w", err)
+		}
+
+		delay, ok := retrier.Next(err)
+		if !ok {
+			return "", fmt.Errorf("download failed after %d retry attempts: %w", retrier.Attempts(), err)
+		}
+		config.emitRetry(retrier.Attempts(), delay, err)
+		if config.Verbose {
+			fmt.Fprintf(config.output(), "\nDownload failed, retrying in %v (retry %d): %v\n", delay.Round(time.Millisecond), retrier.Attempts(), err)
+		}
+		if err := sleepContext(ctx, delay); err != nil {
+			return "", errInterrupted
+		}
+
+		err = downloadRange(config, httpClient, rawURL, partPath, &info)
+	}
+
+	stat, err := os.Stat(partPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to stat download: %v", err)
+	}
+	if info.Size >= 0 && stat.Size() != info.Size {
+		return "", fmt.Errorf("size mismatch: expected %d bytes, got %d", info.Size, stat.Size())
+	}
+
+	if err := os.Rename(partPath, localPath); err != nil {
+		return "", fmt.Errorf("failed to rename download: %v", err)
+	}
+
+	// The modification time tells the next run which version it has
+	if !info.LastModified.IsZero() {
+		if err := os.Chtimes(localPath, time.Now(), info.LastModified); err != nil && config.Verbose {
+			fmt.Fprintf(config.output(), "Warning: failed to set modification time: %v\n", err)
+		}
+	}
+
+	fmt.Fprintf(config.output(), "\r✓ Download completed: %s (%s) in %v\n",
+		localPath,
+		formatBytes(stat.Size()),
+		time.Since(start).Round(time.Second))
+
+	return localPath, nil
+}
+
+// downloadRange appends the missing bytes of rawURL to partPath, asking only
+// for what the part file does not have yet
+func downloadRange(config *Config, httpClient *http.Client, rawURL, partPath string, info *DownloadInfo) error {
+	file, err := os.OpenFile(partPath, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %v", partPath, err)
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %v", partPath, err)
+	}
+
+	offset := stat.Size()
+	if info.Size >= 0 && offset == info.Size {
+		return nil
+	}
+	if info.Size >= 0 && offset > info.Size {
+		offset = 0 // Left over from a different file
+	}
+
+	req, err := newDownloadRequest(config, "GET", rawURL)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		// A file that changed since the part was written is sent whole
+		if info.ETag != "" && !strings.HasPrefix(info.ETag, "W/") {
+			req.Header.Set("If-Range", info.ETag)
+		} else if !info.LastModified.IsZero() {
+			req.Header.Set("If-Range", info.LastModified.UTC().Format(http.TimeFormat))
+		}
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusPartialContent {
+		setValidators(info, resp.Header)
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusPartialContent:
+		start, total, err := parseContentRange(resp.Header.Get("Content-Range"))
+		if err != nil {
+			return err
+		}
+		if start != offset {
+			return fmt.Errorf("server resumed at byte %d instead of %d", start, offset)
+		}
+		if total >= 0 {
+			info.Size = total
+		}
+	case resp.StatusCode == http.StatusOK:
+		// The server ignored the range, start over
+		offset = 0
+		if resp.ContentLength >= 0 {
+			info.Size = resp.ContentLength
+		}
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		if info.Size < 0 || offset != info.Size {
+			return fmt.Errorf("server rejected range starting at byte %d", offset)
+		}
+		return nil
+	default:
+		return newHTTPError(PhaseDownload, resp, nil)
+	}
+
+	if err := file.Truncate(offset); err != nil {
+		return fmt.Errorf("failed to truncate %s: %v", partPath, err)
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek %s: %v", partPath, err)
+	}
+
+	if offset > 0 {
+		fmt.Fprintf(config.output(), "Resuming download from %s...\n", formatBytes(offset))
+	} else {
+		fmt.Fprintf(config.output(), "Downloading %s...\n", filepath.Base(strings.TrimSuffix(partPath, ".part")))
+	}
+
+	remaining := int64(-1)
+	if info.Size >= 0 {
+		remaining = info.Size - offset
+	}
+	progressWriter := NewProgressWriter(file, remaining, strings.TrimSuffix(partPath, ".part"))
+	progressWriter.out = config.output()
+	progressWriter.verb = "Downloading"
+
+	_, err = io.Copy(progressWriter, resp.Body)
+	return err
+}
+
+// setValidators takes the ETag and Last-Modified of a response, keeping those
+// known already when it has none
+func setValidators(info *DownloadInfo, header http.Header) {
+	if etag := header.Get("ETag"); etag != "" {
+		info.ETag = etag
+	}
+	if t, err := http.ParseTime(header.Get("Last-Modified")); err == nil {
+		info.LastModified = t
+	}
+}
+
+// isDownloaded reports whether localPath already holds the remote file. The
+// size has to match and, when the server sends Last-Modified, so does the
+// modification time the file got after its download.
+func isDownloaded(localPath string, info DownloadInfo) bool {
+	stat, err := os.Stat(localPath)
+	if err != nil || info.Size < 0 || stat.Size() != info.Size {
+		return false
+	}
+	return info.LastModified.IsZero() || stat.ModTime().Truncate(time.Second).Equal(info.LastModified)
+}
+
+// parseContentRange parses "bytes start-end/total". total is -1 for "*".
+func parseContentRange(header string) (start, total int64, err error) {
+	var end int64
+	var totalStr string
+	if _, err := fmt.Sscanf(header, "bytes %d-%d/%s", &start, &end, &totalStr); err != nil {
+		return 0, 0, fmt.Errorf("invalid Content-Range header: %q", header)
+	}
+
+	if totalStr == "*" {
+		return start, -1, nil
+	}
+	total, err = strconv.ParseInt(totalStr, 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid Content-Range header: %q", header)
+	}
+	return start, total, nil
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bdragon300/tusgo"
+)
+
+// DownloadServer serves one finished tus upload and records Range headers
+type DownloadServer struct {
+	server *httptest.Server
+	data   []byte
+
+	mu     sync.Mutex
+	ranges []string
+	// AbortGets makes the next N GET requests stop halfway through the body
+	AbortGets int
+	// Offset overrides Upload-Offset to simulate an unfinished upload
+	Offset int64
+	// Modified is sent as Last-Modified unless zero
+	Modified time.Time
+}
+
+func NewDownloadServer(data []byte, filename string) *DownloadServer {
+	mock := &DownloadServer{data: data, Offset: -1}
+	metadata, _ := tusgo.EncodeMetadata(map[string]string{"filename": filename})
+
+	mock.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Tus-Resumable", "1.0.0")
+		switch r.Method {
+		case "HEAD":
+			offset := int64(len(mock.data))
+			if mock.Offset >= 0 {
+				offset = mock.Offset
+			}
+			w.Header().Set("Upload-Metadata", metadata)
+			w.Header().Set("Upload-Length", strconv.Itoa(len(mock.data)))
+			w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+			if !mock.Modified.IsZero() {
+				w.Header().Set("Last-Modified", mock.Modified.UTC().Format(http.TimeFormat))
+			}
+			w.WriteHeader(http.StatusOK)
+		case "GET":
+			mock.mu.Lock()
+			mock.ranges = append(mock.ranges, r.Header.Get("Range"))
+			abort := mock.AbortGets > 0
+			if abort {
+				mock.AbortGets--
+			}
+			mock.mu.Unlock()
+
+			if abort {
+				w.Header().Set("Content-Length", strconv.Itoa(len(mock.data)))
+				w.Write(mock.data[:len(mock.data)/2])
+				panic(http.ErrAbortHandler)
+			}
+			http.ServeContent(w, r, "", mock.Modified, bytes.NewReader(mock.data))
+		}
+	}))
+	return mock
+}
+
+func (m *DownloadServer) Close() {
+	m.server.Close()
+}
+
+func (m *DownloadServer) URL() string {
+	return m.server.URL + "/files/24e533e02ec3bc40"
+}
+
+func (m *DownloadServer) Ranges() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]string(nil), m.ranges...)
+}
+
+func downloadTestConfig(retries int) *Config {
+	return &Config{
+		Endpoint: "http://localhost:1080/files",
+		Headers:  make(map[string]string),
+		Retries:  retries,
+	}
+}
+
+func TestDownloadTusUpload(t *testing.T) {
+	data := make([]byte, 256*1024)
+	rand.Read(data)
+
+	mockServer := NewDownloadServer(data, "report.pdf")
+	defer mockServer.Close()
+	mockServer.AbortGets = 1
+
+	dir := t.TempDir()
+	localPath, err := downloadFile(downloadTestConfig(1), mockServer.URL(), dir)
+	if err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+
+	if localPath != filepath.Join(dir, "report.pdf") {
+		t.Errorf("Expected the original filename, got %s", localPath)
+	}
+
+	downloaded, err := os.ReadFile(localPath)
+	if err != nil || !bytes.Equal(downloaded, data) {
+		t.Error("Downloaded data doesn't match the upload")
+	}
+	if _, err := os.Stat(localPath + ".part"); !os.IsNotExist(err) {
+		t.Error("Part file should have been renamed")
+	}
+
+	// The retry must continue where the aborted GET stopped
+	ranges := mockServer.Ranges()
+	if len(ranges) != 2 || ranges[0] != "" || !strings.HasPrefix(ranges[1], "bytes=") {
+		t.Errorf("Expected a full GET followed by a ranged GET, got %q", ranges)
+	}
+}
+
+func TestDownloadResumesPartFile(t *testing.T) {
+	data := make([]byte, 100*1024)
+	rand.Read(data)
+
+	mockServer := NewDownloadServer(data, "data.bin")
+	defer mockServer.Close()
+
+	dir := t.TempDir()
+	partPath := filepath.Join(dir, "data.bin.part")
+	if err := os.WriteFile(partPath, data[:40000], 0644); err != nil {
+		t.Fatalf("Failed to write part file: %v", err)
+	}
+
+	localPath, err := downloadFile(downloadTestConfig(0), mockServer.URL(), dir)
+	if err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+
+	downloaded, _ := os.ReadFile(localPath)
+	if !bytes.Equal(downloaded, data) {
+		t.Error("Resumed download doesn't match the upload")
+	}
+	if ranges := mockServer.Ranges(); len(ranges) != 1 || ranges[0] != "bytes=40000-" {
+		t.Errorf("Expected a single ranged GET, got %q", ranges)
+	}
+}
+
+func TestDownloadRetryEvents(t *testing.T) {
+	data := make([]byte, 64*1024)
+	rand.Read(data)
+
+	mockServer := NewDownloadServer(data, "data.bin")
+	defer mockServer.Close()
+	mockServer.AbortGets = 1
+
+	var buf bytes.Buffer
+	config := downloadTestConfig(1)
+	config.Retry = &RetryPolicy{MaxDelay: 10 * time.Millisecond}
+	config.events = NewEventWriter(&buf)
+
+	localPath, err := downloadFile(config, mockServer.URL(), t.TempDir())
+	if err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+
+	var retries []Event
+	for _, event := range readEvents(t, &buf) {
+		if event.Event == "retry" {
+			retries = append(retries, event)
+		}
+	}
+	if len(retries) != 1 || retries[0].Attempt != 1 || retries[0].File != localPath {
+		t.Errorf("Expected one retry event for %s, got %+v", localPath, retries)
+	}
+}
+
+func TestDownloadInterrupted(t *testing.T) {
+	mockServer := NewDownloadServer(make([]byte, 64*1024), "data.bin")
+	defer mockServer.Close()
+	mockServer.AbortGets = 1
+
+	ctx, cancel := context.WithCancel(context.Background())
+	config := downloadTestConfig(3)
+	config.Retry = &RetryPolicy{BaseDelay: time.Hour, MaxDelay: time.Hour}
+	config.ctx = ctx
+
+	// Cancelling during the backoff must not wait for the hour
+	time.AfterFunc(100*time.Millisecond, cancel)
+	done := make(chan error, 1)
+	go func() {
+		_, err := downloadFile(config, mockServer.URL(), t.TempDir())
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, errInterrupted) {
+			t.Errorf("Expected errInterrupted, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Download did not stop when cancelled")
+	}
+}
+
+func TestDownloadChangedFile(t *testing.T) {
+	mockServer := NewDownloadServer([]byte("first version"), "notes.txt")
+	defer mockServer.Close()
+	mockServer.Modified = time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	dir := t.TempDir()
+	localPath, err := downloadFile(downloadTestConfig(0), mockServer.URL(), dir)
+	if err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+	if stat, _ := os.Stat(localPath); !stat.ModTime().Equal(mockServer.Modified) {
+		t.Errorf("Expected modification time %v, got %v", mockServer.Modified, stat.ModTime())
+	}
+
+	// Unchanged, nothing is fetched again
+	if _, err := downloadFile(downloadTestConfig(0), mockServer.URL(), dir); err != nil {
+		t.Fatalf("Second download failed: %v", err)
+	}
+	if ranges := mockServer.Ranges(); len(ranges) != 1 {
+		t.Errorf("Expected the unchanged file to be skipped, got %d GETs", len(ranges))
+	}
+
+	// Same size but newer, it must not count as downloaded
+	mockServer.data = []byte("other version")
+	mockServer.Modified = mockServer.Modified.Add(time.Hour)
+	if _, err := downloadFile(downloadTestConfig(0), mockServer.URL(), dir); err != nil {
+		t.Fatalf("Third download failed: %v", err)
+	}
+	if downloaded, _ := os.ReadFile(localPath); string(downloaded) != "other version" {
+		t.Errorf("Expected the changed file, got %q", downloaded)
+	}
+}
+
+func TestDownloadUnfinishedUpload(t *testing.T) {
+	mockServer := NewDownloadServer([]byte("partial"), "partial.txt")
+	defer mockServer.Close()
+	mockServer.Offset = 3
+
+	if _, err := downloadFile(downloadTestConfig(0), mockServer.URL(), t.TempDir()); err == nil {
+		t.Error("Expected an error for an unfinished upload")
+	}
+}
+
+func TestDownloadHookService(t *testing.T) {
+	content := []byte("hook service content")
+
+	handler := http.NewServeMux()
+	handler.HandleFunc("/api/v1/files/abc123", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"file_key": "abc123",
+			"filename": "报告.txt",
+			"size":     len(content),
+		})
+	})
+	handler.HandleFunc("/api/v1/files/abc123/download", func(w http.ResponseWriter, r *http.Request) {
+		// Like the hook service, ignore Range and always send everything
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		w.Write(content)
+	})
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "报告.txt.part"), []byte("stale"), 0644)
+
+	localPath, err := downloadFile(downloadTestConfig(0), server.URL+"/api/v1/files/abc123/download", dir)
+	if err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+
+	if filepath.Base(localPath) != "报告.txt" {
+		t.Errorf("Expected the original filename, got %s", localPath)
+	}
+	downloaded, _ := os.ReadFile(localPath)
+	if !bytes.Equal(downloaded, content) {
+		t.Errorf("Expected %q, got %q", content, downloaded)
+	}
+}
+
+func TestDownloadPath(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		dest     string
+		filename string
+		expected string
+	}{
+		{"", "report.pdf", "report.pdf"},
+		{"", "../../etc/passwd", "passwd"},
+		{dir, "report.pdf", filepath.Join(dir, "report.pdf")},
+		{filepath.Join(dir, "out.pdf"), "report.pdf", filepath.Join(dir, "out.pdf")},
+	}
+
+	for _, test := range tests {
+		if result := downloadPath(test.dest, test.filename); result != test.expected {
+			t.Errorf("downloadPath(%q, %q) = %q, expected %q", test.dest, test.filename, result, test.expected)
+		}
+	}
+}
+
+func TestParseContentRange(t *testing.T) {
+	tests := []struct {
+		header   string
+		start    int64
+		total    int64
+		hasError bool
+	}{
+		{"bytes 100-199/1000", 100, 1000, false},
+		{"bytes 0-9/*", 0, -1, false},
+		{"items 0-9/10", 0, 0, true},
+	}
+
+	for _, test := range tests {
+		start, total, err := parseContentRange(test.header)
+		if (err != nil) != test.hasError {
+			t.Errorf("parseContentRange(%q) error = %v, expected error: %v", test.header, err, test.hasError)
+			continue
+		}
+		if !test.hasError && (start != test.start || total != test.total) {
+			t.Errorf("parseContentRange(%q) = %d, %d, expected %d, %d", test.header, start, total, test.start, test.total)
+		}
+	}
+}
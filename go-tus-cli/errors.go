@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/bdragon300/tusgo"
+)
+
+// Phase names the step of an upload a failed request belongs to
+type Phase string
+
+const (
+	PhaseCreate   Phase = "create"
+	PhaseHead     Phase = "head"
+	PhasePatch    Phase = "patch"
+	PhaseDownload Phase = "download"
+)
+
+// maxErrorBody is how much of an error response body is kept
+const maxErrorBody = 512
+
+// errorHeaders are the response headers kept with an HTTPError, they explain
+// most failed tus requests
+var errorHeaders = []string{
+	"Tus-Resumable", "Tus-Version", "Tus-Extension", "Tus-Max-Size",
+	"Upload-Offset", "Upload-Length", "Upload-Expires", "Retry-After",
+}
+
+// HTTPError is a failed request with what the server answered. StatusCode is
+// 0 if no response was received, Err then holds the transport error.
+type HTTPError struct {
+	Phase      Phase
+	Method     string
+	URL        string
+	StatusCode int
+	Status     string
+	Body       string
+	Header     http.Header
+	Err        error
+}
+
+// newHTTPError describes a request that failed with resp, err is the error
+// it was reported as, if any. The body is read unless it was closed already.
+func newHTTPError(phase Phase, resp *http.Response, err error) *HTTPError {
+	httpErr := &HTTPError{Phase: phase, Err: err, Header: make(http.Header)}
+	if resp == nil {
+		return httpErr
+	}
+
+	httpErr.StatusCode = resp.StatusCode
+	httpErr.Status = resp.Status
+	if resp.Request != nil {
+		httpErr.Method = resp.Request.Method
+		httpErr.URL = resp.Request.URL.String()
+	}
+	for _, name := range errorHeaders {
+		if values := resp.Header.Values(name); len(values) > 0 {
+			httpErr.Header[name] = values
+		}
+	}
+	var body []byte
+	if captured, ok := resp.Body.(*capturedBody); ok {
+		body = captured.data
+	} else if resp.Body != nil {
+		body, _ = io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	}
+	httpErr.Body = strings.TrimSpace(string(body))
+
+	return httpErr
+}
+
+// wrapResponseError attaches the response a tusgo call returned to its error
+func wrapResponseError(phase Phase, resp *http.Response, err error) error {
+	if err == nil {
+		return nil
+	}
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		return err
+	}
+	return newHTTPError(phase, resp, err)
+}
+
+func (e *HTTPError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s request failed", e.Phase)
+	if e.StatusCode != 0 {
+		fmt.Fprintf(&b, " with %s", e.Status)
+	}
+	if e.Body != "" {
+		fmt.Fprintf(&b, ": %s", e.Body)
+	}
+	if e.Err != nil {
+		fmt.Fprintf(&b, ": %v", e.Err)
+	}
+	return b.String()
+}
+
+func (e *HTTPError) Unwrap() error {
+	return e.Err
+}
+
+// capturedBody replaces the body of an error response. It reads as empty,
+// tusgo panics on error bodies shorter than 256 bytes, but keeps the start of
+// the original body for newHTTPError.
+type capturedBody struct {
+	data []byte
+}
+
+func (b *capturedBody) Read(p []byte) (int, error) {
+	return 0, io.EOF
+}
+
+func (b *capturedBody) Close() error {
+	return nil
+}
+
+// errorBodyTransport captures the bodies of error responses
+type errorBodyTransport struct {
+	base http.RoundTripper
+}
+
+func (t *errorBodyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil || resp.StatusCode < 400 {
+		return resp, err
+	}
+
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	resp.Body.Close()
+	resp.Body = &capturedBody{data: data}
+	return resp, nil
+}
+
+// RetryAction is what a failed request calls for
+type RetryAction int
+
+const (
+	FailFast RetryAction = iota // Permanent, retrying won't help
+	Backoff                     // Transient, retry after waiting
+	Resync                      // Offsets differ, ask the server for its offset and continue
+	Resend                      // The data was damaged on the way, send it again
+	Recreate                    // The upload is gone, start a new one
+)
+
+func (a RetryAction) String() string {
+	return [...]string{"fail", "backoff", "resync", "resend", "recreate"}[a]
+}
+
+// retryAction classifies err by its HTTP status following the tus spec, or
+// by the transport or tusgo error if no response was received
+func retryAction(err error) RetryAction {
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) && httpErr.StatusCode != 0 {
+		action := statusRetryAction(httpErr.StatusCode)
+		// A 404 on creation is a wrong endpoint, not a lost upload
+		if action == Recreate && httpErr.Phase == PhaseCreate {
+			return FailFast
+		}
+		return action
+	}
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		return FailFast
+	case errors.Is(err, tusgo.ErrOffsetsNotSynced), errors.Is(err, io.ErrShortWrite):
+		return Resync
+	case errors.Is(err, tusgo.ErrChecksumMismatch):
+		return Resend
+	case errors.Is(err, tusgo.ErrUploadDoesNotExist):
+		return Recreate
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return Backoff
+	}
+
+	// Timeouts, refused and reset connections, DNS failures
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return Backoff
+	}
+
+	return FailFast
+}
+
+// statusRetryAction maps a response status to a RetryAction
+func statusRetryAction(status int) RetryAction {
+	switch {
+	case status == http.StatusConflict:
+		return Resync
+	case status == 460: // Non-standard 460 Checksum Mismatch
+		return Resend
+	case status == http.StatusNotFound, status == http.StatusGone:
+		return Recreate
+	case status == http.StatusLocked, status == http.StatusRequestTimeout, status == http.StatusTooManyRequests:
+		return Backoff
+	case status >= 500:
+		return Backoff
+	default:
+		return FailFast
+	}
+}
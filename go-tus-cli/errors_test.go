@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bdragon300/tusgo"
+)
+
+func TestRetryAction(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected RetryAction
+	}{
+		{&HTTPError{Phase: PhasePatch, StatusCode: http.StatusConflict}, Resync},
+		{&HTTPError{Phase: PhasePatch, StatusCode: http.StatusLocked}, Backoff},
+		{&HTTPError{Phase: PhasePatch, StatusCode: http.StatusNotFound}, Recreate},
+		{&HTTPError{Phase: PhaseHead, StatusCode: http.StatusGone}, Recreate},
+		{&HTTPError{Phase: PhaseCreate, StatusCode: http.StatusNotFound}, FailFast},
+		{&HTTPError{Phase: PhasePatch, StatusCode: 460}, Resend},
+		{&HTTPError{Phase: PhasePatch, StatusCode: http.StatusTooManyRequests}, Backoff},
+		{&HTTPError{Phase: PhasePatch, StatusCode: http.StatusBadGateway}, Backoff},
+		{&HTTPError{Phase: PhasePatch, StatusCode: http.StatusBadRequest}, FailFast},
+		{&HTTPError{Phase: PhasePatch, StatusCode: http.StatusForbidden}, FailFast},
+		{&HTTPError{Phase: PhasePatch, StatusCode: http.StatusUnauthorized}, FailFast},
+		{tusgo.ErrOffsetsNotSynced, Resync},
+		{tusgo.ErrUploadDoesNotExist, Recreate},
+		{io.ErrUnexpectedEOF, Backoff},
+		{context.Canceled, FailFast},
+		{errors.New("connection timeout"), FailFast},
+	}
+
+	for _, test := range tests {
+		if action := retryAction(test.err); action != test.expected {
+			t.Errorf("retryAction(%v) = %s, expected %s", test.err, action, test.expected)
+		}
+	}
+}
+
+func TestNewHTTPError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Tus-Resumable", "1.0.0")
+		w.Header().Set("Upload-Offset", "1024")
+		w.Header().Set("Set-Cookie", "session=secret")
+		w.WriteHeader(http.StatusLocked)
+		w.Write([]byte("upload is locked by another request\n" + strings.Repeat("x", 2*maxErrorBody)))
+	}))
+	defer server.Close()
+
+	resp, err := http.Head(server.URL)
+	if err != nil {
+		t.Fatalf("HEAD failed: %v", err)
+	}
+	resp.Body.Close()
+	if err := newHTTPError(PhaseHead, resp, nil); err.StatusCode != http.StatusLocked || err.Method != "HEAD" {
+		t.Errorf("Expected a HEAD failed with 423, got %+v", err)
+	}
+
+	resp, err = http.Post(server.URL, "text/plain", nil)
+	if err != nil {
+		t.Fatalf("POST failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	httpErr := newHTTPError(PhasePatch, resp, tusgo.ErrUnexpectedResponse)
+	if !strings.HasPrefix(httpErr.Body, "upload is locked") || len(httpErr.Body) > maxErrorBody {
+		t.Errorf("Expected the start of the body, got %d bytes: %.40q", len(httpErr.Body), httpErr.Body)
+	}
+	if httpErr.Header.Get("Upload-Offset") != "1024" || httpErr.Header.Get("Set-Cookie") != "" {
+		t.Errorf("Expected only tus headers, got %v", httpErr.Header)
+	}
+	if !errors.Is(httpErr, tusgo.ErrUnexpectedResponse) {
+		t.Error("Expected the HTTPError to wrap the reported error")
+	}
+	if msg := httpErr.Error(); !strings.HasPrefix(msg, "patch request failed with 423 Locked: upload is locked") {
+		t.Errorf("Unexpected error message: %s", msg)
+	}
+}
+
+// uploadTestFile writes size random bytes and uploads them to server
+func uploadTestFile(t *testing.T, server *StatefulTUSServer, size int, retries int, policy *RetryPolicy) ([]byte, error) {
+	path := filepath.Join(t.TempDir(), "upload.bin")
+	data := make([]byte, size)
+	rand.Read(data)
+	os.WriteFile(path, data, 0644)
+
+	config := &Config{Endpoint: server.URL(), ChunkSize: MinChunkSize, Retries: retries, Retry: policy, Headers: make(map[string]string)}
+	_, err := uploadFile(config, UploadTarget{Path: path})
+	return data, err
+}
+
+func TestUploadResyncsOn409(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+
+	mockServer := NewStatefulTUSServer()
+	mockServer.PatchStatuses = []int{http.StatusConflict, http.StatusConflict}
+	defer mockServer.Close()
+
+	// A conflict is answered with HEAD right away, there is nothing to wait for
+	start := time.Now()
+	data, err := uploadTestFile(t, mockServer, 2*MinChunkSize, 3, nil)
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Re-syncing must not back off, took %v", elapsed)
+	}
+
+	for _, upload := range mockServer.Uploads() {
+		if !bytes.Equal(upload.Data, data) {
+			t.Error("Uploaded data doesn't match")
+		}
+	}
+}
+
+func TestUploadRecreatesGoneUpload(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+
+	mockServer := NewStatefulTUSServer()
+	mockServer.PatchStatuses = []int{http.StatusGone}
+	defer mockServer.Close()
+
+	data, err := uploadTestFile(t, mockServer, MinChunkSize, 3, nil)
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+
+	uploads := mockServer.Uploads()
+	if len(uploads) != 2 {
+		t.Fatalf("Expected a second upload to replace the gone one, got %d", len(uploads))
+	}
+	if !bytes.Equal(uploads["upload_2"].Data, data) {
+		t.Error("Expected the new upload to hold the file")
+	}
+}
+
+func TestUploadFailsFastOn4xx(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+
+	mockServer := NewStatefulTUSServer()
+	mockServer.PatchStatuses = []int{http.StatusForbidden}
+	defer mockServer.Close()
+
+	_, err := uploadTestFile(t, mockServer, MinChunkSize, 3, nil)
+
+	var httpErr *HTTPError
+	if !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusForbidden || httpErr.Phase != PhasePatch {
+		t.Fatalf("Expected a PATCH failed with 403, got %v", err)
+	}
+	if patches := mockServer.PatchCount(); patches != 1 {
+		t.Errorf("Expected no retries after 403, got %d PATCH requests", patches)
+	}
+}
+
+func TestUploadStreamResyncsOn409(t *testing.T) {
+	mockServer := NewStatefulTUSServer()
+	mockServer.PatchStatuses = []int{http.StatusConflict}
+	defer mockServer.Close()
+
+	data := make([]byte, 2*MinChunkSize)
+	rand.Read(data)
+
+	config := &Config{Endpoint: mockServer.URL(), ChunkSize: MinChunkSize, Retries: 3, Headers: make(map[string]string)}
+	start := time.Now()
+	if _, err := uploadStream(config, bytes.NewReader(data), "conflict.bin"); err != nil {
+		t.Fatalf("Stream upload failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Re-syncing must not back off, took %v", elapsed)
+	}
+
+	for _, upload := range mockServer.Uploads() {
+		if !bytes.Equal(upload.Data, data) {
+			t.Error("Uploaded data doesn't match")
+		}
+	}
+}
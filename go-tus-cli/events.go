@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Event is one line of --output json. Fields that don't apply to an event are
+// left out.
+type Event struct {
+	Event      string            `json:"event"` // created, resumed, progress, retry, completed or failed
+	Time       time.Time         `json:"time"`
+	File       string            `json:"file,omitempty"`
+	Part       int               `json:"part,omitempty"` // 1-based part of a --parallel upload
+	UploadURL  string            `json:"upload_url,omitempty"`
+	Offset     int64             `json:"offset,omitempty"` // bytes on the server
+	Size       int64             `json:"size,omitempty"`
+	Rate       int64             `json:"rate,omitempty"` // bytes per second
+	Files      int               `json:"files,omitempty"`
+	FilesDone  int               `json:"files_done,omitempty"`
+	Attempt    int               `json:"attempt,omitempty"`
+	Action     string            `json:"action,omitempty"`
+	DelayMs    int64             `json:"delay_ms,omitempty"`
+	DurationMs int64             `json:"duration_ms,omitempty"`
+	Error      string            `json:"error,omitempty"`
+	Metadata   map[string]string `json:"metadata,omitempty"`
+}
+
+// EventWriter writes events as NDJSON. It is safe for concurrent use and a
+// nil writer drops all events, so text output needs no checks.
+type EventWriter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	now func() time.Time
+}
+
+func NewEventWriter(w io.Writer) *EventWriter {
+	return &EventWriter{enc: json.NewEncoder(w), now: time.Now}
+}
+
+// Emit writes event as one line
+func (ew *EventWriter) Emit(event Event) {
+	if ew == nil {
+		return
+	}
+
+	ew.mu.Lock()
+	defer ew.mu.Unlock()
+	event.Time = ew.now().UTC()
+	ew.enc.Encode(event)
+}
+
+// emit writes event if --output json is set. It names the file and part
+// the upload of config is about.
+func (c *Config) emit(event Event) {
+	if event.File == "" {
+		event.File = c.file
+	}
+	if event.Part == 0 {
+		event.Part = c.part
+	}
+	c.events.Emit(event)
+}
+
+// emitRetry emits a retry event for a failed request that is repeated after delay
+func (c *Config) emitRetry(attempt int, delay time.Duration, err error) {
+	c.emit(Event{
+		Event:   "retry",
+		Attempt: attempt,
+		Action:  retryAction(err).String(),
+		DelayMs: delay.Milliseconds(),
+		Error:   err.Error(),
+	})
+}
+
+// parseOutput returns the event writer for --output, nil for text output,
+// and where text for humans goes. JSON events own stdout, so that text
+// moves to stderr.
+func parseOutput(format string) (*EventWriter, io.Writer, error) {
+	switch format {
+	case "", "text":
+		return nil, os.Stdout, nil
+	case "json":
+		return NewEventWriter(os.Stdout), os.Stderr, nil
+	default:
+		return nil, nil, fmt.Errorf("invalid --output %q, expected text or json", format)
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+)
+
+// readEvents decodes the NDJSON lines in buf
+func readEvents(t *testing.T, buf *bytes.Buffer) []Event {
+	var events []Event
+	scanner := bufio.NewScanner(buf)
+	for scanner.Scan() {
+		var event Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("Invalid event line %q: %v", scanner.Text(), err)
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestEventWriter(t *testing.T) {
+	var none *EventWriter
+	none.Emit(Event{Event: "progress"})
+
+	var buf bytes.Buffer
+	writer := NewEventWriter(&buf)
+	writer.now = func() time.Time { return time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC) }
+	writer.Emit(Event{Event: "progress", File: "a.bin", Offset: 1024, Size: 4096, Rate: 512})
+
+	expected := `{"event":"progress","time":"2024-01-01T12:00:00Z","file":"a.bin","offset":1024,"size":4096,"rate":512}` + "\n"
+	if buf.String() != expected {
+		t.Errorf("Unexpected event line:\n%s\nexpected:\n%s", buf.String(), expected)
+	}
+}
+
+func TestParseOutput(t *testing.T) {
+	stdout := os.Stdout
+	if events, out, err := parseOutput("text"); events != nil || out != os.Stdout || err != nil {
+		t.Errorf("Expected text output on stdout without events, got %v, %v, %v", events, out, err)
+	}
+	if events, out, err := parseOutput("json"); events == nil || out != os.Stderr || err != nil {
+		t.Errorf("Expected events with text on stderr, got %v, %v, %v", events, out, err)
+	}
+	if os.Stdout != stdout {
+		t.Error("parseOutput must not replace os.Stdout")
+	}
+	if _, _, err := parseOutput("yaml"); err == nil {
+		t.Error("Expected an unknown output format to be rejected")
+	}
+}
+
+func TestUploadEvents(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+
+	mockServer := NewStatefulTUSServer()
+	mockServer.PatchStatuses = []int{http.StatusServiceUnavailable}
+	defer mockServer.Close()
+
+	path, _ := createRandomFile(t, MinChunkSize)
+
+	var buf bytes.Buffer
+	config := &Config{
+		Endpoint:  mockServer.URL(),
+		ChunkSize: MinChunkSize,
+		Retries:   3,
+		Retry:     &RetryPolicy{MaxDelay: 10 * time.Millisecond},
+		Headers:   make(map[string]string),
+		events:    NewEventWriter(&buf),
+	}
+	uploadURL, err := uploadFile(config, UploadTarget{Path: path})
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+
+	events := readEvents(t, &buf)
+	var names []string
+	for _, event := range events {
+		names = append(names, event.Event)
+		if event.File != path {
+			t.Errorf("Expected every event to name %s, got %+v", path, event)
+		}
+	}
+	if len(events) != 3 || names[0] != "created" || names[1] != "retry" || names[2] != "completed" {
+		t.Fatalf("Expected created, retry and completed events, got %v", names)
+	}
+
+	if retry := events[1]; retry.Action != "backoff" || retry.Attempt != 1 || retry.Error == "" {
+		t.Errorf("Unexpected retry event: %+v", retry)
+	}
+	completed := events[2]
+	if completed.UploadURL != uploadURL || completed.Size != MinChunkSize || completed.Metadata["filename"] != "parallel.bin" {
+		t.Errorf("Unexpected completed event: %+v", completed)
+	}
+
+	// A permanent error ends with a failed event
+	buf.Reset()
+	mockServer.PatchStatuses = []int{http.StatusForbidden}
+	path, _ = createRandomFile(t, MinChunkSize)
+	if _, err := uploadFile(config, UploadTarget{Path: path}); err == nil {
+		t.Fatal("Expected the upload to fail")
+	}
+
+	events = readEvents(t, &buf)
+	if failed := events[len(events)-1]; failed.Event != "failed" || failed.Error == "" {
+		t.Errorf("Expected a failed event last, got %+v", failed)
+	}
+}
+
+func TestAggregateProgressEvents(t *testing.T) {
+	var emitted []Event
+	progress := NewAggregateProgress(2, 300)
+	progress.emit = func(event Event) { emitted = append(emitted, event) }
+
+	progress.Acknowledge("a", 100)
+	progress.Sent(50)
+	progress.Acknowledge("a", 150)
+	progress.FileDone(UploadResult{Err: errors.New("failed")})
+
+	if len(emitted) != 1 {
+		t.Fatalf("Expected one progress event, got %d", len(emitted))
+	}
+	if event := emitted[0]; event.Offset != 150 || event.Size != 300 || event.Files != 2 || event.FilesDone != 1 {
+		t.Errorf("Unexpected progress event: %+v", event)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// ExpiryWarningWindow is how close to its Upload-Expires time a pending upload
+// has to be before tusc warns about it
+const ExpiryWarningWindow = time.Hour
+
+// stateExpiresAt returns the earliest expiry of the uploads in a state, or
+// nil if the server did not announce one
+func stateExpiresAt(state *UploadState) *time.Time {
+	earliest := state.ExpiresAt
+	for _, part := range state.Parts {
+		if part.ExpiresAt != nil && (earliest == nil || part.ExpiresAt.Before(*earliest)) {
+			earliest = part.ExpiresAt
+		}
+	}
+	return earliest
+}
+
+// isUploadStateExpired reports whether any upload of the state has expired
+func isUploadStateExpired(state *UploadState, now time.Time) bool {
+	expiresAt := stateExpiresAt(state)
+	return expiresAt != nil && !now.Before(*expiresAt)
+}
+
+// refreshExpiry stores a new Upload-Expires value and reports whether it changed
+func refreshExpiry(current **time.Time, expires *time.Time) bool {
+	if expires == nil || (*current != nil && (*current).Equal(*expires)) {
+		return false
+	}
+
+	t := *expires
+	*current = &t
+	return true
+}
+
+// warnIfExpiringSoon prints a warning to out if the state expires within the
+// warning window
+func warnIfExpiringSoon(out io.Writer, state *UploadState, now time.Time) {
+	expiresAt := stateExpiresAt(state)
+	if expiresAt == nil || !now.Before(*expiresAt) {
+		return
+	}
+
+	if remaining := expiresAt.Sub(now); remaining < ExpiryWarningWindow {
+		fmt.Fprintf(out, "Warning: pending upload of %s expires in %v (at %s)\n",
+			state.FilePath,
+			remaining.Round(time.Second),
+			expiresAt.Local().Format(time.RFC1123))
+	}
+}
+
+// warnExpiringUploads reports pending uploads in the state directory that are
+// about to expire, so they can be resumed while the server still keeps them
+func warnExpiringUploads(out io.Writer) {
+	states, err := loadUploadStates()
+	if err != nil {
+		return
+	}
+
+	now := time.Now()
+	for _, state := range states {
+		warnIfExpiringSoon(out, state, now)
+	}
+}
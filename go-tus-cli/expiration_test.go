@@ -0,0 +1,126 @@
+package main
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bdragon300/tusgo"
+)
+
+func TestIsUploadStateExpired(t *testing.T) {
+	now := time.Now()
+	past := now.Add(-time.Minute)
+	future := now.Add(time.Hour)
+
+	tests := []struct {
+		name     string
+		state    *UploadState
+		expected bool
+	}{
+		{"no expiry", &UploadState{}, false},
+		{"future", &UploadState{ExpiresAt: &future}, false},
+		{"past", &UploadState{ExpiresAt: &past}, true},
+		{"expired part", &UploadState{Parts: []PartState{{ExpiresAt: &future}, {ExpiresAt: &past}}}, true},
+	}
+
+	for _, test := range tests {
+		if got := isUploadStateExpired(test.state, now); got != test.expected {
+			t.Errorf("%s: isUploadStateExpired() = %v, expected %v", test.name, got, test.expected)
+		}
+	}
+}
+
+func TestRefreshExpiry(t *testing.T) {
+	var current *time.Time
+	first := time.Now().Add(time.Hour)
+	later := first.Add(time.Minute)
+
+	if refreshExpiry(&current, nil) {
+		t.Error("A missing Upload-Expires should not change the expiry")
+	}
+	if !refreshExpiry(&current, &first) || !current.Equal(first) {
+		t.Errorf("Expected expiry %v, got %v", first, current)
+	}
+	if refreshExpiry(&current, &first) {
+		t.Error("An unchanged Upload-Expires should not report a change")
+	}
+	if !refreshExpiry(&current, &later) || !current.Equal(later) {
+		t.Errorf("Expected expiry %v, got %v", later, current)
+	}
+}
+
+func TestCreatePartialUploadStoresExpiry(t *testing.T) {
+	mockServer := NewStatefulTUSServer()
+	defer mockServer.Close()
+	mockServer.Expires = time.Hour
+
+	baseURL, _ := url.Parse(mockServer.URL())
+	tusClient := tusgo.NewClient(nil, baseURL)
+
+	part := PartState{Size: 10}
+	if err := createPartialUpload(tusClient, &part, nil); err != nil {
+		t.Fatalf("Failed to create partial upload: %v", err)
+	}
+
+	if part.ExpiresAt == nil {
+		t.Fatal("Expected Upload-Expires to be stored")
+	}
+	if remaining := time.Until(*part.ExpiresAt); remaining <= 0 || remaining > time.Hour {
+		t.Errorf("Unexpected expiry %v", part.ExpiresAt)
+	}
+}
+
+func TestUploadFileRestartsExpiredUpload(t *testing.T) {
+	mockServer := NewStatefulTUSServer()
+	defer mockServer.Close()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "expired.txt")
+	if err := os.WriteFile(path, []byte("expired upload content"), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	wd, _ := os.Getwd()
+	os.Chdir(dir)
+	defer os.Chdir(wd)
+
+	oldURL := createServerUpload(t, mockServer, 22)
+	fileID := saveTestState(t, path, mockServer.URL(), oldURL)
+
+	state, err := loadUploadState(fileID)
+	if err != nil || state == nil {
+		t.Fatalf("Failed to load state: %v", err)
+	}
+	expired := time.Now().Add(-time.Minute)
+	state.ExpiresAt = &expired
+	if err := saveUploadState(state); err != nil {
+		t.Fatalf("Failed to save state: %v", err)
+	}
+
+	config := &Config{
+		Endpoint:  mockServer.URL(),
+		ChunkSize: DefaultChunkSize,
+		Headers:   make(map[string]string),
+	}
+
+	uploadURL, err := uploadFile(config, UploadTarget{Path: path})
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	if uploadURL == oldURL {
+		t.Fatal("Expired upload should not be resumed")
+	}
+
+	uploads := mockServer.Uploads()
+	if len(uploads) != 2 {
+		t.Fatalf("Expected a new upload to be created, got %d uploads", len(uploads))
+	}
+	for id, upload := range uploads {
+		if mockServer.URL()+"/"+id == uploadURL && string(upload.Data) != "expired upload content" {
+			t.Errorf("Unexpected upload content: %q", upload.Data)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+// UploadTarget describes a single local file selected for upload
+type UploadTarget struct {
+	Path string
+	// RelativePath mirrors Uppy's relativePath metadata: the path of the file
+	// inside the directory it was picked from, including that directory's
+	// name. It is empty for files given directly or matched by a glob.
+	RelativePath string
+}
+
+// UploadResult summarizes the outcome of a single file upload
+type UploadResult struct {
+	Target    UploadTarget
+	Size      int64
+	UploadURL string
+	Duration  time.Duration
+	Err       error
+}
+
+// hasGlobMeta reports whether the argument contains glob metacharacters
+func hasGlobMeta(arg string) bool {
+	return strings.ContainsAny(arg, "*?[")
+}
+
+// isStateFile reports whether the file is one of tusc's own resume state files
+func isStateFile(path string) bool {
+	name := filepath.Base(path)
+	return strings.HasPrefix(name, ".tusc_") && strings.HasSuffix(name, ".json")
+}
+
+// collectUploadTargets expands the command line arguments into a list of files.
+// Directories are walked recursively and glob patterns are expanded, so quoted
+// patterns like 'logs/*.gz' work even when the shell did not expand them.
+func collectUploadTargets(args []string) ([]UploadTarget, error) {
+	var targets []UploadTarget
+	seen := make(map[string]bool)
+
+	add := func(target UploadTarget) {
+		if seen[target.Path] {
+			return
+		}
+		seen[target.Path] = true
+		targets = append(targets, target)
+	}
+
+	for _, arg := range args {
+		paths := []string{arg}
+		if hasGlobMeta(arg) {
+			matches, err := filepath.Glob(arg)
+			if err != nil {
+				return nil, fmt.Errorf("invalid pattern %q: %v", arg, err)
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("no files match pattern: %s", arg)
+			}
+			paths = matches
+		}
+
+		for _, path := range paths {
+			info, err := os.Stat(path)
+			if err != nil {
+				return nil, fmt.Errorf("file not found: %s", path)
+			}
+
+			if !info.IsDir() {
+				add(UploadTarget{Path: path})
+				continue
+			}
+
+			dirTargets, err := walkUploadDir(path)
+			if err != nil {
+				return nil, err
+			}
+			for _, target := range dirTargets {
+				add(target)
+			}
+		}
+	}
+
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no files to upload")
+	}
+
+	return targets, nil
+}
+
+// walkUploadDir returns every regular file below dir with its relativePath set
+func walkUploadDir(dir string) ([]UploadTarget, error) {
+	// Use the directory's own name as the root of relativePath, the same way
+	// browsers report webkitRelativePath for a picked folder
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve directory %s: %v", dir, err)
+	}
+	root := filepath.Base(absDir)
+
+	var targets []UploadTarget
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || isStateFile(path) {
+			return nil
+		}
+
+		// Follow symlinks to files, skip sockets, devices and the like
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		targets = append(targets, UploadTarget{
+			Path:         path,
+			RelativePath: filepath.ToSlash(filepath.Join(root, rel)),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk directory %s: %v", dir, err)
+	}
+
+	return targets, nil
+}
+
+// printUploadSummary prints one line per file followed by the overall totals
+func printUploadSummary(out io.Writer, results []UploadResult, elapsed time.Duration) {
+	var totalBytes int64
+	failed := 0
+
+	fmt.Fprintln(out, "\nUpload summary:")
+	for _, result := range results {
+		name := result.Target.Path
+		if result.Target.RelativePath != "" {
+			name = result.Target.RelativePath
+		}
+
+		if result.Err != nil {
+			failed++
+			fmt.Fprintf(out, "  ✗ %s: %v\n", name, result.Err)
+			continue
+		}
+
+		totalBytes += result.Size
+		fmt.Fprintf(out, "  ✓ %s (%s) -> %s\n", name, formatBytes(result.Size), result.UploadURL)
+	}
+
+	fmt.Fprintf(out, "\nUploaded %d/%d files (%s) in %v",
+		len(results)-failed, len(results), formatBytes(totalBytes), elapsed.Round(time.Second))
+	if failed > 0 {
+		fmt.Fprintf(out, ", %d failed", failed)
+	}
+	fmt.Fprintln(out)
+}
+
+// uploadResultsExit returns the exit error for a batch of uploads. A batch
+// stopped by a signal exits with ExitInterrupted, whatever else failed.
+func uploadResultsExit(results []UploadResult) error {
+	failed, interrupted := 0, 0
+	for _, result := range results {
+		switch {
+		case errors.Is(result.Err, errInterrupted):
+			interrupted++
+		case result.Err != nil:
+			failed++
+		}
+	}
+
+	if interrupted > 0 {
+		return cli.NewExitError(fmt.Sprintf("%d of %d uploads interrupted", interrupted, len(results)), ExitInterrupted)
+	}
+	if failed > 0 {
+		return cli.NewExitError(fmt.Sprintf("%d of %d uploads failed", failed, len(results)), 1)
+	}
+	return nil
+}
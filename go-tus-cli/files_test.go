@@ -0,0 +1,166 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/urfave/cli/v2"
+)
+
+// createTestTree creates files below a temporary directory and returns its path
+func createTestTree(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	return dir
+}
+
+func TestCollectUploadTargetsDirectory(t *testing.T) {
+	root := createTestTree(t, map[string]string{
+		"dataset/a.txt":            "a",
+		"dataset/sub/b.csv":        "b",
+		"dataset/sub/deep/c.bin":   "c",
+		"dataset/.tusc_abcd.json":  "{}",
+		"other/ignored-by-arg.txt": "x",
+	})
+
+	targets, err := collectUploadTargets([]string{filepath.Join(root, "dataset")})
+	if err != nil {
+		t.Fatalf("collectUploadTargets failed: %v", err)
+	}
+
+	got := make(map[string]string)
+	for _, target := range targets {
+		rel, _ := filepath.Rel(root, target.Path)
+		got[filepath.ToSlash(rel)] = target.RelativePath
+	}
+
+	expected := map[string]string{
+		"dataset/a.txt":          "dataset/a.txt",
+		"dataset/sub/b.csv":      "dataset/sub/b.csv",
+		"dataset/sub/deep/c.bin": "dataset/sub/deep/c.bin",
+	}
+
+	if len(got) != len(expected) {
+		t.Fatalf("Expected %d targets, got %d: %v", len(expected), len(got), got)
+	}
+	for path, relativePath := range expected {
+		if got[path] != relativePath {
+			t.Errorf("Expected relativePath %q for %s, got %q", relativePath, path, got[path])
+		}
+	}
+}
+
+func TestCollectUploadTargetsGlob(t *testing.T) {
+	root := createTestTree(t, map[string]string{
+		"logs/app.1.gz": "1",
+		"logs/app.2.gz": "2",
+		"logs/app.log":  "3",
+	})
+
+	pattern := filepath.Join(root, "logs", "*.gz")
+	targets, err := collectUploadTargets([]string{pattern, filepath.Join(root, "logs", "app.1.gz")})
+	if err != nil {
+		t.Fatalf("collectUploadTargets failed: %v", err)
+	}
+
+	var names []string
+	for _, target := range targets {
+		if target.RelativePath != "" {
+			t.Errorf("Expected empty relativePath for glob match %s, got %q", target.Path, target.RelativePath)
+		}
+		names = append(names, filepath.Base(target.Path))
+	}
+	sort.Strings(names)
+
+	if len(names) != 2 || names[0] != "app.1.gz" || names[1] != "app.2.gz" {
+		t.Errorf("Expected [app.1.gz app.2.gz] without duplicates, got %v", names)
+	}
+}
+
+func TestCollectUploadTargetsErrors(t *testing.T) {
+	root := t.TempDir()
+
+	if _, err := collectUploadTargets([]string{filepath.Join(root, "missing.txt")}); err == nil {
+		t.Error("Expected error for missing file")
+	}
+	if _, err := collectUploadTargets([]string{filepath.Join(root, "*.nothing")}); err == nil {
+		t.Error("Expected error for glob without matches")
+	}
+	if _, err := collectUploadTargets([]string{root}); err == nil {
+		t.Error("Expected error for empty directory")
+	}
+}
+
+func TestCreateFileMetadataRelativePath(t *testing.T) {
+	metadata := createFileMetadata("/tmp/data/report.csv", "")
+	if metadata["relativePath"] != "null" {
+		t.Errorf("Expected relativePath 'null', got %q", metadata["relativePath"])
+	}
+
+	metadata = createFileMetadata("/tmp/data/sub/report.csv", "data/sub/report.csv")
+	if metadata["relativePath"] != "data/sub/report.csv" {
+		t.Errorf("Expected relativePath 'data/sub/report.csv', got %q", metadata["relativePath"])
+	}
+	if metadata["filename"] != "report.csv" {
+		t.Errorf("Expected filename 'report.csv', got %q", metadata["filename"])
+	}
+}
+
+func TestUploadCommandDirectory(t *testing.T) {
+	mockServer := NewStatefulTUSServer()
+	defer mockServer.Close()
+
+	root := createTestTree(t, map[string]string{
+		"photos/one.jpg":      "first file",
+		"photos/2024/two.jpg": "second file",
+	})
+
+	// State files are written to the working directory
+	wd, _ := os.Getwd()
+	os.Chdir(t.TempDir())
+	defer os.Chdir(wd)
+
+	app := &cli.App{
+		Flags: []cli.Flag{
+			&cli.StringFlag{Name: "endpoint", Aliases: []string{"t"}},
+			&cli.Int64Flag{Name: "chunk-size", Aliases: []string{"c"}, Value: 2},
+			&cli.IntFlag{Name: "retries", Aliases: []string{"r"}, Value: 0},
+		},
+		Action: uploadCommand,
+	}
+
+	err := app.Run([]string{"tusc", "-t", mockServer.URL(), filepath.Join(root, "photos")})
+	if err != nil {
+		t.Fatalf("Directory upload failed: %v", err)
+	}
+
+	uploads := mockServer.Uploads()
+	if len(uploads) != 2 {
+		t.Fatalf("Expected 2 uploads, got %d", len(uploads))
+	}
+
+	relativePaths := make(map[string]string)
+	for _, upload := range uploads {
+		if upload.Offset != upload.Size {
+			t.Errorf("Expected upload to be complete (offset=%d), got offset=%d", upload.Size, upload.Offset)
+		}
+		relativePaths[upload.Metadata["relativePath"]] = string(upload.Data)
+	}
+
+	if relativePaths["photos/one.jpg"] != "first file" {
+		t.Errorf("Missing or wrong upload for photos/one.jpg: %v", relativePaths)
+	}
+	if relativePaths["photos/2024/two.jpg"] != "second file" {
+		t.Errorf("Missing or wrong upload for photos/2024/two.jpg: %v", relativePaths)
+	}
+}
@@ -7,6 +7,7 @@ toolchain go1.24.1
 require (
 	github.com/bdragon300/tusgo v0.1.2
 	github.com/urfave/cli/v2 v2.27.5
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
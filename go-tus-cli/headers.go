@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+)
+
+// headerTransport adds the configured headers (profile headers, -H and
+// TUSC_HEADERS) to every request. Headers the request sets itself, like the
+// tus headers, win.
+type headerTransport struct {
+	base    http.RoundTripper
+	headers map[string]string
+}
+
+// headerHTTPClient makes client send headers with its requests. It wraps the
+// auth and signing transports, so those see and sign the headers.
+func headerHTTPClient(client *http.Client, headers map[string]string) *http.Client {
+	if len(headers) == 0 {
+		return client
+	}
+
+	base := client.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	client.Transport = &headerTransport{base: base, headers: headers}
+	return client
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	withHeaders := req.Clone(req.Context())
+	for key, value := range t.headers {
+		if withHeaders.Header.Get(key) == "" {
+			withHeaders.Header.Set(key, value)
+		}
+	}
+	return t.base.RoundTrip(withHeaders)
+}
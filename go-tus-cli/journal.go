@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// StateVersion is the schema version of state files. Files without a version
+// were written before versioning and are read as version 1.
+const StateVersion = 1
+
+// JournalCompactInterval is how many journal entries are appended before they
+// are folded into the state file
+const JournalCompactInterval = 256
+
+// errNewerState is returned for state files with a schema newer than StateVersion
+var errNewerState = errors.New("state file was written by a newer tusc")
+
+// JournalEntry records one offset acknowledged by the server
+type JournalEntry struct {
+	UploadURL string `json:"upload_url"`
+	Offset    int64  `json:"offset"`
+}
+
+// getJournalFilePath returns the path to the offset journal for a given file ID
+func getJournalFilePath(fileID string) string {
+	return filepath.Join(uploadStateDir(), fileID+".journal")
+}
+
+// writeFileAtomic replaces path with data so that a crash leaves either the
+// old or the new content, never a torn file
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	// Persist the rename itself, not supported on every platform
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// appendJournal durably records an offset the server acknowledged for
+// uploadURL. Entries are folded into the state file on its next save.
+func appendJournal(fileID, uploadURL string, offset int64) error {
+	line, err := json.Marshal(JournalEntry{UploadURL: uploadURL, Offset: offset})
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(getJournalFilePath(fileID), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open journal: %v", err)
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write journal: %v", err)
+	}
+	return file.Sync()
+}
+
+// replayJournal applies the journaled offsets to state. A torn last line left
+// by a crash is ignored, everything before it was synced.
+func replayJournal(state *UploadState) error {
+	file, err := os.Open(getJournalFilePath(state.FileID))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read journal: %v", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry JournalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			break
+		}
+		applyJournalEntry(state, entry)
+	}
+	return nil
+}
+
+// applyJournalEntry moves the acknowledged offset of the upload entry refers to forward
+func applyJournalEntry(state *UploadState, entry JournalEntry) {
+	if entry.UploadURL == state.UploadURL {
+		state.UploadOffset = max(state.UploadOffset, entry.Offset)
+		return
+	}
+	for i := range state.Parts {
+		if entry.UploadURL == state.Parts[i].UploadURL {
+			state.Parts[i].UploadOffset = max(state.Parts[i].UploadOffset, entry.Offset)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "state.json")
+
+	if err := writeFileAtomic(path, []byte("first"), 0600); err != nil {
+		t.Fatalf("writeFileAtomic failed: %v", err)
+	}
+	if err := writeFileAtomic(path, []byte("second"), 0600); err != nil {
+		t.Fatalf("writeFileAtomic failed: %v", err)
+	}
+
+	data, _ := os.ReadFile(path)
+	if string(data) != "second" {
+		t.Errorf("Expected replaced content, got %q", data)
+	}
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("Expected no temporary files to be left behind, got %d entries", len(entries))
+	}
+}
+
+func TestJournalReplay(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+
+	path := filepath.Join(t.TempDir(), "data.bin")
+	os.WriteFile(path, []byte("journaled upload"), 0644)
+	fileID := saveTestState(t, path, "http://localhost:1080/files", "http://localhost:1080/files/abc")
+
+	appendJournal(fileID, "http://localhost:1080/files/abc", 4)
+	appendJournal(fileID, "http://localhost:1080/files/abc", 8)
+	appendJournal(fileID, "http://localhost:1080/files/other", 16)
+
+	// Simulate a crash in the middle of the next append
+	journal, _ := os.OpenFile(getJournalFilePath(fileID), os.O_WRONLY|os.O_APPEND, 0600)
+	journal.WriteString(`{"upload_url":"http://localhost:1080/files/abc","off`)
+	journal.Close()
+
+	state, err := loadUploadState(fileID)
+	if err != nil {
+		t.Fatalf("loadUploadState failed: %v", err)
+	}
+	if state.UploadOffset != 8 {
+		t.Errorf("Expected journaled offset 8, got %d", state.UploadOffset)
+	}
+
+	// Saving folds the journal into the state file
+	if err := saveUploadState(state); err != nil {
+		t.Fatalf("saveUploadState failed: %v", err)
+	}
+	if _, err := os.Stat(getJournalFilePath(fileID)); !os.IsNotExist(err) {
+		t.Error("Journal should be removed once compacted")
+	}
+	if info, err := os.Stat(getStateFilePath(fileID)); err == nil && runtime.GOOS != "windows" && info.Mode().Perm() != 0600 {
+		t.Errorf("Expected state file mode 0600, got %o", info.Mode().Perm())
+	}
+
+	state, _ = loadUploadState(fileID)
+	if state.UploadOffset != 8 || state.Version != StateVersion {
+		t.Errorf("Expected offset 8 at version %d, got %d at version %d", StateVersion, state.UploadOffset, state.Version)
+	}
+}
+
+func TestJournalReplayParts(t *testing.T) {
+	state := &UploadState{
+		UploadURL: "http://localhost:1080/files/final",
+		Parts: []PartState{
+			{UploadURL: "http://localhost:1080/files/p1", UploadOffset: 10},
+			{UploadURL: "http://localhost:1080/files/p2"},
+		},
+	}
+
+	applyJournalEntry(state, JournalEntry{UploadURL: "http://localhost:1080/files/p1", Offset: 5})
+	applyJournalEntry(state, JournalEntry{UploadURL: "http://localhost:1080/files/p2", Offset: 7})
+
+	if state.Parts[0].UploadOffset != 10 {
+		t.Errorf("A stale entry must not move the offset back, got %d", state.Parts[0].UploadOffset)
+	}
+	if state.Parts[1].UploadOffset != 7 {
+		t.Errorf("Expected part offset 7, got %d", state.Parts[1].UploadOffset)
+	}
+}
+
+func TestLoadUploadStateNewerVersion(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+
+	os.MkdirAll(uploadStateDir(), 0700)
+	os.WriteFile(getStateFilePath("future"), []byte(`{"version": 99, "file_id": "future"}`), 0600)
+
+	_, err := loadUploadState("future")
+	if err == nil || !strings.Contains(err.Error(), "newer tusc") {
+		t.Errorf("Expected a newer version to be rejected, got: %v", err)
+	}
+
+	states, _ := loadUploadStates()
+	if len(states) != 0 {
+		t.Errorf("Expected the newer state to be skipped, got %d states", len(states))
+	}
+}
+
+func TestUploadFileRemovesJournal(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+
+	mockServer := NewStatefulTUSServer()
+	defer mockServer.Close()
+
+	testFile := createTestFile(t, strings.Repeat("journal ", 10000))
+	defer os.Remove(testFile)
+
+	config := &Config{Endpoint: mockServer.URL(), ChunkSize: DefaultChunkSize, Headers: make(map[string]string)}
+	if _, err := uploadFile(config, UploadTarget{Path: testFile}); err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+
+	// A finished upload leaves neither state nor journal behind
+	entries, _ := os.ReadDir(uploadStateDir())
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), ".json") || strings.HasSuffix(entry.Name(), ".journal") {
+			t.Errorf("Unexpected leftover %s", entry.Name())
+		}
+	}
+}
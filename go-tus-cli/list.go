@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+func listCommand(c *cli.Context) error {
+	config, err := parseClientConfig(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	if err := listUploads(config); err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	return nil
+}
+
+// listUploads prints every pending upload in the state directory with its
+// progress as reported by the server
+func listUploads(config *Config) error {
+	states, err := loadUploadStates()
+	if err != nil {
+		return fmt.Errorf("failed to read state directory: %v", err)
+	}
+
+	if len(states) == 0 {
+		fmt.Fprintln(config.output(), "No pending uploads")
+		return nil
+	}
+
+	httpClient := config.httpClient()
+
+	now := time.Now()
+	for _, state := range states {
+		fmt.Fprintf(config.output(), "➤ %s (%s)\n", state.FilePath, formatBytes(state.FileSize))
+
+		if err := checkResumable(state, now); err != nil {
+			fmt.Fprintf(config.output(), "  not resumable: %v\n", err)
+		} else if status, err := stateStatus(config, httpClient, state); err != nil {
+			fmt.Fprintf(config.output(), "  server status unavailable: %v\n", err)
+		} else {
+			line := fmt.Sprintf("  %s of %s uploaded", formatBytes(status.Offset), formatBytes(state.FileSize))
+			if state.FileSize > 0 {
+				line += fmt.Sprintf(" (%.1f%%)", float64(status.Offset)/float64(state.FileSize)*100)
+			}
+			if expiresAt := stateExpiresAt(state); expiresAt != nil {
+				line += fmt.Sprintf(", expires in %v", expiresAt.Sub(now).Round(time.Second))
+			}
+			fmt.Fprintln(config.output(), line)
+		}
+
+		for _, uploadURL := range stateUploadURLs(state) {
+			fmt.Fprintf(config.output(), "  ↳ %s\n", uploadURL)
+		}
+	}
+
+	return nil
+}
+
+func resumeCommand(c *cli.Context) error {
+	if !c.Bool("all") {
+		return cli.NewExitError("Please pass --all to resume every pending upload", 1)
+	}
+	if c.NArg() > 0 {
+		return cli.NewExitError("resume --all takes no arguments", 1)
+	}
+
+	config, err := parseClientConfig(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	defer watchSignals(config)()
+
+	start := time.Now()
+	results, err := resumeAllUploads(config)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	if len(results) == 0 {
+		fmt.Fprintln(config.output(), "No resumable uploads")
+		return nil
+	}
+
+	printUploadSummary(config.output(), results, time.Since(start))
+	return uploadResultsExit(results)
+}
+
+// resumeAllUploads continues every resumable upload in the state directory.
+// Each upload goes to the endpoint it was started against, so uploads are
+// grouped per endpoint and each group runs through the worker pool.
+func resumeAllUploads(config *Config) ([]UploadResult, error) {
+	states, err := loadUploadStates()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state directory: %v", err)
+	}
+
+	now := time.Now()
+	var endpoints []string
+	targets := make(map[string][]UploadTarget)
+	for _, state := range states {
+		if err := checkResumable(state, now); err != nil {
+			fmt.Fprintf(config.output(), "Skipping %s: %v\n", state.FilePath, err)
+			continue
+		}
+
+		if _, ok := targets[state.Endpoint]; !ok {
+			endpoints = append(endpoints, state.Endpoint)
+		}
+		targets[state.Endpoint] = append(targets[state.Endpoint], UploadTarget{Path: state.FilePath})
+	}
+
+	var results []UploadResult
+	for _, endpoint := range endpoints {
+		endpointConfig := *config
+		endpointConfig.Endpoint = endpoint
+
+		fmt.Fprintf(config.output(), "Resuming %d upload(s) to %s...\n", len(targets[endpoint]), endpoint)
+		results = append(results, uploadTargets(&endpointConfig, targets[endpoint])...)
+	}
+
+	return results, nil
+}
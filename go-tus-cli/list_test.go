@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResumeAllUploads(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+
+	mockServer := NewStatefulTUSServer()
+	defer mockServer.Close()
+
+	dir := t.TempDir()
+	contents := map[string][]byte{
+		filepath.Join(dir, "a.txt"): []byte("first pending upload"),
+		filepath.Join(dir, "b.txt"): []byte("second pending upload"),
+	}
+
+	urls := make(map[string]string)
+	for path, content := range contents {
+		os.WriteFile(path, content, 0644)
+		urls[path] = createServerUpload(t, mockServer, int64(len(content)))
+		saveTestState(t, path, mockServer.URL(), urls[path])
+	}
+
+	// A file changed after its upload started must be skipped
+	changed := filepath.Join(dir, "changed.txt")
+	os.WriteFile(changed, []byte("old"), 0644)
+	saveTestState(t, changed, mockServer.URL(), createServerUpload(t, mockServer, 3))
+	os.WriteFile(changed, []byte("new content"), 0644)
+
+	// The endpoint comes from the state, not from the flags
+	config := &Config{ChunkSize: DefaultChunkSize, Headers: make(map[string]string), Jobs: 2}
+	results, err := resumeAllUploads(config)
+	if err != nil {
+		t.Fatalf("resumeAllUploads failed: %v", err)
+	}
+
+	if len(results) != 2 {
+		t.Fatalf("Expected 2 resumed uploads, got %d", len(results))
+	}
+	for _, result := range results {
+		if result.Err != nil {
+			t.Errorf("Resume of %s failed: %v", result.Target.Path, result.Err)
+		}
+		if result.UploadURL != urls[result.Target.Path] {
+			t.Errorf("Expected %s to continue %s, got %s", result.Target.Path, urls[result.Target.Path], result.UploadURL)
+		}
+	}
+
+	for id, upload := range mockServer.Uploads() {
+		for path, content := range contents {
+			if urls[path] == mockServer.URL()+"/"+id && !bytes.Equal(upload.Data, content) {
+				t.Errorf("Server data for %s doesn't match", path)
+			}
+		}
+	}
+
+	states, _ := loadUploadStates()
+	if len(states) != 1 || states[0].FilePath != changed {
+		t.Errorf("Only the skipped upload should keep its state, got %d states", len(states))
+	}
+}
+
+func TestListUploads(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+
+	mockServer := NewStatefulTUSServer()
+	defer mockServer.Close()
+
+	config := &Config{Headers: make(map[string]string)}
+	if err := listUploads(config); err != nil {
+		t.Fatalf("listUploads without state failed: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "pending.txt")
+	os.WriteFile(path, []byte("pending"), 0644)
+	saveTestState(t, path, mockServer.URL(), createServerUpload(t, mockServer, 7))
+
+	// A state whose upload is gone from the server is still listed
+	gone := filepath.Join(t.TempDir(), "gone.txt")
+	os.WriteFile(gone, []byte("gone"), 0644)
+	saveTestState(t, gone, mockServer.URL(), mockServer.URL()+"/missing")
+
+	if err := listUploads(config); err != nil {
+		t.Fatalf("listUploads failed: %v", err)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLockUploadNoWait(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+
+	lock, err := lockUpload(&Config{}, "abc", "data.bin")
+	if err != nil {
+		t.Fatalf("Failed to take lock: %v", err)
+	}
+
+	_, err = lockUpload(&Config{}, "abc", "data.bin")
+	if err == nil {
+		t.Fatal("Expected the second lock to fail")
+	}
+	if !strings.Contains(err.Error(), "PID "+strconv.Itoa(os.Getpid())) {
+		t.Errorf("Expected the holder's PID in the error, got: %v", err)
+	}
+
+	// Other uploads are not affected
+	other, err := lockUpload(&Config{}, "def", "other.bin")
+	if err != nil {
+		t.Fatalf("Failed to lock another upload: %v", err)
+	}
+	other.Unlock()
+
+	lock.Unlock()
+	if _, err := os.Stat(getLockFilePath("abc")); !os.IsNotExist(err) {
+		t.Error("Lock file should have been removed on release")
+	}
+
+	lock, err = lockUpload(&Config{}, "abc", "data.bin")
+	if err != nil {
+		t.Fatalf("Failed to take released lock: %v", err)
+	}
+	lock.Unlock()
+}
+
+func TestLockUploadWait(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+
+	lock, err := lockUpload(&Config{}, "abc", "data.bin")
+	if err != nil {
+		t.Fatalf("Failed to take lock: %v", err)
+	}
+
+	acquired := make(chan error)
+	go func() {
+		waiting, err := lockUpload(&Config{Wait: true}, "abc", "data.bin")
+		if err == nil {
+			waiting.Unlock()
+		}
+		acquired <- err
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("Waiting lock must not be granted while the lock is held")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	lock.Unlock()
+
+	select {
+	case err := <-acquired:
+		if err != nil {
+			t.Errorf("Waiting lock failed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Waiting lock was not granted after release")
+	}
+}
+
+func TestLockUploadWaitCancelled(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+
+	lock, err := lockUpload(&Config{}, "abc", "data.bin")
+	if err != nil {
+		t.Fatalf("Failed to take lock: %v", err)
+	}
+	defer lock.Unlock()
+
+	// Like Ctrl-C while waiting
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := lockUpload(&Config{Wait: true, ctx: ctx}, "abc", "data.bin"); !errors.Is(err, errInterrupted) {
+		t.Errorf("Expected the wait to be interrupted, got: %v", err)
+	}
+}
+
+func TestUploadFileLocked(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+
+	mockServer := NewStatefulTUSServer()
+	defer mockServer.Close()
+
+	testFile := createTestFile(t, "locked upload")
+	defer os.Remove(testFile)
+
+	fileInfo, _ := os.Stat(testFile)
+	lock, err := lockUpload(&Config{}, generateFileID(testFile, fileInfo), testFile)
+	if err != nil {
+		t.Fatalf("Failed to take lock: %v", err)
+	}
+	defer lock.Unlock()
+
+	config := &Config{Endpoint: mockServer.URL(), ChunkSize: DefaultChunkSize, Headers: make(map[string]string)}
+	if _, err := uploadFile(config, UploadTarget{Path: testFile}); err == nil {
+		t.Fatal("Expected the upload of a locked file to fail")
+	}
+
+	if len(mockServer.Uploads()) != 0 {
+		t.Error("A locked upload must not reach the server")
+	}
+}
@@ -0,0 +1,50 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	kernel32         = syscall.NewLazyDLL("kernel32.dll")
+	procLockFileEx   = kernel32.NewProc("LockFileEx")
+	procUnlockFileEx = kernel32.NewProc("UnlockFileEx")
+)
+
+const (
+	lockfileFailImmediately = 0x1
+	lockfileExclusiveLock   = 0x2
+	errorLockViolation      = syscall.Errno(33)
+)
+
+// tryLockFile takes an exclusive LockFileEx lock without blocking
+func tryLockFile(file *os.File) error {
+	err := lockFileEx(file, lockfileExclusiveLock|lockfileFailImmediately)
+	if err == errorLockViolation {
+		return errLockHeld
+	}
+	return err
+}
+
+func unlockFile(file *os.File) error {
+	overlapped := syscall.Overlapped{OffsetHigh: 1}
+	r, _, err := procUnlockFileEx.Call(file.Fd(), 0, 1, 0, uintptr(unsafe.Pointer(&overlapped)))
+	if r == 0 {
+		return err
+	}
+	return nil
+}
+
+// lockFileEx locks one byte at 4 GiB. Windows locks are mandatory, locking
+// past the end keeps the holder's PID at the start readable.
+func lockFileEx(file *os.File, flags uintptr) error {
+	overlapped := syscall.Overlapped{OffsetHigh: 1}
+	r, _, err := procLockFileEx.Call(file.Fd(), flags, 0, 1, 0, uintptr(unsafe.Pointer(&overlapped)))
+	if r == 0 {
+		return err
+	}
+	return nil
+}
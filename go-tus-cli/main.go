@@ -1,13 +1,13 @@
 package main
 
 import (
-	"crypto/md5"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"mime"
-	"net"
 	"net/http"
 	"net/url"
 	"os"
@@ -34,34 +34,105 @@ type Config struct {
 	Headers   map[string]string
 	Retries   int
 	Verbose   bool
+	Jobs      int
+	Parallel  int
+	Wait      bool // Wait for other tusc processes working on the same upload
+
+	// AdaptiveChunks grows or shrinks ChunkSize per upload with the measured throughput
+	AdaptiveChunks bool
+
+	// Retry is the retry policy, the default policy with Retries per error class when nil
+	Retry *RetryPolicy
+
+	// Metadata is sent with every upload, below the metadata derived from the file
+	Metadata map[string]string
+
+	// HTTPClient is shared by all uploads of a run, a new one is created when nil
+	HTTPClient *http.Client
+
+	progress *AggregateProgress
+	events   *EventWriter    // Set for --output json
+	out      io.Writer       // Text for humans, stdout unless events own it, see output
+	file     string          // File the events of an upload are about
+	part     int             // Part of a --parallel upload the events are about
+	ctx      context.Context // Cancelled by SIGINT and SIGTERM, see watchSignals
+	pause    *PauseGate
+}
+
+// output returns where text for humans goes: out, or stdout when it is nil
+func (c *Config) output() io.Writer {
+	if c.out == nil {
+		return os.Stdout
+	}
+	return c.out
+}
+
+// httpClient returns the client shared by the run, or a new one sending the
+// configured headers when there is none
+func (c *Config) httpClient() *http.Client {
+	if c.HTTPClient == nil {
+		return headerHTTPClient(newHTTPClient(1, nil), c.Headers)
+	}
+	return c.HTTPClient
+}
+
+// progressKey names the upload of config in the aggregate progress, the
+// file and for --parallel uploads the part
+func (c *Config) progressKey() string {
+	return fmt.Sprintf("%s#%d", c.file, c.part)
+}
+
+// runContext returns the context transfers of the run are cancelled with
+func (c *Config) runContext() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
 }
 
 // UploadState represents the state of an upload for resumption
 type UploadState struct {
-	FileID      string            `json:"file_id"`
-	FilePath    string            `json:"file_path"`
-	FileSize    int64             `json:"file_size"`
-	FileModTime time.Time         `json:"file_mod_time"`
-	UploadURL   string            `json:"upload_url"`
-	Metadata    map[string]string `json:"metadata"`
-	Endpoint    string            `json:"endpoint"`
-	CreatedAt   time.Time         `json:"created_at"`
+	Version      int               `json:"version"` // Schema version, see StateVersion
+	FileID       string            `json:"file_id"`
+	FilePath     string            `json:"file_path"`
+	FileSize     int64             `json:"file_size"`
+	FileModTime  time.Time         `json:"file_mod_time"`
+	UploadURL    string            `json:"upload_url"`
+	UploadOffset int64             `json:"upload_offset,omitempty"` // Last offset the server acknowledged
+	Metadata     map[string]string `json:"metadata"`
+	Endpoint     string            `json:"endpoint"`
+	CreatedAt    time.Time         `json:"created_at"`
+	ExpiresAt    *time.Time        `json:"expires_at,omitempty"` // Upload-Expires announced by the server
+	Parts        []PartState       `json:"parts,omitempty"`      // Partial uploads of a --parallel upload
 }
 
 // ProgressWriter wraps an io.Writer to provide upload progress feedback
 type ProgressWriter struct {
 	writer     io.Writer
+	out        io.Writer // where the progress line goes
 	total      int64
 	written    int64
 	lastUpdate time.Time
 	filename   string
+	verb       string // "Uploading" or "Downloading"
+	aggregate  *AggregateProgress
+	upload     string // key of the upload in aggregate
+	offset     int64  // remote offset the writer starts at
+	onWrite    func() // called after every successful write to the stream
+
+	// onProgress, if set, reports progress every second instead of the
+	// progress line, with the bytes written and the rate since the last report
+	onProgress  func(written, rate int64)
+	lastWritten int64
 }
 
 func NewProgressWriter(w io.Writer, total int64, filename string) *ProgressWriter {
 	return &ProgressWriter{
 		writer:     w,
+		out:        os.Stdout,
 		total:      total,
 		filename:   filepath.Base(filename),
+		verb:       "Uploading",
 		lastUpdate: time.Now(),
 	}
 }
@@ -73,24 +144,60 @@ func (pw *ProgressWriter) Write(p []byte) (int, error) {
 	}
 
 	pw.written += int64(n)
+
+	if pw.onWrite != nil {
+		pw.onWrite()
+	}
+
+	// Concurrent uploads report through the shared status line
+	if pw.aggregate != nil {
+		pw.aggregate.Sent(int64(n))
+		pw.aggregate.Acknowledge(pw.upload, pw.offset+pw.written)
+		return n, err
+	}
+
 	now := time.Now()
 
 	// Update progress every second
-	if now.Sub(pw.lastUpdate) >= time.Second {
-		percentage := float64(pw.written) / float64(pw.total) * 100
-		fmt.Printf("\rUploading %s: %.1f%% (%s/%s)",
+	if now.Sub(pw.lastUpdate) < time.Second {
+		return n, err
+	}
+
+	if pw.onProgress != nil {
+		rate := float64(pw.written-pw.lastWritten) / now.Sub(pw.lastUpdate).Seconds()
+		pw.onProgress(pw.written, int64(rate))
+	} else {
+		percentage := 100.0
+		if pw.total > 0 {
+			percentage = float64(pw.written) / float64(pw.total) * 100
+		}
+		fmt.Fprintf(pw.out, "\r%s %s: %.1f%% (%s/%s)",
+			pw.verb,
 			pw.filename,
 			percentage,
 			formatBytes(pw.written),
 			formatBytes(pw.total))
-		pw.lastUpdate = now
 	}
+	pw.lastUpdate = now
+	pw.lastWritten = pw.written
 
 	return n, err
 }
 
-// uploadWithRetry implements retry logic similar to tus-go-client examples
-func uploadWithRetry(stream *tusgo.UploadStream, file *os.File, config *Config) (err error) {
+// uploadWithRetry implements retry logic similar to tus-go-client examples.
+// onPatch, if not nil, is called after every PATCH the server acknowledged.
+func uploadWithRetry(stream *tusgo.UploadStream, file *os.File, config *Config, onPatch func()) error {
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to get file info: %v", err)
+	}
+
+	return uploadReaderWithRetry(stream, file, fileInfo.Size(), file.Name(), config, onPatch)
+}
+
+// uploadReaderWithRetry uploads size bytes from src, which must be positioned
+// like the stream: offset 0 of src is offset 0 of the remote upload
+func uploadReaderWithRetry(stream *tusgo.UploadStream, src io.ReadSeeker, size int64, name string, config *Config, onPatch func()) (err error) {
 	// Add panic recovery for the upload process
 	defer func() {
 		if r := recover(); r != nil {
@@ -100,145 +207,203 @@ func uploadWithRetry(stream *tusgo.UploadStream, file *os.File, config *Config)
 
 	// Set stream and file pointer to be equal to the remote pointer
 	// (if we resume the upload that was interrupted earlier)
-	_, err = stream.Sync()
+	err = withRetry(config, func() error { return syncStream(stream) })
 	if err != nil {
-		return fmt.Errorf("failed to sync with server: %v", err)
+		return fmt.Errorf("failed to sync with server: %w", err)
 	}
 
 	currentOffset := stream.Tell()
-	if _, err = file.Seek(currentOffset, io.SeekStart); err != nil {
+	if _, err = src.Seek(currentOffset, io.SeekStart); err != nil {
 		return fmt.Errorf("failed to seek file: %v", err)
 	}
 
-	fileInfo, err := file.Stat()
-	if err != nil {
-		return fmt.Errorf("failed to get file info: %v", err)
+	// newProgressWriter reports the bytes sent after offset
+	newProgressWriter := func(offset int64) *ProgressWriter {
+		pw := NewProgressWriter(stream, size-offset, name)
+		pw.out = config.output()
+		pw.aggregate = config.progress
+		pw.upload = config.progressKey()
+		pw.offset = offset
+		pw.onWrite = onPatch
+		if config.events != nil {
+			pw.onProgress = func(written, rate int64) {
+				config.emit(Event{Event: "progress", UploadURL: stream.Upload.Location, Offset: offset + written, Size: size, Rate: rate})
+			}
+		}
+		return pw
 	}
+	progressWriter := newProgressWriter(currentOffset)
 
-	remainingBytes := fileInfo.Size() - currentOffset
-	progressWriter := NewProgressWriter(stream, remainingBytes, file.Name())
+	if currentOffset > 0 {
+		config.emit(Event{Event: "resumed", UploadURL: stream.Upload.Location, Offset: currentOffset, Size: size})
+	}
 
 	// Start upload with retry logic
-	if currentOffset > 0 {
-		fmt.Printf("Resuming upload from %s...\n", formatBytes(currentOffset))
+	if config.progress != nil {
+		config.progress.Acknowledge(config.progressKey(), currentOffset)
+	} else if currentOffset > 0 {
+		fmt.Fprintf(config.output(), "Resuming upload from %s...\n", formatBytes(currentOffset))
 	} else {
-		fmt.Printf("Uploading %s...\n", filepath.Base(file.Name()))
+		fmt.Fprintf(config.output(), "Uploading %s...\n", filepath.Base(name))
 	}
 
 	start := time.Now()
-	written, err := io.Copy(progressWriter, file)
-
-	// Retry logic based on tus-go-client examples
-	attempts := config.Retries
-	for err != nil && attempts > 0 {
-		// Check if it's a retryable error
-		if !isRetryableError(err) {
-			return fmt.Errorf("upload failed with permanent error: %v", err)
+	sizer := NewChunkSizer(config)
+	written, err := copyChunks(progressWriter, stream, src, sizer, config)
+
+	// Retry following the retry policy, every error class has its own budget
+	ctx := config.runContext()
+	retrier := newRetrier(config)
+	for err != nil {
+		// A signal stopped the transfer, the caller records the offset
+		if ctx.Err() != nil {
+			return errInterrupted
 		}
 
-		if config.Verbose {
-			fmt.Printf("\nUpload failed, retrying... (%d attempts left): %v\n", attempts, err)
+		// A retry that made progress ends the streak of failures
+		if written > 0 {
+			retrier.Success()
+			written = 0
 		}
 
-		// Exponential backoff: 1s, 2s, 4s, 8s, etc.
-		backoffDuration := time.Duration(1<<(config.Retries-attempts)) * time.Second
-		if config.Verbose {
-			fmt.Printf("Waiting %v before retry...\n", backoffDuration)
+		if errors.Is(err, errChunkTooLarge) {
+			// A rejected chunk size is retried right away with the smaller size
+			if config.Verbose {
+				fmt.Fprintf(config.output(), "\n%v, retrying with %s chunks\n", err, formatBytes(sizer.Size()))
+			}
+		} else {
+			action := retryAction(err)
+			delay, ok := retrier.Next(err)
+			switch {
+			case action == FailFast:
+				return fmt.Errorf("upload failed with permanent error: %w", err)
+			case action == Recreate:
+				return fmt.Errorf("upload no longer exists on the server: %w", err)
+			case !ok:
+				return fmt.Errorf("upload failed after %d retry attempts: %w", retrier.Attempts(), err)
+			}
+
+			config.emitRetry(retrier.Attempts(), delay, err)
+			if config.Verbose {
+				fmt.Fprintf(config.output(), "\nUpload failed (%s, retry %d), retrying in %v: %v\n",
+					action, retrier.Attempts(), delay.Round(time.Millisecond), err)
+			}
+			if sleepContext(ctx, delay) != nil {
+				return errInterrupted
+			}
 		}
-		time.Sleep(backoffDuration)
 
 		// Re-sync and seek to current position
-		_, err = stream.Sync()
-		if err != nil {
+		if err = syncStream(stream); err != nil {
 			if config.Verbose {
-				fmt.Printf("Failed to sync during retry: %v\n", err)
+				fmt.Fprintf(config.output(), "Failed to sync during retry: %v\n", err)
 			}
-			attempts--
 			continue
 		}
 
 		currentOffset = stream.Tell()
-		if _, err = file.Seek(currentOffset, io.SeekStart); err != nil {
+		if _, err = src.Seek(currentOffset, io.SeekStart); err != nil {
 			if config.Verbose {
-				fmt.Printf("Failed to seek during retry: %v\n", err)
+				fmt.Fprintf(config.output(), "Failed to seek during retry: %v\n", err)
 			}
-			attempts--
 			continue
 		}
 
 		// Update progress writer for remaining bytes
-		remainingBytes = fileInfo.Size() - currentOffset
-		progressWriter = NewProgressWriter(stream, remainingBytes, file.Name())
+		progressWriter = newProgressWriter(currentOffset)
 
 		if config.Verbose {
-			fmt.Printf("Retrying upload from offset %s...\n", formatBytes(currentOffset))
+			fmt.Fprintf(config.output(), "Retrying upload from offset %s...\n", formatBytes(currentOffset))
 		}
 
 		// Try to resume the transfer again
-		written, err = io.Copy(progressWriter, file)
-		attempts--
-	}
-
-	if attempts == 0 && err != nil {
-		return fmt.Errorf("upload failed after %d retry attempts: %v", config.Retries, err)
+		written, err = copyChunks(progressWriter, stream, src, sizer, config)
 	}
 
 	duration := time.Since(start)
 	totalWritten := currentOffset + written
 
+	// The aggregate status line reports completed files itself
+	if config.progress != nil {
+		return nil
+	}
+
 	// Clear progress line and show completion
-	fmt.Printf("\r✓ Upload completed: %s (%s) in %v\n",
-		filepath.Base(file.Name()),
+	fmt.Fprintf(config.output(), "\r✓ Upload completed: %s (%s) in %v\n",
+		filepath.Base(name),
 		formatBytes(totalWritten),
 		duration.Round(time.Second))
 
 	if config.Verbose {
 		if duration.Seconds() > 0 {
 			avgSpeed := float64(written) / duration.Seconds()
-			fmt.Printf("Average speed: %s/s\n", formatBytes(int64(avgSpeed)))
+			fmt.Fprintf(config.output(), "Average speed: %s/s\n", formatBytes(int64(avgSpeed)))
 		}
 	}
 
 	return nil
 }
 
-// isRetryableError determines if an error is retryable based on tus-go-client patterns
-func isRetryableError(err error) bool {
-	// Network errors are retryable
-	if _, ok := err.(net.Error); ok {
-		return true
-	}
+// copyChunks sends src to the stream, one PATCH per chunk of the size sizer
+// picks, and feeds every PATCH's outcome back to sizer
+func copyChunks(pw *ProgressWriter, stream *tusgo.UploadStream, src io.Reader, sizer *ChunkSizer, config *Config) (int64, error) {
+	var buffer []byte
+	var written int64
 
-	// Checksum mismatch errors are retryable (from tusgo.ErrChecksumMismatch)
-	if errors.Is(err, tusgo.ErrChecksumMismatch) {
-		return true
-	}
+	for {
+		// SIGUSR1 holds the transfer between chunks
+		if err := config.pause.Wait(config.runContext()); err != nil {
+			return written, err
+		}
 
-	// HTTP timeout errors are retryable
-	if strings.Contains(err.Error(), "timeout") {
-		return true
-	}
+		size := sizer.Size()
+		if int64(len(buffer)) < size {
+			buffer = make([]byte, size)
+		}
 
-	// Connection errors are retryable
-	if strings.Contains(err.Error(), "connection") {
-		return true
-	}
+		n, readErr := io.ReadFull(src, buffer[:size])
+		if n > 0 {
+			// One Write is one PATCH as long as it fits the stream's chunk
+			stream.ChunkSize = size
+			stream.LastResponse = nil
 
-	// Server errors (5xx) are retryable
-	if strings.Contains(err.Error(), "server error") ||
-		strings.Contains(err.Error(), "internal server error") ||
-		strings.Contains(err.Error(), "bad gateway") ||
-		strings.Contains(err.Error(), "service unavailable") ||
-		strings.Contains(err.Error(), "gateway timeout") {
-		return true
-	}
+			start := time.Now()
+			w, err := pw.Write(buffer[:n])
+			written += int64(w)
+
+			if err != nil {
+				tooLarge := stream.LastResponse != nil && stream.LastResponse.StatusCode == http.StatusRequestEntityTooLarge
+				if sizer.Failure(tooLarge) && tooLarge {
+					return written, fmt.Errorf("%w: %s", errChunkTooLarge, formatBytes(size))
+				}
+				return written, wrapResponseError(PhasePatch, stream.LastResponse, err)
+			}
 
-	// Short writes might be retryable
-	if strings.Contains(err.Error(), "short write") {
-		return true
+			sizer.Success(int64(n), time.Since(start))
+			if config.Verbose && sizer.Size() != size {
+				fmt.Fprintf(config.output(), "\nChunk size %s -> %s\n", formatBytes(size), formatBytes(sizer.Size()))
+			}
+		}
+
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			return written, nil
+		}
+		if readErr != nil {
+			return written, readErr
+		}
 	}
+}
 
-	return false
+// syncStream fetches the remote offset of the stream's upload
+func syncStream(stream *tusgo.UploadStream) error {
+	resp, err := stream.Sync()
+	return wrapResponseError(PhaseHead, resp, err)
+}
+
+// isRetryableError reports whether a failed request is worth repeating in
+// some way, see retryAction
+func isRetryableError(err error) bool {
+	return retryAction(err) != FailFast
 }
 
 // detectMimeType detects MIME type based on file extension
@@ -252,11 +417,17 @@ func detectMimeType(filePath string) string {
 	return mimeType
 }
 
-// createFileMetadata creates comprehensive metadata for the file
-func createFileMetadata(filePath string) map[string]string {
+// createFileMetadata creates comprehensive metadata for the file.
+// relativePath is sent as "null" when empty, matching what Uppy sends for
+// files that were not picked as part of a folder.
+func createFileMetadata(filePath string, relativePath string) map[string]string {
 	filename := filepath.Base(filePath)
 	mimeType := detectMimeType(filePath)
 
+	if relativePath == "" {
+		relativePath = "null"
+	}
+
 	// Extract file extension for filetype
 	ext := filepath.Ext(filename)
 	if ext != "" {
@@ -269,7 +440,7 @@ func createFileMetadata(filePath string) map[string]string {
 		"type":         mimeType,
 		"filetype":     mimeType, // Send MIME type instead of extension for TUS server Content-Type
 		"fileext":      ext,      // Keep the actual extension in a separate field
-		"relativePath": "null",   // Default to null as we don't have relative path context
+		"relativePath": relativePath,
 		"content-type": mimeType, // Explicit content-type for TUS server
 		"contentType":  mimeType, // Try camelCase version
 	}
@@ -277,31 +448,54 @@ func createFileMetadata(filePath string) map[string]string {
 	return metadata
 }
 
-// generateFileID creates a unique identifier for a file based on path, size, and modification time
+// fileMetadata returns the metadata of filePath with the Metadata of the
+// config added, for keys the file doesn't set itself
+func (c *Config) fileMetadata(filePath string, relativePath string) map[string]string {
+	metadata := createFileMetadata(filePath, relativePath)
+	for key, value := range c.Metadata {
+		if _, ok := metadata[key]; !ok {
+			metadata[key] = value
+		}
+	}
+	return metadata
+}
+
+// generateFileID creates a unique identifier for a file based on its absolute path, size, and modification time
 func generateFileID(filePath string, fileInfo os.FileInfo) string {
-	data := fmt.Sprintf("%s-%d-%d", filePath, fileInfo.Size(), fileInfo.ModTime().Unix())
-	hash := md5.Sum([]byte(data))
-	return fmt.Sprintf("%x", hash)
+	return hashFileID(absPath(filePath), fileInfo)
 }
 
 // getStateFilePath returns the path to the state file for a given file ID
 func getStateFilePath(fileID string) string {
-	return fmt.Sprintf(".tusc_%s.json", fileID)
+	return filepath.Join(uploadStateDir(), fileID+".json")
 }
 
-// saveUploadState saves the upload state to a file
+// saveUploadState atomically saves the upload state to a file and folds the
+// offset journal into it
 func saveUploadState(state *UploadState) error {
 	stateFile := getStateFilePath(state.FileID)
+	if err := replayJournal(state); err != nil {
+		return err
+	}
+
+	state.Version = StateVersion
 	data, err := json.MarshalIndent(state, "", "  ")
 	if err != nil {
 		return fmt.Errorf("failed to marshal state: %v", err)
 	}
 
-	err = os.WriteFile(stateFile, data, 0644)
+	if err := os.MkdirAll(filepath.Dir(stateFile), 0700); err != nil {
+		return fmt.Errorf("failed to create state directory: %v", err)
+	}
+
+	err = writeFileAtomic(stateFile, data, 0600)
 	if err != nil {
 		return fmt.Errorf("failed to write state file: %v", err)
 	}
 
+	// Every journaled offset is in the state file now
+	os.Remove(getJournalFilePath(state.FileID))
+
 	return nil
 }
 
@@ -322,6 +516,14 @@ func loadUploadState(fileID string) (*UploadState, error) {
 		return nil, fmt.Errorf("failed to unmarshal state: %v", err)
 	}
 
+	if state.Version > StateVersion {
+		return nil, fmt.Errorf("%w: %s has version %d, upgrade tusc to resume it", errNewerState, stateFile, state.Version)
+	}
+
+	if err := replayJournal(&state); err != nil {
+		return nil, err
+	}
+
 	return &state, nil
 }
 
@@ -332,13 +534,14 @@ func removeUploadState(fileID string) error {
 	if err != nil && !os.IsNotExist(err) {
 		return fmt.Errorf("failed to remove state file: %v", err)
 	}
+	os.Remove(getJournalFilePath(fileID))
 	return nil
 }
 
 // validateUploadState checks if the stored state is still valid
 func validateUploadState(state *UploadState, filePath string, fileInfo os.FileInfo, endpoint string) bool {
 	// Check if file path matches
-	if state.FilePath != filePath {
+	if state.FilePath != absPath(filePath) {
 		return false
 	}
 
@@ -357,6 +560,11 @@ func validateUploadState(state *UploadState, filePath string, fileInfo os.FileIn
 		return false
 	}
 
+	// Expired uploads are gone from the server, resuming would only fail
+	if isUploadStateExpired(state, time.Now()) {
+		return false
+	}
+
 	return true
 }
 
@@ -372,11 +580,20 @@ func main() {
 		Description: "A simple, clean, and smart TUS (resumable upload) client built with official libraries.",
 		Flags: []cli.Flag{
 			&cli.StringFlag{
-				Name:     "endpoint",
-				Aliases:  []string{"t"},
-				Usage:    "TUS server endpoint URL",
-				EnvVars:  []string{"TUSC_ENDPOINT"},
-				Required: true,
+				Name:    "profile",
+				Usage:   "Profile of the config file to take defaults from (default: its default_profile)",
+				EnvVars: []string{"TUSC_PROFILE"},
+			},
+			&cli.StringFlag{
+				Name:    "config",
+				Usage:   "Config file with profiles (default: ~/.config/tusc/config.yaml)",
+				EnvVars: []string{"TUSC_CONFIG"},
+			},
+			&cli.StringFlag{
+				Name:    "endpoint",
+				Aliases: []string{"t"},
+				Usage:   "TUS server endpoint URL",
+				EnvVars: []string{"TUSC_ENDPOINT"},
 			},
 			&cli.Int64Flag{
 				Name:    "chunk-size",
@@ -385,6 +602,11 @@ func main() {
 				EnvVars: []string{"TUSC_CHUNK_SIZE"},
 				Value:   2,
 			},
+			&cli.BoolFlag{
+				Name:    "adaptive-chunks",
+				Usage:   "Adapt the chunk size to throughput and errors, starting at --chunk-size",
+				EnvVars: []string{"TUSC_ADAPTIVE_CHUNKS"},
+			},
 			&cli.StringSliceFlag{
 				Name:    "header",
 				Aliases: []string{"H"},
@@ -398,6 +620,152 @@ func main() {
 				EnvVars: []string{"TUSC_RETRIES"},
 				Value:   DefaultRetries,
 			},
+			&cli.DurationFlag{
+				Name:    "retry-delay",
+				Usage:   "Longest wait before the first retry, doubled per retry (waits are randomized below it)",
+				EnvVars: []string{"TUSC_RETRY_DELAY"},
+				Value:   DefaultRetryDelay,
+			},
+			&cli.DurationFlag{
+				Name:    "retry-max-delay",
+				Usage:   "Longest wait before any retry",
+				EnvVars: []string{"TUSC_RETRY_MAX_DELAY"},
+				Value:   DefaultRetryMaxDelay,
+			},
+			&cli.DurationFlag{
+				Name:    "retry-max-time",
+				Usage:   "Give up when failures persist this long, e.g. 10m (default: no limit)",
+				EnvVars: []string{"TUSC_RETRY_MAX_TIME"},
+			},
+			&cli.StringFlag{
+				Name:    "retry-budget",
+				Usage:   "Retries per error class, e.g. \"backoff=5,resync=10,resend=3\" (default: --retries each)",
+				EnvVars: []string{"TUSC_RETRY_BUDGET"},
+			},
+			&cli.IntFlag{
+				Name:    "jobs",
+				Aliases: []string{"j"},
+				Usage:   "Number of files to upload concurrently (default: 1, max: 32)",
+				EnvVars: []string{"TUSC_JOBS"},
+				Value:   DefaultJobs,
+			},
+			&cli.IntFlag{
+				Name:    "parallel",
+				Aliases: []string{"p"},
+				Usage:   "Split each file into N partial uploads sent in parallel (requires concatenation extension, max: 16)",
+				EnvVars: []string{"TUSC_PARALLEL"},
+				Value:   DefaultParallel,
+			},
+			&cli.StringFlag{
+				Name:    "limit-rate",
+				Usage:   "Limit the combined upload rate, e.g. 5MB/s, or by time of day: \"08:00-18:00=5MB/s,*=unlimited\"",
+				EnvVars: []string{"TUSC_LIMIT_RATE"},
+			},
+			&cli.BoolFlag{
+				Name:    "wait",
+				Usage:   "Wait for another tusc process uploading the same file instead of failing",
+				EnvVars: []string{"TUSC_WAIT"},
+			},
+			&cli.StringFlag{
+				Name:    "cacert",
+				Usage:   "PEM file with the CA certificates to verify the server with",
+				EnvVars: []string{"TUSC_CACERT"},
+			},
+			&cli.StringFlag{
+				Name:    "cert",
+				Usage:   "PEM file with the client certificate, requires --key",
+				EnvVars: []string{"TUSC_CERT"},
+			},
+			&cli.StringFlag{
+				Name:    "key",
+				Usage:   "PEM file with the private key of --cert",
+				EnvVars: []string{"TUSC_KEY"},
+			},
+			&cli.BoolFlag{
+				Name:    "insecure",
+				Usage:   "Don't verify the server certificate",
+				EnvVars: []string{"TUSC_INSECURE"},
+			},
+			&cli.StringFlag{
+				Name:    "bearer-token",
+				Usage:   "Send this bearer token with every request",
+				EnvVars: []string{"TUSC_BEARER_TOKEN"},
+			},
+			&cli.StringFlag{
+				Name:    "user",
+				Usage:   "Basic auth credentials (format: 'user:password')",
+				EnvVars: []string{"TUSC_USER"},
+			},
+			&cli.StringFlag{
+				Name:    "oauth2-token-url",
+				Usage:   "Get bearer tokens from this OAuth2 token endpoint (client credentials grant)",
+				EnvVars: []string{"TUSC_OAUTH2_TOKEN_URL"},
+			},
+			&cli.StringFlag{
+				Name:    "oauth2-client-id",
+				Usage:   "OAuth2 client ID",
+				EnvVars: []string{"TUSC_OAUTH2_CLIENT_ID"},
+			},
+			&cli.StringFlag{
+				Name:    "oauth2-client-secret",
+				Usage:   "OAuth2 client secret",
+				EnvVars: []string{"TUSC_OAUTH2_CLIENT_SECRET"},
+			},
+			&cli.StringFlag{
+				Name:    "oauth2-scope",
+				Usage:   "Space-separated OAuth2 scopes to request",
+				EnvVars: []string{"TUSC_OAUTH2_SCOPE"},
+			},
+			&cli.StringFlag{
+				Name:    "sign",
+				Usage:   "Sign every request: hmac-sha256 or aws-sigv4",
+				EnvVars: []string{"TUSC_SIGN"},
+			},
+			&cli.StringFlag{
+				Name:    "sign-key-id",
+				Usage:   "Key ID to sign with, the access key for aws-sigv4 (default: AWS_ACCESS_KEY_ID)",
+				EnvVars: []string{"TUSC_SIGN_KEY_ID"},
+			},
+			&cli.StringFlag{
+				Name:    "sign-secret",
+				Usage:   "Secret to sign with, the secret key for aws-sigv4 (default: AWS_SECRET_ACCESS_KEY)",
+				EnvVars: []string{"TUSC_SIGN_SECRET"},
+			},
+			&cli.StringFlag{
+				Name:    "sign-region",
+				Usage:   "AWS region for aws-sigv4 (default: AWS_REGION)",
+				EnvVars: []string{"TUSC_SIGN_REGION"},
+			},
+			&cli.StringFlag{
+				Name:    "sign-service",
+				Usage:   "AWS service for aws-sigv4 (default: execute-api)",
+				EnvVars: []string{"TUSC_SIGN_SERVICE"},
+			},
+			&cli.StringFlag{
+				Name:    "credential-helper",
+				Usage:   "Get basic auth credentials for the endpoint host from this command, e.g. \"my-vault-cli get\" (git credential helper protocol)",
+				EnvVars: []string{"TUSC_CREDENTIAL_HELPER"},
+			},
+			&cli.BoolFlag{
+				Name:    "netrc",
+				Usage:   "Get basic auth credentials for the endpoint host from $NETRC or ~/.netrc",
+				EnvVars: []string{"TUSC_NETRC"},
+			},
+			&cli.StringFlag{
+				Name:    "netrc-file",
+				Usage:   "Like --netrc, with this netrc file",
+				EnvVars: []string{"TUSC_NETRC_FILE"},
+			},
+			&cli.StringFlag{
+				Name:    "output",
+				Value:   "text",
+				Usage:   "Output format: text, or json for NDJSON events on stdout",
+				EnvVars: []string{"TUSC_OUTPUT"},
+			},
+			&cli.BoolFlag{
+				Name:  "no-wait",
+				Usage: "Fail if another tusc process is uploading the same file (default, overrides TUSC_WAIT)",
+			},
 			&cli.BoolFlag{
 				Name:  "verbose",
 				Usage: "Enable verbose output",
@@ -407,9 +775,60 @@ func main() {
 			{
 				Name:      "upload",
 				Aliases:   []string{"u"},
-				Usage:     "Upload a file to TUS server",
+				Usage:     "Upload files, directories or glob patterns to TUS server (\"-\" reads stdin)",
 				Action:    uploadCommand,
-				ArgsUsage: "<file>",
+				ArgsUsage: "<file|dir|glob>... | - [--name NAME]",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:    "name",
+						Aliases: []string{"n"},
+						Usage:   "Filename for data read from stdin (default: stdin)",
+					},
+				},
+			},
+			{
+				Name:      "delete",
+				Aliases:   []string{"abort"},
+				Usage:     "Terminate an upload on the server and remove its local state",
+				Action:    deleteCommand,
+				ArgsUsage: "<url|file>",
+			},
+			{
+				Name:    "list",
+				Aliases: []string{"ls"},
+				Usage:   "List pending uploads with their progress on the server",
+				Action:  listCommand,
+			},
+			{
+				Name:   "resume",
+				Usage:  "Continue pending uploads from the state directory",
+				Action: resumeCommand,
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "all",
+						Usage: "Resume every resumable upload",
+					},
+				},
+			},
+			{
+				Name:      "status",
+				Aliases:   []string{"s"},
+				Usage:     "Show the server side state of an upload",
+				Action:    statusCommand,
+				ArgsUsage: "<url|file>",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "json",
+						Usage: "Print the status as JSON",
+					},
+				},
+			},
+			{
+				Name:      "download",
+				Aliases:   []string{"dl"},
+				Usage:     "Download a finished upload, resuming a partial download",
+				Action:    downloadCommand,
+				ArgsUsage: "<url> [destination]",
 			},
 			{
 				Name:    "options",
@@ -419,8 +838,8 @@ func main() {
 			},
 		},
 		Action: func(c *cli.Context) error {
-			// Default action is upload if file is provided
-			if c.NArg() == 1 {
+			// Default action is upload if files are provided
+			if c.NArg() > 0 {
 				return uploadCommand(c)
 			}
 			return cli.ShowAppHelp(c)
@@ -449,17 +868,50 @@ func uploadCommand(c *cli.Context) (err error) {
 		}
 	}()
 
-	if c.NArg() != 1 {
-		return cli.NewExitError("Please provide exactly one file to upload", 1)
+	if c.NArg() == 0 {
+		return cli.NewExitError("Please provide at least one file, directory or glob to upload", 1)
 	}
 
 	config, err := parseConfig(c)
 	if err != nil {
 		return cli.NewExitError(err.Error(), 1)
 	}
+	defer watchSignals(config)()
+
+	// Stream stdin with a deferred length, e.g. `pg_dump | tusc upload - --name backup.sql`
+	name, isStdin, err := stdinUploadName(c.Args().Slice(), c.String("name"))
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	if isStdin {
+		if err := uploadStdin(config, name); errors.Is(err, errInterrupted) {
+			return interruptedExit(err)
+		} else if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		return nil
+	}
+
+	targets, err := collectUploadTargets(c.Args().Slice())
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	warnExpiringUploads(config.output())
+
+	// A single file keeps the plain single-upload output
+	if len(targets) == 1 {
+		_, err = uploadFile(config, targets[0])
+		return interruptedExit(err)
+	}
+
+	fmt.Fprintf(config.output(), "Uploading %d files with %d worker(s)...\n", len(targets), min(config.Jobs, len(targets)))
+
+	start := time.Now()
+	results := uploadTargets(config, targets)
+	printUploadSummary(config.output(), results, time.Since(start))
 
-	filePath := c.Args().Get(0)
-	return uploadFile(config, filePath)
+	return uploadResultsExit(results)
 }
 
 func optionsCommand(c *cli.Context) error {
@@ -472,13 +924,39 @@ func optionsCommand(c *cli.Context) error {
 }
 
 func parseConfig(c *cli.Context) (*Config, error) {
-	// Parse endpoint
-	endpoint := c.String("endpoint")
-	if endpoint == "" {
+	config, err := parseClientConfig(c)
+	if err != nil {
+		return nil, err
+	}
+	if config.Endpoint == "" {
 		return nil, fmt.Errorf("endpoint is required")
 	}
+	return config, nil
+}
+
+// parseClientConfig parses the global flags without requiring an endpoint,
+// for commands that work on stored uploads, which remember theirs
+func parseClientConfig(c *cli.Context) (*Config, error) {
+	// Parse output first, so warnings already go where it wants them
+	events, out, err := parseOutput(c.String("output"))
+	if err != nil {
+		return nil, err
+	}
+
+	// Take what flags and environment leave unset from the profile
+	profile, err := selectProfile(c)
+	if err != nil {
+		return nil, err
+	}
+	if profile == nil {
+		profile = &Profile{}
+	}
+	if err := applyProfile(c, profile); err != nil {
+		return nil, err
+	}
 
 	// Validate endpoint URL
+	endpoint := c.String("endpoint")
 	if _, err := url.Parse(endpoint); err != nil {
 		return nil, fmt.Errorf("invalid endpoint URL: %v", err)
 	}
@@ -489,16 +967,19 @@ func parseConfig(c *cli.Context) (*Config, error) {
 
 	// Validate chunk size
 	if chunkSize < MinChunkSize {
-		fmt.Printf("Warning: chunk size too small, using minimum %s\n", formatBytes(MinChunkSize))
+		fmt.Fprintf(out, "Warning: chunk size too small, using minimum %s\n", formatBytes(MinChunkSize))
 		chunkSize = MinChunkSize
 	}
 	if chunkSize > MaxChunkSize {
-		fmt.Printf("Warning: chunk size too large, using maximum %s\n", formatBytes(MaxChunkSize))
+		fmt.Fprintf(out, "Warning: chunk size too large, using maximum %s\n", formatBytes(MaxChunkSize))
 		chunkSize = MaxChunkSize
 	}
 
-	// Parse headers
-	headers := make(map[string]string)
+	// Parse headers, overriding those of the profile
+	headers := maps.Clone(profile.Headers)
+	if headers == nil {
+		headers = make(map[string]string)
+	}
 	for _, header := range c.StringSlice("header") {
 		parts := strings.SplitN(header, ":", 2)
 		if len(parts) == 2 {
@@ -509,98 +990,281 @@ func parseConfig(c *cli.Context) (*Config, error) {
 	// Parse retries
 	retries := c.Int("retries")
 	if retries < 0 {
-		fmt.Printf("Warning: retries cannot be negative, using 0\n")
+		fmt.Fprintf(out, "Warning: retries cannot be negative, using 0\n")
 		retries = 0
 	}
 	if retries > MaxRetries {
-		fmt.Printf("Warning: retries too high, using maximum %d\n", MaxRetries)
+		fmt.Fprintf(out, "Warning: retries too high, using maximum %d\n", MaxRetries)
 		retries = MaxRetries
 	}
 
+	// Parse retry policy
+	retry := &RetryPolicy{
+		BaseDelay:  c.Duration("retry-delay"),
+		MaxDelay:   c.Duration("retry-max-delay"),
+		MaxElapsed: c.Duration("retry-max-time"),
+	}
+	if retry.BaseDelay < 0 || retry.MaxDelay < 0 || retry.MaxElapsed < 0 {
+		return nil, fmt.Errorf("retry delays cannot be negative")
+	}
+	if spec := c.String("retry-budget"); spec != "" {
+		budgets, err := parseRetryBudgets(spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --retry-budget: %v", err)
+		}
+		retry.Budgets = budgets
+	}
+
+	// Parse concurrent jobs
+	jobs := c.Int("jobs")
+	if jobs < 1 {
+		jobs = DefaultJobs
+	}
+	if jobs > MaxJobs {
+		fmt.Fprintf(out, "Warning: jobs too high, using maximum %d\n", MaxJobs)
+		jobs = MaxJobs
+	}
+
+	// Parse parallel parts per file
+	parallel := c.Int("parallel")
+	if parallel < 1 {
+		parallel = DefaultParallel
+	}
+	if parallel > MaxParallel {
+		fmt.Fprintf(out, "Warning: parallel parts too high, using maximum %d\n", MaxParallel)
+		parallel = MaxParallel
+	}
+
+	// Parse TLS settings
+	tlsConfig, err := loadTLSConfig(c.String("cacert"), c.String("cert"), c.String("key"), c.Bool("insecure"))
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse authentication
+	auth, err := parseAuth(c, tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	// Look up credentials for the endpoint host, unless given some other way.
+	// An Authorization header counts, the header transport sends it with
+	// every request.
+	if auth == nil && !hasHeader(headers, "Authorization") {
+		netrcFile := c.String("netrc-file")
+		if netrcFile == "" && c.Bool("netrc") {
+			netrcFile = netrcPath()
+		}
+		if auth, err = credentialAuth(endpoint, c.String("credential-helper"), netrcFile); err != nil {
+			return nil, err
+		}
+	}
+
+	// Parse request signing
+	signer, err := newSigner(c.String("sign"), SignerOptions{
+		KeyID:   c.String("sign-key-id"),
+		Secret:  c.String("sign-secret"),
+		Region:  c.String("sign-region"),
+		Service: c.String("sign-service"),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := signer.(*SigV4Signer); ok && auth != nil {
+		return nil, fmt.Errorf("aws-sigv4 signing sets the Authorization header and can't be used with other credentials")
+	}
+
+	// Parse bandwidth limit, shared by all uploads through the HTTP client
+	httpClient := newHTTPClient(jobs*parallel, tlsConfig)
+	if spec := c.String("limit-rate"); spec != "" {
+		schedule, err := parseRateLimit(spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --limit-rate: %v", err)
+		}
+		if c.Bool("verbose") {
+			fmt.Fprintf(out, "Limiting upload rate to %s\n", schedule)
+		}
+		httpClient = limitHTTPClient(httpClient, NewRateLimiter(schedule))
+	}
+
 	return &Config{
-		Endpoint:  endpoint,
-		ChunkSize: chunkSize,
-		Headers:   headers,
-		Retries:   retries,
-		Verbose:   c.Bool("verbose"),
+		Endpoint:       endpoint,
+		ChunkSize:      chunkSize,
+		AdaptiveChunks: c.Bool("adaptive-chunks"),
+		Headers:        headers,
+		Retries:        retries,
+		Retry:          retry,
+		Verbose:        c.Bool("verbose"),
+		Jobs:           jobs,
+		Parallel:       parallel,
+		Wait:           c.Bool("wait") && !c.Bool("no-wait"),
+		Metadata:       profile.Metadata,
+		HTTPClient:     headerHTTPClient(authHTTPClient(signHTTPClient(httpClient, signer), auth), headers),
+		events:         events,
+		out:            out,
 	}, nil
 }
 
-func uploadFile(config *Config, filePath string) (err error) {
+// uploadFile uploads a single target and returns its upload URL
+func uploadFile(config *Config, target UploadTarget) (uploadURL string, err error) {
 	// Add panic recovery for the entire upload process
 	defer func() {
 		if r := recover(); r != nil {
 			err = fmt.Errorf("panic during upload: %v", r)
 		}
+		if err != nil {
+			config.emit(Event{Event: "failed", Error: err.Error()})
+		}
 	}()
 
+	// Events of this upload are about target
+	fileConfig := *config
+	fileConfig.file = target.Path
+	config = &fileConfig
+
+	filePath := target.Path
+
 	// Check if file exists
 	fileInfo, err := os.Stat(filePath)
 	if err != nil {
-		return fmt.Errorf("file not found: %s", filePath)
+		return "", fmt.Errorf("file not found: %s", filePath)
+	}
+
+	// Pipes and devices cannot be rewound, they are streamed through a spool
+	if !fileInfo.Mode().IsRegular() {
+		return uploadPipe(config, target)
 	}
 
 	if config.Verbose {
-		fmt.Printf("File: %s\n", filePath)
-		fmt.Printf("Size: %s\n", formatBytes(fileInfo.Size()))
-		fmt.Printf("Endpoint: %s\n", config.Endpoint)
-		fmt.Printf("Retries: %d\n", config.Retries)
+		fmt.Fprintf(config.output(), "File: %s\n", filePath)
+		fmt.Fprintf(config.output(), "Size: %s\n", formatBytes(fileInfo.Size()))
+		fmt.Fprintf(config.output(), "Endpoint: %s\n", config.Endpoint)
+		fmt.Fprintf(config.output(), "Retries: %d\n", config.Retries)
 	}
 
 	// Generate file ID for state management
 	fileID := generateFileID(filePath, fileInfo)
 
+	// Only one process may work on an upload at a time
+	lock, err := lockUpload(config, fileID, filePath)
+	if err != nil {
+		return "", err
+	}
+	defer lock.Unlock()
+
+	migrateLegacyState(filePath, fileInfo, fileID)
+
 	// Check for existing upload state
 	existingState, err := loadUploadState(fileID)
+	if errors.Is(err, errNewerState) {
+		// Starting over would overwrite state this version doesn't understand
+		return "", err
+	}
 	if err != nil {
 		if config.Verbose {
-			fmt.Printf("Warning: failed to load upload state: %v\n", err)
+			fmt.Fprintf(config.output(), "Warning: failed to load upload state: %v\n", err)
 		}
 	}
 
 	// Parse endpoint URL
 	baseURL, err := url.Parse(config.Endpoint)
 	if err != nil {
-		return fmt.Errorf("invalid endpoint URL: %v", err)
+		return "", fmt.Errorf("invalid endpoint URL: %v", err)
 	}
 
-	// Create HTTP client with reasonable timeout
-	httpClient := &http.Client{
-		Timeout: 60 * time.Minute, // Increase timeout for large files
-		Transport: &http.Transport{
-			MaxIdleConns:          10,
-			MaxIdleConnsPerHost:   2,
-			IdleConnTimeout:       90 * time.Second,
-			ResponseHeaderTimeout: 30 * time.Second, // Add response header timeout
-		},
-	}
+	// Reuse the shared HTTP client so concurrent uploads share one transport
+	httpClient := config.httpClient()
 
-	// Create TUS client
-	tusClient := tusgo.NewClient(httpClient, baseURL)
+	// Create TUS client, its requests are cancelled by SIGINT and SIGTERM
+	tusClient := tusgo.NewClient(httpClient, baseURL).WithContext(config.runContext())
 
 	// Open file
 	file, err := os.Open(filePath)
 	if err != nil {
-		return fmt.Errorf("failed to open file: %v", err)
+		return "", fmt.Errorf("failed to open file: %v", err)
 	}
 	defer file.Close()
 
+	// Discard state that no longer matches the file
+	if existingState != nil && !validateUploadState(existingState, filePath, fileInfo, config.Endpoint) {
+		if isUploadStateExpired(existingState, time.Now()) {
+			fmt.Fprintf(config.output(), "Previous upload expired at %s, starting a new upload\n",
+				stateExpiresAt(existingState).Local().Format(time.RFC1123))
+		}
+		existingState = nil
+	}
+
+	// Large files may be split into partial uploads sent in parallel
+	if state, ok := parallelUploadState(config, tusClient, existingState, fileID, filePath, fileInfo); ok {
+		if state.Metadata == nil {
+			state.Metadata = config.fileMetadata(filePath, target.RelativePath)
+		}
+
+		start := time.Now()
+		uploadURL, err = uploadFileParallel(config, tusClient, file, state)
+		if err != nil {
+			return "", err
+		}
+		config.emit(Event{
+			Event:      "completed",
+			UploadURL:  uploadURL,
+			Size:       state.FileSize,
+			DurationMs: time.Since(start).Milliseconds(),
+			Metadata:   state.Metadata,
+		})
+
+		if err := removeUploadState(fileID); err != nil && config.Verbose {
+			fmt.Fprintf(config.output(), "Warning: failed to clean up state file: %v\n", err)
+		}
+		if config.Verbose {
+			fmt.Fprintf(config.output(), "Upload URL: %s\n", uploadURL)
+		}
+		return uploadURL, nil
+	}
+
+	uploadURL, err = uploadSingle(config, tusClient, file, target, fileID, fileInfo, existingState)
+	if err != nil && retryAction(err) == Recreate {
+		// The server expired or deleted the upload, its data is lost
+		fmt.Fprintf(config.output(), "Upload no longer exists on the server, starting a new upload\n")
+		if err := removeUploadState(fileID); err != nil && config.Verbose {
+			fmt.Fprintf(config.output(), "Warning: failed to clean up state file: %v\n", err)
+		}
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return "", fmt.Errorf("failed to seek file: %v", err)
+		}
+		uploadURL, err = uploadSingle(config, tusClient, file, target, fileID, fileInfo, nil)
+	}
+	return uploadURL, err
+}
+
+// uploadSingle resumes existingState, or creates a new upload if it is nil,
+// and sends the file in one stream
+func uploadSingle(config *Config, tusClient *tusgo.Client, file *os.File, target UploadTarget, fileID string, fileInfo os.FileInfo, existingState *UploadState) (string, error) {
+	filePath := target.Path
+	start := time.Now()
+	var err error
+
 	var upload tusgo.Upload
 	var metadata map[string]string
 	var isResume bool
+	var state *UploadState
+	var createStart time.Time
 
 	// Check if we can resume an existing upload
-	if existingState != nil && validateUploadState(existingState, filePath, fileInfo, config.Endpoint) {
+	if existingState != nil {
 		if config.Verbose {
-			fmt.Printf("Found existing upload state, attempting to resume...\n")
-			fmt.Printf("Previous upload URL: %s\n", existingState.UploadURL)
+			fmt.Fprintf(config.output(), "Found existing upload state, attempting to resume...\n")
+			fmt.Fprintf(config.output(), "Previous upload URL: %s\n", existingState.UploadURL)
+			if existingState.ExpiresAt != nil {
+				fmt.Fprintf(config.output(), "Upload expires: %s\n", existingState.ExpiresAt.Local().Format(time.RFC1123))
+			}
 		}
 
 		// Try to resume existing upload
 		uploadURL, err := url.Parse(existingState.UploadURL)
 		if err != nil {
 			if config.Verbose {
-				fmt.Printf("Invalid upload URL in state, creating new upload: %v\n", err)
+				fmt.Fprintf(config.output(), "Invalid upload URL in state, creating new upload: %v\n", err)
 			}
 		} else {
 			upload = tusgo.Upload{
@@ -608,6 +1272,7 @@ func uploadFile(config *Config, filePath string) (err error) {
 				Location:   uploadURL.String(),
 			}
 			metadata = existingState.Metadata
+			state = existingState
 			isResume = true
 		}
 	}
@@ -619,119 +1284,173 @@ func uploadFile(config *Config, filePath string) (err error) {
 		}
 
 		// Create comprehensive metadata
-		metadata = createFileMetadata(filePath)
+		metadata = config.fileMetadata(filePath, target.RelativePath)
 
 		// Create upload on server
 		if config.Verbose {
-			fmt.Println("Creating upload on server...")
-			fmt.Println("File metadata:")
+			fmt.Fprintln(config.output(), "Creating upload on server...")
+			fmt.Fprintln(config.output(), "File metadata:")
 			for key, value := range metadata {
-				fmt.Printf("  %s: %s\n", key, value)
+				fmt.Fprintf(config.output(), "  %s: %s\n", key, value)
 			}
 		}
 
 		// Add panic recovery for CreateUpload
+		createStart = time.Now()
 		func() {
 			defer func() {
 				if r := recover(); r != nil {
 					err = fmt.Errorf("panic during upload creation: %v", r)
 				}
 			}()
-			_, err = tusClient.CreateUpload(&upload, fileInfo.Size(), false, metadata)
+			err = withRetry(config, func() error {
+				return createUpload(config, tusClient, file, &upload, fileInfo.Size(), metadata)
+			})
 		}()
 
 		if err != nil {
-			return fmt.Errorf("failed to create upload: %v", err)
+			return "", fmt.Errorf("failed to create upload: %w", err)
 		}
 
+		config.emit(Event{
+			Event:     "created",
+			UploadURL: upload.Location,
+			Offset:    upload.RemoteOffset,
+			Size:      fileInfo.Size(),
+			Metadata:  metadata,
+		})
 		if config.Verbose {
-			fmt.Printf("Upload created: %s\n", upload.Location)
+			fmt.Fprintf(config.output(), "Upload created: %s\n", upload.Location)
+			if upload.RemoteOffset > 0 {
+				fmt.Fprintf(config.output(), "Sent %s with the creation request\n", formatBytes(upload.RemoteOffset))
+			}
 		}
 
 		// Save upload state for resumption
-		state := &UploadState{
+		state = &UploadState{
 			FileID:      fileID,
-			FilePath:    filePath,
+			FilePath:    absPath(filePath),
 			FileSize:    fileInfo.Size(),
 			FileModTime: fileInfo.ModTime(),
 			UploadURL:   upload.Location,
 			Metadata:    metadata,
 			Endpoint:    config.Endpoint,
 			CreatedAt:   time.Now(),
+			ExpiresAt:   upload.UploadExpired,
 		}
 
 		err = saveUploadState(state)
 		if err != nil {
 			if config.Verbose {
-				fmt.Printf("Warning: failed to save upload state: %v\n", err)
+				fmt.Fprintf(config.output(), "Warning: failed to save upload state: %v\n", err)
 			}
 		}
 	} else {
 		if config.Verbose {
-			fmt.Printf("Resuming upload: %s\n", upload.Location)
+			fmt.Fprintf(config.output(), "Resuming upload: %s\n", upload.Location)
 		}
 	}
 
-	// Create upload stream - this handles all the resumable upload logic
-	var stream *tusgo.UploadStream
-	func() {
-		defer func() {
-			if r := recover(); r != nil {
-				err = fmt.Errorf("panic creating upload stream: %v", r)
-			}
+	// creation-with-upload may already have sent the whole file
+	if !isResume && fileInfo.Size() > 0 && upload.RemoteOffset == fileInfo.Size() {
+		reportCreatedWithUpload(config, filePath, fileInfo.Size(), createStart)
+	} else {
+		// Create upload stream - this handles all the resumable upload logic
+		var stream *tusgo.UploadStream
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					err = fmt.Errorf("panic creating upload stream: %v", r)
+				}
+			}()
+			stream = tusgo.NewUploadStream(tusClient, &upload)
 		}()
-		stream = tusgo.NewUploadStream(tusClient, &upload)
-	}()
 
-	if err != nil {
-		return err
-	}
+		if err != nil {
+			return "", err
+		}
 
-	// Use retry logic for upload
-	err = uploadWithRetry(stream, file, config)
-	if err != nil {
-		return err
+		// A server behind the journal lost data it had acknowledged
+		if isResume && config.Verbose {
+			if _, err := stream.Sync(); err == nil && upload.RemoteOffset < state.UploadOffset {
+				fmt.Fprintf(config.output(), "Warning: server offset %s is behind the acknowledged %s, resending the difference\n",
+					formatBytes(upload.RemoteOffset), formatBytes(state.UploadOffset))
+			}
+		}
+
+		// Journal every acknowledged offset. The server pushes Upload-Expires
+		// forward with every PATCH, keep the state in sync so an expired
+		// upload is never resumed.
+		journaled := 0
+		onPatch := func() {
+			state.UploadOffset = upload.RemoteOffset
+			if err := appendJournal(fileID, upload.Location, upload.RemoteOffset); err != nil && config.Verbose {
+				fmt.Fprintf(config.output(), "Warning: failed to journal offset: %v\n", err)
+			}
+			journaled++
+			if refreshExpiry(&state.ExpiresAt, upload.UploadExpired) || journaled%JournalCompactInterval == 0 {
+				if err := saveUploadState(state); err != nil && config.Verbose {
+					fmt.Fprintf(config.output(), "Warning: failed to save upload state: %v\n", err)
+				}
+			}
+		}
+
+		// Use retry logic for upload
+		err = uploadWithRetry(stream, file, config, onPatch)
+		if errors.Is(err, errInterrupted) {
+			checkpointUpload(config, tusClient, state)
+			return "", err
+		}
+		if err != nil {
+			return "", err
+		}
 	}
 
 	// Clean up state file after successful upload
 	err = removeUploadState(fileID)
 	if err != nil {
 		if config.Verbose {
-			fmt.Printf("Warning: failed to clean up state file: %v\n", err)
+			fmt.Fprintf(config.output(), "Warning: failed to clean up state file: %v\n", err)
 		}
 	}
 
 	if config.Verbose {
-		fmt.Printf("Upload URL: %s\n", upload.Location)
+		fmt.Fprintf(config.output(), "Upload URL: %s\n", upload.Location)
 	}
 
-	return nil
+	config.emit(Event{
+		Event:      "completed",
+		UploadURL:  upload.Location,
+		Size:       fileInfo.Size(),
+		DurationMs: time.Since(start).Milliseconds(),
+		Metadata:   metadata,
+	})
+	return upload.Location, nil
 }
 
 func showServerOptions(config *Config) error {
-	fmt.Printf("Querying TUS server capabilities: %s\n", config.Endpoint)
+	fmt.Fprintf(config.output(), "Querying TUS server capabilities: %s\n", config.Endpoint)
 
 	req, err := http.NewRequest("OPTIONS", config.Endpoint, nil)
 	if err != nil {
 		return fmt.Errorf("failed to create request: %v", err)
 	}
 
-	// Add custom headers
-	for key, value := range config.Headers {
-		req.Header.Set(key, value)
-	}
-
-	client := &http.Client{Timeout: 10 * time.Second}
+	// A copy, so the timeout doesn't outlive the query, with the headers, auth,
+	// TLS and signing of the run
+	copied := *config.httpClient()
+	client := &copied
+	client.Timeout = 10 * time.Second
 	resp, err := client.Do(req)
 	if err != nil {
 		return fmt.Errorf("failed to query server: %v", err)
 	}
 	defer resp.Body.Close()
 
-	fmt.Println("\nServer capabilities:")
+	fmt.Fprintln(config.output(), "\nServer capabilities:")
 	for name, values := range resp.Header {
 		if strings.HasPrefix(strings.ToLower(name), "tus-") {
-			fmt.Printf("  %s: %s\n", name, strings.Join(values, ", "))
+			fmt.Fprintf(config.output(), "  %s: %s\n", name, strings.Join(values, ", "))
 		}
 	}
 
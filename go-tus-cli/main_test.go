@@ -2,17 +2,36 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/bdragon300/tusgo"
 	"github.com/urfave/cli/v2"
 )
 
+// TestMain keeps the state files written by tests out of the user's state directory
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "tusc-state-*")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to create state directory: %v\n", err)
+		os.Exit(1)
+	}
+	os.Setenv("XDG_STATE_HOME", dir)
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
 // MockTUSServer creates a simple mock TUS server for testing
 type MockTUSServer struct {
 	server *httptest.Server
@@ -61,6 +80,307 @@ func (m *MockTUSServer) URL() string {
 	return m.server.URL + "/files"
 }
 
+// StatefulTUSServer is a mock TUS server that keeps uploaded data in memory
+type StatefulTUSServer struct {
+	server  *httptest.Server
+	mu      sync.Mutex
+	uploads map[string]*StatefulUpload
+	count   int
+
+	// Expires, if set, is announced as Upload-Expires on creation and PATCH
+	Expires time.Duration
+	// CreationWithUpload advertises and accepts data in the creation request
+	CreationWithUpload bool
+	// FailPatches makes the next N PATCH requests store half of their data
+	// and then fail with 503
+	FailPatches int
+	patches     int
+	// MaxPatchSize, if set, rejects larger PATCH bodies with 413 like a proxy
+	MaxPatchSize int64
+	patchSizes   []int64
+	// PatchStatuses answers the next PATCH requests with these statuses
+	// without storing anything
+	PatchStatuses []int
+	// Patched, if set, is called with the new offset after every stored PATCH
+	Patched func(offset int64)
+	// Token, if set, rejects PATCH requests without it as bearer token with 401
+	Token string
+	// FailConcats makes the next N concatenation requests fail with 503
+	FailConcats int
+	// Verify, if set, rejects requests it returns an error for with 403, like
+	// a gateway checking signatures
+	Verify func(r *http.Request) error
+}
+
+type StatefulUpload struct {
+	Size     int64
+	Offset   int64
+	Data     []byte
+	Metadata map[string]string
+	Partial  bool
+	Final    bool
+	Parts    []string // Locations of the partial uploads of a final upload
+}
+
+func NewStatefulTUSServer() *StatefulTUSServer {
+	mock := &StatefulTUSServer{uploads: make(map[string]*StatefulUpload)}
+
+	handler := http.NewServeMux()
+	handler.HandleFunc("/files", mock.handleCreate)
+	handler.HandleFunc("/files/", mock.handleUpload)
+
+	mock.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if mock.Verify != nil {
+			if err := mock.Verify(r); err != nil {
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			}
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	return mock
+}
+
+func (m *StatefulTUSServer) Close() {
+	m.server.Close()
+}
+
+func (m *StatefulTUSServer) URL() string {
+	return m.server.URL + "/files"
+}
+
+// Uploads returns a snapshot of all uploads keyed by their ID
+func (m *StatefulTUSServer) Uploads() map[string]*StatefulUpload {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	uploads := make(map[string]*StatefulUpload, len(m.uploads))
+	for id, upload := range m.uploads {
+		uploads[id] = upload
+	}
+	return uploads
+}
+
+// AcceptedPatchSizes returns the body sizes of the accepted PATCH requests
+func (m *StatefulTUSServer) AcceptedPatchSizes() []int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]int64(nil), m.patchSizes...)
+}
+
+// PatchCount returns the number of PATCH requests received
+func (m *StatefulTUSServer) PatchCount() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.patches
+}
+
+func (m *StatefulTUSServer) handleCreate(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", "1.0.0")
+
+	switch r.Method {
+	case "OPTIONS":
+		w.Header().Set("Tus-Version", "1.0.0")
+		extensions := "creation,creation-defer-length,termination,concatenation,expiration"
+		if m.CreationWithUpload {
+			extensions += ",creation-with-upload"
+		}
+		w.Header().Set("Tus-Extension", extensions)
+		w.WriteHeader(http.StatusOK)
+	case "POST":
+		if concat := r.Header.Get("Upload-Concat"); strings.HasPrefix(concat, "final;") {
+			m.handleConcat(w, r, strings.TrimPrefix(concat, "final;"))
+			return
+		}
+
+		// Deferred uploads keep Size at -1 until a PATCH sends Upload-Length
+		size := int64(-1)
+		if r.Header.Get("Upload-Defer-Length") != "1" {
+			var err error
+			size, err = strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+		}
+
+		metadata, _ := tusgo.DecodeMetadata(r.Header.Get("Upload-Metadata"))
+		upload := &StatefulUpload{
+			Size:     size,
+			Data:     make([]byte, max(size, 0)),
+			Metadata: metadata,
+			Partial:  r.Header.Get("Upload-Concat") == "partial",
+		}
+
+		if m.CreationWithUpload && r.Header.Get("Content-Type") == "application/offset+octet-stream" {
+			data, err := io.ReadAll(r.Body)
+			if err != nil || int64(len(data)) > size {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			copy(upload.Data, data)
+			upload.Offset = int64(len(data))
+			w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+		}
+
+		m.mu.Lock()
+		m.count++
+		id := fmt.Sprintf("upload_%d", m.count)
+		m.uploads[id] = upload
+		m.mu.Unlock()
+
+		m.setExpires(w)
+		w.Header().Set("Location", m.server.URL+"/files/"+id)
+		w.WriteHeader(http.StatusCreated)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// setExpires announces the upload expiry, pushed forward on every request
+func (m *StatefulTUSServer) setExpires(w http.ResponseWriter) {
+	if m.Expires != 0 {
+		w.Header().Set("Upload-Expires", time.Now().Add(m.Expires).UTC().Format(http.TimeFormat))
+	}
+}
+
+// handleConcat creates a final upload from finished partial uploads
+func (m *StatefulTUSServer) handleConcat(w http.ResponseWriter, r *http.Request, locations string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.FailConcats > 0 {
+		m.FailConcats--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	final := &StatefulUpload{Final: true}
+	final.Metadata, _ = tusgo.DecodeMetadata(r.Header.Get("Upload-Metadata"))
+
+	for _, location := range strings.Fields(locations) {
+		part, exists := m.uploads[location[strings.LastIndex(location, "/")+1:]]
+		if !exists || !part.Partial || part.Offset != part.Size {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		final.Data = append(final.Data, part.Data...)
+		final.Parts = append(final.Parts, location)
+	}
+	final.Size = int64(len(final.Data))
+	final.Offset = final.Size
+
+	m.count++
+	id := fmt.Sprintf("upload_%d", m.count)
+	m.uploads[id] = final
+
+	w.Header().Set("Location", m.server.URL+"/files/"+id)
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (m *StatefulTUSServer) handleUpload(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/files/")
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	upload, exists := m.uploads[id]
+	if !exists {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Tus-Resumable", "1.0.0")
+
+	switch r.Method {
+	case "HEAD":
+		w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+		if upload.Size < 0 {
+			w.Header().Set("Upload-Defer-Length", "1")
+		} else {
+			w.Header().Set("Upload-Length", strconv.FormatInt(upload.Size, 10))
+		}
+		if len(upload.Metadata) > 0 {
+			metadata, _ := tusgo.EncodeMetadata(upload.Metadata)
+			w.Header().Set("Upload-Metadata", metadata)
+		}
+		switch {
+		case upload.Partial:
+			w.Header().Set("Upload-Concat", "partial")
+		case upload.Final:
+			w.Header().Set("Upload-Concat", "final;"+strings.Join(upload.Parts, " "))
+		}
+		m.setExpires(w)
+		w.WriteHeader(http.StatusOK)
+	case "PATCH":
+		m.patches++
+		if m.Token != "" && r.Header.Get("Authorization") != "Bearer "+m.Token {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if len(m.PatchStatuses) > 0 {
+			status := m.PatchStatuses[0]
+			m.PatchStatuses = m.PatchStatuses[1:]
+			w.WriteHeader(status)
+			fmt.Fprintf(w, "injected %d", status)
+			return
+		}
+
+		offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+		if err != nil || offset != upload.Offset {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+
+		if length := r.Header.Get("Upload-Length"); length != "" && upload.Size < 0 {
+			upload.Size, _ = strconv.ParseInt(length, 10, 64)
+		}
+
+		data, err := io.ReadAll(r.Body)
+		end := offset + int64(len(data))
+		if err != nil || (upload.Size >= 0 && end > upload.Size) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if m.MaxPatchSize > 0 && int64(len(data)) > m.MaxPatchSize {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+		m.patchSizes = append(m.patchSizes, int64(len(data)))
+
+		failed := m.FailPatches > 0
+		if failed {
+			m.FailPatches--
+			data = data[:len(data)/2]
+			end = offset + int64(len(data))
+		}
+
+		if end > int64(len(upload.Data)) {
+			upload.Data = append(upload.Data, make([]byte, end-int64(len(upload.Data)))...)
+		}
+		copy(upload.Data[offset:], data)
+		upload.Offset += int64(len(data))
+
+		if failed {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		if m.Patched != nil {
+			m.Patched(upload.Offset)
+		}
+
+		m.setExpires(w)
+		w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+		w.WriteHeader(http.StatusNoContent)
+	case "DELETE":
+		delete(m.uploads, id)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
 func TestParseConfig(t *testing.T) {
 	app := &cli.App{
 		Flags: []cli.Flag{
@@ -141,6 +461,28 @@ func TestShowServerOptions(t *testing.T) {
 	}
 }
 
+func TestShowServerOptionsUsesHTTPClient(t *testing.T) {
+	var authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		w.Header().Set("Tus-Version", "1.0.0")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	client := authHTTPClient(newHTTPClient(1, nil), &BearerAuth{Token: "secret"})
+	config := &Config{Endpoint: server.URL, HTTPClient: client, out: io.Discard}
+	if err := showServerOptions(config); err != nil {
+		t.Fatalf("showServerOptions failed: %v", err)
+	}
+	if authorization != "Bearer secret" {
+		t.Errorf("Expected the OPTIONS request to be authorized, got Authorization %q", authorization)
+	}
+	if client.Timeout != 60*time.Minute {
+		t.Errorf("Expected the timeout of the run's client to be kept, got %v", client.Timeout)
+	}
+}
+
 func TestProgressWriter(t *testing.T) {
 	var buf strings.Builder
 	pw := NewProgressWriter(&buf, 100, "test.txt")
@@ -236,22 +578,22 @@ func TestIsRetryableError(t *testing.T) {
 			name:     "Checksum mismatch should be retryable",
 		},
 		{
-			err:      fmt.Errorf("connection timeout"),
+			err:      &url.Error{Op: "Patch", URL: "http://localhost/files/1", Err: os.ErrDeadlineExceeded},
 			expected: true,
 			name:     "Timeout error should be retryable",
 		},
 		{
-			err:      fmt.Errorf("connection refused"),
+			err:      &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", fmt.Errorf("connection refused"))},
 			expected: true,
 			name:     "Connection error should be retryable",
 		},
 		{
-			err:      fmt.Errorf("internal server error"),
+			err:      &HTTPError{Phase: PhasePatch, StatusCode: http.StatusInternalServerError},
 			expected: true,
 			name:     "Server error should be retryable",
 		},
 		{
-			err:      fmt.Errorf("short write"),
+			err:      io.ErrShortWrite,
 			expected: true,
 			name:     "Short write should be retryable",
 		},
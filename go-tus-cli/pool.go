@@ -0,0 +1,274 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultJobs = 1  // Default number of concurrent uploads
+	MaxJobs     = 32 // Maximum number of concurrent uploads
+)
+
+// newHTTPClient creates the HTTP client shared by all uploads of a run.
+// The idle connection pool is sized so every worker can keep its connection.
+// tlsConfig may be nil for Go's defaults.
+func newHTTPClient(jobs int, tlsConfig *tls.Config) *http.Client {
+	if jobs < 2 {
+		jobs = 2
+	}
+
+	return &http.Client{
+		Timeout: 60 * time.Minute, // Increase timeout for large files
+		Transport: &errorBodyTransport{base: &http.Transport{
+			MaxIdleConns:          jobs * 2,
+			MaxIdleConnsPerHost:   jobs,
+			IdleConnTimeout:       90 * time.Second,
+			ResponseHeaderTimeout: 30 * time.Second, // Add response header timeout
+			TLSClientConfig:       tlsConfig,
+		}},
+	}
+}
+
+// AggregateProgress tracks the combined progress of concurrent uploads and
+// renders it as a single status line
+type AggregateProgress struct {
+	mu          sync.Mutex
+	unit        string // what is being counted, "files" or "parts"
+	totalBytes  int64
+	totalFiles  int
+	doneBytes   int64            // bytes on the server, the sum of offsets
+	offsets     map[string]int64 // offset the server acknowledged per upload
+	sentBytes   int64            // bytes sent during this run, used for the rate
+	doneFiles   int
+	failedFiles int
+	start       time.Time
+	lineWidth   int
+	out         io.Writer // where the status line goes
+
+	// emit, if set, gets a progress event instead of the status line
+	emit func(Event)
+}
+
+func NewAggregateProgress(totalFiles int, totalBytes int64) *AggregateProgress {
+	return &AggregateProgress{
+		unit:       "files",
+		totalFiles: totalFiles,
+		totalBytes: totalBytes,
+		offsets:    make(map[string]int64),
+		start:      time.Now(),
+		out:        os.Stdout,
+	}
+}
+
+// Sent records bytes sent to the server, resent ones included
+func (ap *AggregateProgress) Sent(n int64) {
+	ap.mu.Lock()
+	ap.sentBytes += n
+	ap.mu.Unlock()
+}
+
+// Acknowledge records the offset the server acknowledged for upload, either
+// resumed or after a PATCH. Bytes sent again after a failed chunk, or for an
+// upload created anew, are counted once.
+func (ap *AggregateProgress) Acknowledge(upload string, offset int64) {
+	ap.mu.Lock()
+	ap.doneBytes += offset - ap.offsets[upload]
+	ap.offsets[upload] = offset
+	ap.mu.Unlock()
+}
+
+// FileDone prints the outcome of one file above the status line
+func (ap *AggregateProgress) FileDone(result UploadResult) {
+	ap.mu.Lock()
+	defer ap.mu.Unlock()
+
+	ap.clearLine()
+	if result.Err != nil {
+		ap.failedFiles++
+	} else {
+		ap.doneFiles++
+	}
+
+	// Uploads emit their completed and failed events themselves
+	switch {
+	case ap.emit != nil:
+	case result.Err != nil:
+		fmt.Fprintf(ap.out, "✗ %s: %v\n", result.Target.Path, result.Err)
+	default:
+		fmt.Fprintf(ap.out, "✓ %s (%s)\n", result.Target.Path, formatBytes(result.Size))
+	}
+	ap.render()
+}
+
+// Run redraws the status line every second until stop is closed
+func (ap *AggregateProgress) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			ap.mu.Lock()
+			ap.clearLine()
+			ap.mu.Unlock()
+			return
+		case <-ticker.C:
+			ap.mu.Lock()
+			ap.render()
+			ap.mu.Unlock()
+		}
+	}
+}
+
+// clearLine erases the status line, the caller must hold ap.mu
+func (ap *AggregateProgress) clearLine() {
+	if ap.lineWidth > 0 {
+		fmt.Fprintf(ap.out, "\r%s\r", strings.Repeat(" ", ap.lineWidth))
+		ap.lineWidth = 0
+	}
+}
+
+// render draws the status line, the caller must hold ap.mu
+func (ap *AggregateProgress) render() {
+	if ap.emit != nil {
+		event := Event{Event: "progress", Offset: ap.doneBytes, Size: ap.totalBytes}
+		if elapsed := time.Since(ap.start).Seconds(); elapsed > 0 {
+			event.Rate = int64(float64(ap.sentBytes) / elapsed)
+		}
+		if ap.unit == "files" {
+			event.Files = ap.totalFiles
+			event.FilesDone = ap.doneFiles + ap.failedFiles
+		}
+		ap.emit(event)
+		return
+	}
+
+	percentage := 100.0
+	if ap.totalBytes > 0 {
+		percentage = float64(ap.doneBytes) / float64(ap.totalBytes) * 100
+	}
+
+	line := fmt.Sprintf("Total: %.1f%% (%s/%s), %d/%d %s",
+		percentage,
+		formatBytes(ap.doneBytes),
+		formatBytes(ap.totalBytes),
+		ap.doneFiles+ap.failedFiles,
+		ap.totalFiles,
+		ap.unit)
+
+	elapsed := time.Since(ap.start).Seconds()
+	if elapsed > 0 && ap.sentBytes > 0 {
+		rate := float64(ap.sentBytes) / elapsed
+		line += fmt.Sprintf(" at %s/s", formatBytes(int64(rate)))
+
+		if remaining := ap.totalBytes - ap.doneBytes; remaining > 0 {
+			eta := time.Duration(float64(remaining)/rate) * time.Second
+			line += fmt.Sprintf(", ETA %v", eta.Round(time.Second))
+		}
+	}
+
+	fmt.Fprintf(ap.out, "\r%s", line)
+	ap.lineWidth = len([]rune(line))
+}
+
+// uploadTarget uploads one target and wraps the outcome in an UploadResult
+func uploadTarget(config *Config, target UploadTarget) UploadResult {
+	start := time.Now()
+	result := UploadResult{Target: target}
+	if info, err := os.Stat(target.Path); err == nil {
+		result.Size = info.Size()
+	}
+
+	result.UploadURL, result.Err = uploadFile(config, target)
+	result.Duration = time.Since(start)
+	return result
+}
+
+// uploadTargets uploads all targets over config.Jobs workers. Every worker runs
+// the regular single-file path, so each file keeps its own state file and
+// resume logic. Results are returned in the order of targets.
+func uploadTargets(config *Config, targets []UploadTarget) []UploadResult {
+	jobs := config.Jobs
+	if jobs < 1 {
+		jobs = 1
+	}
+	if jobs > len(targets) {
+		jobs = len(targets)
+	}
+
+	if config.HTTPClient == nil {
+		config.HTTPClient = headerHTTPClient(newHTTPClient(jobs, nil), config.Headers)
+	}
+
+	// Per-file progress lines would interleave, so concurrent workers report
+	// through one aggregate status line instead
+	var stop chan struct{}
+	var done sync.WaitGroup
+	if jobs > 1 {
+		var totalBytes int64
+		for _, target := range targets {
+			if info, err := os.Stat(target.Path); err == nil {
+				totalBytes += info.Size()
+			}
+		}
+
+		progress := NewAggregateProgress(len(targets), totalBytes)
+		progress.out = config.output()
+		if config.events != nil {
+			progress.emit = config.emit
+		}
+		config.progress = progress
+		stop = make(chan struct{})
+		done.Add(1)
+		go func() {
+			defer done.Done()
+			progress.Run(stop)
+		}()
+	}
+
+	results := make([]UploadResult, len(targets))
+	indexes := make(chan int)
+
+	var wg sync.WaitGroup
+	for w := 0; w < jobs; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				// Once interrupted the remaining targets are not started
+				if config.runContext().Err() != nil {
+					results[i] = UploadResult{Target: targets[i], Err: errInterrupted}
+					continue
+				}
+
+				results[i] = uploadTarget(config, targets[i])
+				if config.progress != nil {
+					config.progress.FileDone(results[i])
+				} else if results[i].Err != nil {
+					fmt.Fprintf(os.Stderr, "Error uploading %s: %v\n", targets[i].Path, results[i].Err)
+				}
+			}
+		}()
+	}
+
+	for i := range targets {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	if stop != nil {
+		close(stop)
+		done.Wait()
+		config.progress = nil
+	}
+
+	return results
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUploadTargetsConcurrent(t *testing.T) {
+	mockServer := NewStatefulTUSServer()
+	defer mockServer.Close()
+
+	files := make(map[string]string)
+	for i := 0; i < 12; i++ {
+		files[fmt.Sprintf("batch/file_%02d.txt", i)] = fmt.Sprintf("content of file number %d", i)
+	}
+	root := createTestTree(t, files)
+
+	targets, err := collectUploadTargets([]string{filepath.Join(root, "batch")})
+	if err != nil {
+		t.Fatalf("collectUploadTargets failed: %v", err)
+	}
+
+	// State files are written to the working directory
+	wd, _ := os.Getwd()
+	os.Chdir(t.TempDir())
+	defer os.Chdir(wd)
+
+	config := &Config{
+		Endpoint:  mockServer.URL(),
+		ChunkSize: DefaultChunkSize,
+		Headers:   make(map[string]string),
+		Jobs:      4,
+	}
+
+	results := uploadTargets(config, targets)
+	if len(results) != len(targets) {
+		t.Fatalf("Expected %d results, got %d", len(targets), len(results))
+	}
+
+	for i, result := range results {
+		if result.Target != targets[i] {
+			t.Errorf("Result %d is for %s, expected %s", i, result.Target.Path, targets[i].Path)
+		}
+		if result.Err != nil {
+			t.Errorf("Upload of %s failed: %v", result.Target.Path, result.Err)
+		}
+		if result.UploadURL == "" {
+			t.Errorf("Missing upload URL for %s", result.Target.Path)
+		}
+	}
+
+	uploads := mockServer.Uploads()
+	if len(uploads) != len(files) {
+		t.Fatalf("Expected %d uploads, got %d", len(files), len(uploads))
+	}
+	for _, upload := range uploads {
+		expected := files[upload.Metadata["relativePath"]]
+		if string(upload.Data[:upload.Offset]) != expected {
+			t.Errorf("Upload %s has data %q, expected %q",
+				upload.Metadata["relativePath"], upload.Data[:upload.Offset], expected)
+		}
+	}
+
+	if config.progress != nil {
+		t.Error("Aggregate progress should be detached after the run")
+	}
+}
+
+func TestAggregateProgress(t *testing.T) {
+	progress := NewAggregateProgress(2, 300)
+
+	progress.Acknowledge("a.txt#0", 100)
+	progress.Sent(50)
+	progress.Acknowledge("a.txt#0", 150)
+
+	if progress.doneBytes != 150 {
+		t.Errorf("Expected 150 done bytes, got %d", progress.doneBytes)
+	}
+	if progress.sentBytes != 50 {
+		t.Errorf("Expected 50 sent bytes, got %d", progress.sentBytes)
+	}
+
+	// b.txt is created anew after 100 bytes and sent again, which counts once
+	progress.Sent(100)
+	progress.Acknowledge("b.txt#0", 100)
+	progress.Acknowledge("b.txt#0", 0)
+	progress.Sent(150)
+	progress.Acknowledge("b.txt#0", 150)
+
+	if progress.doneBytes != 300 {
+		t.Errorf("Expected 300 done bytes, got %d", progress.doneBytes)
+	}
+	if progress.sentBytes != 300 {
+		t.Errorf("Expected 300 sent bytes, got %d", progress.sentBytes)
+	}
+
+	progress.FileDone(UploadResult{Target: UploadTarget{Path: "a.txt"}, Size: 150})
+	progress.FileDone(UploadResult{Target: UploadTarget{Path: "b.txt"}, Err: fmt.Errorf("boom")})
+
+	if progress.doneFiles != 1 || progress.failedFiles != 1 {
+		t.Errorf("Expected 1 done and 1 failed file, got %d and %d", progress.doneFiles, progress.failedFiles)
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigFile is the tusc config file with its named profiles
+type ConfigFile struct {
+	DefaultProfile string             `yaml:"default_profile"` // Used when --profile is not set
+	Profiles       map[string]Profile `yaml:"profiles"`
+}
+
+// Profile holds defaults for the global flags. Flags and TUSC_* environment
+// variables override it, it overrides the built-in defaults.
+type Profile struct {
+	Endpoint       string            `yaml:"endpoint"`
+	Headers        map[string]string `yaml:"headers"`
+	ChunkSize      int64             `yaml:"chunk_size"` // Megabytes, like --chunk-size
+	AdaptiveChunks bool              `yaml:"adaptive_chunks"`
+	Retries        *int              `yaml:"retries"`
+	RetryDelay     string            `yaml:"retry_delay"`
+	RetryMaxDelay  string            `yaml:"retry_max_delay"`
+	RetryMaxTime   string            `yaml:"retry_max_time"`
+	RetryBudget    string            `yaml:"retry_budget"`
+	Jobs           int               `yaml:"jobs"`
+	Parallel       int               `yaml:"parallel"`
+	LimitRate      string            `yaml:"limit_rate"`
+	TLS            ProfileTLS        `yaml:"tls"`
+	Auth           ProfileAuth       `yaml:"auth"`
+	Signing        ProfileSigning    `yaml:"signing"`
+	Metadata       map[string]string `yaml:"metadata"` // Sent with every upload
+}
+
+// ProfileTLS holds the TLS settings of a profile
+type ProfileTLS struct {
+	CAFile             string `yaml:"ca_file"`
+	CertFile           string `yaml:"cert_file"`
+	KeyFile            string `yaml:"key_file"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+}
+
+// ProfileAuth holds the credentials of a profile, at most one kind of them
+type ProfileAuth struct {
+	BearerToken  string `yaml:"bearer_token"`
+	User         string `yaml:"user"` // user:password for basic auth
+	TokenURL     string `yaml:"oauth2_token_url"`
+	ClientID     string `yaml:"oauth2_client_id"`
+	ClientSecret string `yaml:"oauth2_client_secret"`
+	Scope        string `yaml:"oauth2_scope"`
+
+	CredentialHelper string `yaml:"credential_helper"` // Command, e.g. "my-vault-cli get"
+	Netrc            bool   `yaml:"netrc"`
+	NetrcFile        string `yaml:"netrc_file"`
+}
+
+// ProfileSigning holds the request signing settings of a profile
+type ProfileSigning struct {
+	Scheme  string `yaml:"scheme"` // hmac-sha256 or aws-sigv4
+	KeyID   string `yaml:"key_id"`
+	Secret  string `yaml:"secret"`
+	Region  string `yaml:"region"`
+	Service string `yaml:"service"`
+}
+
+// configFilePath returns where the config file is read from,
+// $XDG_CONFIG_HOME/tusc/config.yaml or ~/.config/tusc/config.yaml
+func configFilePath() string {
+	if dir := os.Getenv("XDG_CONFIG_HOME"); filepath.IsAbs(dir) {
+		return filepath.Join(dir, "tusc", "config.yaml")
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		return filepath.Join(home, ".config", "tusc", "config.yaml")
+	}
+	return ""
+}
+
+// loadConfigFile reads the config file at path. A missing file is an empty
+// config, unknown keys are errors so typos don't go unnoticed.
+func loadConfigFile(path string) (*ConfigFile, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &ConfigFile{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open config file: %v", err)
+	}
+	defer file.Close()
+
+	var config ConfigFile
+	decoder := yaml.NewDecoder(file)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&config); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("invalid config file %s: %v", path, err)
+	}
+	return &config, nil
+}
+
+// selectProfile returns the profile named by --profile, or the config file's
+// default profile. It returns nil when neither is set.
+func selectProfile(c *cli.Context) (*Profile, error) {
+	path := c.String("config")
+	if path == "" {
+		path = configFilePath()
+	}
+	if path == "" {
+		return nil, nil
+	}
+
+	config, err := loadConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	name := c.String("profile")
+	if name == "" {
+		name = config.DefaultProfile
+	}
+	if name == "" {
+		return nil, nil
+	}
+
+	profile, ok := config.Profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("profile %q not found in %s", name, path)
+	}
+	return &profile, nil
+}
+
+// applyProfile sets the flags the profile has a value for, unless they were
+// given on the command line or in the environment. Headers and metadata are
+// merged by the caller instead, key by key.
+func applyProfile(c *cli.Context, profile *Profile) error {
+	values := map[string]string{
+		"endpoint":        profile.Endpoint,
+		"retry-delay":     profile.RetryDelay,
+		"retry-max-delay": profile.RetryMaxDelay,
+		"retry-max-time":  profile.RetryMaxTime,
+		"retry-budget":    profile.RetryBudget,
+		"limit-rate":      profile.LimitRate,
+		"cacert":          profile.TLS.CAFile,
+		"cert":            profile.TLS.CertFile,
+		"key":             profile.TLS.KeyFile,
+		"sign":            profile.Signing.Scheme,
+		"sign-key-id":     profile.Signing.KeyID,
+		"sign-secret":     profile.Signing.Secret,
+		"sign-region":     profile.Signing.Region,
+		"sign-service":    profile.Signing.Service,
+	}
+
+	// Credentials given on the command line or in the environment replace
+	// those of the profile, whatever their kind
+	auth := map[string]string{
+		"bearer-token":         profile.Auth.BearerToken,
+		"user":                 profile.Auth.User,
+		"oauth2-token-url":     profile.Auth.TokenURL,
+		"oauth2-client-id":     profile.Auth.ClientID,
+		"oauth2-client-secret": profile.Auth.ClientSecret,
+		"oauth2-scope":         profile.Auth.Scope,
+		"credential-helper":    profile.Auth.CredentialHelper,
+		"netrc-file":           profile.Auth.NetrcFile,
+		"netrc":                "",
+	}
+	if profile.Auth.Netrc {
+		auth["netrc"] = "true"
+	}
+	authSet := false
+	for name := range auth {
+		authSet = authSet || c.IsSet(name)
+	}
+	if !authSet {
+		maps.Copy(values, auth)
+	}
+	if profile.ChunkSize != 0 {
+		values["chunk-size"] = strconv.FormatInt(profile.ChunkSize, 10)
+	}
+	if profile.AdaptiveChunks {
+		values["adaptive-chunks"] = "true"
+	}
+	if profile.Retries != nil {
+		values["retries"] = strconv.Itoa(*profile.Retries)
+	}
+	if profile.Jobs != 0 {
+		values["jobs"] = strconv.Itoa(profile.Jobs)
+	}
+	if profile.Parallel != 0 {
+		values["parallel"] = strconv.Itoa(profile.Parallel)
+	}
+	if profile.TLS.InsecureSkipVerify {
+		values["insecure"] = "true"
+	}
+
+	for name, value := range values {
+		if value == "" || c.IsSet(name) {
+			continue
+		}
+		if err := c.Set(name, value); err != nil {
+			return fmt.Errorf("invalid %s in profile: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// loadTLSConfig builds the TLS settings of --cacert, --cert, --key and
+// --insecure. It returns nil when none is set, keeping Go's defaults.
+func loadTLSConfig(caFile, certFile, keyFile string, insecure bool) (*tls.Config, error) {
+	if caFile == "" && certFile == "" && keyFile == "" && !insecure {
+		return nil, nil
+	}
+
+	config := &tls.Config{InsecureSkipVerify: insecure}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %v", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", caFile)
+		}
+	}
+
+	if certFile != "" || keyFile != "" {
+		if certFile == "" || keyFile == "" {
+			return nil, fmt.Errorf("a client certificate needs both --cert and --key")
+		}
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
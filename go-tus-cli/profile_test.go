@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/urfave/cli/v2"
+)
+
+const testConfigFile = `default_profile: local
+profiles:
+  local:
+    endpoint: http://localhost:1080/files/
+  staging:
+    endpoint: https://staging.example.com/files/
+    chunk_size: 8
+    retries: 0
+    jobs: 4
+    headers:
+      Authorization: Bearer abc
+      X-Tags: red, green
+    metadata:
+      project: demo
+      filename: ignored
+`
+
+// writeConfigFile writes content as the config file of a temporary config home
+func writeConfigFile(t *testing.T, content string) string {
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	path := filepath.Join(dir, "tusc", "config.yaml")
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// runWithProfile parses args with the profile related global flags
+func runWithProfile(t *testing.T, args ...string) (*Config, error) {
+	var config *Config
+	app := &cli.App{
+		Flags: []cli.Flag{
+			&cli.StringFlag{Name: "profile", EnvVars: []string{"TUSC_PROFILE"}},
+			&cli.StringFlag{Name: "config"},
+			&cli.StringFlag{Name: "endpoint", EnvVars: []string{"TUSC_ENDPOINT"}},
+			&cli.Int64Flag{Name: "chunk-size", Value: 2, EnvVars: []string{"TUSC_CHUNK_SIZE"}},
+			&cli.IntFlag{Name: "retries", Value: DefaultRetries},
+			&cli.IntFlag{Name: "jobs", Value: DefaultJobs},
+			&cli.StringSliceFlag{Name: "header", Aliases: []string{"H"}},
+		},
+		Action: func(c *cli.Context) (err error) {
+			config, err = parseConfig(c)
+			return err
+		},
+	}
+	err := app.Run(append([]string{"tusc"}, args...))
+	return config, err
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	path := writeConfigFile(t, testConfigFile)
+
+	config, err := loadConfigFile(path)
+	if err != nil {
+		t.Fatalf("Failed to load config file: %v", err)
+	}
+	staging := config.Profiles["staging"]
+	if config.DefaultProfile != "local" || staging.ChunkSize != 8 || staging.Retries == nil || *staging.Retries != 0 {
+		t.Errorf("Unexpected config: %+v", config)
+	}
+	if staging.Headers["X-Tags"] != "red, green" {
+		t.Errorf("Expected the header value with a comma, got %q", staging.Headers["X-Tags"])
+	}
+
+	if config, err := loadConfigFile(filepath.Join(t.TempDir(), "missing.yaml")); err != nil || len(config.Profiles) != 0 {
+		t.Errorf("Expected a missing file to be an empty config, got %+v, %v", config, err)
+	}
+
+	writeConfigFile(t, "profiles:\n  x:\n    endpiont: http://example.com\n")
+	if _, err := loadConfigFile(configFilePath()); err == nil {
+		t.Error("Expected an unknown key to be rejected")
+	}
+}
+
+func TestProfilePrecedence(t *testing.T) {
+	writeConfigFile(t, testConfigFile)
+
+	// The default profile applies without --profile
+	config, err := runWithProfile(t, "--chunk-size", "4")
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	if config.Endpoint != "http://localhost:1080/files/" || config.ChunkSize != 4*1024*1024 {
+		t.Errorf("Unexpected default profile config: %+v", config)
+	}
+
+	// Flag > env > profile > defaults
+	t.Setenv("TUSC_PROFILE", "staging")
+	t.Setenv("TUSC_CHUNK_SIZE", "16")
+	config, err = runWithProfile(t, "--endpoint", "http://flag.example.com/files/", "-H", "Authorization: Bearer flag")
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	if config.Endpoint != "http://flag.example.com/files/" {
+		t.Errorf("Expected the flag endpoint, got %s", config.Endpoint)
+	}
+	if config.ChunkSize != 16*1024*1024 {
+		t.Errorf("Expected the env chunk size, got %d", config.ChunkSize)
+	}
+	if config.Retries != 0 || config.Jobs != 4 {
+		t.Errorf("Expected retries and jobs of the profile, got %d and %d", config.Retries, config.Jobs)
+	}
+	if config.Headers["Authorization"] != "Bearer flag" || config.Headers["X-Tags"] != "red, green" {
+		t.Errorf("Unexpected headers: %v", config.Headers)
+	}
+
+	metadata := config.fileMetadata("/tmp/report.pdf", "")
+	if metadata["project"] != "demo" || metadata["filename"] != "report.pdf" {
+		t.Errorf("Expected profile metadata below the file's, got %v", metadata)
+	}
+
+	if _, err := runWithProfile(t, "--profile", "production"); err == nil {
+		t.Error("Expected an unknown profile to be rejected")
+	}
+}
+
+func TestLoadTLSConfig(t *testing.T) {
+	if config, err := loadTLSConfig("", "", "", false); config != nil || err != nil {
+		t.Errorf("Expected Go's defaults without settings, got %v, %v", config, err)
+	}
+	if config, err := loadTLSConfig("", "", "", true); err != nil || !config.InsecureSkipVerify {
+		t.Errorf("Expected --insecure to skip verification, got %v, %v", config, err)
+	}
+	if _, err := loadTLSConfig("", "client.pem", "", false); err == nil {
+		t.Error("Expected --cert without --key to be rejected")
+	}
+
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	os.WriteFile(path, []byte("not a certificate"), 0600)
+	if _, err := loadTLSConfig(path, "", "", false); err == nil {
+		t.Error("Expected a CA file without certificates to be rejected")
+	}
+}
+
+func TestProfileHeadersReachUploadRequests(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+
+	mockServer := NewStatefulTUSServer()
+	defer mockServer.Close()
+	var patches atomic.Int32
+	mockServer.Verify = func(r *http.Request) error {
+		if r.Header.Get("X-Api-Key") != "from-profile" {
+			return fmt.Errorf("missing X-Api-Key on %s", r.Method)
+		}
+		if r.Method == http.MethodPatch {
+			patches.Add(1)
+		}
+		return nil
+	}
+
+	writeConfigFile(t, "profiles:\n  p:\n    endpoint: "+mockServer.URL()+"\n    headers:\n      X-Api-Key: from-profile\n")
+	config, err := runWithProfile(t, "--profile", "p")
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+
+	path, _ := createRandomFile(t, MinChunkSize)
+	if _, err := uploadFile(config, UploadTarget{Path: path}); err != nil {
+		t.Fatalf("Upload with profile headers failed: %v", err)
+	}
+	if patches.Load() == 0 {
+		t.Error("Expected the profile header on the PATCH requests")
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxRateWait bounds a single sleep of the limiter so a schedule change is
+// picked up within a second even while a large write is being paid off
+const maxRateWait = time.Second
+
+// RateWindow limits uploads to Rate bytes/s between two times of day, given
+// in minutes since midnight. A window with Start > End wraps past midnight.
+type RateWindow struct {
+	Start int
+	End   int
+	Rate  int64 // 0 means unlimited
+}
+
+// RateSchedule maps the time of day to an upload rate. The first matching
+// window wins, outside all windows Default applies.
+type RateSchedule struct {
+	Windows []RateWindow
+	Default int64 // 0 means unlimited
+}
+
+// parseRateLimit parses a --limit-rate value, either a single rate such as
+// "5MB/s" or a schedule such as "08:00-18:00=5MB/s,*=unlimited"
+func parseRateLimit(spec string) (*RateSchedule, error) {
+	spec = strings.TrimSpace(spec)
+	if !strings.Contains(spec, "=") {
+		rate, err := parseRate(spec)
+		if err != nil {
+			return nil, err
+		}
+		return &RateSchedule{Default: rate}, nil
+	}
+
+	schedule := &RateSchedule{}
+	for _, entry := range strings.Split(spec, ",") {
+		when, value, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid rate schedule entry %q, expected HH:MM-HH:MM=RATE or *=RATE", entry)
+		}
+
+		rate, err := parseRate(value)
+		if err != nil {
+			return nil, err
+		}
+
+		when = strings.TrimSpace(when)
+		if when == "*" {
+			schedule.Default = rate
+			continue
+		}
+
+		from, to, ok := strings.Cut(when, "-")
+		if !ok {
+			return nil, fmt.Errorf("invalid time range %q, expected HH:MM-HH:MM", when)
+		}
+		start, err := parseTimeOfDay(from)
+		if err != nil {
+			return nil, err
+		}
+		end, err := parseTimeOfDay(to)
+		if err != nil {
+			return nil, err
+		}
+		if start == end {
+			return nil, fmt.Errorf("empty time range %q", when)
+		}
+
+		schedule.Windows = append(schedule.Windows, RateWindow{Start: start, End: end, Rate: rate})
+	}
+
+	return schedule, nil
+}
+
+// parseRate parses a rate in bytes per second such as "512K", "5MB/s" or
+// "unlimited". Units are binary, like --chunk-size.
+func parseRate(value string) (int64, error) {
+	value = strings.TrimSpace(value)
+	if strings.EqualFold(value, "unlimited") {
+		return 0, nil
+	}
+
+	number := strings.TrimSuffix(value, "/s")
+	unit := strings.TrimLeft(number, "0123456789.")
+	number = strings.TrimSpace(strings.TrimSuffix(number, unit))
+
+	var multiplier int64
+	switch strings.ToUpper(strings.TrimSpace(unit)) {
+	case "", "B":
+		multiplier = 1
+	case "K", "KB", "KIB":
+		multiplier = 1024
+	case "M", "MB", "MIB":
+		multiplier = 1024 * 1024
+	case "G", "GB", "GIB":
+		multiplier = 1024 * 1024 * 1024
+	}
+
+	amount, err := strconv.ParseFloat(number, 64)
+	if err != nil || amount <= 0 || multiplier == 0 {
+		return 0, fmt.Errorf("invalid rate %q, expected e.g. 500K, 5MB/s or unlimited", value)
+	}
+
+	rate := int64(amount * float64(multiplier))
+	if rate < 1 {
+		return 0, fmt.Errorf("rate %q is below 1 byte/s", value)
+	}
+	return rate, nil
+}
+
+// parseTimeOfDay parses HH:MM into minutes since midnight, 24:00 is allowed
+// as the end of a range
+func parseTimeOfDay(value string) (int, error) {
+	hours, minutes, ok := strings.Cut(strings.TrimSpace(value), ":")
+	h, errH := strconv.Atoi(hours)
+	m, errM := strconv.Atoi(minutes)
+	if !ok || errH != nil || errM != nil || h < 0 || m < 0 || m > 59 || h*60+m > 24*60 {
+		return 0, fmt.Errorf("invalid time of day %q, expected HH:MM", value)
+	}
+	return h*60 + m, nil
+}
+
+// RateAt returns the rate in bytes/s that applies at t, 0 for unlimited
+func (s *RateSchedule) RateAt(t time.Time) int64 {
+	minute := t.Hour()*60 + t.Minute()
+	for _, window := range s.Windows {
+		inside := minute >= window.Start && minute < window.End
+		if window.Start > window.End {
+			inside = minute >= window.Start || minute < window.End
+		}
+		if inside {
+			return window.Rate
+		}
+	}
+	return s.Default
+}
+
+// String describes the schedule for verbose output
+func (s *RateSchedule) String() string {
+	format := func(rate int64) string {
+		if rate == 0 {
+			return "unlimited"
+		}
+		return formatBytes(rate) + "/s"
+	}
+
+	var parts []string
+	for _, window := range s.Windows {
+		parts = append(parts, fmt.Sprintf("%02d:%02d-%02d:%02d=%s",
+			window.Start/60, window.Start%60, window.End/60, window.End%60, format(window.Rate)))
+	}
+	if len(parts) == 0 {
+		return format(s.Default)
+	}
+	return strings.Join(append(parts, "*="+format(s.Default)), ",")
+}
+
+// RateLimiter is a token bucket shared by every upload of a run. The rate is
+// looked up in the schedule on every call, so long transfers follow it live.
+type RateLimiter struct {
+	mu       sync.Mutex
+	schedule *RateSchedule
+	tokens   float64 // may go negative, the debt is paid off by waiting
+	last     time.Time
+
+	now   func() time.Time
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+func NewRateLimiter(schedule *RateSchedule) *RateLimiter {
+	return &RateLimiter{
+		schedule: schedule,
+		now:      time.Now,
+		sleep:    sleepContext,
+	}
+}
+
+// WaitN blocks until n more bytes may be sent, or until ctx is done. The
+// bytes are taken from the bucket at once and every caller waits until the
+// shared debt is paid off, which keeps the combined rate of concurrent
+// uploads at the limit without holding the lock while waiting.
+func (l *RateLimiter) WaitN(ctx context.Context, n int) error {
+	l.mu.Lock()
+	if l.refill() > 0 {
+		l.tokens -= float64(n)
+	}
+	l.mu.Unlock()
+
+	for {
+		wait := l.debt()
+		if wait == 0 {
+			return nil
+		}
+		if err := l.sleep(ctx, min(wait, maxRateWait)); err != nil {
+			return err
+		}
+	}
+}
+
+// debt returns how long it takes to earn the tokens the bucket is short of
+// at the current rate, 0 when it isn't short or the rate is unlimited
+func (l *RateLimiter) debt() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	rate := l.refill()
+	if rate == 0 || l.tokens >= 0 {
+		return 0
+	}
+	return max(time.Duration(-l.tokens/rate*float64(time.Second)), time.Nanosecond)
+}
+
+// refill adds the tokens earned since the last call at the current rate and
+// returns that rate. At most one second worth of tokens is kept.
+func (l *RateLimiter) refill() float64 {
+	now := l.now()
+	rate := float64(l.schedule.RateAt(now))
+	if rate == 0 {
+		l.tokens = 0
+	} else if !l.last.IsZero() {
+		l.tokens = min(l.tokens+now.Sub(l.last).Seconds()*rate, rate)
+	}
+	l.last = now
+	return rate
+}
+
+// limitHTTPClient makes client send request bodies through limiter. Only
+// bodies are limited, so PATCH data is throttled but downloads are not.
+func limitHTTPClient(client *http.Client, limiter *RateLimiter) *http.Client {
+	if limiter == nil {
+		return client
+	}
+
+	base := client.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	client.Transport = &rateLimitedTransport{base: base, limiter: limiter}
+	return client
+}
+
+type rateLimitedTransport struct {
+	base    http.RoundTripper
+	limiter *RateLimiter
+}
+
+func (t *rateLimitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return t.base.RoundTrip(req)
+	}
+
+	limited := req.Clone(req.Context())
+	limited.Body = &rateLimitedBody{ReadCloser: req.Body, limiter: t.limiter, ctx: req.Context()}
+	if req.GetBody != nil {
+		limited.GetBody = func() (io.ReadCloser, error) {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			return &rateLimitedBody{ReadCloser: body, limiter: t.limiter, ctx: req.Context()}, nil
+		}
+	}
+	return t.base.RoundTrip(limited)
+}
+
+// rateLimitedBody pays for every read of a request body before handing the
+// bytes to the transport. Waiting stops when the request is cancelled.
+type rateLimitedBody struct {
+	io.ReadCloser
+	limiter *RateLimiter
+	ctx     context.Context
+}
+
+func (b *rateLimitedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		if err := b.limiter.WaitN(b.ctx, n); err != nil {
+			return 0, err
+		}
+	}
+	return n, err
+}
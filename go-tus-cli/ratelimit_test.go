@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+		valid    bool
+	}{
+		{"1024", 1024, true},
+		{"500K", 500 * 1024, true},
+		{"5MB/s", 5 * 1024 * 1024, true},
+		{"1.5 MiB/s", 3 * 512 * 1024, true},
+		{"2g", 2 * 1024 * 1024 * 1024, true},
+		{"unlimited", 0, true},
+		{"0", 0, false},
+		{"-5MB", 0, false},
+		{"5Mbps", 0, false},
+		{"fast", 0, false},
+	}
+
+	for _, test := range tests {
+		rate, err := parseRate(test.input)
+		if (err == nil) != test.valid {
+			t.Errorf("parseRate(%q) error = %v, expected valid: %v", test.input, err, test.valid)
+			continue
+		}
+		if test.valid && rate != test.expected {
+			t.Errorf("parseRate(%q) = %d, expected %d", test.input, rate, test.expected)
+		}
+	}
+}
+
+func TestParseRateLimit(t *testing.T) {
+	schedule, err := parseRateLimit("08:00-18:00=5MB/s, 22:00-06:00=20MB/s, *=unlimited")
+	if err != nil {
+		t.Fatalf("parseRateLimit failed: %v", err)
+	}
+
+	at := func(clock string) time.Time {
+		parsed, _ := time.Parse("15:04", clock)
+		return parsed
+	}
+
+	tests := []struct {
+		clock    string
+		expected int64
+	}{
+		{"07:59", 0},
+		{"08:00", 5 * 1024 * 1024},
+		{"17:59", 5 * 1024 * 1024},
+		{"18:00", 0},
+		{"23:30", 20 * 1024 * 1024},
+		{"03:00", 20 * 1024 * 1024},
+		{"06:00", 0},
+	}
+	for _, test := range tests {
+		if rate := schedule.RateAt(at(test.clock)); rate != test.expected {
+			t.Errorf("RateAt(%s) = %d, expected %d", test.clock, rate, test.expected)
+		}
+	}
+
+	if s := schedule.String(); s != "08:00-18:00=5.0 MB/s,22:00-06:00=20.0 MB/s,*=unlimited" {
+		t.Errorf("Unexpected schedule description: %s", s)
+	}
+
+	single, err := parseRateLimit("512K")
+	if err != nil || single.RateAt(at("12:00")) != 512*1024 {
+		t.Errorf("Expected a plain rate to apply all day, got %v (%v)", single, err)
+	}
+
+	for _, invalid := range []string{"8-18=5MB", "08:00=5MB", "08:00-08:00=5MB", "08:00-25:00=5MB", "*=nothing"} {
+		if _, err := parseRateLimit(invalid); err == nil {
+			t.Errorf("Expected %q to be rejected", invalid)
+		}
+	}
+}
+
+// fakeClock drives a RateLimiter without real sleeps
+type fakeClock struct {
+	now   time.Time
+	slept time.Duration
+}
+
+func (c *fakeClock) limiter(schedule *RateSchedule) *RateLimiter {
+	limiter := NewRateLimiter(schedule)
+	limiter.now = func() time.Time { return c.now }
+	limiter.sleep = func(ctx context.Context, d time.Duration) error {
+		c.now = c.now.Add(d)
+		c.slept += d
+		return ctx.Err()
+	}
+	return limiter
+}
+
+func TestRateLimiterWaitN(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.Local)}
+	limiter := clock.limiter(&RateSchedule{Default: 1000})
+
+	// 5000 bytes at 1000 bytes/s must take 5s, whatever the write sizes
+	limiter.WaitN(context.Background(), 2000)
+	limiter.WaitN(context.Background(), 3000)
+	if clock.slept < 4900*time.Millisecond || clock.slept > 5100*time.Millisecond {
+		t.Errorf("Expected about 5s of waiting, got %v", clock.slept)
+	}
+
+	// Idle time earns at most one second worth of tokens
+	clock.now = clock.now.Add(time.Minute)
+	clock.slept = 0
+	limiter.WaitN(context.Background(), 3000)
+	if clock.slept < 1900*time.Millisecond || clock.slept > 2100*time.Millisecond {
+		t.Errorf("Expected about 2s of waiting after idling, got %v", clock.slept)
+	}
+}
+
+func TestRateLimiterWaitNCancelled(t *testing.T) {
+	limiter := NewRateLimiter(&RateSchedule{Default: 1000})
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// A wait of 100s ends with the context, and doesn't block other callers
+	done := make(chan error, 1)
+	go func() { done <- limiter.WaitN(ctx, 100*1000) }()
+	time.Sleep(50 * time.Millisecond)
+
+	cancelled, cancelNow := context.WithCancel(context.Background())
+	cancelNow()
+	start := time.Now()
+	if err := limiter.WaitN(cancelled, 1); !errors.Is(err, context.Canceled) || time.Since(start) > time.Second {
+		t.Errorf("Expected another caller to be cancelled right away, got %v after %v", err, time.Since(start))
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected the wait to be cancelled, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the wait to end with its context")
+	}
+}
+
+func TestRateLimiterFollowsSchedule(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 17, 59, 58, 0, time.Local)}
+	schedule, _ := parseRateLimit("08:00-18:00=1K,*=unlimited")
+	limiter := clock.limiter(schedule)
+
+	// A write that would take 100s during work hours is released at 18:00
+	limiter.WaitN(context.Background(), 100*1024)
+	if clock.slept > 3*time.Second {
+		t.Errorf("Expected the limit to be lifted at 18:00, waited %v", clock.slept)
+	}
+
+	clock.slept = 0
+	limiter.WaitN(context.Background(), 100*1024*1024)
+	if clock.slept != 0 {
+		t.Errorf("Expected no waiting while unlimited, waited %v", clock.slept)
+	}
+}
+
+func TestRateLimitedUploads(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+
+	mockServer := NewStatefulTUSServer()
+	defer mockServer.Close()
+
+	dir := t.TempDir()
+	var targets []UploadTarget
+	for i := 0; i < 2; i++ {
+		path := filepath.Join(dir, fmt.Sprintf("file_%d.txt", i))
+		os.WriteFile(path, []byte(strings.Repeat("x", 64*1024)), 0644)
+		targets = append(targets, UploadTarget{Path: path})
+	}
+
+	// The limit is shared, two parallel 64KB uploads at 256KB/s take 0.5s
+	config := &Config{
+		Endpoint:   mockServer.URL(),
+		ChunkSize:  DefaultChunkSize,
+		Headers:    make(map[string]string),
+		Jobs:       2,
+		HTTPClient: limitHTTPClient(newHTTPClient(2, nil), NewRateLimiter(&RateSchedule{Default: 256 * 1024})),
+	}
+
+	start := time.Now()
+	for _, result := range uploadTargets(config, targets) {
+		if result.Err != nil {
+			t.Fatalf("Upload of %s failed: %v", result.Target.Path, result.Err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Errorf("Expected the combined rate to be limited, took only %v", elapsed)
+	}
+
+	for _, upload := range mockServer.Uploads() {
+		if upload.Offset != 64*1024 {
+			t.Errorf("Expected a complete upload, got offset %d", upload.Offset)
+		}
+	}
+}
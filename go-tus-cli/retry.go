@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultRetryDelay    = 1 * time.Second  // First backoff
+	DefaultRetryMaxDelay = 30 * time.Second // Longest single backoff
+)
+
+// RetryPolicy decides how often and how long to wait before failed requests
+// are repeated. It is shared by creation, HEAD and PATCH requests.
+type RetryPolicy struct {
+	BaseDelay  time.Duration // Backoff cap of the first retry, doubled per retry
+	MaxDelay   time.Duration // Backoff cap of any retry
+	MaxElapsed time.Duration // How long a streak of failures is retried, 0 for no limit
+
+	// Budgets limits the retries per error class, classes not listed get
+	// --retries
+	Budgets map[RetryAction]int
+}
+
+// Retrier applies the retry policy to one operation, e.g. one upload. Every
+// error class has its own budget for the whole operation, while the backoff
+// and MaxElapsed start over after each Success.
+type Retrier struct {
+	policy  RetryPolicy
+	retries int
+	used    map[RetryAction]int
+
+	backoffs int       // backoffs since the last success
+	since    time.Time // first failure since the last success
+
+	now    func() time.Time
+	jitter func(n int64) int64 // random number in [0, n)
+}
+
+// newRetrier starts retrying with config's policy, or the default policy if
+// it has none
+func newRetrier(config *Config) *Retrier {
+	policy := RetryPolicy{BaseDelay: DefaultRetryDelay, MaxDelay: DefaultRetryMaxDelay}
+	if config.Retry != nil {
+		policy = *config.Retry
+	}
+
+	return &Retrier{
+		policy:  policy,
+		retries: config.Retries,
+		used:    make(map[RetryAction]int),
+		now:     time.Now,
+		jitter:  rand.Int63n,
+	}
+}
+
+// budget returns how often errors of class action may be retried
+func (r *Retrier) budget(action RetryAction) int {
+	if budget, ok := r.policy.Budgets[action]; ok {
+		return budget
+	}
+	return r.retries
+}
+
+// Next reports whether err should be retried and how long to wait before.
+// Transient errors back off exponentially with full jitter, or as long as the
+// server asks with Retry-After. A conflict or damaged chunk is retried at once.
+func (r *Retrier) Next(err error) (time.Duration, bool) {
+	action := retryAction(err)
+	if action == FailFast || action == Recreate || r.used[action] >= r.budget(action) {
+		return 0, false
+	}
+
+	now := r.now()
+	if r.since.IsZero() {
+		r.since = now
+	}
+
+	var delay time.Duration
+	if action == Backoff {
+		ceiling := r.policy.MaxDelay
+		if r.backoffs < 32 && r.policy.BaseDelay<<r.backoffs < ceiling {
+			ceiling = r.policy.BaseDelay << r.backoffs
+		}
+		if ceiling > 0 {
+			delay = time.Duration(r.jitter(int64(ceiling) + 1))
+		}
+		if after, ok := retryAfter(err, now); ok && after > delay {
+			delay = after
+		}
+		r.backoffs++
+	}
+
+	if r.policy.MaxElapsed > 0 && now.Add(delay).Sub(r.since) > r.policy.MaxElapsed {
+		return 0, false
+	}
+
+	r.used[action]++
+	return delay, true
+}
+
+// Success ends a streak of failures
+func (r *Retrier) Success() {
+	r.backoffs = 0
+	r.since = time.Time{}
+}
+
+// Attempts returns how many retries were made
+func (r *Retrier) Attempts() int {
+	attempts := 0
+	for _, n := range r.used {
+		attempts += n
+	}
+	return attempts
+}
+
+// retryAfter returns the wait the server asked for with Retry-After, given in
+// seconds or as an HTTP date
+func retryAfter(err error, now time.Time) (time.Duration, bool) {
+	var httpErr *HTTPError
+	if !errors.As(err, &httpErr) {
+		return 0, false
+	}
+
+	value := httpErr.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return max(at.Sub(now), 0), true
+	}
+	return 0, false
+}
+
+// withRetry calls fn until it succeeds or the retry policy gives up
+func withRetry(config *Config, fn func() error) error {
+	ctx := config.runContext()
+	retrier := newRetrier(config)
+	for {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return errInterrupted
+		}
+
+		delay, ok := retrier.Next(err)
+		if !ok {
+			return err
+		}
+		config.emitRetry(retrier.Attempts(), delay, err)
+		if config.Verbose {
+			fmt.Fprintf(config.output(), "Request failed, retrying in %v: %v\n", delay.Round(time.Millisecond), err)
+		}
+		if err := sleepContext(ctx, delay); err != nil {
+			return errInterrupted
+		}
+	}
+}
+
+// sleepContext waits for d, or returns the context's error once ctx is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// parseRetryBudgets parses per error class retry budgets like
+// "backoff=5,resync=10,resend=3"
+func parseRetryBudgets(spec string) (map[RetryAction]int, error) {
+	budgets := make(map[RetryAction]int)
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		class, value, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("%q is not CLASS=N", item)
+		}
+
+		var action RetryAction
+		switch strings.ToLower(strings.TrimSpace(class)) {
+		case "backoff":
+			action = Backoff
+		case "resync":
+			action = Resync
+		case "resend":
+			action = Resend
+		default:
+			return nil, fmt.Errorf("unknown error class %q, expected backoff, resync or resend", class)
+		}
+
+		n, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid retry budget %q", value)
+		}
+		budgets[action] = n
+	}
+	return budgets, nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// testRetrier returns a retrier with a fake clock whose jitter always picks
+// the longest wait
+func testRetrier(policy RetryPolicy, retries int) (*Retrier, *time.Time) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	retrier := newRetrier(&Config{Retries: retries, Retry: &policy})
+	retrier.now = func() time.Time { return now }
+	retrier.jitter = func(n int64) int64 { return n - 1 }
+	return retrier, &now
+}
+
+func statusError(status int, header http.Header) error {
+	return &HTTPError{Phase: PhasePatch, StatusCode: status, Status: http.StatusText(status), Header: header}
+}
+
+func TestRetrierBackoff(t *testing.T) {
+	retrier, _ := testRetrier(RetryPolicy{BaseDelay: time.Second, MaxDelay: 5 * time.Second}, 10)
+
+	// Full jitter waits up to 1s, 2s, 4s, then at most MaxDelay
+	for _, expected := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second} {
+		delay, ok := retrier.Next(statusError(http.StatusServiceUnavailable, nil))
+		if !ok || delay != expected {
+			t.Errorf("Expected to wait up to %v, got %v (%v)", expected, delay, ok)
+		}
+	}
+
+	// Progress starts the backoff over
+	retrier.Success()
+	if delay, _ := retrier.Next(statusError(http.StatusBadGateway, nil)); delay != time.Second {
+		t.Errorf("Expected the backoff to start over after a success, got %v", delay)
+	}
+
+	// A conflict is resolved right away
+	if delay, ok := retrier.Next(statusError(http.StatusConflict, nil)); !ok || delay != 0 {
+		t.Errorf("Expected a conflict to be retried without waiting, got %v (%v)", delay, ok)
+	}
+	if _, ok := retrier.Next(statusError(http.StatusForbidden, nil)); ok {
+		t.Error("Expected 403 not to be retried")
+	}
+}
+
+func TestRetrierBudgets(t *testing.T) {
+	retrier, _ := testRetrier(RetryPolicy{BaseDelay: time.Second, MaxDelay: time.Second, Budgets: map[RetryAction]int{Resync: 1}}, 2)
+
+	conflict := statusError(http.StatusConflict, nil)
+	if _, ok := retrier.Next(conflict); !ok {
+		t.Error("Expected the first conflict to be retried")
+	}
+	if _, ok := retrier.Next(conflict); ok {
+		t.Error("Expected the resync budget of 1 to be used up")
+	}
+
+	// Other classes keep --retries, however often the others failed
+	unavailable := statusError(http.StatusServiceUnavailable, nil)
+	for i := 0; i < 2; i++ {
+		if _, ok := retrier.Next(unavailable); !ok {
+			t.Errorf("Expected backoff retry %d to be allowed", i+1)
+		}
+	}
+	if _, ok := retrier.Next(unavailable); ok {
+		t.Error("Expected the backoff budget of --retries to be used up")
+	}
+	if retrier.Attempts() != 3 {
+		t.Errorf("Expected 3 retries, got %d", retrier.Attempts())
+	}
+}
+
+func TestRetrierMaxElapsed(t *testing.T) {
+	retrier, now := testRetrier(RetryPolicy{BaseDelay: 10 * time.Second, MaxDelay: time.Minute, MaxElapsed: 30 * time.Second}, 10)
+
+	unavailable := statusError(http.StatusServiceUnavailable, nil)
+	delay, ok := retrier.Next(unavailable)
+	if !ok {
+		t.Fatal("Expected the first failure to be retried")
+	}
+	*now = now.Add(delay)
+
+	// 10s have passed, waiting 20s more ends right at the limit
+	delay, ok = retrier.Next(unavailable)
+	if !ok || delay != 20*time.Second {
+		t.Fatalf("Expected a 20s wait, got %v (%v)", delay, ok)
+	}
+	*now = now.Add(delay)
+
+	if _, ok := retrier.Next(unavailable); ok {
+		t.Error("Expected retrying to stop after 30s of failures")
+	}
+}
+
+func TestRetrierRetryAfter(t *testing.T) {
+	retrier, now := testRetrier(RetryPolicy{BaseDelay: time.Second, MaxDelay: time.Second}, 10)
+
+	delay, _ := retrier.Next(statusError(http.StatusTooManyRequests, http.Header{"Retry-After": {"120"}}))
+	if delay != 2*time.Minute {
+		t.Errorf("Expected to wait the 120s the server asked for, got %v", delay)
+	}
+
+	date := now.Add(45 * time.Second).Format(http.TimeFormat)
+	delay, _ = retrier.Next(statusError(http.StatusServiceUnavailable, http.Header{"Retry-After": {date}}))
+	if delay != 45*time.Second {
+		t.Errorf("Expected to wait until the Retry-After date, got %v", delay)
+	}
+
+	// A Retry-After shorter than the backoff doesn't shorten it
+	delay, _ = retrier.Next(statusError(http.StatusLocked, http.Header{"Retry-After": {"0"}}))
+	if delay != time.Second {
+		t.Errorf("Expected the backoff, got %v", delay)
+	}
+}
+
+func TestParseRetryBudgets(t *testing.T) {
+	budgets, err := parseRetryBudgets("backoff=5, resync=10,RESEND=0")
+	if err != nil {
+		t.Fatalf("parseRetryBudgets failed: %v", err)
+	}
+	if budgets[Backoff] != 5 || budgets[Resync] != 10 || budgets[Resend] != 0 || len(budgets) != 3 {
+		t.Errorf("Unexpected budgets: %v", budgets)
+	}
+
+	for _, invalid := range []string{"backoff", "backoff=-1", "fail=3", "recreate=1", "resync=many"} {
+		if _, err := parseRetryBudgets(invalid); err == nil {
+			t.Errorf("Expected %q to be rejected", invalid)
+		}
+	}
+}
+
+func TestUploadRetryBudget(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+
+	mockServer := NewStatefulTUSServer()
+	mockServer.PatchStatuses = []int{503, 503, 503}
+	defer mockServer.Close()
+
+	// One retry doesn't get past three failures
+	_, err := uploadTestFile(t, mockServer, MinChunkSize, 1, &RetryPolicy{MaxDelay: 10 * time.Millisecond})
+	var httpErr *HTTPError
+	if !errors.As(err, &httpErr) || httpErr.StatusCode != 503 {
+		t.Fatalf("Expected the upload to fail with 503, got %v", err)
+	}
+
+	// A larger backoff budget does
+	mockServer.PatchStatuses = []int{503, 503, 503}
+	policy := &RetryPolicy{BaseDelay: 10 * time.Millisecond, MaxDelay: 10 * time.Millisecond, Budgets: map[RetryAction]int{Backoff: 3}}
+	if _, err := uploadTestFile(t, mockServer, MinChunkSize, 1, policy); err != nil {
+		t.Fatalf("Expected the upload to succeed with a backoff budget of 3: %v", err)
+	}
+}
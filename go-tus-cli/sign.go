@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Signer signs requests to the tus server, every attempt anew and after the
+// credentials are set. payload is the request body, nil without one.
+type Signer interface {
+	Sign(req *http.Request, payload []byte) error
+}
+
+// SignerOptions are the --sign-* values
+type SignerOptions struct {
+	KeyID   string
+	Secret  string
+	Region  string
+	Service string
+}
+
+// signers builds the Signer of each --sign scheme
+var signers = map[string]func(opts SignerOptions) (Signer, error){
+	"hmac-sha256": newHMACSigner,
+	"aws-sigv4":   newSigV4Signer,
+}
+
+// signedHeaders are the tus headers that are signed when a request has them
+var signedHeaders = []string{
+	"content-type", "tus-resumable", "upload-checksum", "upload-concat",
+	"upload-defer-length", "upload-length", "upload-metadata", "upload-offset",
+}
+
+// newSigner builds the Signer of scheme, nil if scheme is empty
+func newSigner(scheme string, opts SignerOptions) (Signer, error) {
+	if scheme == "" {
+		return nil, nil
+	}
+	build, ok := signers[scheme]
+	if !ok {
+		var schemes []string
+		for name := range signers {
+			schemes = append(schemes, name)
+		}
+		slices.Sort(schemes)
+		return nil, fmt.Errorf("unknown signing scheme %q (supported: %s)", scheme, strings.Join(schemes, ", "))
+	}
+	return build(opts)
+}
+
+// HMACSigner signs the request target, host, date, tus headers and body
+// digest with HMAC-SHA256 in a Signature header, like draft-cavage HTTP
+// signatures:
+//
+//	Signature: keyId="id",algorithm="hmac-sha256",headers="(request-target) host date digest",signature="..."
+type HMACSigner struct {
+	KeyID  string
+	Secret []byte
+	now    func() time.Time
+}
+
+func newHMACSigner(opts SignerOptions) (Signer, error) {
+	if opts.KeyID == "" || opts.Secret == "" {
+		return nil, fmt.Errorf("hmac-sha256 signing requires --sign-key-id and --sign-secret")
+	}
+	return &HMACSigner{KeyID: opts.KeyID, Secret: []byte(opts.Secret), now: time.Now}, nil
+}
+
+func (s *HMACSigner) Sign(req *http.Request, payload []byte) error {
+	req.Header.Set("Date", s.now().UTC().Format(http.TimeFormat))
+	headers := []string{"(request-target)", "host", "date"}
+	if payload != nil {
+		digest := sha256.Sum256(payload)
+		req.Header.Set("Digest", "SHA-256="+base64.StdEncoding.EncodeToString(digest[:]))
+		headers = append(headers, "digest")
+	}
+	for _, name := range signedHeaders {
+		if req.Header.Get(name) != "" {
+			headers = append(headers, name)
+		}
+	}
+
+	mac := hmac.New(sha256.New, s.Secret)
+	mac.Write([]byte(hmacSigningString(req, headers)))
+	req.Header.Set("Signature", fmt.Sprintf(`keyId="%s",algorithm="hmac-sha256",headers="%s",signature="%s"`,
+		s.KeyID, strings.Join(headers, " "), base64.StdEncoding.EncodeToString(mac.Sum(nil))))
+	return nil
+}
+
+// hmacSigningString returns the "name: value" lines of headers that are signed
+func hmacSigningString(req *http.Request, headers []string) string {
+	lines := make([]string, len(headers))
+	for i, name := range headers {
+		switch name {
+		case "(request-target)":
+			lines[i] = name + ": " + strings.ToLower(req.Method) + " " + req.URL.RequestURI()
+		case "host":
+			lines[i] = name + ": " + requestHost(req)
+		default:
+			lines[i] = name + ": " + req.Header.Get(name)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// SigV4Signer signs requests with AWS Signature Version 4, e.g. for an API
+// Gateway (service execute-api) in front of tusd
+type SigV4Signer struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	Region          string
+	Service         string
+	now             func() time.Time
+}
+
+// newSigV4Signer takes what opts leave empty from the AWS_* environment
+// variables, like the AWS CLI
+func newSigV4Signer(opts SignerOptions) (Signer, error) {
+	s := &SigV4Signer{
+		AccessKeyID:     opts.KeyID,
+		SecretAccessKey: opts.Secret,
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		Region:          opts.Region,
+		Service:         opts.Service,
+		now:             time.Now,
+	}
+	for _, value := range []struct {
+		field *string
+		env   []string
+	}{
+		{&s.AccessKeyID, []string{"AWS_ACCESS_KEY_ID"}},
+		{&s.SecretAccessKey, []string{"AWS_SECRET_ACCESS_KEY"}},
+		{&s.Region, []string{"AWS_REGION", "AWS_DEFAULT_REGION"}},
+	} {
+		for _, name := range value.env {
+			if *value.field == "" {
+				*value.field = os.Getenv(name)
+			}
+		}
+	}
+	if s.Service == "" {
+		s.Service = "execute-api"
+	}
+
+	if s.AccessKeyID == "" || s.SecretAccessKey == "" || s.Region == "" {
+		return nil, fmt.Errorf("aws-sigv4 signing requires an access key, a secret key and a region (--sign-key-id, --sign-secret and --sign-region, or AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_REGION)")
+	}
+	return s, nil
+}
+
+func (s *SigV4Signer) Sign(req *http.Request, payload []byte) error {
+	now := s.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	payloadHash := hexSHA256(payload)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	if s.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.SessionToken)
+	}
+	if s.Service == "s3" {
+		req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	}
+
+	// Host, the x-amz-* headers and the tus headers are signed
+	headers := map[string]string{"host": requestHost(req)}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if strings.HasPrefix(name, "x-amz-") || slices.Contains(signedHeaders, name) {
+			headers[name] = strings.Join(strings.Fields(strings.Join(values, ",")), " ")
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+
+	// The path as sent, which services other than S3 encode once more
+	path := req.URL.EscapedPath()
+	if s.Service != "s3" {
+		path = uriEncode(path, true)
+	}
+	if path == "" {
+		path = "/"
+	}
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		strings.Join(names, ";"),
+		payloadHash,
+	}, "\n")
+	scope := day + "/" + s.Region + "/" + s.Service + "/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hexSHA256([]byte(canonicalRequest))
+
+	key := []byte("AWS4" + s.SecretAccessKey)
+	for _, part := range []string{day, s.Region, s.Service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKeyID, scope, strings.Join(names, ";"), hex.EncodeToString(hmacSHA256(key, stringToSign))))
+	return nil
+}
+
+// canonicalQuery returns the query sorted by key and value, each encoded
+func canonicalQuery(query url.Values) string {
+	var pairs []string
+	for key, values := range query {
+		for _, value := range values {
+			pairs = append(pairs, uriEncode(key, false)+"="+uriEncode(value, false))
+		}
+	}
+	slices.Sort(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// uriEncode percent-encodes everything but unreserved characters and, in
+// paths, slashes
+func uriEncode(s string, path bool) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', path && c == '/':
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hexSHA256(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// requestHost returns the Host header the request is sent with
+func requestHost(req *http.Request) string {
+	if req.Host != "" {
+		return req.Host
+	}
+	return req.URL.Host
+}
+
+// signTransport signs every request. Bodies are read before they're sent
+// to sign their digest, they are at most a chunk.
+type signTransport struct {
+	base   http.RoundTripper
+	signer Signer
+}
+
+// signHTTPClient makes client sign its requests with signer
+func signHTTPClient(client *http.Client, signer Signer) *http.Client {
+	if signer == nil {
+		return client
+	}
+
+	base := client.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	client.Transport = &signTransport{base: base, signer: signer}
+	return client
+}
+
+func (t *signTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	signed := req.Clone(req.Context())
+
+	var payload []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		var err error
+		payload, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read request body: %w", err)
+		}
+		signed.Body = io.NopCloser(bytes.NewReader(payload))
+		signed.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(payload)), nil
+		}
+		signed.ContentLength = int64(len(payload))
+	}
+
+	if err := t.signer.Sign(signed, payload); err != nil {
+		return nil, err
+	}
+	return t.base.RoundTrip(signed)
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/bdragon300/tusgo"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	ExitInterrupted   = 3               // Exit code when a signal stopped the transfer
+	CheckpointTimeout = 5 * time.Second // How long to ask the server for the offset after a signal
+)
+
+// errInterrupted is returned by transfers stopped by SIGINT or SIGTERM
+var errInterrupted = errors.New("interrupted")
+
+// PauseGate holds transfers between chunks while paused. A nil gate is never paused.
+type PauseGate struct {
+	mu     sync.Mutex
+	resume chan struct{} // closed on resume, nil while running
+}
+
+// Pause reports whether the gate was running
+func (g *PauseGate) Pause() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.resume != nil {
+		return false
+	}
+	g.resume = make(chan struct{})
+	return true
+}
+
+// Resume reports whether the gate was paused
+func (g *PauseGate) Resume() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.resume == nil {
+		return false
+	}
+	close(g.resume)
+	g.resume = nil
+	return true
+}
+
+// Wait blocks while the gate is paused or until ctx is done
+func (g *PauseGate) Wait(ctx context.Context) error {
+	if g == nil {
+		return ctx.Err()
+	}
+
+	g.mu.Lock()
+	resume := g.resume
+	g.mu.Unlock()
+
+	if resume != nil {
+		select {
+		case <-resume:
+		case <-ctx.Done():
+		}
+	}
+	return ctx.Err()
+}
+
+// watchSignals cancels the context of config on an interrupt signal and pauses
+// or resumes its transfers on the pause and resume signals. A second interrupt
+// kills the process right away. The returned function stops watching.
+func watchSignals(config *Config) func() {
+	ctx, cancel := context.WithCancel(config.runContext())
+	config.ctx = ctx
+	config.pause = &PauseGate{}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, slices.Concat(interruptSignals, pauseSignals, resumeSignals)...)
+
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case sig := <-signals:
+				switch {
+				case slices.Contains(pauseSignals, sig):
+					if config.pause.Pause() {
+						fmt.Fprintf(config.output(), "\nPaused, send SIGUSR2 to resume\n")
+					}
+				case slices.Contains(resumeSignals, sig):
+					if config.pause.Resume() {
+						fmt.Fprintf(config.output(), "Resumed\n")
+					}
+				default:
+					fmt.Fprintf(config.output(), "\nInterrupted, stopping the transfer...\n")
+					signal.Reset(interruptSignals...)
+					cancel()
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(signals)
+		close(done)
+		cancel()
+	}
+}
+
+// confirmedOffset asks the server how much of an interrupted upload it
+// stored. The run's context is cancelled by then, so the HEAD gets its own.
+func confirmedOffset(tusClient *tusgo.Client, location string) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), CheckpointTimeout)
+	defer cancel()
+
+	var upload tusgo.Upload
+	resp, err := tusClient.WithContext(ctx).GetUpload(&upload, location)
+	if err != nil {
+		return 0, wrapResponseError(PhaseHead, resp, err)
+	}
+	return upload.RemoteOffset, nil
+}
+
+// checkpointUpload records the offsets the server confirmed for an
+// interrupted upload, or for each of its parts, in the state file so the
+// next run resumes right there. Without an answer the last acknowledged
+// offset from the journal is kept.
+func checkpointUpload(config *Config, tusClient *tusgo.Client, state *UploadState) {
+	if len(state.Parts) == 0 {
+		if offset, err := confirmedOffset(tusClient, state.UploadURL); err == nil {
+			state.UploadOffset = offset
+		} else if config.Verbose {
+			fmt.Fprintf(config.output(), "Warning: failed to get the upload offset: %v\n", err)
+		}
+	}
+	for i := range state.Parts {
+		part := &state.Parts[i]
+		if part.UploadURL == "" {
+			continue
+		}
+		if offset, err := confirmedOffset(tusClient, part.UploadURL); err == nil {
+			part.UploadOffset = offset
+		} else if config.Verbose {
+			fmt.Fprintf(config.output(), "Warning: failed to get the offset of part %d: %v\n", i+1, err)
+		}
+	}
+
+	if err := saveUploadState(state); err != nil {
+		fmt.Fprintf(config.output(), "Warning: failed to save upload state: %v\n", err)
+		return
+	}
+
+	confirmed := state.UploadOffset
+	for _, part := range state.Parts {
+		confirmed += part.UploadOffset
+	}
+	fmt.Fprintf(config.output(), "%s: %s of %s confirmed by the server, run the same command again to resume\n",
+		filepath.Base(state.FilePath), formatBytes(confirmed), formatBytes(state.FileSize))
+}
+
+// interruptedExit turns an error of an interrupted run into the
+// ExitInterrupted exit code
+func interruptedExit(err error) error {
+	if errors.Is(err, errInterrupted) {
+		return cli.NewExitError("Upload interrupted", ExitInterrupted)
+	}
+	return err
+}
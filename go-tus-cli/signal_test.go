@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+func TestPauseGate(t *testing.T) {
+	var none *PauseGate
+	if err := none.Wait(context.Background()); err != nil {
+		t.Errorf("Expected a nil gate never to hold, got %v", err)
+	}
+
+	gate := &PauseGate{}
+	if !gate.Pause() || gate.Pause() {
+		t.Error("Expected only the first Pause to pause the gate")
+	}
+
+	waited := make(chan error, 1)
+	go func() { waited <- gate.Wait(context.Background()) }()
+	select {
+	case err := <-waited:
+		t.Fatalf("Expected Wait to block while paused, got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if !gate.Resume() || gate.Resume() {
+		t.Error("Expected only the first Resume to resume the gate")
+	}
+	select {
+	case err := <-waited:
+		if err != nil {
+			t.Errorf("Expected Wait to return after Resume, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected Wait to return after Resume")
+	}
+
+	// An interrupt ends the pause
+	gate.Pause()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := gate.Wait(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected a cancelled Wait to fail, got %v", err)
+	}
+}
+
+func TestUploadInterruptCheckpoints(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+
+	mockServer := NewStatefulTUSServer()
+	defer mockServer.Close()
+
+	path, data := createRandomFile(t, 4*MinChunkSize)
+	fileInfo, _ := os.Stat(path)
+
+	// SIGINT arrives while the second chunk is acknowledged
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mockServer.Patched = func(offset int64) {
+		if offset >= 2*MinChunkSize {
+			cancel()
+		}
+	}
+
+	config := &Config{Endpoint: mockServer.URL(), ChunkSize: MinChunkSize, Retries: 3, Headers: make(map[string]string), ctx: ctx}
+	if _, err := uploadFile(config, UploadTarget{Path: path}); !errors.Is(err, errInterrupted) {
+		t.Fatalf("Expected the upload to be interrupted, got %v", err)
+	}
+
+	state, err := loadUploadState(generateFileID(path, fileInfo))
+	if err != nil || state == nil {
+		t.Fatalf("Expected the state to be kept: %v", err)
+	}
+	if state.UploadOffset != 2*MinChunkSize {
+		t.Errorf("Expected the confirmed offset %d in the state, got %d", 2*MinChunkSize, state.UploadOffset)
+	}
+
+	// The next run sends only the rest
+	mockServer.mu.Lock()
+	mockServer.Patched = nil
+	mockServer.mu.Unlock()
+
+	config.ctx = nil
+	if _, err := uploadFile(config, UploadTarget{Path: path}); err != nil {
+		t.Fatalf("Resumed upload failed: %v", err)
+	}
+	if patches := mockServer.PatchCount(); patches != 4 {
+		t.Errorf("Expected 4 PATCH requests in total, got %d", patches)
+	}
+	for _, upload := range mockServer.Uploads() {
+		if !bytes.Equal(upload.Data, data) {
+			t.Error("Uploaded data doesn't match")
+		}
+	}
+}
+
+func TestUploadResultsExit(t *testing.T) {
+	failed := UploadResult{Err: errors.New("failed")}
+	interrupted := UploadResult{Err: errInterrupted}
+
+	if err := uploadResultsExit([]UploadResult{{}, {}}); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+
+	var exit cli.ExitCoder
+	if err := uploadResultsExit([]UploadResult{{}, failed}); !errors.As(err, &exit) || exit.ExitCode() != 1 {
+		t.Errorf("Expected exit code 1, got %v", err)
+	}
+	if err := uploadResultsExit([]UploadResult{failed, interrupted}); !errors.As(err, &exit) || exit.ExitCode() != ExitInterrupted {
+		t.Errorf("Expected exit code %d, got %v", ExitInterrupted, err)
+	}
+}
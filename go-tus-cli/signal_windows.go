@@ -0,0 +1,16 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// Windows has no signals to pause a transfer with
+
+var (
+	interruptSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	pauseSignals     []os.Signal
+	resumeSignals    []os.Signal
+)
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// Spool is a bounded on-disk ring buffer for sources that cannot be rewound,
+// like stdin or a FIFO. It holds the bytes the server has not acknowledged
+// yet, so a failed PATCH can be replayed from disk. Offsets are absolute
+// positions in the source.
+type Spool struct {
+	file     *os.File
+	capacity int64
+	start    int64 // offset of the oldest byte held
+	end      int64 // offset after the newest byte held
+}
+
+// NewSpool creates a spool holding up to capacity bytes in a temporary file
+func NewSpool(capacity int64) (*Spool, error) {
+	file, err := os.CreateTemp("", "tusc-spool-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create spool file: %v", err)
+	}
+
+	return &Spool{file: file, capacity: capacity}, nil
+}
+
+// Close removes the spool file
+func (s *Spool) Close() error {
+	s.file.Close()
+	return os.Remove(s.file.Name())
+}
+
+// Start returns the offset of the oldest byte held
+func (s *Spool) Start() int64 {
+	return s.start
+}
+
+// End returns the offset after the newest byte held
+func (s *Spool) End() int64 {
+	return s.end
+}
+
+// Free returns how many bytes can be added before the spool is full
+func (s *Spool) Free() int64 {
+	return s.capacity - (s.end - s.start)
+}
+
+// Write appends p to the spool. It fails if p does not fit.
+func (s *Spool) Write(p []byte) (int, error) {
+	if int64(len(p)) > s.Free() {
+		return 0, fmt.Errorf("spool full: %d bytes held, capacity %d", s.end-s.start, s.capacity)
+	}
+
+	written := 0
+	for written < len(p) {
+		pos := (s.end + int64(written)) % s.capacity
+		n := min(int64(len(p)-written), s.capacity-pos)
+		if _, err := s.file.WriteAt(p[written:written+int(n)], pos); err != nil {
+			return written, fmt.Errorf("failed to write spool: %v", err)
+		}
+		written += int(n)
+	}
+
+	s.end += int64(written)
+	return written, nil
+}
+
+// Fill reads up to n bytes from src into the spool. It returns io.EOF if src
+// ended before n bytes were read.
+func (s *Spool) Fill(src io.Reader, n int64) (int64, error) {
+	if n > s.Free() {
+		n = s.Free()
+	}
+	return io.CopyN(s, src, n)
+}
+
+// Reader returns a reader for the n bytes held at offset
+func (s *Spool) Reader(offset, n int64) (io.Reader, error) {
+	if offset < s.start || offset+n > s.end {
+		return nil, fmt.Errorf("bytes %d-%d are not in the spool (%d-%d)", offset, offset+n, s.start, s.end)
+	}
+
+	// The range may wrap around the end of the file
+	pos := offset % s.capacity
+	if first := s.capacity - pos; n > first {
+		return io.MultiReader(
+			io.NewSectionReader(s.file, pos, first),
+			io.NewSectionReader(s.file, 0, n-first),
+		), nil
+	}
+	return io.NewSectionReader(s.file, pos, n), nil
+}
+
+// Release drops the bytes before offset once the server acknowledged them
+func (s *Spool) Release(offset int64) {
+	if offset > s.start {
+		s.start = min(offset, s.end)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestSpoolWrapsAround(t *testing.T) {
+	spool, err := NewSpool(10)
+	if err != nil {
+		t.Fatalf("NewSpool failed: %v", err)
+	}
+	defer spool.Close()
+
+	src := bytes.NewReader([]byte("abcdefghijklmnopqrstuvwxyz"))
+
+	if n, err := spool.Fill(src, 8); n != 8 || err != nil {
+		t.Fatalf("Fill returned %d, %v", n, err)
+	}
+	if _, err := spool.Write([]byte("xyz")); err == nil {
+		t.Error("Expected an error when writing past the capacity")
+	}
+
+	spool.Release(6)
+	if spool.Start() != 6 || spool.Free() != 8 {
+		t.Errorf("Unexpected spool state after release: start %d, free %d", spool.Start(), spool.Free())
+	}
+
+	// Bytes 8-15 wrap around the end of the spool file
+	if n, err := spool.Fill(src, 8); n != 8 || err != nil {
+		t.Fatalf("Fill returned %d, %v", n, err)
+	}
+
+	reader, err := spool.Reader(6, 10)
+	if err != nil {
+		t.Fatalf("Reader failed: %v", err)
+	}
+	data, _ := io.ReadAll(reader)
+	if string(data) != "ghijklmnop" {
+		t.Errorf("Expected %q, got %q", "ghijklmnop", data)
+	}
+
+	if _, err := spool.Reader(2, 4); err == nil {
+		t.Error("Expected an error for released bytes")
+	}
+}
+
+func TestSpoolFillEOF(t *testing.T) {
+	spool, err := NewSpool(10)
+	if err != nil {
+		t.Fatalf("NewSpool failed: %v", err)
+	}
+	defer spool.Close()
+
+	n, err := spool.Fill(bytes.NewReader([]byte("abc")), 10)
+	if n != 3 || !errors.Is(err, io.EOF) {
+		t.Errorf("Expected 3 bytes and EOF, got %d, %v", n, err)
+	}
+	if spool.End() != 3 {
+		t.Errorf("Expected end 3, got %d", spool.End())
+	}
+}
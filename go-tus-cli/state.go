@@ -0,0 +1,114 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// stateDir returns the per-user directory tusc keeps its state in,
+// $XDG_STATE_HOME/tusc or ~/.local/state/tusc. State used to be written to
+// the working directory, so running tusc from elsewhere (e.g. cron) never
+// found it.
+func stateDir() string {
+	if dir := os.Getenv("XDG_STATE_HOME"); filepath.IsAbs(dir) {
+		return filepath.Join(dir, "tusc")
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		return filepath.Join(home, ".local", "state", "tusc")
+	}
+	return ".tusc_state"
+}
+
+// uploadStateDir returns the directory holding one state file per upload
+func uploadStateDir() string {
+	return filepath.Join(stateDir(), "uploads")
+}
+
+// absPath makes path absolute so state written from one working directory is
+// found from any other
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
+
+// hashFileID hashes path, size and modification time into a file ID
+func hashFileID(path string, fileInfo os.FileInfo) string {
+	data := fmt.Sprintf("%s-%d-%d", path, fileInfo.Size(), fileInfo.ModTime().Unix())
+	return fmt.Sprintf("%x", md5.Sum([]byte(data)))
+}
+
+// migrateLegacyState moves a state file left in the working directory by
+// older versions, keyed by the path as given, into the state directory
+func migrateLegacyState(filePath string, fileInfo os.FileInfo, fileID string) {
+	legacyFile := fmt.Sprintf(".tusc_%s.json", hashFileID(filePath, fileInfo))
+	data, err := os.ReadFile(legacyFile)
+	if err != nil {
+		return
+	}
+
+	var state UploadState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return
+	}
+
+	state.FileID = fileID
+	state.FilePath = absPath(filePath)
+	if existing, _ := loadUploadState(fileID); existing == nil {
+		if err := saveUploadState(&state); err != nil {
+			return
+		}
+	}
+	os.Remove(legacyFile)
+}
+
+// loadUploadStates returns every state in the state directory, oldest first
+func loadUploadStates() ([]*UploadState, error) {
+	matches, err := filepath.Glob(getStateFilePath("*"))
+	if err != nil {
+		return nil, err
+	}
+
+	var states []*UploadState
+	for _, stateFile := range matches {
+		data, err := os.ReadFile(stateFile)
+		if err != nil {
+			continue
+		}
+
+		var state UploadState
+		if err := json.Unmarshal(data, &state); err != nil || state.Version > StateVersion {
+			continue
+		}
+		if err := replayJournal(&state); err != nil {
+			continue
+		}
+		states = append(states, &state)
+	}
+
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].CreatedAt.Before(states[j].CreatedAt)
+	})
+	return states, nil
+}
+
+// checkResumable returns why a state can no longer be resumed, or nil
+func checkResumable(state *UploadState, now time.Time) error {
+	fileInfo, err := os.Stat(state.FilePath)
+	if err != nil {
+		return fmt.Errorf("file is gone")
+	}
+	if fileInfo.Size() != state.FileSize || !fileInfo.ModTime().Equal(state.FileModTime) {
+		return fmt.Errorf("file changed since the upload started")
+	}
+	if isUploadStateExpired(state, now) {
+		return fmt.Errorf("upload expired at %s", stateExpiresAt(state).Local().Format(time.RFC1123))
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStateDir(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", "/var/lib/someone/state")
+	if dir := stateDir(); dir != filepath.Join("/var/lib/someone/state", "tusc") {
+		t.Errorf("Expected XDG_STATE_HOME to be used, got %s", dir)
+	}
+
+	// The spec says relative paths are invalid and must be ignored
+	t.Setenv("XDG_STATE_HOME", "relative/state")
+	t.Setenv("HOME", "/home/someone")
+	if dir := stateDir(); dir != filepath.Join("/home/someone", ".local", "state", "tusc") {
+		t.Errorf("Expected ~/.local/state to be used, got %s", dir)
+	}
+}
+
+func TestGenerateFileIDIgnoresWorkingDirectory(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "data.bin")
+	os.WriteFile(path, []byte("same file"), 0644)
+	fileInfo, _ := os.Stat(path)
+
+	wd, _ := os.Getwd()
+	os.Chdir(dir)
+	defer os.Chdir(wd)
+
+	if generateFileID("data.bin", fileInfo) != generateFileID(path, fileInfo) {
+		t.Error("Relative and absolute paths of the same file should share a file ID")
+	}
+}
+
+func TestMigrateLegacyState(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+
+	dir := t.TempDir()
+	wd, _ := os.Getwd()
+	os.Chdir(dir)
+	defer os.Chdir(wd)
+
+	os.WriteFile("legacy.bin", []byte("interrupted upload"), 0644)
+	fileInfo, _ := os.Stat("legacy.bin")
+
+	// Older versions keyed the state by the path as given and kept it in the working directory
+	legacyFile := ".tusc_" + hashFileID("legacy.bin", fileInfo) + ".json"
+	data, _ := json.Marshal(&UploadState{
+		FileID:    hashFileID("legacy.bin", fileInfo),
+		FilePath:  "legacy.bin",
+		FileSize:  fileInfo.Size(),
+		UploadURL: "http://localhost:1080/files/legacy",
+	})
+	os.WriteFile(legacyFile, data, 0644)
+
+	state, err := lookupUploadState("legacy.bin")
+	if err != nil || state == nil {
+		t.Fatalf("Expected migrated state, got %v (%v)", state, err)
+	}
+
+	if state.UploadURL != "http://localhost:1080/files/legacy" {
+		t.Errorf("Unexpected upload URL: %s", state.UploadURL)
+	}
+	if state.FilePath != filepath.Join(absPath("."), "legacy.bin") {
+		t.Errorf("Expected an absolute file path, got %s", state.FilePath)
+	}
+	if _, err := os.Stat(legacyFile); !os.IsNotExist(err) {
+		t.Error("Legacy state file should have been removed")
+	}
+	if _, err := os.Stat(getStateFilePath(state.FileID)); err != nil {
+		t.Errorf("State should be in the state directory: %v", err)
+	}
+}
+
+func TestCheckResumable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.bin")
+	os.WriteFile(path, []byte("pending"), 0644)
+	fileInfo, _ := os.Stat(path)
+
+	now := time.Now()
+	past := now.Add(-time.Minute)
+
+	tests := []struct {
+		name      string
+		state     UploadState
+		resumable bool
+	}{
+		{"unchanged", UploadState{FilePath: path, FileSize: fileInfo.Size(), FileModTime: fileInfo.ModTime()}, true},
+		{"missing", UploadState{FilePath: path + ".gone", FileSize: fileInfo.Size(), FileModTime: fileInfo.ModTime()}, false},
+		{"changed", UploadState{FilePath: path, FileSize: fileInfo.Size() + 1, FileModTime: fileInfo.ModTime()}, false},
+		{"expired", UploadState{FilePath: path, FileSize: fileInfo.Size(), FileModTime: fileInfo.ModTime(), ExpiresAt: &past}, false},
+	}
+
+	for _, test := range tests {
+		if err := checkResumable(&test.state, now); (err == nil) != test.resumable {
+			t.Errorf("%s: checkResumable() = %v, expected resumable: %v", test.name, err, test.resumable)
+		}
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+// UploadStatus is what the server reports about an upload on HEAD
+type UploadStatus struct {
+	URL            string            `json:"url"`
+	File           string            `json:"file,omitempty"` // Local file, if looked up from a state file
+	Offset         int64             `json:"offset"`
+	Length         int64             `json:"length"` // -1 while the length is deferred
+	DeferredLength bool              `json:"deferred_length"`
+	Complete       bool              `json:"complete"`
+	Expires        *time.Time        `json:"expires,omitempty"`
+	Concat         string            `json:"concat,omitempty"`    // "partial" or "final"
+	PartURLs       []string          `json:"part_urls,omitempty"` // Partial uploads of a final upload
+	Metadata       map[string]string `json:"metadata,omitempty"`
+	Parts          []*UploadStatus   `json:"parts,omitempty"` // Partial uploads of an unfinished --parallel upload
+}
+
+func statusCommand(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return cli.NewExitError("Please provide exactly one upload URL or file", 1)
+	}
+
+	// Upload URLs, also those of state files, are absolute
+	config, err := parseClientConfig(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	status, err := uploadStatus(config, c.Args().Get(0))
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	if c.Bool("json") {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(status)
+	}
+
+	printUploadStatus(config.output(), status, "")
+	return nil
+}
+
+// uploadStatus reports the server side state of an upload. The argument is
+// either an upload URL or the local file the upload was started for.
+func uploadStatus(config *Config, arg string) (*UploadStatus, error) {
+	state, err := lookupUploadState(arg)
+	if err != nil {
+		return nil, err
+	}
+
+	httpClient := config.httpClient()
+
+	switch {
+	case state != nil:
+		return stateStatus(config, httpClient, state)
+	case isUploadURL(arg):
+		return getUploadStatus(config, httpClient, arg)
+	default:
+		return nil, fmt.Errorf("no upload state found for %s", arg)
+	}
+}
+
+// stateStatus fetches the server side status of the upload a state refers to
+func stateStatus(config *Config, httpClient *http.Client, state *UploadState) (*UploadStatus, error) {
+	if state.UploadURL != "" {
+		status, err := getUploadStatus(config, httpClient, state.UploadURL)
+		if err != nil {
+			return nil, err
+		}
+		status.File = state.FilePath
+		return status, nil
+	}
+
+	// A --parallel upload that has not been concatenated yet
+	status := &UploadStatus{File: state.FilePath, Length: state.FileSize, Expires: stateExpiresAt(state)}
+	for _, part := range state.Parts {
+		partStatus, err := getUploadStatus(config, httpClient, part.UploadURL)
+		if err != nil {
+			return nil, err
+		}
+		status.Offset += partStatus.Offset
+		status.Parts = append(status.Parts, partStatus)
+	}
+	return status, nil
+}
+
+// getUploadStatus sends a HEAD request for uploadURL and parses the response
+func getUploadStatus(config *Config, httpClient *http.Client, uploadURL string) (*UploadStatus, error) {
+	req, err := http.NewRequest("HEAD", uploadURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Tus-Resumable", "1.0.0")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent:
+	case http.StatusNotFound, http.StatusGone:
+		return nil, fmt.Errorf("upload not found: %s", uploadURL)
+	default:
+		return nil, newHTTPError(PhaseHead, resp, nil)
+	}
+
+	return parseUploadStatus(uploadURL, resp.Header)
+}
+
+// parseUploadStatus builds an UploadStatus from the headers of a HEAD response
+func parseUploadStatus(uploadURL string, header http.Header) (*UploadStatus, error) {
+	status := &UploadStatus{URL: uploadURL, Length: -1}
+
+	offset, err := strconv.ParseInt(header.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Upload-Offset header: %q", header.Get("Upload-Offset"))
+	}
+	status.Offset = offset
+
+	if length := header.Get("Upload-Length"); length != "" {
+		status.Length, err = strconv.ParseInt(length, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Upload-Length header: %q", length)
+		}
+	}
+	status.DeferredLength = header.Get("Upload-Defer-Length") == "1"
+	status.Complete = status.Length >= 0 && status.Offset == status.Length
+
+	if expires := header.Get("Upload-Expires"); expires != "" {
+		if t, err := http.ParseTime(expires); err == nil {
+			status.Expires = &t
+		}
+	}
+
+	if concat := header.Get("Upload-Concat"); concat != "" {
+		kind, parts, _ := strings.Cut(concat, ";")
+		status.Concat = kind
+		for _, part := range strings.Fields(parts) {
+			status.PartURLs = append(status.PartURLs, resolveUploadURL(uploadURL, part))
+		}
+	}
+
+	if metadata := header.Get("Upload-Metadata"); metadata != "" {
+		status.Metadata, err = decodeUploadMetadata(metadata)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Upload-Metadata header: %v", err)
+		}
+	}
+
+	return status, nil
+}
+
+// decodeUploadMetadata decodes an Upload-Metadata header. Unlike
+// tusgo.DecodeMetadata it accepts keys without a value, which the protocol allows.
+func decodeUploadMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	for _, item := range strings.Split(header, ",") {
+		fields := strings.Fields(item)
+		switch len(fields) {
+		case 0:
+			continue
+		case 1:
+			metadata[fields[0]] = ""
+		case 2:
+			value, err := base64.StdEncoding.DecodeString(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("bad value for %q: %v", fields[0], err)
+			}
+			metadata[fields[0]] = string(value)
+		default:
+			return nil, fmt.Errorf("bad metadata item %q", item)
+		}
+	}
+	return metadata, nil
+}
+
+// resolveUploadURL resolves a possibly relative upload reference against base
+func resolveUploadURL(base, ref string) string {
+	baseURL, err := url.Parse(base)
+	if err != nil {
+		return ref
+	}
+	refURL, err := url.Parse(ref)
+	if err != nil {
+		return ref
+	}
+	return baseURL.ResolveReference(refURL).String()
+}
+
+// printUploadStatus prints a status to out in human readable form
+func printUploadStatus(out io.Writer, status *UploadStatus, indent string) {
+	if status.URL != "" {
+		fmt.Fprintf(out, "%sURL:      %s\n", indent, status.URL)
+	}
+	if status.File != "" {
+		fmt.Fprintf(out, "%sFile:     %s\n", indent, status.File)
+	}
+
+	switch {
+	case status.Complete:
+		fmt.Fprintf(out, "%sStatus:   complete\n", indent)
+	case status.Length > 0:
+		fmt.Fprintf(out, "%sStatus:   in progress (%.1f%%)\n", indent, float64(status.Offset)/float64(status.Length)*100)
+	default:
+		fmt.Fprintf(out, "%sStatus:   in progress\n", indent)
+	}
+
+	fmt.Fprintf(out, "%sOffset:   %s (%d bytes)\n", indent, formatBytes(status.Offset), status.Offset)
+	switch {
+	case status.Length >= 0:
+		fmt.Fprintf(out, "%sLength:   %s (%d bytes)\n", indent, formatBytes(status.Length), status.Length)
+	case status.DeferredLength:
+		fmt.Fprintf(out, "%sLength:   deferred\n", indent)
+	default:
+		fmt.Fprintf(out, "%sLength:   unknown\n", indent)
+	}
+
+	if status.Expires != nil {
+		when := "expired"
+		if remaining := time.Until(*status.Expires); remaining > 0 {
+			when = fmt.Sprintf("in %v", remaining.Round(time.Second))
+		}
+		fmt.Fprintf(out, "%sExpires:  %s (%s)\n", indent, status.Expires.Local().Format(time.RFC1123), when)
+	}
+
+	if status.Concat != "" {
+		fmt.Fprintf(out, "%sConcat:   %s\n", indent, status.Concat)
+		for _, part := range status.PartURLs {
+			fmt.Fprintf(out, "%s  ↳ %s\n", indent, part)
+		}
+	}
+
+	if len(status.Metadata) > 0 {
+		keys := make([]string, 0, len(status.Metadata))
+		for key := range status.Metadata {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		fmt.Fprintf(out, "%sMetadata:\n", indent)
+		for _, key := range keys {
+			fmt.Fprintf(out, "%s  %s: %s\n", indent, key, status.Metadata[key])
+		}
+	}
+
+	for i, part := range status.Parts {
+		fmt.Fprintf(out, "%sPart %d:\n", indent, i+1)
+		printUploadStatus(out, part, indent+"  ")
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/bdragon300/tusgo"
+	"github.com/urfave/cli/v2"
+)
+
+func TestParseUploadStatus(t *testing.T) {
+	header := http.Header{}
+	header.Set("Upload-Offset", "1024")
+	header.Set("Upload-Defer-Length", "1")
+	header.Set("Upload-Expires", "Wed, 25 Jun 2031 16:00:00 GMT")
+	header.Set("Upload-Concat", "final;/files/a http://other.example/files/b")
+	header.Set("Upload-Metadata", "filename d29ybGRfZG9taW5hdGlvbl9wbGFuLnBkZg==,is_confidential")
+
+	status, err := parseUploadStatus("http://localhost:1080/files/final", header)
+	if err != nil {
+		t.Fatalf("parseUploadStatus failed: %v", err)
+	}
+
+	if status.Offset != 1024 || status.Length != -1 || !status.DeferredLength || status.Complete {
+		t.Errorf("Unexpected offset/length: %+v", status)
+	}
+	if status.Expires == nil || !status.Expires.Equal(time.Date(2031, 6, 25, 16, 0, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected expiry: %v", status.Expires)
+	}
+	if status.Concat != "final" || len(status.PartURLs) != 2 ||
+		status.PartURLs[0] != "http://localhost:1080/files/a" ||
+		status.PartURLs[1] != "http://other.example/files/b" {
+		t.Errorf("Unexpected concat parts: %s %v", status.Concat, status.PartURLs)
+	}
+	if status.Metadata["filename"] != "world_domination_plan.pdf" {
+		t.Errorf("Unexpected metadata: %v", status.Metadata)
+	}
+	if _, ok := status.Metadata["is_confidential"]; !ok {
+		t.Errorf("Expected key without value in metadata: %v", status.Metadata)
+	}
+}
+
+func TestParseUploadStatusInvalidOffset(t *testing.T) {
+	if _, err := parseUploadStatus("http://localhost:1080/files/x", http.Header{}); err == nil {
+		t.Error("Expected an error for a missing Upload-Offset")
+	}
+}
+
+func TestUploadStatusByFile(t *testing.T) {
+	mockServer := NewStatefulTUSServer()
+	defer mockServer.Close()
+	mockServer.Expires = time.Hour
+
+	wd, _ := os.Getwd()
+	os.Chdir(t.TempDir())
+	defer os.Chdir(wd)
+
+	testFile := createTestFile(t, "pending upload")
+	defer os.Remove(testFile)
+
+	baseURL, _ := url.Parse(mockServer.URL())
+	tusClient := tusgo.NewClient(nil, baseURL)
+	var upload tusgo.Upload
+	if _, err := tusClient.CreateUpload(&upload, 14, false, map[string]string{"filename": "pending.txt"}); err != nil {
+		t.Fatalf("Failed to create upload: %v", err)
+	}
+	saveTestState(t, testFile, mockServer.URL(), upload.Location)
+
+	config := &Config{Endpoint: mockServer.URL(), Headers: make(map[string]string)}
+	status, err := uploadStatus(config, testFile)
+	if err != nil {
+		t.Fatalf("uploadStatus failed: %v", err)
+	}
+
+	if status.URL != upload.Location || status.File != testFile {
+		t.Errorf("Unexpected URL or file: %s, %s", status.URL, status.File)
+	}
+	if status.Offset != 0 || status.Length != 14 || status.Complete {
+		t.Errorf("Unexpected offset/length: %d/%d", status.Offset, status.Length)
+	}
+	if status.Expires == nil {
+		t.Error("Expected Upload-Expires to be reported")
+	}
+	if status.Metadata["filename"] != "pending.txt" {
+		t.Errorf("Unexpected metadata: %v", status.Metadata)
+	}
+}
+
+func TestStatusCommandWithoutEndpoint(t *testing.T) {
+	mockServer := NewStatefulTUSServer()
+	defer mockServer.Close()
+
+	wd, _ := os.Getwd()
+	os.Chdir(t.TempDir())
+	defer os.Chdir(wd)
+
+	testFile := createTestFile(t, "pending upload")
+	defer os.Remove(testFile)
+	saveTestState(t, testFile, mockServer.URL(), createServerUpload(t, mockServer, 14))
+
+	app := &cli.App{
+		Flags: []cli.Flag{
+			&cli.StringFlag{Name: "endpoint", Aliases: []string{"t"}},
+			&cli.BoolFlag{Name: "json"},
+		},
+		Action: statusCommand,
+	}
+	if err := app.Run([]string{"tusc", "--json", testFile}); err != nil {
+		t.Errorf("status without --endpoint failed: %v", err)
+	}
+}
+
+func TestUploadStatusFinalUpload(t *testing.T) {
+	mockServer := NewStatefulTUSServer()
+	defer mockServer.Close()
+
+	path, _ := createRandomFile(t, 3*MinChunkSize)
+
+	wd, _ := os.Getwd()
+	os.Chdir(t.TempDir())
+	defer os.Chdir(wd)
+
+	config := &Config{
+		Endpoint:  mockServer.URL(),
+		ChunkSize: DefaultChunkSize,
+		Headers:   make(map[string]string),
+		Parallel:  3,
+	}
+
+	uploadURL, err := uploadFile(config, UploadTarget{Path: path})
+	if err != nil {
+		t.Fatalf("Parallel upload failed: %v", err)
+	}
+
+	status, err := uploadStatus(config, uploadURL)
+	if err != nil {
+		t.Fatalf("uploadStatus failed: %v", err)
+	}
+
+	if !status.Complete || status.Length != 3*MinChunkSize {
+		t.Errorf("Expected a complete upload, got %d/%d", status.Offset, status.Length)
+	}
+	if status.Concat != "final" || len(status.PartURLs) != 3 {
+		t.Errorf("Expected a final upload of 3 parts, got %s %v", status.Concat, status.PartURLs)
+	}
+}
+
+func TestUploadStatusNotFound(t *testing.T) {
+	mockServer := NewStatefulTUSServer()
+	defer mockServer.Close()
+
+	wd, _ := os.Getwd()
+	os.Chdir(t.TempDir())
+	defer os.Chdir(wd)
+
+	config := &Config{Endpoint: mockServer.URL(), Headers: make(map[string]string)}
+	if _, err := uploadStatus(config, mockServer.URL()+"/missing"); err == nil {
+		t.Error("Expected an error for an unknown upload")
+	}
+	if _, err := uploadStatus(config, "does-not-exist.bin"); err == nil {
+		t.Error("Expected an error for a missing file")
+	}
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bdragon300/tusgo"
+)
+
+const (
+	StdinArg         = "-"     // Upload argument that reads from standard input
+	DefaultStdinName = "stdin" // Filename sent for stdin uploads without --name
+)
+
+// stdinUploadName recognises `upload - [--name NAME]`. urfave/cli stops parsing
+// flags at the first argument, so a --name given after "-" is picked up here.
+func stdinUploadName(args []string, name string) (string, bool, error) {
+	if len(args) == 0 || args[0] != StdinArg {
+		return "", false, nil
+	}
+
+	rest := args[1:]
+	for len(rest) > 0 {
+		switch {
+		case (rest[0] == "--name" || rest[0] == "-n") && len(rest) > 1:
+			name = rest[1]
+			rest = rest[2:]
+		case strings.HasPrefix(rest[0], "--name="):
+			name = strings.TrimPrefix(rest[0], "--name=")
+			rest = rest[1:]
+		default:
+			return "", false, fmt.Errorf("uploads from stdin take no other arguments: %s", rest[0])
+		}
+	}
+
+	if name == "" {
+		name = DefaultStdinName
+	}
+	return name, true, nil
+}
+
+// uploadStream uploads everything read from src as a single upload. The length
+// is deferred (creation-defer-length) and sent with the last chunk once src
+// reaches EOF, so src is read exactly once and never seeked.
+func uploadStream(config *Config, src io.Reader, name string) (string, error) {
+	baseURL, err := url.Parse(config.Endpoint)
+	if err != nil {
+		return "", fmt.Errorf("invalid endpoint URL: %v", err)
+	}
+
+	httpClient := config.httpClient()
+	tusClient := tusgo.NewClient(httpClient, baseURL).WithContext(config.runContext())
+
+	if !serverSupports(tusClient, "creation-defer-length") {
+		return "", fmt.Errorf("server does not support the creation-defer-length extension")
+	}
+
+	metadata := config.fileMetadata(name, "")
+	if config.Verbose {
+		fmt.Fprintln(config.output(), "Creating upload with deferred length...")
+	}
+
+	var upload tusgo.Upload
+	err = withRetry(config, func() error {
+		resp, err := tusClient.CreateUpload(&upload, tusgo.SizeUnknown, false, metadata)
+		return wrapResponseError(PhaseCreate, resp, err)
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to create upload: %w", err)
+	}
+
+	location, err := baseURL.Parse(upload.Location)
+	if err != nil {
+		return "", fmt.Errorf("invalid upload URL: %v", err)
+	}
+	uploadURL := location.String()
+
+	config.emit(Event{Event: "created", UploadURL: uploadURL, Metadata: metadata})
+	if config.Verbose {
+		fmt.Fprintf(config.output(), "Upload created: %s\n", uploadURL)
+	}
+	fmt.Fprintf(config.output(), "Streaming %s...\n", name)
+
+	// Adaptive chunks start from --chunk-size like those of files
+	sizer := NewChunkSizer(config)
+
+	// Bytes are kept on disk until the server acknowledged them, so a failed
+	// PATCH can be replayed although src cannot be rewound. Each round spools
+	// one chunk of the current size, adaptive chunks may grow to MaxChunkSize.
+	capacity := sizer.Size()
+	if config.AdaptiveChunks {
+		capacity = MaxChunkSize
+	}
+	spool, err := NewSpool(capacity)
+	if err != nil {
+		return "", err
+	}
+	defer spool.Close()
+
+	reader := bufio.NewReader(src)
+	start := time.Now()
+	lastUpdate := start
+	var offset int64
+
+	for eof := false; !eof; {
+		_, err := spool.Fill(reader, sizer.Size())
+		switch {
+		case errors.Is(err, io.EOF):
+			eof = true
+		case err != nil:
+			return "", fmt.Errorf("failed to read input: %v", err)
+		default:
+			// A full chunk may still be the last one
+			_, err := reader.Peek(1)
+			eof = errors.Is(err, io.EOF)
+		}
+
+		// The last chunk announces the upload length
+		length := int64(-1)
+		if eof {
+			length = spool.End()
+		}
+
+		offset, err = sendSpooled(config, httpClient, uploadURL, spool, sizer, offset, length)
+		if err != nil {
+			return "", fmt.Errorf("upload failed at offset %s: %w", formatBytes(offset), err)
+		}
+
+		if now := time.Now(); now.Sub(lastUpdate) >= time.Second {
+			rate := float64(offset) / now.Sub(start).Seconds()
+			if config.events != nil {
+				config.emit(Event{Event: "progress", UploadURL: uploadURL, Offset: offset, Rate: int64(rate)})
+			} else {
+				fmt.Fprintf(config.output(), "\rProgress: %s at %s/s", formatBytes(offset), formatBytes(int64(rate)))
+			}
+			lastUpdate = now
+		}
+	}
+
+	fmt.Fprintf(config.output(), "\r✓ Upload completed: %s (%s) in %v\n",
+		name,
+		formatBytes(offset),
+		time.Since(start).Round(time.Second))
+
+	if config.Verbose {
+		fmt.Fprintf(config.output(), "Upload URL: %s\n", uploadURL)
+	}
+
+	config.emit(Event{
+		Event:      "completed",
+		UploadURL:  uploadURL,
+		Size:       offset,
+		DurationMs: time.Since(start).Milliseconds(),
+		Metadata:   metadata,
+	})
+	return uploadURL, nil
+}
+
+// sendSpooled sends the spooled bytes from offset to the end of the spool in
+// PATCHes sized by sizer and returns the new offset. After a failed request the
+// server offset is checked with HEAD and the transfer continues from there,
+// replaying from the spool.
+func sendSpooled(config *Config, httpClient *http.Client, uploadURL string, spool *Spool, sizer *ChunkSizer, offset, length int64) (int64, error) {
+	ctx := config.runContext()
+	retrier := newRetrier(config)
+	for {
+		// SIGUSR1 holds the transfer between chunks
+		if config.pause.Wait(ctx) != nil {
+			return offset, errInterrupted
+		}
+
+		n := min(spool.End()-offset, sizer.Size())
+		data, err := spool.Reader(offset, n)
+		if err != nil {
+			return offset, err
+		}
+
+		// Only the PATCH that ends at the end of the spool may be the last one
+		chunkLength := int64(-1)
+		if offset+n == spool.End() {
+			chunkLength = length
+		}
+
+		start := time.Now()
+		newOffset, err := patchStreamChunk(config, httpClient, uploadURL, data, n, offset, chunkLength)
+		if err == nil {
+			retrier.Success()
+			sizer.Success(newOffset-offset, time.Since(start))
+			if n > 0 && newOffset == offset {
+				return offset, fmt.Errorf("server stored none of %d bytes", n)
+			}
+			offset = newOffset
+			spool.Release(offset)
+			if offset == spool.End() {
+				return offset, nil
+			}
+			continue // Send the rest of the spool
+		}
+
+		tooLarge := errors.Is(err, errChunkTooLarge)
+		if sizer.Failure(tooLarge) && tooLarge {
+			if config.Verbose {
+				fmt.Fprintf(config.output(), "\n%v, retrying with %s chunks\n", err, formatBytes(sizer.Size()))
+			}
+			continue
+		}
+
+		// The spooled bytes are gone once acknowledged, a lost upload can't
+		// be recreated
+		if ctx.Err() != nil {
+			return offset, errInterrupted
+		}
+		delay, ok := retrier.Next(err)
+		if !ok {
+			return offset, err
+		}
+		config.emitRetry(retrier.Attempts(), delay, err)
+		if config.Verbose {
+			fmt.Fprintf(config.output(), "\nUpload failed (%s, retry %d), retrying in %v: %v\n",
+				retryAction(err), retrier.Attempts(), delay.Round(time.Millisecond), err)
+		}
+		if sleepContext(ctx, delay) != nil {
+			return offset, errInterrupted
+		}
+
+		serverOffset, lengthKnown, err := getStreamOffset(config, httpClient, uploadURL)
+		if err != nil {
+			if config.Verbose {
+				fmt.Fprintf(config.output(), "Failed to check upload offset: %v\n", err)
+			}
+			continue
+		}
+		if serverOffset < spool.Start() || serverOffset > spool.End() {
+			return offset, fmt.Errorf("server offset %d is outside the spooled bytes %d-%d", serverOffset, spool.Start(), spool.End())
+		}
+
+		if config.Verbose {
+			fmt.Fprintf(config.output(), "Replaying from offset %s\n", formatBytes(serverOffset))
+		}
+		offset = serverOffset
+		spool.Release(offset)
+
+		// The lost response may have been the one that completed the chunk
+		if offset == spool.End() && (length < 0 || lengthKnown) {
+			return offset, nil
+		}
+	}
+}
+
+// patchStreamChunk sends size bytes of a deferred length upload and returns
+// the offset the server reports. length is the final upload length for the
+// last chunk, or -1.
+func patchStreamChunk(config *Config, httpClient *http.Client, uploadURL string, data io.Reader, size, offset, length int64) (int64, error) {
+	req, err := http.NewRequestWithContext(config.runContext(), "PATCH", uploadURL, data)
+	if err != nil {
+		return offset, err
+	}
+	req.ContentLength = size
+
+	req.Header.Set("Tus-Resumable", "1.0.0")
+	req.Header.Set("Content-Type", "application/offset+octet-stream")
+	req.Header.Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	if length >= 0 {
+		req.Header.Set("Upload-Length", strconv.FormatInt(length, 10))
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return offset, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNoContent:
+	case resp.StatusCode == http.StatusConflict:
+		return offset, newHTTPError(PhasePatch, resp, tusgo.ErrOffsetsNotSynced)
+	case resp.StatusCode == http.StatusNotFound, resp.StatusCode == http.StatusGone:
+		return offset, newHTTPError(PhasePatch, resp, tusgo.ErrUploadDoesNotExist)
+	case resp.StatusCode == http.StatusRequestEntityTooLarge:
+		return offset, fmt.Errorf("%w: %s", errChunkTooLarge, formatBytes(size))
+	default:
+		return offset, newHTTPError(PhasePatch, resp, nil)
+	}
+
+	newOffset, err := strconv.ParseInt(resp.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || newOffset < offset || newOffset > offset+size {
+		return offset, fmt.Errorf("invalid Upload-Offset header: %s", resp.Header.Get("Upload-Offset"))
+	}
+
+	return newOffset, nil
+}
+
+// getStreamOffset asks the server how much of a deferred length upload it has
+// stored and whether the upload length is known yet
+func getStreamOffset(config *Config, httpClient *http.Client, uploadURL string) (int64, bool, error) {
+	req, err := http.NewRequestWithContext(config.runContext(), "HEAD", uploadURL, nil)
+	if err != nil {
+		return 0, false, err
+	}
+
+	req.Header.Set("Tus-Resumable", "1.0.0")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return 0, false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, false, newHTTPError(PhaseHead, resp, nil)
+	}
+
+	offset, err := strconv.ParseInt(resp.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid Upload-Offset header: %s", resp.Header.Get("Upload-Offset"))
+	}
+
+	return offset, resp.Header.Get("Upload-Length") != "", nil
+}
+
+// uploadPipe uploads a named pipe or device given as an upload target
+func uploadPipe(config *Config, target UploadTarget) (string, error) {
+	file, err := os.Open(target.Path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file: %v", err)
+	}
+	defer file.Close()
+
+	return uploadStream(config, file, filepath.Base(target.Path))
+}
+
+// uploadStdin is the upload command for `upload -`
+func uploadStdin(config *Config, name string) (err error) {
+	// Events of this upload are about name
+	stdinConfig := *config
+	stdinConfig.file = name
+	config = &stdinConfig
+	defer func() {
+		if err != nil {
+			config.emit(Event{Event: "failed", Error: err.Error()})
+		}
+	}()
+
+	if info, err := os.Stdin.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		return fmt.Errorf("refusing to read upload data from a terminal, pipe it into tusc instead")
+	}
+
+	_, err = uploadStream(config, os.Stdin, name)
+	return err
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"testing"
+)
+
+func TestStdinUploadName(t *testing.T) {
+	tests := []struct {
+		args     []string
+		flag     string
+		name     string
+		isStdin  bool
+		hasError bool
+	}{
+		{[]string{"file.txt"}, "", "", false, false},
+		{[]string{"-"}, "", DefaultStdinName, true, false},
+		{[]string{"-"}, "flag.sql", "flag.sql", true, false},
+		{[]string{"-", "--name", "backup.sql"}, "", "backup.sql", true, false},
+		{[]string{"-", "--name=backup.sql"}, "", "backup.sql", true, false},
+		{[]string{"-", "-n", "backup.sql"}, "", "backup.sql", true, false},
+		{[]string{"-", "other.txt"}, "", "", false, true},
+	}
+
+	for _, test := range tests {
+		name, isStdin, err := stdinUploadName(test.args, test.flag)
+		if (err != nil) != test.hasError {
+			t.Errorf("stdinUploadName(%v) error = %v, expected error: %v", test.args, err, test.hasError)
+			continue
+		}
+		if name != test.name || isStdin != test.isStdin {
+			t.Errorf("stdinUploadName(%v) = %q, %v, expected %q, %v", test.args, name, isStdin, test.name, test.isStdin)
+		}
+	}
+}
+
+func TestUploadStream(t *testing.T) {
+	sizes := []int{0, 100, 2 * MinChunkSize, 3*MinChunkSize + 5}
+
+	for _, size := range sizes {
+		mockServer := NewStatefulTUSServer()
+
+		data := make([]byte, size)
+		rand.Read(data)
+
+		config := &Config{
+			Endpoint:  mockServer.URL(),
+			ChunkSize: MinChunkSize,
+			Headers:   make(map[string]string),
+		}
+
+		uploadURL, err := uploadStream(config, bytes.NewReader(data), "backup.sql")
+		if err != nil {
+			t.Fatalf("Stream upload of %d bytes failed: %v", size, err)
+		}
+
+		expectedPatches := (size + MinChunkSize - 1) / MinChunkSize
+		if expectedPatches == 0 {
+			expectedPatches = 1
+		}
+		if n := mockServer.PatchCount(); n != expectedPatches {
+			t.Errorf("Size %d: expected %d PATCH requests, got %d", size, expectedPatches, n)
+		}
+
+		for id, upload := range mockServer.Uploads() {
+			if mockServer.URL()+"/"+id != uploadURL {
+				t.Errorf("Size %d: unexpected upload URL %s", size, uploadURL)
+			}
+			if upload.Size != int64(size) || upload.Offset != int64(size) {
+				t.Errorf("Size %d: server has size %d, offset %d", size, upload.Size, upload.Offset)
+			}
+			if !bytes.Equal(upload.Data, data) {
+				t.Errorf("Size %d: uploaded data doesn't match input", size)
+			}
+			if upload.Metadata["filename"] != "backup.sql" {
+				t.Errorf("Size %d: expected filename metadata, got %v", size, upload.Metadata)
+			}
+		}
+
+		mockServer.Close()
+	}
+}
+
+func TestUploadStreamRequiresDeferLength(t *testing.T) {
+	mockServer := NewMockTUSServer()
+	defer mockServer.Close()
+
+	config := &Config{
+		Endpoint:  mockServer.URL(),
+		ChunkSize: MinChunkSize,
+		Headers:   make(map[string]string),
+	}
+
+	if _, err := uploadStream(config, bytes.NewReader([]byte("data")), "data.txt"); err == nil {
+		t.Error("Expected an error for a server without creation-defer-length")
+	}
+}
+
+func TestUploadStreamReplaysFromSpool(t *testing.T) {
+	mockServer := NewStatefulTUSServer()
+	defer mockServer.Close()
+	mockServer.FailPatches = 2
+
+	data := make([]byte, 3*MinChunkSize+5)
+	rand.Read(data)
+
+	config := &Config{
+		Endpoint:  mockServer.URL(),
+		ChunkSize: MinChunkSize,
+		Headers:   make(map[string]string),
+		Retries:   2,
+	}
+
+	// A plain reader cannot be rewound, every retry must come from the spool
+	src := io.MultiReader(bytes.NewReader(data))
+	if _, err := uploadStream(config, src, "replay.bin"); err != nil {
+		t.Fatalf("Stream upload failed: %v", err)
+	}
+
+	for _, upload := range mockServer.Uploads() {
+		if upload.Size != int64(len(data)) || !bytes.Equal(upload.Data, data) {
+			t.Error("Uploaded data doesn't match input after replay")
+		}
+	}
+}
@@ -19,8 +19,10 @@ echo "Waiting 8 seconds before interrupting..."
 sleep 8
 
 echo "Interrupting upload..."
-kill $UPLOAD_PID
-wait $UPLOAD_PID 2>/dev/null
+kill -INT $UPLOAD_PID
+wait $UPLOAD_PID
+EXIT_CODE=$?
+echo "Exit code: $EXIT_CODE (3 means the offset was saved)"
 
 echo ""
 echo "Checking for state file..."
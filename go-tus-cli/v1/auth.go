@@ -0,0 +1,329 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TokenExpiryMargin is how long before it expires an OAuth2 token is renewed,
+// so it doesn't run out while a chunk is on the way
+const TokenExpiryMargin = 30 * time.Second
+
+// Authenticator sets the credentials of requests to the tus server
+type Authenticator interface {
+	Authorize(req *http.Request) error
+}
+
+// Refresher is an Authenticator whose credentials can be renewed after the
+// server rejected them with 401. rejected is the request that was refused.
+type Refresher interface {
+	Refresh(rejected *http.Request) error
+}
+
+// BearerAuth sends a static bearer token
+type BearerAuth struct {
+	Token string
+}
+
+func (a *BearerAuth) Authorize(req *http.Request) error {
+	req.Header.Set("Authorization", "Bearer "+a.Token)
+	return nil
+}
+
+// BasicAuth sends a username and password
+type BasicAuth struct {
+	Username string
+	Password string
+}
+
+func (a *BasicAuth) Authorize(req *http.Request) error {
+	req.SetBasicAuth(a.Username, a.Password)
+	return nil
+}
+
+// OAuth2Auth gets bearer tokens from an OAuth2 token endpoint with the
+// client credentials grant. Tokens are kept in memory only.
+type OAuth2Auth struct {
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	Scope        string // Space-separated scopes, none if empty
+
+	client *http.Client
+	now    func() time.Time
+
+	mu     sync.Mutex
+	token  string
+	expiry time.Time // zero if the token doesn't expire
+}
+
+func NewOAuth2Auth(tokenURL, clientID, clientSecret, scope string, tlsConfig *tls.Config) *OAuth2Auth {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	return &OAuth2Auth{
+		TokenURL:     tokenURL,
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		Scope:        scope,
+		client:       &http.Client{Timeout: 30 * time.Second, Transport: transport},
+		now:          time.Now,
+	}
+}
+
+func (a *OAuth2Auth) Authorize(req *http.Request) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.token == "" || (!a.expiry.IsZero() && a.now().After(a.expiry.Add(-TokenExpiryMargin))) {
+		if err := a.fetchToken(req.Context()); err != nil {
+			return err
+		}
+	}
+	req.Header.Set("Authorization", "Bearer "+a.token)
+	return nil
+}
+
+// Refresh gets a new token unless another request did so since rejected was sent
+func (a *OAuth2Auth) Refresh(rejected *http.Request) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if rejected.Header.Get("Authorization") != "Bearer "+a.token {
+		return nil
+	}
+	return a.fetchToken(rejected.Context())
+}
+
+// fetchToken requests a token from TokenURL, the client authenticates with
+// HTTP basic auth as RFC 6749 recommends
+func (a *OAuth2Auth) fetchToken(ctx context.Context) error {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if a.Scope != "" {
+		form.Set("scope", a.Scope)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("failed to create token request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(a.ClientID), url.QueryEscape(a.ClientSecret))
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var token struct {
+		AccessToken      string `json:"access_token"`
+		TokenType        string `json:"token_type"`
+		ExpiresIn        int64  `json:"expires_in"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	json.Unmarshal(body, &token)
+
+	if resp.StatusCode != http.StatusOK || token.AccessToken == "" {
+		switch {
+		case token.Error != "" && token.ErrorDescription != "":
+			return fmt.Errorf("token request failed with %s: %s: %s", resp.Status, token.Error, token.ErrorDescription)
+		case token.Error != "":
+			return fmt.Errorf("token request failed with %s: %s", resp.Status, token.Error)
+		default:
+			return fmt.Errorf("token request failed with %s", resp.Status)
+		}
+	}
+	if token.TokenType != "" && !strings.EqualFold(token.TokenType, "bearer") {
+		return fmt.Errorf("unsupported token type %q", token.TokenType)
+	}
+
+	a.token = token.AccessToken
+	a.expiry = time.Time{}
+	if token.ExpiresIn > 0 {
+		a.expiry = a.now().Add(time.Duration(token.ExpiresIn) * time.Second)
+	}
+	return nil
+}
+
+// authTransport authorizes every request. If the server answers 401 and the
+// credentials can be renewed, the request is sent once more with new ones.
+type authTransport struct {
+	base http.RoundTripper
+	auth Authenticator
+}
+
+// authHTTPClient makes client authorize its requests with auth
+func authHTTPClient(client *http.Client, auth Authenticator) *http.Client {
+	if auth == nil {
+		return client
+	}
+
+	base := client.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	client.Transport = &authTransport{base: base, auth: auth}
+	return client
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	authorized := req.Clone(req.Context())
+	if err := t.auth.Authorize(authorized); err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+
+	refresher, ok := t.auth.(Refresher)
+	if !ok {
+		return t.base.RoundTrip(authorized)
+	}
+
+	// Bodies that can't be created again, like tusgo's PATCH bodies, are
+	// recorded as they are sent
+	var recorded *replayBody
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		recorded = newReplayBody(req.Body)
+		authorized.Body = recorded
+	}
+
+	resp, err := t.base.RoundTrip(authorized)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		recorded.release()
+		return resp, err
+	}
+
+	if err := refresher.Refresh(authorized); err != nil {
+		recorded.release()
+		resp.Body.Close()
+		return nil, err
+	}
+
+	replay := req.Clone(req.Context())
+	switch {
+	case recorded != nil:
+		replay.Body = recorded.replay()
+	case req.GetBody != nil:
+		if replay.Body, err = req.GetBody(); err != nil {
+			return resp, nil
+		}
+	}
+	if err := t.auth.Authorize(replay); err != nil {
+		if replay.Body != nil {
+			replay.Body.Close()
+		}
+		resp.Body.Close()
+		return nil, err
+	}
+
+	resp.Body.Close()
+	return t.base.RoundTrip(replay)
+}
+
+// replayBody records what the transport reads of a request body, so the
+// request can be sent again with the part that was read plus the rest
+type replayBody struct {
+	body   io.ReadCloser
+	sent   bytes.Buffer
+	once   sync.Once
+	closed chan struct{}
+}
+
+func newReplayBody(body io.ReadCloser) *replayBody {
+	return &replayBody{body: body, closed: make(chan struct{})}
+}
+
+func (b *replayBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	b.sent.Write(p[:n])
+	return n, err
+}
+
+// Close only marks the body as done, the original body is kept for a replay
+func (b *replayBody) Close() error {
+	b.once.Do(func() { close(b.closed) })
+	return nil
+}
+
+// replay returns the whole body once the transport is done with it
+func (b *replayBody) replay() io.ReadCloser {
+	<-b.closed
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(b.sent.Bytes()), b.body), b.body}
+}
+
+// release closes the original body once the transport is done with it. The
+// transport may close request bodies after RoundTrip returned.
+func (b *replayBody) release() {
+	if b == nil {
+		return
+	}
+	go func() {
+		<-b.closed
+		b.body.Close()
+	}()
+}
+
+// buildAuth builds the Authenticator of -bearer-token, -user or the -oauth2-*
+// values, or of the credentials -credential-helper or -netrc have for the
+// endpoint host. It returns nil without credentials.
+func buildAuth(config Config) (Authenticator, error) {
+	var methods []string
+	for flag, value := range map[string]string{
+		"-bearer-token":     config.BearerToken,
+		"-user":             config.User,
+		"-oauth2-token-url": config.OAuth2TokenURL,
+	} {
+		if value != "" {
+			methods = append(methods, flag)
+		}
+	}
+	if len(methods) > 1 {
+		slices.Sort(methods)
+		return nil, fmt.Errorf("%s can't be used together", strings.Join(methods, " and "))
+	}
+
+	switch {
+	case config.BearerToken != "":
+		return &BearerAuth{Token: config.BearerToken}, nil
+	case config.User != "":
+		username, password, _ := strings.Cut(config.User, ":")
+		return &BasicAuth{Username: username, Password: password}, nil
+	case config.OAuth2TokenURL != "":
+		tokenURL, err := url.Parse(config.OAuth2TokenURL)
+		if err != nil || tokenURL.Host == "" {
+			return nil, fmt.Errorf("invalid -oauth2-token-url %q", config.OAuth2TokenURL)
+		}
+		if config.OAuth2ClientID == "" {
+			return nil, fmt.Errorf("-oauth2-token-url requires -oauth2-client-id")
+		}
+		return NewOAuth2Auth(tokenURL.String(), config.OAuth2ClientID, config.OAuth2ClientSecret,
+			config.OAuth2Scope, config.TLS), nil
+	case hasHeader(config.Headers, "Authorization"):
+		return nil, nil
+	}
+
+	// Credentials for the endpoint host
+	netrcFile := config.NetrcFile
+	if netrcFile == "" && config.Netrc {
+		netrcFile = netrcPath()
+	}
+	return credentialAuth(config.TusdEndpoint, config.CredentialHelper, netrcFile)
+}
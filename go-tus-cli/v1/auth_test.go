@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestOAuth2RefreshReplaysPatch(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+
+	// The token endpoint issues t1, t2, ...
+	var issued atomic.Int32
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id, secret, _ := r.BasicAuth(); id != "tusc" || secret != "s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		n := issued.Add(1)
+		json.NewEncoder(w).Encode(map[string]any{"access_token": fmt.Sprintf("t%d", n), "expires_in": 3600})
+	}))
+	defer tokenServer.Close()
+
+	testContent := make([]byte, 2*MinChunkSize)
+	rand.Read(testContent)
+	testFile := createTestFile(t, int64(len(testContent)), testContent)
+	defer os.Remove(testFile)
+
+	// The server revokes the first token after the first chunk, which must
+	// not have ended up in the state file
+	mockServer := NewMockTUSServer()
+	defer mockServer.Close()
+	mockServer.Token = "t1"
+	mockServer.Patched = func(offset int64) {
+		mockServer.Token = "t2"
+		if state, err := os.ReadFile(getStateFile(calculateFileHash(testFile))); err == nil && strings.Contains(string(state), "t1") {
+			t.Error("Expected the token not to be saved in the state file")
+		}
+	}
+
+	config := Config{
+		TusdEndpoint:       mockServer.URL(),
+		FilePath:           testFile,
+		ChunkSize:          MinChunkSize,
+		Headers:            make(map[string]string),
+		OAuth2TokenURL:     tokenServer.URL,
+		OAuth2ClientID:     "tusc",
+		OAuth2ClientSecret: "s3cret",
+	}
+	auth, err := buildAuth(config)
+	if err != nil {
+		t.Fatalf("Failed to build auth: %v", err)
+	}
+	config.Auth = auth
+
+	if err := uploadFile(config); err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	if n := issued.Load(); n != 2 {
+		t.Errorf("Expected 2 tokens to be issued, got %d", n)
+	}
+	if mockServer.Patches != 3 {
+		t.Errorf("Expected 3 PATCH requests, got %d", mockServer.Patches)
+	}
+	for _, upload := range mockServer.uploads {
+		if !bytes.Equal(upload.Data, testContent) {
+			t.Error("Uploaded data doesn't match")
+		}
+	}
+}
+
+func TestBuildAuth(t *testing.T) {
+	if auth, err := buildAuth(Config{}); auth != nil || err != nil {
+		t.Errorf("Expected no authenticator, got %v, %v", auth, err)
+	}
+	if auth, err := buildAuth(Config{User: "alice:pa:ss"}); err != nil || *auth.(*BasicAuth) != (BasicAuth{Username: "alice", Password: "pa:ss"}) {
+		t.Errorf("Unexpected basic auth %v, %v", auth, err)
+	}
+	if _, err := buildAuth(Config{BearerToken: "abc", User: "alice:secret"}); err == nil {
+		t.Error("Expected two kinds of credentials to be rejected")
+	}
+	if _, err := buildAuth(Config{OAuth2TokenURL: "https://auth.example.com/token"}); err == nil {
+		t.Error("Expected -oauth2-token-url without a client ID to be rejected")
+	}
+
+	// Credentials of a flag replace those of the profile
+	config := Config{User: "alice:secret"}
+	applyProfileAuth(&config, ProfileAuth{BearerToken: "from-profile"})
+	if config.BearerToken != "" {
+		t.Errorf("Expected the profile's token to be ignored, got %q", config.BearerToken)
+	}
+}
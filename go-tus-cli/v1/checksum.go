@@ -0,0 +1,105 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"net/http"
+	"strings"
+)
+
+// ErrChecksumMismatch is returned when the server answers 460 Checksum Mismatch
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// maxChecksumResends limits how often a chunk is resent after 460 responses
+// before the failure counts as a regular retry
+const maxChecksumResends = 3
+
+// checksumAlgorithms maps tus algorithm names to hash constructors, in the
+// order they are preferred when negotiating with "auto"
+var checksumAlgorithms = []struct {
+	name string
+	hash func() hash.Hash
+}{
+	{"sha256", sha256.New},
+	{"sha1", sha1.New},
+	{"md5", md5.New},
+	{"crc32", func() hash.Hash { return crc32.NewIEEE() }},
+}
+
+// parseChecksumAlgorithms parses the -checksum value: "auto", a single
+// algorithm or a comma-separated list in order of preference
+func parseChecksumAlgorithms(value string) ([]string, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if value == "" || value == "none" {
+		return nil, nil
+	}
+
+	var names []string
+	if value == "auto" {
+		for _, algorithm := range checksumAlgorithms {
+			names = append(names, algorithm.name)
+		}
+		return names, nil
+	}
+
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if newChecksumHash(name) == nil {
+			return nil, fmt.Errorf("unsupported checksum algorithm: %s", name)
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// newChecksumHash returns a hash for the algorithm, or nil if it is unknown
+func newChecksumHash(name string) hash.Hash {
+	for _, algorithm := range checksumAlgorithms {
+		if algorithm.name == name {
+			return algorithm.hash()
+		}
+	}
+	return nil
+}
+
+// checksumHeader returns the Upload-Checksum header value for data
+func checksumHeader(algorithm string, data []byte) string {
+	h := newChecksumHash(algorithm)
+	h.Write(data)
+	return algorithm + " " + base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// negotiateChecksum picks the first wanted algorithm the server lists in
+// Tus-Checksum-Algorithm of its OPTIONS response. It returns an empty string
+// when the server does not support the checksum extension or none of the
+// wanted algorithms.
+func negotiateChecksum(options http.Header, wanted []string) string {
+	if !headerListContains(options.Get("Tus-Extension"), "checksum") {
+		return ""
+	}
+
+	supported := options.Get("Tus-Checksum-Algorithm")
+	for _, name := range wanted {
+		if headerListContains(supported, name) {
+			return name
+		}
+	}
+
+	return ""
+}
+
+// headerListContains reports whether a comma-separated header lists value
+func headerListContains(list, value string) bool {
+	for _, item := range strings.Split(list, ",") {
+		if strings.EqualFold(strings.TrimSpace(item), value) {
+			return true
+		}
+	}
+	return false
+}
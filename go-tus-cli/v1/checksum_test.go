@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestParseChecksumAlgorithms(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+		wantErr  bool
+	}{
+		{"", nil, false},
+		{"none", nil, false},
+		{"sha1", []string{"sha1"}, false},
+		{"SHA256, md5", []string{"sha256", "md5"}, false},
+		{"auto", []string{"sha256", "sha1", "md5", "crc32"}, false},
+		{"sha512", nil, true},
+	}
+
+	for _, test := range tests {
+		result, err := parseChecksumAlgorithms(test.input)
+		if (err != nil) != test.wantErr {
+			t.Errorf("parseChecksumAlgorithms(%q) error = %v, wantErr %v", test.input, err, test.wantErr)
+			continue
+		}
+		if strings.Join(result, ",") != strings.Join(test.expected, ",") {
+			t.Errorf("parseChecksumAlgorithms(%q) = %v, expected %v", test.input, result, test.expected)
+		}
+	}
+}
+
+func TestChecksumHeader(t *testing.T) {
+	tests := []struct {
+		algorithm string
+		expected  string
+	}{
+		{"sha1", "sha1 qvTGHdzF6KLavt4PO0gs2a6pQ00="},
+		{"md5", "md5 XUFAKrxLKna5cZ2REBfFkg=="},
+		{"crc32", "crc32 NhCmhg=="},
+		{"sha256", "sha256 LPJNul+wow4m6DsqxbninhsWHlwfp0JecwQzYpOLmCQ="},
+	}
+
+	for _, test := range tests {
+		result := checksumHeader(test.algorithm, []byte("hello"))
+		if result != test.expected {
+			t.Errorf("checksumHeader(%q) = %q, expected %q", test.algorithm, result, test.expected)
+		}
+	}
+}
+
+func TestNegotiateChecksum(t *testing.T) {
+	mockServer := NewMockTUSServer()
+	defer mockServer.Close()
+	mockServer.ChecksumAlgorithms = "md5,sha1"
+
+	client := &http.Client{}
+
+	options, err := serverOptions(client, mockServer.URL(), nil)
+	if err != nil {
+		t.Fatalf("serverOptions failed: %v", err)
+	}
+
+	algorithm := negotiateChecksum(options, []string{"sha256", "sha1", "md5"})
+	if algorithm != "sha1" {
+		t.Errorf("Expected sha1 to be negotiated, got %q", algorithm)
+	}
+
+	algorithm = negotiateChecksum(options, []string{"crc32"})
+	if algorithm != "" {
+		t.Errorf("Expected no algorithm to be negotiated, got %q", algorithm)
+	}
+}
+
+func TestChecksumUploadResendsOnMismatch(t *testing.T) {
+	mockServer := NewMockTUSServer()
+	defer mockServer.Close()
+
+	// Two damaged chunks in a row stay within the resend budget
+	mockServer.CorruptPatches = 2
+
+	testContent := bytes.Repeat([]byte("checksummed chunk data "), 100)
+	testFile := createTestFile(t, int64(len(testContent)), testContent)
+	defer os.Remove(testFile)
+	defer clearState(calculateFileHash(testFile))
+
+	config := Config{
+		TusdEndpoint: mockServer.URL(),
+		ChunkSize:    1024,
+		Headers:      make(map[string]string),
+		FilePath:     testFile,
+		Checksum:     "sha1",
+	}
+
+	if err := uploadFile(config); err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+
+	chunks := (len(testContent) + 1023) / 1024
+	if len(mockServer.ChecksumHeaders) != chunks+2 {
+		t.Errorf("Expected %d checksummed PATCH requests, got %d", chunks+2, len(mockServer.ChecksumHeaders))
+	}
+	for _, header := range mockServer.ChecksumHeaders {
+		if !strings.HasPrefix(header, "sha1 ") {
+			t.Errorf("Expected sha1 checksum header, got %q", header)
+		}
+	}
+
+	for _, upload := range mockServer.GetUploads() {
+		if !bytes.Equal(upload.Data[:upload.Offset], testContent) {
+			t.Error("Uploaded data doesn't match original content")
+		}
+	}
+}
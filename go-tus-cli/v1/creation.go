@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// serverOptions queries the endpoint with an OPTIONS request and returns the
+// response headers, which list the supported extensions
+func serverOptions(client *http.Client, endpoint string, headers map[string]string) (http.Header, error) {
+	req, err := http.NewRequest("OPTIONS", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	optionsClient := *client
+	optionsClient.Timeout = 10 * time.Second
+	resp, err := optionsClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	return resp.Header, nil
+}
+
+// createUploadWithFirstChunk creates the upload and sends the first chunk of
+// the file in the same request (creation-with-upload). Files that fit into one
+// chunk are uploaded completely. It returns the upload URL and the offset the
+// server confirmed.
+func createUploadWithFirstChunk(client *http.Client, config Config, fileSize int64) (string, int64, error) {
+	readSize := config.ChunkSize
+	if fileSize < readSize {
+		readSize = fileSize
+	}
+
+	file, err := os.Open(config.FilePath)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to open file for reading: %v", err)
+	}
+	defer file.Close()
+
+	data := make([]byte, readSize)
+	n, err := file.ReadAt(data, 0)
+	if err != nil && int64(n) != readSize {
+		return "", 0, fmt.Errorf("failed to read file at offset 0: %v", err)
+	}
+
+	return createUploadWithData(client, config.TusdEndpoint, fileSize, config.uploadMetadata(config.FilePath), config.Headers, data, config.ChecksumAlgorithm)
+}
+
+// createUploadWithData creates an upload with data in the request body
+func createUploadWithData(client *http.Client, endpoint string, fileSize int64, metadata map[string]string, headers map[string]string, data []byte, checksumAlgorithm string) (string, int64, error) {
+	req, err := http.NewRequest("POST", endpoint, bytes.NewReader(data))
+	if err != nil {
+		return "", 0, err
+	}
+
+	req.Header.Set("Tus-Resumable", "1.0.0")
+	req.Header.Set("Content-Type", "application/offset+octet-stream")
+	req.Header.Set("Content-Length", strconv.Itoa(len(data)))
+	req.Header.Set("Upload-Length", strconv.FormatInt(fileSize, 10))
+	req.Header.Set("Upload-Metadata", encodeMetadata(metadata))
+	if checksumAlgorithm != "" {
+		req.Header.Set("Upload-Checksum", checksumHeader(checksumAlgorithm, data))
+	}
+
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", 0, err
+	}
+	defer resp.Body.Close()
+
+	// Non-standard HTTP code '460 Checksum Mismatch'
+	if resp.StatusCode == 460 {
+		return "", 0, newHTTPError(PhaseCreate, resp, ErrChecksumMismatch)
+	}
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return "", 0, newHTTPError(PhaseCreate, resp, nil)
+	}
+
+	location := resp.Header.Get("Location")
+	if location == "" {
+		return "", 0, fmt.Errorf("no Location header in response")
+	}
+
+	// The server reports how much of the body it stored, ask for it if the
+	// header is missing
+	offsetStr := resp.Header.Get("Upload-Offset")
+	if offsetStr == "" {
+		offset, err := getUploadOffset(client, location, headers)
+		return location, offset, err
+	}
+
+	offset, err := strconv.ParseInt(offsetStr, 10, 64)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid Upload-Offset header: %s", offsetStr)
+	}
+
+	return location, offset, nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestCreationWithUploadSmallFile(t *testing.T) {
+	mockServer := NewMockTUSServer()
+	defer mockServer.Close()
+	mockServer.CreationWithUpload = true
+
+	testContent := []byte("Small file sent with the creation request.")
+	testFile := createTestFile(t, int64(len(testContent)), testContent)
+	defer os.Remove(testFile)
+
+	config := Config{
+		TusdEndpoint: mockServer.URL(),
+		ChunkSize:    1024 * 1024,
+		Headers:      make(map[string]string),
+		FilePath:     testFile,
+	}
+
+	if err := uploadFile(config); err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+
+	if mockServer.Patches != 0 {
+		t.Errorf("Expected no PATCH requests, got %d", mockServer.Patches)
+	}
+
+	upload := mockServer.GetUpload("upload_1")
+	if upload == nil || upload.Offset != upload.Size {
+		t.Fatalf("Expected a complete upload, got %+v", upload)
+	}
+	if !bytes.Equal(upload.Data, testContent) {
+		t.Error("Uploaded data doesn't match original content")
+	}
+}
+
+func TestCreationWithUploadFirstChunk(t *testing.T) {
+	mockServer := NewMockTUSServer()
+	defer mockServer.Close()
+	mockServer.CreationWithUpload = true
+
+	testSize := int64(5 * 1024)
+	testFile := createTestFile(t, testSize, nil)
+	defer os.Remove(testFile)
+
+	originalContent, err := os.ReadFile(testFile)
+	if err != nil {
+		t.Fatalf("Failed to read test file: %v", err)
+	}
+
+	config := Config{
+		TusdEndpoint: mockServer.URL(),
+		ChunkSize:    1024,
+		Headers:      make(map[string]string),
+		FilePath:     testFile,
+		Checksum:     "sha1",
+	}
+
+	if err := uploadFile(config); err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+
+	// The first of five chunks travels with the POST
+	if mockServer.Patches != 4 {
+		t.Errorf("Expected 4 PATCH requests, got %d", mockServer.Patches)
+	}
+
+	upload := mockServer.GetUpload("upload_1")
+	if upload == nil || !bytes.Equal(upload.Data, originalContent) {
+		t.Error("Uploaded data doesn't match original content")
+	}
+}
+
+func TestCreateUploadWithoutExtension(t *testing.T) {
+	mockServer := NewMockTUSServer()
+	defer mockServer.Close()
+
+	testContent := []byte("Server without creation-with-upload.")
+	testFile := createTestFile(t, int64(len(testContent)), testContent)
+	defer os.Remove(testFile)
+
+	config := Config{
+		TusdEndpoint: mockServer.URL(),
+		ChunkSize:    1024 * 1024,
+		Headers:      make(map[string]string),
+		FilePath:     testFile,
+	}
+
+	if err := uploadFile(config); err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+
+	if mockServer.Patches != 1 {
+		t.Errorf("Expected the data in a single PATCH, got %d requests", mockServer.Patches)
+	}
+}
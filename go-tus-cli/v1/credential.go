@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+)
+
+// HostAuth authorizes only the requests to Host, so credentials looked up for
+// the endpoint aren't sent to upload URLs on other hosts
+type HostAuth struct {
+	Host string
+	Auth Authenticator
+}
+
+func (a *HostAuth) Authorize(req *http.Request) error {
+	if !strings.EqualFold(req.URL.Host, a.Host) {
+		return nil
+	}
+	return a.Auth.Authorize(req)
+}
+
+// credentialAuth looks up basic auth credentials for the host of endpoint,
+// first from the credential helper, then from the netrc file. It returns nil
+// when neither is set or has credentials for the host.
+func credentialAuth(endpoint, helper, netrcFile string) (Authenticator, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" || (helper == "" && netrcFile == "") {
+		return nil, nil
+	}
+
+	var username, password string
+	if helper != "" {
+		if username, password, err = helperCredentials(helper, u); err != nil {
+			return nil, err
+		}
+	}
+	if username == "" && password == "" && netrcFile != "" {
+		if username, password, err = netrcCredentials(netrcFile, u); err != nil {
+			return nil, err
+		}
+	}
+	if username == "" && password == "" {
+		return nil, nil
+	}
+	return &HostAuth{Host: u.Host, Auth: &BasicAuth{Username: username, Password: password}}, nil
+}
+
+// helperCredentials asks helper for the credentials of u with git's
+// credential helper protocol: the protocol and host go to its stdin, it
+// answers with username= and password= lines. helper is split on spaces
+// and not run by a shell, e.g. "my-vault-cli get".
+func helperCredentials(helper string, u *url.URL) (username, password string, err error) {
+	args := strings.Fields(helper)
+	if len(args) == 0 {
+		return "", "", nil
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = strings.NewReader(fmt.Sprintf("protocol=%s\nhost=%s\n\n", u.Scheme, u.Host))
+	cmd.Stderr = os.Stderr // Helpers may prompt on the terminal
+	output, err := cmd.Output()
+	if err != nil {
+		return "", "", fmt.Errorf("credential helper %q failed: %v", args[0], err)
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		key, value, _ := strings.Cut(scanner.Text(), "=")
+		switch key {
+		case "":
+			return username, password, nil
+		case "username":
+			username = value
+		case "password":
+			password = value
+		case "quit":
+			return "", "", fmt.Errorf("credential helper %q gave up", args[0])
+		}
+	}
+	return username, password, nil
+}
+
+// netrcPath returns the default netrc file, $NETRC or ~/.netrc (~/_netrc on
+// Windows)
+func netrcPath() string {
+	if path := os.Getenv("NETRC"); path != "" {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	if runtime.GOOS == "windows" {
+		return filepath.Join(home, "_netrc")
+	}
+	return filepath.Join(home, ".netrc")
+}
+
+// netrcCredentials returns the login and password of the machine entry of
+// u's host in the netrc file at path, or of its default entry
+func netrcCredentials(path string, u *url.URL) (username, password string, err error) {
+	if path == "" {
+		return "", "", errors.New("no netrc file, set $NETRC")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read netrc file: %v", err)
+	}
+
+	// Macro definitions run until the next blank line and hold no credentials
+	var lines []string
+	inMacro := false
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		switch {
+		case inMacro:
+			inMacro = len(fields) > 0
+		case len(fields) > 0 && strings.HasPrefix(fields[0], "#"):
+		default:
+			lines = append(lines, line)
+			inMacro = slices.Contains(fields, "macdef")
+		}
+	}
+
+	type entry struct {
+		machine         string // empty for the default entry
+		login, password string
+	}
+	var entries []entry
+	tokens := strings.Fields(strings.Join(lines, "\n"))
+	for i := 0; i < len(tokens); i++ {
+		if tokens[i] == "default" {
+			entries = append(entries, entry{})
+			continue
+		}
+		if i+1 == len(tokens) {
+			break
+		}
+		key, value := tokens[i], tokens[i+1]
+		i++
+		switch {
+		case key == "machine":
+			entries = append(entries, entry{machine: value})
+		case len(entries) == 0:
+		case key == "login":
+			entries[len(entries)-1].login = value
+		case key == "password":
+			entries[len(entries)-1].password = value
+		}
+	}
+
+	for _, entry := range entries {
+		if entry.machine == "" || strings.EqualFold(entry.machine, u.Hostname()) || strings.EqualFold(entry.machine, u.Host) {
+			return entry.login, entry.password, nil
+		}
+	}
+	return "", "", nil
+}
+
+// hasHeader reports whether headers set name, whatever its case
+func hasHeader(headers map[string]string, name string) bool {
+	for key := range headers {
+		if strings.EqualFold(key, name) {
+			return true
+		}
+	}
+	return false
+}
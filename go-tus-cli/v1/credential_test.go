@@ -0,0 +1,124 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+const testNetrc = `# uploads
+machine tus.example.com login alice password s3cret
+macdef init
+machine tus.example.com login mallory password macro
+
+machine localhost:8080
+  login bob
+  password hunter2
+default login anonymous password guest
+`
+
+func TestNetrcCredentials(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".netrc")
+	os.WriteFile(path, []byte(testNetrc), 0600)
+
+	tests := []struct {
+		endpoint string
+		username string
+		password string
+	}{
+		{"https://tus.example.com/files/", "alice", "s3cret"},
+		{"https://TUS.example.com:8443/files/", "alice", "s3cret"},
+		{"http://localhost:8080/files/", "bob", "hunter2"},
+		{"http://other.example.com/files/", "anonymous", "guest"},
+	}
+
+	for _, test := range tests {
+		u, _ := url.Parse(test.endpoint)
+		username, password, err := netrcCredentials(path, u)
+		if err != nil || username != test.username || password != test.password {
+			t.Errorf("netrcCredentials(%s) = %q, %q, %v, expected %q, %q", test.endpoint, username, password, err, test.username, test.password)
+		}
+	}
+
+	if _, _, err := netrcCredentials(filepath.Join(t.TempDir(), "missing"), &url.URL{Host: "x"}); err == nil {
+		t.Error("Expected a missing netrc file to be reported")
+	}
+}
+
+func TestHelperCredentials(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the test helper is a shell script")
+	}
+
+	// The helper answers with the host it was asked for as password
+	helper := filepath.Join(t.TempDir(), "helper")
+	script := "#!/bin/sh\n[ \"$1\" = get ] || exit 1\nread protocol\nread host\necho username=alice\necho \"password=$protocol,$host\"\necho\necho password=ignored\n"
+	os.WriteFile(helper, []byte(script), 0700)
+
+	u, _ := url.Parse("https://tus.example.com:8443/files/")
+	username, password, err := helperCredentials(helper+" get", u)
+	if err != nil || username != "alice" || password != "protocol=https,host=tus.example.com:8443" {
+		t.Errorf("Unexpected helper credentials %q, %q, %v", username, password, err)
+	}
+
+	if _, _, err := helperCredentials(helper+" erase", u); err == nil {
+		t.Error("Expected a failing helper to be reported")
+	}
+}
+
+func TestCredentialAuthOnlyForEndpointHost(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".netrc")
+	os.WriteFile(path, []byte("machine 127.0.0.1 login alice password s3cret\n"), 0600)
+
+	var authorized []bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _, ok := r.BasicAuth()
+		authorized = append(authorized, ok)
+	}))
+	defer server.Close()
+
+	auth, err := credentialAuth(server.URL+"/files/", "", path)
+	if err != nil || auth == nil {
+		t.Fatalf("Expected credentials for the endpoint, got %v", err)
+	}
+	client := authHTTPClient(&http.Client{}, auth)
+
+	// localhost is the same server under another host name
+	other, _ := url.Parse(server.URL)
+	other.Host = "localhost:" + other.Port()
+	for _, target := range []string{server.URL + "/files/1", other.String() + "/files/1"} {
+		resp, err := client.Get(target)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+	}
+	if len(authorized) != 2 || !authorized[0] || authorized[1] {
+		t.Errorf("Expected credentials only for the endpoint host, got %v", authorized)
+	}
+
+	if auth, err := credentialAuth(server.URL, "", ""); auth != nil || err != nil {
+		t.Errorf("Expected no credentials without helper or netrc, got %v, %v", auth, err)
+	}
+}
+
+func TestBuildAuthFromNetrc(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".netrc")
+	os.WriteFile(path, []byte("machine tus.example.com login alice password s3cret\n"), 0600)
+
+	config := Config{TusdEndpoint: "https://tus.example.com/files/", NetrcFile: path}
+	auth, err := buildAuth(config)
+	if host, ok := auth.(*HostAuth); err != nil || !ok || host.Host != "tus.example.com" {
+		t.Errorf("Expected credentials of the netrc file, got %v, %v", auth, err)
+	}
+
+	// An Authorization header is used as it is
+	config.Headers = map[string]string{"authorization": "Bearer abc"}
+	if auth, err := buildAuth(config); auth != nil || err != nil {
+		t.Errorf("Expected no authenticator with an Authorization header, got %v, %v", auth, err)
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Phase names the step of an upload a failed request belongs to
+type Phase string
+
+const (
+	PhaseCreate    Phase = "create"
+	PhaseHead      Phase = "head"
+	PhasePatch     Phase = "patch"
+	PhaseTerminate Phase = "terminate"
+)
+
+// maxErrorBody is how much of an error response body is kept
+const maxErrorBody = 512
+
+// errorHeaders are the response headers kept with an HTTPError
+var errorHeaders = []string{
+	"Tus-Resumable", "Tus-Version", "Tus-Extension", "Tus-Max-Size",
+	"Upload-Offset", "Upload-Length", "Upload-Expires", "Retry-After",
+}
+
+// HTTPError is a request the server answered with an unexpected status
+type HTTPError struct {
+	Phase      Phase
+	Method     string
+	URL        string
+	StatusCode int
+	Status     string
+	Body       string
+	Header     http.Header
+	Err        error
+}
+
+// newHTTPError describes the failed response resp, err is the error it
+// stands for, if any
+func newHTTPError(phase Phase, resp *http.Response, err error) *HTTPError {
+	httpErr := &HTTPError{
+		Phase:      phase,
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		Header:     make(http.Header),
+		Err:        err,
+	}
+	if resp.Request != nil {
+		httpErr.Method = resp.Request.Method
+		httpErr.URL = resp.Request.URL.String()
+	}
+	for _, name := range errorHeaders {
+		if values := resp.Header.Values(name); len(values) > 0 {
+			httpErr.Header[name] = values
+		}
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	httpErr.Body = strings.TrimSpace(string(body))
+
+	return httpErr
+}
+
+func (e *HTTPError) Error() string {
+	msg := fmt.Sprintf("%s request failed with %s", e.Phase, e.Status)
+	if e.Body != "" {
+		msg += ": " + e.Body
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *HTTPError) Unwrap() error {
+	return e.Err
+}
+
+// RetryAction is what a failed request calls for
+type RetryAction int
+
+const (
+	FailFast RetryAction = iota // Permanent, retrying won't help
+	Backoff                     // Transient, retry after waiting
+	Resync                      // Offsets differ, ask the server for its offset and continue
+	Resend                      // The data was damaged on the way, send it again
+	Recreate                    // The upload is gone, start a new one
+)
+
+func (a RetryAction) String() string {
+	return [...]string{"fail", "backoff", "resync", "resend", "recreate"}[a]
+}
+
+// retryAction classifies err by its HTTP status following the tus spec, or
+// by the transport error if no response was received
+func retryAction(err error) RetryAction {
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		action := statusRetryAction(httpErr.StatusCode)
+		// A 404 on creation is a wrong endpoint, not a lost upload
+		if action == Recreate && httpErr.Phase == PhaseCreate {
+			return FailFast
+		}
+		return action
+	}
+
+	switch {
+	case err == nil, errors.Is(err, context.Canceled):
+		return FailFast
+	case errors.Is(err, ErrChecksumMismatch):
+		return Resend
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return Backoff
+	}
+
+	// Timeouts, refused and reset connections, DNS failures
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return Backoff
+	}
+
+	return FailFast
+}
+
+// statusRetryAction maps a response status to a RetryAction
+func statusRetryAction(status int) RetryAction {
+	switch {
+	case status == http.StatusConflict:
+		return Resync
+	case status == 460: // Non-standard 460 Checksum Mismatch
+		return Resend
+	case status == http.StatusNotFound, status == http.StatusGone:
+		return Recreate
+	case status == http.StatusLocked, status == http.StatusRequestTimeout, status == http.StatusTooManyRequests:
+		return Backoff
+	case status >= 500:
+		return Backoff
+	default:
+		return FailFast
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetryAction(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected RetryAction
+	}{
+		{&HTTPError{Phase: PhasePatch, StatusCode: http.StatusConflict}, Resync},
+		{&HTTPError{Phase: PhasePatch, StatusCode: http.StatusLocked}, Backoff},
+		{&HTTPError{Phase: PhasePatch, StatusCode: http.StatusGone}, Recreate},
+		{&HTTPError{Phase: PhaseCreate, StatusCode: http.StatusNotFound}, FailFast},
+		{&HTTPError{Phase: PhasePatch, StatusCode: 460, Err: ErrChecksumMismatch}, Resend},
+		{&HTTPError{Phase: PhasePatch, StatusCode: http.StatusServiceUnavailable}, Backoff},
+		{&HTTPError{Phase: PhasePatch, StatusCode: http.StatusForbidden}, FailFast},
+		{ErrChecksumMismatch, Resend},
+		{io.ErrUnexpectedEOF, Backoff},
+		{errors.New("connection refused"), FailFast},
+	}
+
+	for _, test := range tests {
+		if action := retryAction(test.err); action != test.expected {
+			t.Errorf("retryAction(%v) = %s, expected %s", test.err, action, test.expected)
+		}
+	}
+}
+
+func uploadWithStatuses(t *testing.T, statuses ...int) (*MockTUSServer, []byte, error) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+
+	mockServer := NewMockTUSServer()
+	t.Cleanup(mockServer.Close)
+	mockServer.PatchStatuses = statuses
+
+	testContent := []byte(strings.Repeat("tus", MinChunkSize))
+	testFile := createTestFile(t, int64(len(testContent)), testContent)
+	t.Cleanup(func() { os.Remove(testFile) })
+
+	config := Config{
+		TusdEndpoint: mockServer.URL(),
+		FilePath:     testFile,
+		ChunkSize:    MinChunkSize,
+		Headers:      make(map[string]string),
+	}
+	return mockServer, testContent, uploadFile(config)
+}
+
+func TestUploadResyncsOn409(t *testing.T) {
+	start := time.Now()
+	mockServer, content, err := uploadWithStatuses(t, http.StatusConflict)
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Re-syncing must not back off, took %v", elapsed)
+	}
+
+	for _, upload := range mockServer.GetUploads() {
+		if !bytes.Equal(upload.Data, content) {
+			t.Error("Uploaded data doesn't match")
+		}
+	}
+}
+
+func TestUploadRecreatesGoneUpload(t *testing.T) {
+	mockServer, content, err := uploadWithStatuses(t, http.StatusGone)
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+
+	uploads := mockServer.GetUploads()
+	if len(uploads) != 2 {
+		t.Fatalf("Expected a second upload to replace the gone one, got %d", len(uploads))
+	}
+	if !bytes.Equal(uploads["upload_2"].Data, content) {
+		t.Error("Expected the new upload to hold the file")
+	}
+}
+
+func TestUploadFailsFastOn4xx(t *testing.T) {
+	mockServer, _, err := uploadWithStatuses(t, http.StatusForbidden)
+
+	var httpErr *HTTPError
+	if !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusForbidden {
+		t.Fatalf("Expected a PATCH failed with 403, got %v", err)
+	}
+	if httpErr.Body != "injected 403" || httpErr.Method != "PATCH" {
+		t.Errorf("Expected the request and response body in the error, got %+v", httpErr)
+	}
+	if mockServer.Patches != 1 {
+		t.Errorf("Expected no retries after 403, got %d PATCH requests", mockServer.Patches)
+	}
+}
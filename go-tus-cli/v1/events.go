@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Event is one line of -output json. Fields that don't apply to an event are
+// left out.
+type Event struct {
+	Event      string            `json:"event"` // created, resumed, progress, retry, completed or failed
+	Time       time.Time         `json:"time"`
+	File       string            `json:"file,omitempty"`
+	UploadURL  string            `json:"upload_url,omitempty"`
+	Offset     int64             `json:"offset,omitempty"` // bytes on the server
+	Size       int64             `json:"size,omitempty"`
+	Rate       int64             `json:"rate,omitempty"` // bytes per second
+	Attempt    int               `json:"attempt,omitempty"`
+	Action     string            `json:"action,omitempty"`
+	DelayMs    int64             `json:"delay_ms,omitempty"`
+	DurationMs int64             `json:"duration_ms,omitempty"`
+	Error      string            `json:"error,omitempty"`
+	Metadata   map[string]string `json:"metadata,omitempty"`
+}
+
+// EventWriter writes events as NDJSON. A nil writer drops all events.
+type EventWriter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	now func() time.Time
+}
+
+func NewEventWriter(w io.Writer) *EventWriter {
+	return &EventWriter{enc: json.NewEncoder(w), now: time.Now}
+}
+
+// Emit writes event as one line
+func (ew *EventWriter) Emit(event Event) {
+	if ew == nil {
+		return
+	}
+
+	ew.mu.Lock()
+	defer ew.mu.Unlock()
+	event.Time = ew.now().UTC()
+	ew.enc.Encode(event)
+}
+
+// emit writes event for the uploaded file if -output json is set
+func (c Config) emit(event Event) {
+	if event.File == "" {
+		event.File = c.FilePath
+		if c.FilePath == "-" && c.Name != "" {
+			event.File = c.Name
+		}
+	}
+	c.events.Emit(event)
+}
+
+// emitRetry emits a retry event for a failed request that is repeated after delay
+func (c Config) emitRetry(attempt int, delay time.Duration, err error) {
+	c.emit(Event{
+		Event:   "retry",
+		Attempt: attempt,
+		Action:  retryAction(err).String(),
+		DelayMs: delay.Milliseconds(),
+		Error:   err.Error(),
+	})
+}
+
+// uploadMetadata is the metadata uploads of name are created with, the
+// Metadata of the config without overriding the name
+func (c Config) uploadMetadata(name string) map[string]string {
+	metadata := maps.Clone(c.Metadata)
+	if metadata == nil {
+		metadata = make(map[string]string)
+	}
+	metadata["name"] = filepath.Base(name)
+	return metadata
+}
+
+// parseOutput returns the event writer for -output, nil for text output, and
+// where text for humans goes. JSON events own stdout, so that is stderr then.
+func parseOutput(format string) (*EventWriter, io.Writer, error) {
+	switch format {
+	case "", "text":
+		return nil, os.Stdout, nil
+	case "json":
+		return NewEventWriter(os.Stdout), os.Stderr, nil
+	default:
+		return nil, nil, fmt.Errorf("invalid -output %q, expected text or json", format)
+	}
+}
+
+// output returns where text for humans goes: out, or stdout when it is nil
+func (c Config) output() io.Writer {
+	if c.out == nil {
+		return os.Stdout
+	}
+	return c.out
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUploadEvents(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+
+	mockServer := NewMockTUSServer()
+	defer mockServer.Close()
+	mockServer.PatchStatuses = []int{503}
+
+	testContent := []byte(strings.Repeat("x", MinChunkSize))
+	testFile := createTestFile(t, int64(len(testContent)), testContent)
+	defer os.Remove(testFile)
+
+	var buf bytes.Buffer
+	events := NewEventWriter(&buf)
+	events.now = func() time.Time { return time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC) }
+
+	config := Config{
+		TusdEndpoint: mockServer.URL(),
+		FilePath:     testFile,
+		ChunkSize:    MinChunkSize,
+		Headers:      make(map[string]string),
+		Retry:        &RetryPolicy{Retries: 1, MaxDelay: 10 * time.Millisecond},
+		events:       events,
+	}
+	if err := uploadFile(config); err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+
+	var names []string
+	var last Event
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		if err := json.Unmarshal(scanner.Bytes(), &last); err != nil {
+			t.Fatalf("Invalid event line %q: %v", scanner.Text(), err)
+		}
+		if last.File != testFile || last.Time.IsZero() {
+			t.Errorf("Expected every event to name the file and time, got %s", scanner.Text())
+		}
+		names = append(names, last.Event)
+	}
+
+	if strings.Join(names, ",") != "created,retry,completed" {
+		t.Fatalf("Expected created, retry and completed events, got %v", names)
+	}
+	if !strings.HasPrefix(last.UploadURL, mockServer.URL()) || last.Size != MinChunkSize || last.Metadata["name"] == "" {
+		t.Errorf("Unexpected completed event: %+v", last)
+	}
+
+	if _, _, err := parseOutput("xml"); err == nil {
+		t.Error("Expected an unknown output format to be rejected")
+	}
+}
+
+func TestParseOutput(t *testing.T) {
+	stdout := os.Stdout
+	if events, out, err := parseOutput("text"); events != nil || out != os.Stdout || err != nil {
+		t.Errorf("Expected text output on stdout without events, got %v, %v, %v", events, out, err)
+	}
+	if events, out, err := parseOutput("json"); events == nil || out != os.Stderr || err != nil {
+		t.Errorf("Expected events with text on stderr, got %v, %v, %v", events, out, err)
+	}
+	if os.Stdout != stdout {
+		t.Error("parseOutput must not replace os.Stdout")
+	}
+}
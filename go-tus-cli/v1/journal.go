@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// StateVersion is the schema version of state files. Files without a version
+// were written before versioning and are read as version 1.
+const StateVersion = 1
+
+// JournalEntry records one offset acknowledged by the server
+type JournalEntry struct {
+	URL    string `json:"url"`
+	Offset int64  `json:"offset"`
+}
+
+// getJournalFile returns the offset journal that belongs to stateFile
+func getJournalFile(stateFile string) string {
+	return strings.TrimSuffix(stateFile, ".json") + ".journal"
+}
+
+// writeFileAtomic replaces path with data so that a crash leaves either the
+// old or the new content, never a torn file
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	// Persist the rename itself, not supported on every platform
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// appendJournal durably records an offset the server acknowledged for
+// uploadURL next to stateFile. Entries are folded in on the next saveState.
+func appendJournal(stateFile, uploadURL string, offset int64) error {
+	line, err := json.Marshal(JournalEntry{URL: uploadURL, Offset: offset})
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(stateFile), 0700); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(getJournalFile(stateFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open journal: %v", err)
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write journal: %v", err)
+	}
+	return file.Sync()
+}
+
+// replayJournal moves state.Offset forward to the offsets journaled for its
+// URL. A torn last line left by a crash is ignored.
+func replayJournal(stateFile string, state *UploadState) {
+	file, err := os.Open(getJournalFile(stateFile))
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry JournalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			break
+		}
+		if entry.URL == state.URL && entry.Offset > state.Offset {
+			state.Offset = entry.Offset
+		}
+	}
+}
+
+// readStateFile reads a state file and applies its journal
+func readStateFile(stateFile string) (*UploadState, error) {
+	data, err := os.ReadFile(stateFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var state UploadState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+	if state.Version > StateVersion {
+		return nil, fmt.Errorf("state file %s was written by a newer tusc (version %d)", stateFile, state.Version)
+	}
+	if err := scrubStateFile(stateFile, &state); err != nil {
+		return nil, fmt.Errorf("failed to remove credentials from state file %s: %v", stateFile, err)
+	}
+
+	replayJournal(stateFile, &state)
+	return &state, nil
+}
+
+// removeStateFile removes a state file together with its journal
+func removeStateFile(stateFile string) {
+	os.Remove(stateFile)
+	os.Remove(getJournalFile(stateFile))
+}
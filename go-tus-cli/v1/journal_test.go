@@ -0,0 +1,109 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "state.json")
+
+	writeFileAtomic(path, []byte("first"), 0644)
+	if err := writeFileAtomic(path, []byte("second"), 0644); err != nil {
+		t.Fatalf("writeFileAtomic failed: %v", err)
+	}
+
+	if data, _ := os.ReadFile(path); string(data) != "second" {
+		t.Errorf("Expected replaced content, got %q", data)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("Expected no temporary files to be left behind, got %d entries", len(entries))
+	}
+}
+
+func TestJournalReplay(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+
+	testContent := []byte("journaled upload")
+	testFile := createTestFile(t, int64(len(testContent)), testContent)
+	defer os.Remove(testFile)
+
+	uploadURL := "http://localhost:1080/files/abc"
+	if err := saveState(calculateFileHash(testFile), &UploadState{URL: uploadURL, FileSize: int64(len(testContent))}); err != nil {
+		t.Fatalf("Failed to save state: %v", err)
+	}
+	defer clearState(calculateFileHash(testFile))
+
+	stateFile := getStateFile(calculateFileHash(testFile))
+	appendJournal(stateFile, uploadURL, 4)
+	appendJournal(stateFile, uploadURL, 8)
+	appendJournal(stateFile, "http://localhost:1080/files/other", 16)
+
+	// Simulate a crash in the middle of the next append
+	journal, _ := os.OpenFile(getJournalFile(stateFile), os.O_WRONLY|os.O_APPEND, 0600)
+	journal.WriteString(`{"url":"http://localhost:1080/files/abc","off`)
+	journal.Close()
+
+	state, err := loadState(calculateFileHash(testFile))
+	if err != nil {
+		t.Fatalf("Failed to load state: %v", err)
+	}
+	if state.Offset != 8 {
+		t.Errorf("Expected journaled offset 8, got %d", state.Offset)
+	}
+
+	// Saving folds the journal into the state file
+	if err := saveState(calculateFileHash(testFile), state); err != nil {
+		t.Fatalf("Failed to save state: %v", err)
+	}
+	if _, err := os.Stat(getJournalFile(stateFile)); !os.IsNotExist(err) {
+		t.Error("Journal should be removed once compacted")
+	}
+
+	state, _ = loadState(calculateFileHash(testFile))
+	if state.Offset != 8 || state.Version != StateVersion {
+		t.Errorf("Expected offset 8 at version %d, got %d at version %d", StateVersion, state.Offset, state.Version)
+	}
+}
+
+func TestReadStateFileNewerVersion(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), ".tusc_state_future_1.json")
+	os.WriteFile(stateFile, []byte(`{"version": 99, "url": "http://localhost:1080/files/abc"}`), 0644)
+
+	_, err := readStateFile(stateFile)
+	if err == nil || !strings.Contains(err.Error(), "newer tusc") {
+		t.Errorf("Expected a newer version to be rejected, got: %v", err)
+	}
+}
+
+func TestUploadRemovesJournal(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+
+	mockServer := NewMockTUSServer()
+	defer mockServer.Close()
+
+	testContent := []byte(strings.Repeat("x", 3*MinChunkSize))
+	testFile := createTestFile(t, int64(len(testContent)), testContent)
+	defer os.Remove(testFile)
+
+	config := Config{
+		TusdEndpoint: mockServer.URL(),
+		FilePath:     testFile,
+		ChunkSize:    MinChunkSize,
+		Headers:      make(map[string]string),
+	}
+	if err := uploadFile(config); err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+
+	// A finished upload leaves neither state nor journal behind
+	entries, _ := os.ReadDir(stateDir())
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), ".json") || strings.HasSuffix(entry.Name(), ".journal") {
+			t.Errorf("Unexpected leftover %s", entry.Name())
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LockPollInterval is how often -wait tries to take a held lock
+const LockPollInterval = 250 * time.Millisecond
+
+// errLockHeld is returned by tryLockFile when another process holds the lock
+var errLockHeld = errors.New("lock held by another process")
+
+// UploadLock is an advisory lock on the state of one file's upload. It
+// replaces guessing from PIDs in state file names: the operating system
+// drops the lock when the process dies, and a reused PID cannot hold it.
+type UploadLock struct {
+	file *os.File
+}
+
+// getLockFile returns the path to the lock file of the upload with key, see
+// calculateFileHash
+func getLockFile(key string) string {
+	return filepath.Join(stateDir(), fmt.Sprintf(".tusc_lock_%s", key))
+}
+
+// lockUpload takes the lock of filePath's upload with key. If another
+// process holds it, lockUpload polls until it is released with -wait, or
+// until the run is cancelled, and fails otherwise.
+func lockUpload(config Config, key, filePath string) (*UploadLock, error) {
+	lockPath := getLockFile(key)
+	if err := os.MkdirAll(filepath.Dir(lockPath), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create state directory: %v", err)
+	}
+
+	waiting := false
+	for {
+		file, err := openLockFile(lockPath)
+		if errors.Is(err, errLockHeld) {
+			if !config.Wait {
+				return nil, fmt.Errorf("%s is already being uploaded by another tusc process%s, use -wait to wait for it", filePath, lockHolder(lockPath))
+			}
+			if !waiting {
+				fmt.Fprintf(config.output(), "Waiting for another tusc process%s to finish with %s...\n", lockHolder(lockPath), filePath)
+				waiting = true
+			}
+			if err := sleepContext(config.runContext(), LockPollInterval); err != nil {
+				return nil, errInterrupted
+			}
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to lock %s: %v", lockPath, err)
+		}
+
+		// Record the holder for the error message of the next process
+		file.Truncate(0)
+		file.WriteAt([]byte(strconv.Itoa(os.Getpid())), 0)
+
+		return &UploadLock{file: file}, nil
+	}
+}
+
+// openLockFile opens and locks the lock file at lockPath without blocking.
+// A file its holder removed while we opened it is opened anew, so two
+// processes never hold locks on different files of the same path.
+func openLockFile(lockPath string) (*os.File, error) {
+	for {
+		file, err := os.OpenFile(lockPath, os.O_CREATE|os.O_RDWR, 0600)
+		if err != nil {
+			return nil, err
+		}
+		if err := tryLockFile(file); err != nil {
+			file.Close()
+			return nil, err
+		}
+
+		locked, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		if current, err := os.Stat(lockPath); err == nil && os.SameFile(locked, current) {
+			return file, nil
+		}
+		unlockFile(file)
+		file.Close()
+	}
+}
+
+// lockHolder describes the process recorded in the lock file at lockPath
+func lockHolder(lockPath string) string {
+	data, _ := os.ReadFile(lockPath)
+	if pid := strings.TrimSpace(string(data)); pid != "" && len(pid) < 32 {
+		return fmt.Sprintf(" (PID %s)", pid)
+	}
+	return ""
+}
+
+// Unlock releases the lock and removes the lock file. It is removed while
+// still locked, a process that opened it meanwhile notices and opens the
+// path again. Where open files can't be removed, it is removed after
+// closing unless another process has it open by then.
+func (l *UploadLock) Unlock() error {
+	removed := os.Remove(l.file.Name()) == nil
+	err := unlockFile(l.file)
+	l.file.Close()
+	if !removed {
+		os.Remove(l.file.Name())
+	}
+	return err
+}
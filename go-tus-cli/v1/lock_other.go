@@ -0,0 +1,15 @@
+//go:build !unix && !windows
+
+package main
+
+import "os"
+
+// Platforms without file locking run without upload locks
+
+func tryLockFile(file *os.File) error {
+	return nil
+}
+
+func unlockFile(file *os.File) error {
+	return nil
+}
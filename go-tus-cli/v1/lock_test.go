@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLockUpload(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+
+	testContent := []byte("file uploaded by two processes")
+	testFile := createTestFile(t, int64(len(testContent)), testContent)
+	defer os.Remove(testFile)
+
+	lock, err := lockUpload(Config{}, calculateFileHash(testFile), testFile)
+	if err != nil {
+		t.Fatalf("Failed to take lock: %v", err)
+	}
+
+	_, err = lockUpload(Config{}, calculateFileHash(testFile), testFile)
+	if err == nil || !strings.Contains(err.Error(), "PID "+strconv.Itoa(os.Getpid())) {
+		t.Fatalf("Expected the second lock to fail naming the holder, got: %v", err)
+	}
+
+	acquired := make(chan error)
+	go func() {
+		waiting, err := lockUpload(Config{Wait: true}, calculateFileHash(testFile), testFile)
+		if err == nil {
+			waiting.Unlock()
+		}
+		acquired <- err
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("Waiting lock must not be granted while the lock is held")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	lock.Unlock()
+
+	select {
+	case err := <-acquired:
+		if err != nil {
+			t.Errorf("Waiting lock failed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Waiting lock was not granted after release")
+	}
+
+	if _, err := os.Stat(getLockFile(calculateFileHash(testFile))); !os.IsNotExist(err) {
+		t.Error("Lock file should have been removed on release")
+	}
+}
+
+func TestLockUploadWaitCancelled(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+
+	lock, err := lockUpload(Config{}, "abc", "data.bin")
+	if err != nil {
+		t.Fatalf("Failed to take lock: %v", err)
+	}
+	defer lock.Unlock()
+
+	// Like Ctrl-C while waiting
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := lockUpload(Config{Wait: true, ctx: ctx}, "abc", "data.bin"); !errors.Is(err, errInterrupted) {
+		t.Errorf("Expected the wait to be interrupted, got: %v", err)
+	}
+}
+
+func TestUploadFileLocked(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+
+	mockServer := NewMockTUSServer()
+	defer mockServer.Close()
+
+	testContent := []byte("locked upload")
+	testFile := createTestFile(t, int64(len(testContent)), testContent)
+	defer os.Remove(testFile)
+
+	lock, err := lockUpload(Config{}, calculateFileHash(testFile), testFile)
+	if err != nil {
+		t.Fatalf("Failed to take lock: %v", err)
+	}
+	defer lock.Unlock()
+
+	config := Config{
+		TusdEndpoint: mockServer.URL(),
+		ChunkSize:    1024,
+		Headers:      make(map[string]string),
+		FilePath:     testFile,
+	}
+	if err := uploadFile(config); err == nil {
+		t.Fatal("Expected the upload of a locked file to fail")
+	}
+
+	if len(mockServer.GetUploads()) != 0 {
+		t.Error("A locked upload must not reach the server")
+	}
+}
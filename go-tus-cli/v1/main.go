@@ -2,15 +2,20 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"crypto/md5"
 	"crypto/sha1"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"maps"
 	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -21,17 +26,99 @@ type Config struct {
 	ChunkSize    int64
 	Headers      map[string]string
 	Reset        bool
+	Delete       bool
+	Status       bool
+	JSON         bool
+	Wait         bool // Wait for other tusc processes uploading the same file
 	ShowOptions  bool
 	FilePath     string
+	Name         string // Filename sent for uploads from stdin
+
+	// Checksum is the requested algorithm list, ChecksumAlgorithm the one
+	// negotiated with the server (empty when checksums are not used)
+	Checksum          string
+	ChecksumAlgorithm string
+
+	// Metadata is sent with every upload besides the name, from the profile
+	Metadata map[string]string
+
+	// The -cacert, -cert, -key and -insecure values, TLS the settings built
+	// from them (Go's defaults when nil)
+	CACert   string
+	Cert     string
+	Key      string
+	Insecure bool
+	TLS      *tls.Config
+
+	// The -bearer-token, -user and -oauth2-* values, Auth the authenticator
+	// built from them (nil without credentials). They are never saved in the
+	// state file.
+	BearerToken        string
+	User               string
+	OAuth2TokenURL     string
+	OAuth2ClientID     string
+	OAuth2ClientSecret string
+	OAuth2Scope        string
+	Auth               Authenticator
+
+	// The -sign* values, Signer the signer built from them (nil when requests
+	// aren't signed)
+	Sign        string
+	SignKeyID   string
+	SignSecret  string
+	SignRegion  string
+	SignService string
+	Signer      Signer
+
+	// The -credential-helper, -netrc and -netrc-file values, used for
+	// credentials of the endpoint host when none of the above is set
+	CredentialHelper string
+	Netrc            bool
+	NetrcFile        string
+
+	// VaultKeyFile is the -vault-key-file value, Vault the vault built from it
+	// or TUSC_VAULT_PASSPHRASE (nil when sensitive headers aren't kept)
+	VaultKeyFile string
+	Vault        *HeaderVault
+
+	// LimitRate is the -limit-rate value, RateLimit the limiter built from it
+	LimitRate string
+	RateLimit *RateLimiter
+
+	// Output is the -output value, events the writer built from it (nil for
+	// text output) and out where text for humans goes, see output
+	Output string
+	events *EventWriter
+	out    io.Writer
+
+	// The -retry* values, Retry is the policy built from them (the default
+	// policy when nil)
+	Retries       int
+	RetryDelay    time.Duration
+	RetryMaxDelay time.Duration
+	RetryMaxTime  time.Duration
+	RetryBudget   string
+	Retry         *RetryPolicy
+
+	// ctx is cancelled by SIGINT and SIGTERM, pause holds the upload between
+	// chunks on SIGUSR1, see watchSignals
+	ctx   context.Context
+	pause *PauseGate
+
+	// stateKey names the state and lock files of FilePath, see
+	// calculateFileHash
+	stateKey string
 }
 
 type UploadState struct {
+	Version   int               `json:"version"`
 	URL       string            `json:"url"`
 	Offset    int64             `json:"offset"`
 	FileSize  int64             `json:"file_size"`
 	Endpoint  string            `json:"endpoint"`
 	ChunkSize int64             `json:"chunk_size"`
-	Headers   map[string]string `json:"headers"`
+	Headers   map[string]string `json:"headers"` // Without credentials, see isSensitiveHeader
+	Vault     *SealedHeaders    `json:"vault,omitempty"`
 	Timestamp int64             `json:"timestamp"`
 }
 
@@ -42,17 +129,67 @@ const (
 )
 
 func main() {
-	var config Config
+	config := Config{
+		ChunkSize:     DefaultChunkSize / (1024 * 1024),
+		Retries:       DefaultRetries,
+		RetryDelay:    DefaultRetryDelay,
+		RetryMaxDelay: DefaultRetryMaxDelay,
+	}
 	var headersList []string
 
-	// Load configuration from environment variables first
+	// Load the profile of the config file first
+	profile, err := loadProfile(os.Args[1:])
+	if err == nil && profile != nil {
+		err = applyProfile(&config, profile)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Load configuration from environment variables (these override the profile)
 	loadConfigFromEnv(&config)
 
 	// Parse command line flags (these will override environment variables)
 	flag.StringVar(&config.TusdEndpoint, "t", config.TusdEndpoint, "[required] tusd endpoint")
 	flag.BoolVar(&config.ShowOptions, "o", false, "List tusd OPTIONS")
-	flag.Int64Var(&config.ChunkSize, "c", 2, "Read up to MEGABYTES bytes at a time (max: 32, default: 2)")
+	flag.Int64Var(&config.ChunkSize, "c", config.ChunkSize, "Read up to MEGABYTES bytes at a time (max: 32, default: 2)")
 	flag.BoolVar(&config.Reset, "r", false, "Reuploads given file from the beginning")
+	flag.BoolVar(&config.Delete, "d", false, "Terminates the upload of given file or URL and removes its state")
+	flag.BoolVar(&config.Status, "s", false, "Shows the server side state of the upload of given file or URL")
+	flag.BoolVar(&config.JSON, "json", false, "Prints -s output as JSON")
+	flag.StringVar(&config.Checksum, "checksum", config.Checksum, "Checksum algorithm for each chunk (sha1, md5, crc32, sha256 or auto)")
+	flag.StringVar(&config.Name, "name", "", "Filename for data read from stdin")
+	flag.BoolVar(&config.Wait, "wait", false, "Waits for another tusc process uploading the same file")
+	flag.StringVar(&config.LimitRate, "limit-rate", config.LimitRate, "Limits the upload rate, e.g. 5MB/s or 08:00-18:00=5MB/s,*=unlimited")
+	flag.IntVar(&config.Retries, "retries", config.Retries, "Retries per error class")
+	flag.DurationVar(&config.RetryDelay, "retry-delay", config.RetryDelay, "Longest wait before the first retry, doubled per retry")
+	flag.DurationVar(&config.RetryMaxDelay, "retry-max-delay", config.RetryMaxDelay, "Longest wait before any retry")
+	flag.DurationVar(&config.RetryMaxTime, "retry-max-time", config.RetryMaxTime, "Gives up when failures persist this long")
+	flag.StringVar(&config.RetryBudget, "retry-budget", config.RetryBudget, "Retries per error class, e.g. backoff=5,resync=10,resend=3")
+	flag.StringVar(&config.Output, "output", config.Output, "Output format: text, or json for NDJSON events on stdout")
+	flag.String("profile", "", "Profile of the config file to take defaults from")
+	flag.String("config", "", "Config file with profiles")
+	flag.StringVar(&config.CACert, "cacert", config.CACert, "PEM file with the CA certificates to verify the server with")
+	flag.StringVar(&config.Cert, "cert", config.Cert, "PEM file with the client certificate")
+	flag.StringVar(&config.Key, "key", config.Key, "PEM file with the private key of -cert")
+	flag.BoolVar(&config.Insecure, "insecure", config.Insecure, "Doesn't verify the server certificate")
+	flag.StringVar(&config.BearerToken, "bearer-token", config.BearerToken, "Sends this bearer token with every request")
+	flag.StringVar(&config.User, "user", config.User, "Basic auth credentials (format: user:password)")
+	flag.StringVar(&config.OAuth2TokenURL, "oauth2-token-url", config.OAuth2TokenURL, "Gets bearer tokens from this OAuth2 token endpoint")
+	flag.StringVar(&config.OAuth2ClientID, "oauth2-client-id", config.OAuth2ClientID, "OAuth2 client ID")
+	flag.StringVar(&config.OAuth2ClientSecret, "oauth2-client-secret", config.OAuth2ClientSecret, "OAuth2 client secret")
+	flag.StringVar(&config.OAuth2Scope, "oauth2-scope", config.OAuth2Scope, "Space-separated OAuth2 scopes")
+	flag.StringVar(&config.Sign, "sign", config.Sign, "Signs every request: hmac-sha256 or aws-sigv4")
+	flag.StringVar(&config.SignKeyID, "sign-key-id", config.SignKeyID, "Key ID to sign with, the access key for aws-sigv4")
+	flag.StringVar(&config.SignSecret, "sign-secret", config.SignSecret, "Secret to sign with, the secret key for aws-sigv4")
+	flag.StringVar(&config.SignRegion, "sign-region", config.SignRegion, "AWS region for aws-sigv4")
+	flag.StringVar(&config.SignService, "sign-service", config.SignService, "AWS service for aws-sigv4 (default: execute-api)")
+	flag.StringVar(&config.CredentialHelper, "credential-helper", config.CredentialHelper, "Gets credentials for the endpoint host from this command, e.g. \"my-vault-cli get\"")
+	flag.BoolVar(&config.Netrc, "netrc", config.Netrc, "Gets credentials for the endpoint host from $NETRC or ~/.netrc")
+	flag.StringVar(&config.NetrcFile, "netrc-file", config.NetrcFile, "Like -netrc, with this netrc file")
+	flag.StringVar(&config.VaultKeyFile, "vault-key-file", config.VaultKeyFile, "Key file to seal sensitive headers into the state file with")
+	noWait := flag.Bool("no-wait", false, "Fails if another tusc process is uploading the same file (default)")
 
 	// Custom flag for headers
 	flag.Func("H", "Set additional header", func(header string) error {
@@ -64,6 +201,9 @@ func main() {
 		fmt.Fprintf(os.Stderr, `
 Usage:
   %s [options] file
+  %s [options] [-name NAME] -
+  %s [options] -d file|url
+  %s [options] -s [-json] file|url
 
 Options:
   -t URI            [required] tusd endpoint.
@@ -75,20 +215,128 @@ Options:
   -H HEADER         Set additional header.
                     Can also be set via TUSC_HEADERS environment variable (comma-separated).
   -r                Reuploads given file from the beginning.
+  -d                Terminates the upload of given file or upload URL on the
+                    server (termination extension) and removes its state.
+  -s                Shows offset, length, expiry, concatenation parts and
+                    metadata of the upload of given file or upload URL.
+  -json             Prints the -s output as JSON.
+  -checksum ALGO    Send an Upload-Checksum with every chunk.
+                    > sha1, md5, crc32, sha256, a comma-separated list in
+                      order of preference, or auto
+                    Negotiated against the server's Tus-Checksum-Algorithm.
+                    Can also be set via TUSC_CHECKSUM environment variable.
+  -name NAME        Filename for data read from stdin (file "-").
+                    The upload length is deferred until EOF.
+  -limit-rate RATE  Limits the upload rate, e.g. 500K or 5MB/s.
+                    > a schedule by time of day is re-evaluated during the
+                      upload: "08:00-18:00=5MB/s,*=unlimited"
+                    Can also be set via TUSC_LIMIT_RATE environment variable.
+  -retries N        Retries of a chunk per error class.
+                    > default: 5 (3 for checksum mismatches)
+                    Can also be set via TUSC_RETRIES environment variable.
+  -retry-delay D    Longest wait before the first retry, doubled per retry
+                    up to -retry-max-delay. Waits are random below it, or as
+                    long as the server asks with Retry-After.
+                    > default: 1s, -retry-max-delay default: 30s
+  -retry-max-time D Gives up when a chunk keeps failing this long.
+                    > default: no limit
+  -retry-budget B   Retries per error class: backoff (5xx, 423, network
+                    errors), resync (409) and resend (460), e.g.
+                    "backoff=10,resync=20".
+                    Can also be set via TUSC_RETRY_BUDGET environment variable.
+  -output FORMAT    text, or json for one JSON event per line on stdout
+                    (created, resumed, progress, retry, completed, failed).
+                    Other output goes to stderr.
+                    Can also be set via TUSC_OUTPUT environment variable.
+  -profile NAME     Takes defaults from profile NAME of the config file.
+                    > default: its default_profile
+                    Can also be set via TUSC_PROFILE environment variable.
+  -config FILE      Config file with profiles, shared with tusc v2.
+                    > default: ~/.config/tusc/config.yaml
+                    Can also be set via TUSC_CONFIG environment variable.
+  -cacert FILE      PEM file with the CA certificates to verify the server with.
+  -cert FILE        PEM file with a client certificate, requires -key.
+  -key FILE         PEM file with the private key of -cert.
+  -insecure         Doesn't verify the server certificate.
+  -bearer-token T   Sends bearer token T with every request.
+  -user USER:PASS   Sends basic auth credentials with every request.
+  -oauth2-token-url URL
+                    Gets bearer tokens from the OAuth2 token endpoint URL
+                    with the client credentials of -oauth2-client-id and
+                    -oauth2-client-secret, for the -oauth2-scope scopes.
+                    Tokens are renewed before they expire and when the
+                    server answers 401, the rejected request is sent again.
+  -sign SCHEME      Signs every request for a gateway in front of tusd:
+                    > hmac-sha256: a Signature header over the request
+                      target, host, date, body digest and tus headers
+                      (draft-cavage HTTP signatures), with -sign-key-id
+                      and -sign-secret
+                    > aws-sigv4: AWS Signature Version 4 with -sign-key-id,
+                      -sign-secret, -sign-region and -sign-service, by
+                      default AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY,
+                      AWS_REGION and execute-api
+                    Can also be set via TUSC_SIGN environment variable.
+  -sign-key-id ID, -sign-secret SECRET, -sign-region REGION, -sign-service NAME
+                    Signing settings, also TUSC_SIGN_KEY_ID, TUSC_SIGN_SECRET,
+                    TUSC_SIGN_REGION and TUSC_SIGN_SERVICE.
+  -credential-helper CMD
+                    Gets basic auth credentials for the endpoint host from
+                    CMD, e.g. "my-vault-cli get", with git's credential
+                    helper protocol. Tried before -netrc.
+  -netrc            Gets basic auth credentials for the endpoint host from
+                    $NETRC or ~/.netrc.
+  -netrc-file FILE  Like -netrc, with netrc file FILE.
+                    Only used without other credentials or an
+                    Authorization header, and only sent to the endpoint host.
+  -vault-key-file FILE
+                    Seals sensitive headers (Authorization, cookies, API
+                    keys, ...) into the state file with a key derived from
+                    FILE, at least 32 bytes, so a resumed upload gets them
+                    back. Without it or TUSC_VAULT_PASSPHRASE they are left
+                    out of the state file.
+                    Can also be set via TUSC_VAULT_KEY_FILE environment variable.
+  -wait             Waits until another tusc process uploading the same
+                    file is done instead of failing.
+  -no-wait          Fails if another tusc process is uploading the same
+                    file (default).
   -h                Shows usage.
 
 Environment Variables:
   TUSC_ENDPOINT     TUS server endpoint
   TUSC_CHUNK_SIZE   Chunk size in megabytes
   TUSC_HEADERS      Additional headers (format: "key1:value1,key2:value2")
+  TUSC_CHECKSUM     Checksum algorithm(s)
+  TUSC_LIMIT_RATE   Upload rate limit or schedule
+  TUSC_RETRIES      Retries per error class
+  TUSC_RETRY_DELAY, TUSC_RETRY_MAX_DELAY, TUSC_RETRY_MAX_TIME
+                    Retry waits (e.g. "500ms", "1m")
+  TUSC_RETRY_BUDGET Retries per error class
+  TUSC_OUTPUT       Output format
+  TUSC_PROFILE, TUSC_CONFIG
+                    Profile and config file
+  TUSC_CACERT, TUSC_CERT, TUSC_KEY, TUSC_INSECURE
+                    TLS settings
+  TUSC_BEARER_TOKEN, TUSC_USER, TUSC_OAUTH2_TOKEN_URL, TUSC_OAUTH2_CLIENT_ID,
+  TUSC_OAUTH2_CLIENT_SECRET, TUSC_OAUTH2_SCOPE, TUSC_CREDENTIAL_HELPER,
+  TUSC_NETRC, TUSC_NETRC_FILE
+                    Credentials
+  TUSC_SIGN, TUSC_SIGN_KEY_ID, TUSC_SIGN_SECRET, TUSC_SIGN_REGION, TUSC_SIGN_SERVICE
+                    Request signing
+  TUSC_VAULT_PASSPHRASE, TUSC_VAULT_KEY_FILE
+                    Seal sensitive headers into state files
+
+Precedence: flags > environment variables > profile > defaults
 
 ➤ https://tus.io/protocols/resumable-upload.html
 ➤ Optimized for large files with resumable uploads
 
-`, os.Args[0])
+`, os.Args[0], os.Args[0], os.Args[0], os.Args[0])
 	}
 
 	flag.Parse()
+	if *noWait {
+		config.Wait = false
+	}
 
 	// Parse headers from command line
 	if config.Headers == nil {
@@ -101,6 +349,11 @@ Environment Variables:
 		}
 	}
 
+	// Credentials of the profile, unless the environment or a flag set any
+	if profile != nil {
+		applyProfileAuth(&config, profile.Auth)
+	}
+
 	// Validate required flags
 	if config.TusdEndpoint == "" {
 		fmt.Fprintf(os.Stderr, "Error: tusd endpoint is required\n")
@@ -108,9 +361,79 @@ Environment Variables:
 		os.Exit(1)
 	}
 
+	// Validate checksum algorithms
+	if _, err := parseChecksumAlgorithms(config.Checksum); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Validate TLS settings
+	config.TLS, err = loadTLSConfig(config.CACert, config.Cert, config.Key, config.Insecure)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Validate credentials
+	config.Auth, err = buildAuth(config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Validate request signing
+	config.Signer, err = newSigner(config.Sign, SignerOptions{
+		KeyID:   config.SignKeyID,
+		Secret:  config.SignSecret,
+		Region:  config.SignRegion,
+		Service: config.SignService,
+	})
+	if err == nil && config.Auth != nil {
+		if _, ok := config.Signer.(*SigV4Signer); ok {
+			err = fmt.Errorf("aws-sigv4 signing sets the Authorization header and can't be used with other credentials")
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Validate header vault
+	config.Vault, err = loadHeaderVault(os.Getenv("TUSC_VAULT_PASSPHRASE"), config.VaultKeyFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Validate rate limit
+	if config.LimitRate != "" {
+		schedule, err := parseRateLimit(config.LimitRate)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid -limit-rate: %v\n", err)
+			os.Exit(1)
+		}
+		config.RateLimit = NewRateLimiter(schedule)
+	}
+
+	// Validate retry policy
+	retry, err := buildRetryPolicy(config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	config.Retry = retry
+
+	// Validate output format
+	events, out, err := parseOutput(config.Output)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	config.events, config.out = events, out
+
 	// Handle options request
 	if config.ShowOptions {
-		showServerOptions(config.TusdEndpoint, config.Headers)
+		showServerOptions(config)
 		return
 	}
 
@@ -123,24 +446,51 @@ Environment Variables:
 	}
 	config.FilePath = args[0]
 
+	// Handle termination request
+	if config.Delete {
+		if err := deleteUpload(config, config.FilePath); err != nil {
+			fmt.Fprintf(os.Stderr, "Delete failed: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Handle status request
+	if config.Status {
+		if err := showUploadStatus(config, config.FilePath, config.JSON); err != nil {
+			fmt.Fprintf(os.Stderr, "Status failed: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Convert and validate chunk size
 	config.ChunkSize *= 1024 * 1024
 	if config.ChunkSize > MaxChunkSize {
-		fmt.Printf("Warning: chunk size %d MB exceeds maximum %d MB, using %d MB\n",
+		fmt.Fprintf(config.output(), "Warning: chunk size %d MB exceeds maximum %d MB, using %d MB\n",
 			config.ChunkSize/(1024*1024), MaxChunkSize/(1024*1024), MaxChunkSize/(1024*1024))
 		config.ChunkSize = MaxChunkSize
 	}
 	if config.ChunkSize < MinChunkSize {
-		fmt.Printf("Warning: chunk size %d bytes is too small, using %d KB\n",
+		fmt.Fprintf(config.output(), "Warning: chunk size %d bytes is too small, using %d KB\n",
 			config.ChunkSize, MinChunkSize/1024)
 		config.ChunkSize = MinChunkSize
 	}
 
+	defer watchSignals(&config)()
+
+	// Stream stdin with a deferred length
+	if config.FilePath == "-" {
+		if err := uploadStdin(config); err != nil {
+			exitUploadError(config, err)
+		}
+		return
+	}
+
 	// Upload file
-	err := uploadFile(config)
+	err = uploadFile(config)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Upload failed: %v\n", err)
-		os.Exit(1)
+		exitUploadError(config, err)
 	}
 }
 
@@ -157,9 +507,16 @@ func loadConfigFromEnv(config *Config) {
 		}
 	}
 
+	// Load rate limit from environment
+	if limitRate := os.Getenv("TUSC_LIMIT_RATE"); limitRate != "" {
+		config.LimitRate = limitRate
+	}
+
 	// Load headers from environment
 	if headersStr := os.Getenv("TUSC_HEADERS"); headersStr != "" {
-		config.Headers = make(map[string]string)
+		if config.Headers == nil {
+			config.Headers = make(map[string]string)
+		}
 		for _, header := range strings.Split(headersStr, ",") {
 			parts := strings.SplitN(strings.TrimSpace(header), ":", 2)
 			if len(parts) == 2 {
@@ -167,11 +524,107 @@ func loadConfigFromEnv(config *Config) {
 			}
 		}
 	}
+
+	// Load checksum algorithm from environment
+	if checksum := os.Getenv("TUSC_CHECKSUM"); checksum != "" {
+		config.Checksum = checksum
+	}
+
+	// Load retry policy from environment
+	if retriesStr := os.Getenv("TUSC_RETRIES"); retriesStr != "" {
+		if retries, err := strconv.Atoi(retriesStr); err == nil {
+			config.Retries = retries
+		}
+	}
+	for name, value := range map[string]*time.Duration{
+		"TUSC_RETRY_DELAY":     &config.RetryDelay,
+		"TUSC_RETRY_MAX_DELAY": &config.RetryMaxDelay,
+		"TUSC_RETRY_MAX_TIME":  &config.RetryMaxTime,
+	} {
+		if d, err := time.ParseDuration(os.Getenv(name)); err == nil {
+			*value = d
+		}
+	}
+	if budget := os.Getenv("TUSC_RETRY_BUDGET"); budget != "" {
+		config.RetryBudget = budget
+	}
+
+	// Load output format from environment
+	if output := os.Getenv("TUSC_OUTPUT"); output != "" {
+		config.Output = output
+	}
+
+	// Load TLS settings from environment
+	for name, value := range map[string]*string{
+		"TUSC_CACERT": &config.CACert,
+		"TUSC_CERT":   &config.Cert,
+		"TUSC_KEY":    &config.Key,
+	} {
+		if path := os.Getenv(name); path != "" {
+			*value = path
+		}
+	}
+	if insecure, err := strconv.ParseBool(os.Getenv("TUSC_INSECURE")); err == nil {
+		config.Insecure = insecure
+	}
+
+	// Load credentials from environment
+	for name, value := range map[string]*string{
+		"TUSC_BEARER_TOKEN":         &config.BearerToken,
+		"TUSC_USER":                 &config.User,
+		"TUSC_OAUTH2_TOKEN_URL":     &config.OAuth2TokenURL,
+		"TUSC_OAUTH2_CLIENT_ID":     &config.OAuth2ClientID,
+		"TUSC_OAUTH2_CLIENT_SECRET": &config.OAuth2ClientSecret,
+		"TUSC_OAUTH2_SCOPE":         &config.OAuth2Scope,
+		"TUSC_CREDENTIAL_HELPER":    &config.CredentialHelper,
+		"TUSC_SIGN":                 &config.Sign,
+		"TUSC_SIGN_KEY_ID":          &config.SignKeyID,
+		"TUSC_SIGN_SECRET":          &config.SignSecret,
+		"TUSC_SIGN_REGION":          &config.SignRegion,
+		"TUSC_SIGN_SERVICE":         &config.SignService,
+		"TUSC_NETRC_FILE":           &config.NetrcFile,
+	} {
+		if v := os.Getenv(name); v != "" {
+			*value = v
+		}
+	}
+	if netrc, err := strconv.ParseBool(os.Getenv("TUSC_NETRC")); err == nil {
+		config.Netrc = netrc
+	}
+	if keyFile := os.Getenv("TUSC_VAULT_KEY_FILE"); keyFile != "" {
+		config.VaultKeyFile = keyFile
+	}
+}
+
+// buildRetryPolicy builds the retry policy from the -retry* values
+func buildRetryPolicy(config Config) (*RetryPolicy, error) {
+	if config.Retries < 0 || config.RetryDelay < 0 || config.RetryMaxDelay < 0 || config.RetryMaxTime < 0 {
+		return nil, fmt.Errorf("retries and retry delays cannot be negative")
+	}
+
+	policy := &RetryPolicy{
+		Retries:    config.Retries,
+		BaseDelay:  config.RetryDelay,
+		MaxDelay:   config.RetryMaxDelay,
+		MaxElapsed: config.RetryMaxTime,
+		Budgets:    map[RetryAction]int{Resend: min(maxChecksumResends, config.Retries)},
+	}
+	if config.RetryBudget != "" {
+		budgets, err := parseRetryBudgets(config.RetryBudget)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -retry-budget: %v", err)
+		}
+		for action, n := range budgets {
+			policy.Budgets[action] = n
+		}
+	}
+	return policy, nil
 }
 
-func showServerOptions(endpoint string, headers map[string]string) {
-	fmt.Printf("➤ %s ☁\n", endpoint)
-	fmt.Println("⌄")
+func showServerOptions(config Config) {
+	endpoint, headers := config.TusdEndpoint, config.Headers
+	fmt.Fprintf(config.output(), "➤ %s ☁\n", endpoint)
+	fmt.Fprintln(config.output(), "⌄")
 
 	req, err := http.NewRequest("OPTIONS", endpoint, nil)
 	if err != nil {
@@ -183,7 +636,7 @@ func showServerOptions(endpoint string, headers map[string]string) {
 		req.Header.Set(key, value)
 	}
 
-	client := &http.Client{Timeout: 10 * time.Second}
+	client := authHTTPClient(signHTTPClient(&http.Client{Timeout: 10 * time.Second, Transport: defaultTransport(config)}, config.Signer), config.Auth)
 	resp, err := client.Do(req)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error querying server options: %v\n", err)
@@ -193,27 +646,29 @@ func showServerOptions(endpoint string, headers map[string]string) {
 
 	for name, values := range resp.Header {
 		if strings.HasPrefix(strings.ToLower(name), "tus-") {
-			fmt.Printf("➤ %s\n", name)
+			fmt.Fprintf(config.output(), "➤ %s\n", name)
 			for _, value := range values {
 				if strings.Contains(value, ",") {
 					for _, item := range strings.Split(value, ",") {
-						fmt.Printf("↳ %s\n", strings.TrimSpace(item))
+						fmt.Fprintf(config.output(), "↳ %s\n", strings.TrimSpace(item))
 					}
 				} else {
-					fmt.Printf("↳ %s\n", value)
+					fmt.Fprintf(config.output(), "↳ %s\n", value)
 				}
 			}
 		}
 	}
 }
 
-func getStateFile(filePath string) string {
-	// Include process ID to avoid conflicts between concurrent processes
-	pid := os.Getpid()
-	hash := calculateFileHash(filePath)
-	return fmt.Sprintf(".tusc_state_%s_%d.json", hash, pid)
+// getStateFile returns the state file of the upload with key, see
+// calculateFileHash
+func getStateFile(key string) string {
+	return filepath.Join(stateDir(), fmt.Sprintf(".tusc_state_%s.json", key))
 }
 
+// calculateFileHash returns the key of filePath's upload, which names its
+// state and lock files. Files with the same content share it, wherever they
+// are. Hashing may read the whole file, so it is computed once per upload.
 func calculateFileHash(filePath string) string {
 	// Get file info for intelligent hash strategy
 	fileInfo, err := os.Stat(filePath)
@@ -244,8 +699,12 @@ func calculateFileHash(filePath string) string {
 		fallthrough // If header hash fails, use metadata
 
 	default: // > 500MB: Use path + metadata for performance
-		// Combine path, size, and modification time
-		data := fmt.Sprintf("%s|%d|%d", filePath, fileSize, fileInfo.ModTime().Unix())
+		// Combine absolute path, size, and modification time
+		absPath, err := filepath.Abs(filePath)
+		if err != nil {
+			absPath = filePath
+		}
+		data := fmt.Sprintf("%s|%d|%d", absPath, fileSize, fileInfo.ModTime().Unix())
 		hash := md5.Sum([]byte(data))
 		return fmt.Sprintf("meta_%x", hash)
 	}
@@ -289,178 +748,98 @@ func calculateFileHeaderSHA1(filePath string, headerSize int64) ([20]byte, error
 	return result, nil
 }
 
-func loadState(filePath string) (*UploadState, error) {
-	// First try to load our own state file
-	stateFile := getStateFile(filePath)
-	data, err := os.ReadFile(stateFile)
-	if err == nil {
-		var state UploadState
-		err = json.Unmarshal(data, &state)
-		if err == nil {
-			return &state, nil
-		}
-	}
-
-	// If our state file doesn't exist, check for other processes' state files
-	// This helps with resuming uploads started by other processes
-	return findCompatibleState(filePath)
+func loadState(key string) (*UploadState, error) {
+	return readStateFile(getStateFile(key))
 }
 
-func findCompatibleState(filePath string) (*UploadState, error) {
-	// Try to find state files with any hash strategy for this file
-	baseHash := calculateFileHash(filePath)
-	pattern := fmt.Sprintf(".tusc_state_%s_*.json", baseHash)
-
-	matches, err := filepath.Glob(pattern)
-	if err != nil || len(matches) == 0 {
-		// Also try legacy path-based hash for backward compatibility
-		legacyHash := md5.Sum([]byte(filePath))
-		legacyPattern := fmt.Sprintf(".tusc_state_%x_*.json", legacyHash)
-		matches, err = filepath.Glob(legacyPattern)
-		if err != nil || len(matches) == 0 {
-			return nil, fmt.Errorf("no state file found")
-		}
-	}
-
-	// Find the most recent compatible state file
-	var newestState *UploadState
-	var newestTime int64
-
-	for _, stateFile := range matches {
-		data, err := os.ReadFile(stateFile)
-		if err != nil {
-			continue
-		}
-
-		var state UploadState
-		err = json.Unmarshal(data, &state)
-		if err != nil {
-			continue
-		}
-
-		// Check if state is compatible (same file size)
-		fileInfo, err := os.Stat(filePath)
-		if err != nil {
-			continue
-		}
-
-		if state.FileSize == fileInfo.Size() && state.Timestamp > newestTime {
-			newestState = &state
-			newestTime = state.Timestamp
-		}
-	}
-
-	if newestState == nil {
-		return nil, fmt.Errorf("no compatible state file found")
+// legacyStateFiles returns the state files of filePath's upload written by
+// versions that named them by key and PID, or by path and PID before that
+func legacyStateFiles(key, filePath string) []string {
+	var files []string
+	for _, pattern := range []string{
+		fmt.Sprintf(".tusc_state_%s_*.json", key),
+		fmt.Sprintf(".tusc_state_%x_*.json", md5.Sum([]byte(filePath))),
+	} {
+		files = append(files, globStateFiles(pattern)...)
 	}
-
-	return newestState, nil
+	return files
 }
 
-func checkConcurrentUploads(filePath string) error {
-	hash := md5.Sum([]byte(filePath))
-	pattern := fmt.Sprintf(".tusc_state_%x_*.json", hash)
-
-	matches, err := filepath.Glob(pattern)
-	if err != nil {
-		return nil
+// migrateLegacyStates moves the state of filePath's upload from its legacy
+// state files to the state file of key. Only the one furthest along is kept.
+// The caller holds the lock of key, so none of them belongs to a running
+// upload.
+func migrateLegacyStates(out io.Writer, key, filePath string) {
+	legacy := legacyStateFiles(key, filePath)
+	if len(legacy) == 0 {
+		return
 	}
 
-	currentPid := os.Getpid()
-	now := time.Now().Unix()
-	activeUploads := 0
-
-	for _, stateFile := range matches {
-		// Extract PID from filename
-		parts := strings.Split(stateFile, "_")
-		if len(parts) < 3 {
-			continue
-		}
-		pidStr := strings.TrimSuffix(parts[len(parts)-1], ".json")
-		pid, err := strconv.Atoi(pidStr)
-		if err != nil {
-			continue
-		}
-
-		// Skip our own process
-		if pid == currentPid {
-			continue
+	if _, err := os.Stat(getStateFile(key)); os.IsNotExist(err) {
+		var furthest *UploadState
+		for _, stateFile := range legacy {
+			if state, err := readStateFile(stateFile); err == nil && (furthest == nil || state.Offset > furthest.Offset) {
+				furthest = state
+			}
 		}
-
-		// Check if the process is still running and file is recent
-		if info, err := os.Stat(stateFile); err == nil {
-			// Consider files modified in the last 5 minutes as active
-			if now-info.ModTime().Unix() < 300 {
-				if isProcessRunning(pid) {
-					activeUploads++
-				} else {
-					// Clean up stale state file from dead process
-					os.Remove(stateFile)
-				}
+		if furthest != nil {
+			if err := saveState(key, furthest); err != nil {
+				fmt.Fprintf(out, "Warning: failed to migrate state: %v\n", err)
+				return
 			}
 		}
 	}
 
-	if activeUploads > 0 {
-		return fmt.Errorf("detected %d other active upload(s) for this file", activeUploads)
+	for _, stateFile := range legacy {
+		removeStateFile(stateFile)
 	}
-
-	return nil
 }
 
-func isProcessRunning(pid int) bool {
-	// Check if process exists by trying to send signal 0
-	process, err := os.FindProcess(pid)
+// newUploadState returns the state of config's upload at offset, with its
+// sensitive headers sealed by the vault
+func newUploadState(config Config, uploadURL string, offset, fileSize int64) (*UploadState, error) {
+	sealed, err := config.Vault.Seal(config.Headers)
 	if err != nil {
-		return false
+		return nil, err
 	}
-
-	// On Unix systems, Signal(0) can be used to check if process exists
-	err = process.Signal(os.Signal(nil))
-	return err == nil
+	return &UploadState{
+		URL:       uploadURL,
+		Offset:    offset,
+		FileSize:  fileSize,
+		Endpoint:  config.TusdEndpoint,
+		ChunkSize: config.ChunkSize,
+		Headers:   config.Headers,
+		Vault:     sealed,
+	}, nil
 }
 
-func saveState(filePath string, state *UploadState) error {
-	stateFile := getStateFile(filePath)
+func saveState(key string, state *UploadState) error {
+	stateFile := getStateFile(key)
 	state.Timestamp = time.Now().Unix()
+	state.Version = StateVersion
+	state.Headers, _ = splitHeaders(state.Headers)
+	replayJournal(stateFile, state)
 
 	data, err := json.MarshalIndent(state, "", "  ")
 	if err != nil {
 		return err
 	}
 
-	return os.WriteFile(stateFile, data, 0644)
-}
-
-func clearState(filePath string) {
-	// Clear our own state file
-	stateFile := getStateFile(filePath)
-	os.Remove(stateFile)
+	if err := os.MkdirAll(filepath.Dir(stateFile), 0700); err != nil {
+		return err
+	}
 
-	// Also clean up old state files from other processes for this file
-	// Only clean files older than 1 hour to avoid interfering with active uploads
-	baseHash := calculateFileHash(filePath)
-	patterns := []string{
-		fmt.Sprintf(".tusc_state_%s_*.json", baseHash),                  // New hash format
-		fmt.Sprintf(".tusc_state_%x_*.json", md5.Sum([]byte(filePath))), // Legacy format
+	if err := writeFileAtomic(stateFile, data, 0600); err != nil {
+		return err
 	}
 
-	for _, pattern := range patterns {
-		matches, err := filepath.Glob(pattern)
-		if err != nil {
-			continue
-		}
+	// Every journaled offset is in the state file now
+	os.Remove(getJournalFile(stateFile))
+	return nil
+}
 
-		now := time.Now().Unix()
-		for _, file := range matches {
-			if info, err := os.Stat(file); err == nil {
-				// Remove files older than 1 hour
-				if now-info.ModTime().Unix() > 3600 {
-					os.Remove(file)
-				}
-			}
-		}
-	}
+func clearState(key string) {
+	removeStateFile(getStateFile(key))
 }
 
 func calculateTimeout(chunkSize, fileSize int64) time.Duration {
@@ -490,79 +869,173 @@ func uploadFile(config Config) error {
 		return fmt.Errorf("file not found: %s", config.FilePath)
 	}
 
-	fmt.Printf("total: file size %s, chunk size %s\n",
+	fmt.Fprintf(config.output(), "total: file size %s, chunk size %s\n",
 		formatBytes(fileInfo.Size()), formatBytes(config.ChunkSize))
 
 	timeout := calculateTimeout(config.ChunkSize, fileInfo.Size())
+	if config.RateLimit != nil {
+		timeout += limitedTransferTime(config.RateLimit, config.ChunkSize)
+	}
 
-	client := &http.Client{
+	client := authHTTPClient(signHTTPClient(limitHTTPClient(&http.Client{
 		Timeout: timeout,
 		Transport: &http.Transport{
 			MaxIdleConns:        10,
 			MaxIdleConnsPerHost: 2,
 			IdleConnTimeout:     90 * time.Second,
+			TLSClientConfig:     config.TLS,
 		},
-	}
+	}, config.RateLimit), config.Signer), config.Auth)
 
 	var uploadURL string
 	var offset int64
 
-	if config.Reset {
-		clearState(config.FilePath)
+	// One OPTIONS request serves checksum negotiation and creation-with-upload
+	options, optionsErr := serverOptions(client, config.TusdEndpoint, config.Headers)
+
+	if config.Checksum != "" {
+		wanted, err := parseChecksumAlgorithms(config.Checksum)
+		if err != nil {
+			return err
+		}
+
+		if optionsErr == nil {
+			config.ChecksumAlgorithm = negotiateChecksum(options, wanted)
+		}
+		switch {
+		case optionsErr != nil:
+			fmt.Fprintf(config.output(), "Warning: failed to query checksum support, uploading without checksums: %v\n", optionsErr)
+		case config.ChecksumAlgorithm == "":
+			fmt.Fprintf(config.output(), "Warning: server supports none of the requested checksum algorithms, uploading without checksums\n")
+		default:
+			fmt.Fprintf(config.output(), "checksum: %s\n", config.ChecksumAlgorithm)
+		}
 	}
 
-	// Check for concurrent uploads before proceeding
-	if err := checkConcurrentUploads(config.FilePath); err != nil {
-		fmt.Printf("Warning: %v\n", err)
+	// Only one process may work on a file's upload at a time
+	config.stateKey = calculateFileHash(config.FilePath)
+	lock, err := lockUpload(config, config.stateKey, config.FilePath)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	migrateLegacyStates(config.output(), config.stateKey, config.FilePath)
+	if config.Reset {
+		clearState(config.stateKey)
 	}
 
-	state, err := loadState(config.FilePath)
+	state, err := loadState(config.stateKey)
 	if err == nil && state.FileSize == fileInfo.Size() && state.Endpoint == config.TusdEndpoint {
 		uploadURL = state.URL
 		offset = state.Offset
-		fmt.Printf("Resuming upload from offset %s\n", formatBytes(offset))
-
-		currentOffset, err := getUploadOffset(client, uploadURL, config.Headers)
+		fmt.Fprintf(config.output(), "Resuming upload from offset %s\n", formatBytes(offset))
+		config.restoreHeaders(state)
+
+		var currentOffset int64
+		err := withRetry(config, func() (err error) {
+			currentOffset, err = getUploadOffset(client, uploadURL, config.Headers)
+			return err
+		})
+		if err != nil && retryAction(err) != Recreate {
+			// The upload may well be there, starting over would lose it
+			return fmt.Errorf("failed to check upload offset: %w", err)
+		}
 		if err != nil {
-			fmt.Printf("Upload URL no longer valid, creating new upload\n")
+			fmt.Fprintf(config.output(), "Upload URL no longer valid, creating new upload\n")
 			uploadURL = ""
 			offset = 0
 		} else {
 			offset = currentOffset
-			fmt.Printf("Server confirmed offset: %s\n", formatBytes(offset))
+			fmt.Fprintf(config.output(), "Server confirmed offset: %s\n", formatBytes(offset))
+			config.emit(Event{Event: "resumed", UploadURL: uploadURL, Offset: offset, Size: fileInfo.Size()})
+
+			state.Offset = offset
+			if err := saveState(config.stateKey, state); err != nil {
+				fmt.Fprintf(config.output(), "Warning: failed to save state: %v\n", err)
+			}
 		}
 	}
 
 	if uploadURL == "" {
-		uploadURL, err = createUpload(client, config.TusdEndpoint, fileInfo.Size(), filepath.Base(config.FilePath), config.Headers)
+		uploadURL, offset, err = createFileUpload(client, config, options, fileInfo.Size())
 		if err != nil {
-			return fmt.Errorf("failed to create upload: %v", err)
+			return err
 		}
-		offset = 0
-		fmt.Printf("Created new upload: %s\n", uploadURL)
+	}
+
+	err = uploadFileInChunks(client, config, uploadURL, offset, fileInfo.Size())
+	if retryAction(err) == Recreate {
+		// The server expired or deleted the upload, its data is lost
+		fmt.Fprintf(config.output(), "\nUpload no longer exists on the server, creating new upload\n")
+		clearState(config.stateKey)
 
-		// Save initial state for new uploads
-		initialState := &UploadState{
-			URL:       uploadURL,
-			Offset:    offset,
-			FileSize:  fileInfo.Size(),
-			Endpoint:  config.TusdEndpoint,
-			ChunkSize: config.ChunkSize,
-			Headers:   config.Headers,
+		uploadURL, offset, err = createFileUpload(client, config, options, fileInfo.Size())
+		if err != nil {
+			return err
 		}
-		if err := saveState(config.FilePath, initialState); err != nil {
-			fmt.Printf("Warning: failed to save initial state: %v\n", err)
+		err = uploadFileInChunks(client, config, uploadURL, offset, fileInfo.Size())
+	}
+	return err
+}
+
+// createFileUpload creates a new upload for config.FilePath and saves its
+// state. It returns the upload URL and how much the creation request sent.
+func createFileUpload(client *http.Client, config Config, options http.Header, fileSize int64) (uploadURL string, offset int64, err error) {
+	// Send the first chunk with the creation request to save a round trip
+	withUpload := fileSize > 0 && headerListContains(options.Get("Tus-Extension"), "creation-with-upload")
+	if withUpload {
+		err = withRetry(config, func() (err error) {
+			uploadURL, offset, err = createUploadWithFirstChunk(client, config, fileSize)
+			return err
+		})
+		if errors.Is(err, ErrChecksumMismatch) {
+			fmt.Fprintf(config.output(), "Checksum mismatch on creation, creating an empty upload instead\n")
+			withUpload = false
 		}
 	}
+	if !withUpload {
+		err = withRetry(config, func() (err error) {
+			uploadURL, err = createUpload(client, config.TusdEndpoint, fileSize, config.uploadMetadata(config.FilePath), config.Headers)
+			return err
+		})
+	}
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to create upload: %w", err)
+	}
 
-	return uploadFileInChunks(client, config, uploadURL, offset, fileInfo.Size())
+	if offset > 0 {
+		fmt.Fprintf(config.output(), "Created new upload with first %s: %s\n", formatBytes(offset), uploadURL)
+	} else {
+		fmt.Fprintf(config.output(), "Created new upload: %s\n", uploadURL)
+	}
+	config.emit(Event{
+		Event:     "created",
+		UploadURL: uploadURL,
+		Offset:    offset,
+		Size:      fileSize,
+		Metadata:  config.uploadMetadata(config.FilePath),
+	})
+
+	// Save initial state for new uploads
+	initialState, err := newUploadState(config, uploadURL, offset, fileSize)
+	if err == nil {
+		err = saveState(config.stateKey, initialState)
+	}
+	if err != nil {
+		fmt.Fprintf(config.output(), "Warning: failed to save initial state: %v\n", err)
+	}
+
+	return uploadURL, offset, nil
 }
 
 func uploadFileInChunks(client *http.Client, config Config, uploadURL string, startOffset, fileSize int64) error {
 	offset := startOffset
 	buffer := make([]byte, config.ChunkSize)
-	lastProgressTime := time.Now()
+	start := time.Now()
+	lastProgressTime := start
 	var lastOffset int64 = startOffset
+	stateFile := getStateFile(config.stateKey)
 
 	for offset < fileSize {
 		file, err := os.Open(config.FilePath)
@@ -587,39 +1060,34 @@ func uploadFileInChunks(client *http.Client, config Config, uploadURL string, st
 			break
 		}
 
-		const maxRetries = 5
-		var uploadErr error
-		for retry := 0; retry < maxRetries; retry++ {
-			uploadErr = uploadChunk(client, uploadURL, buffer[:n], offset, config.Headers)
-			if uploadErr == nil {
-				break
+		uploadErr := sendChunk(client, config, uploadURL, buffer[:n], offset, config.Headers)
+		if uploadErr != nil {
+			state, err := newUploadState(config, uploadURL, offset, fileSize)
+			if err != nil {
+				fmt.Fprintf(config.output(), "Warning: failed to save state: %v\n", err)
+				return uploadErr
 			}
 
-			fmt.Printf("Retry %d/%d for chunk at offset %s: %v\n",
-				retry+1, maxRetries, formatBytes(offset), uploadErr)
-
-			backoffTime := time.Duration(1<<retry) * time.Second
-			if backoffTime > 30*time.Second {
-				backoffTime = 30 * time.Second
+			// Part of the cancelled chunk may have arrived
+			interrupted := errors.Is(uploadErr, errInterrupted)
+			if interrupted {
+				if serverOffset, err := confirmedOffset(client, uploadURL, config.Headers); err == nil && serverOffset > offset {
+					state.Offset = serverOffset
+				}
 			}
-			time.Sleep(backoffTime)
-		}
 
-		if uploadErr != nil {
-			state := &UploadState{
-				URL:       uploadURL,
-				Offset:    offset,
-				FileSize:  fileSize,
-				Endpoint:  config.TusdEndpoint,
-				ChunkSize: config.ChunkSize,
-				Headers:   config.Headers,
+			saveState(config.stateKey, state)
+			if interrupted {
+				fmt.Fprintf(config.output(), "Saved offset %s of %s, run the same command again to resume\n",
+					formatBytes(state.Offset), formatBytes(fileSize))
 			}
-			saveState(config.FilePath, state)
-			return fmt.Errorf("failed to upload chunk at offset %s after %d retries: %v",
-				formatBytes(offset), maxRetries, uploadErr)
+			return uploadErr
 		}
 
 		offset += int64(n)
+		if err := appendJournal(stateFile, uploadURL, offset); err != nil {
+			fmt.Fprintf(config.output(), "Warning: failed to journal offset: %v\n", err)
+		}
 
 		now := time.Now()
 		if now.Sub(lastProgressTime) >= time.Second {
@@ -627,12 +1095,16 @@ func uploadFileInChunks(client *http.Client, config Config, uploadURL string, st
 			bytesUploaded := offset - lastOffset
 			speed := float64(bytesUploaded) / now.Sub(lastProgressTime).Seconds()
 
-			fmt.Printf("\rUploading: %.2f%% (%s/%s) at %s/s",
-				percentage,
-				formatBytes(offset),
-				formatBytes(fileSize),
-				formatBytes(int64(speed)),
-			)
+			if config.events != nil {
+				config.emit(Event{Event: "progress", UploadURL: uploadURL, Offset: offset, Size: fileSize, Rate: int64(speed)})
+			} else {
+				fmt.Fprintf(config.output(), "\rUploading: %.2f%% (%s/%s) at %s/s",
+					percentage,
+					formatBytes(offset),
+					formatBytes(fileSize),
+					formatBytes(int64(speed)),
+				)
+			}
 
 			lastProgressTime = now
 			lastOffset = offset
@@ -650,29 +1122,35 @@ func uploadFileInChunks(client *http.Client, config Config, uploadURL string, st
 
 		// Save state at regular intervals
 		if (offset-startOffset)%saveInterval < config.ChunkSize && offset > startOffset {
-			state := &UploadState{
-				URL:       uploadURL,
-				Offset:    offset,
-				FileSize:  fileSize,
-				Endpoint:  config.TusdEndpoint,
-				ChunkSize: config.ChunkSize,
-				Headers:   config.Headers,
+			state, err := newUploadState(config, uploadURL, offset, fileSize)
+			if err == nil {
+				err = saveState(config.stateKey, state)
 			}
-			if err := saveState(config.FilePath, state); err != nil {
-				fmt.Printf("Warning: failed to save state: %v\n", err)
+			if err != nil {
+				fmt.Fprintf(config.output(), "Warning: failed to save state: %v\n", err)
 			}
 		}
 	}
 
-	clearState(config.FilePath)
+	clearState(config.stateKey)
+
+	fmt.Fprintln(config.output(), "\n➤ All parts uploaded 🐈")
+	fmt.Fprintf(config.output(), "↳ %s\n", uploadURL)
 
-	fmt.Println("\n➤ All parts uploaded 🐈")
-	fmt.Printf("↳ %s\n", uploadURL)
+	config.emit(Event{
+		Event:      "completed",
+		UploadURL:  uploadURL,
+		Size:       fileSize,
+		DurationMs: time.Since(start).Milliseconds(),
+		Metadata:   config.uploadMetadata(config.FilePath),
+	})
 
 	return nil
 }
 
-func createUpload(client *http.Client, endpoint string, fileSize int64, filename string, headers map[string]string) (string, error) {
+// createUpload creates an empty upload. A fileSize of deferredLength creates
+// an upload whose length is sent with a later PATCH.
+func createUpload(client *http.Client, endpoint string, fileSize int64, metadata map[string]string, headers map[string]string) (string, error) {
 	req, err := http.NewRequest("POST", endpoint, nil)
 	if err != nil {
 		return "", err
@@ -680,8 +1158,12 @@ func createUpload(client *http.Client, endpoint string, fileSize int64, filename
 
 	req.Header.Set("Tus-Resumable", "1.0.0")
 	req.Header.Set("Content-Length", "0")
-	req.Header.Set("Upload-Length", strconv.FormatInt(fileSize, 10))
-	req.Header.Set("Upload-Metadata", "name "+encodeBase64(filename))
+	if fileSize == deferredLength {
+		req.Header.Set("Upload-Defer-Length", "1")
+	} else {
+		req.Header.Set("Upload-Length", strconv.FormatInt(fileSize, 10))
+	}
+	req.Header.Set("Upload-Metadata", encodeMetadata(metadata))
 
 	for key, value := range headers {
 		req.Header.Set(key, value)
@@ -694,7 +1176,7 @@ func createUpload(client *http.Client, endpoint string, fileSize int64, filename
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
-		return "", fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return "", newHTTPError(PhaseCreate, resp, nil)
 	}
 
 	location := resp.Header.Get("Location")
@@ -706,41 +1188,81 @@ func createUpload(client *http.Client, endpoint string, fileSize int64, filename
 }
 
 func getUploadOffset(client *http.Client, uploadURL string, headers map[string]string) (int64, error) {
-	req, err := http.NewRequest("HEAD", uploadURL, nil)
+	info, err := getUploadInfo(client, uploadURL, headers)
 	if err != nil {
 		return 0, err
 	}
+	return info.Offset, nil
+}
 
-	req.Header.Set("Tus-Resumable", "1.0.0")
-	for key, value := range headers {
-		req.Header.Set(key, value)
-	}
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return 0, err
+// sendChunk uploads one chunk following the retry policy. Transient errors
+// are retried with backoff, a damaged chunk is resent and an offset conflict
+// is resolved by asking the server for its offset. Other errors, including a
+// gone upload, are returned.
+func sendChunk(client *http.Client, config Config, uploadURL string, data []byte, offset int64, headers map[string]string) error {
+	// SIGUSR1 holds the upload between chunks
+	ctx := config.runContext()
+	if config.pause.Wait(ctx) != nil {
+		return errInterrupted
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return 0, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
-	}
+	retrier := newRetrier(config.Retry)
+	for {
+		uploadErr := uploadChunk(ctx, client, uploadURL, data, offset, headers, config.ChecksumAlgorithm)
+		if uploadErr == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return errInterrupted
+		}
 
-	offsetStr := resp.Header.Get("Upload-Offset")
-	if offsetStr == "" {
-		return 0, fmt.Errorf("no Upload-Offset header in response")
-	}
+		action := retryAction(uploadErr)
+		delay, ok := retrier.Next(uploadErr)
+		if !ok {
+			if action == FailFast || action == Recreate {
+				return uploadErr
+			}
+			return fmt.Errorf("failed to upload chunk at offset %s after %d retries: %w",
+				formatBytes(offset), retrier.Attempts(), uploadErr)
+		}
 
-	offset, err := strconv.ParseInt(offsetStr, 10, 64)
-	if err != nil {
-		return 0, fmt.Errorf("invalid Upload-Offset header: %s", offsetStr)
+		switch action {
+		case Resend:
+			config.emitRetry(retrier.Attempts(), 0, uploadErr)
+			fmt.Fprintf(config.output(), "Checksum mismatch for chunk at offset %s, resending (%d)\n",
+				formatBytes(offset), retrier.Attempts())
+		case Resync:
+			config.emitRetry(retrier.Attempts(), 0, uploadErr)
+			// A lost response may have hidden that (part of) the chunk arrived
+			serverOffset, err := getUploadOffset(client, uploadURL, headers)
+			if err != nil {
+				return fmt.Errorf("failed to re-sync offset: %w", err)
+			}
+			end := offset + int64(len(data))
+			if serverOffset < offset || serverOffset > end {
+				return fmt.Errorf("server offset %s is outside the chunk at %s: %w",
+					formatBytes(serverOffset), formatBytes(offset), uploadErr)
+			}
+			if serverOffset == end {
+				return nil
+			}
+			fmt.Fprintf(config.output(), "Offset conflict for chunk at offset %s, continuing from %s\n",
+				formatBytes(offset), formatBytes(serverOffset))
+			data = data[serverOffset-offset:]
+			offset = serverOffset
+		default:
+			config.emitRetry(retrier.Attempts(), delay, uploadErr)
+			fmt.Fprintf(config.output(), "Retry %d for chunk at offset %s in %v: %v\n",
+				retrier.Attempts(), formatBytes(offset), delay.Round(time.Millisecond), uploadErr)
+			if sleepContext(ctx, delay) != nil {
+				return errInterrupted
+			}
+		}
 	}
-
-	return offset, nil
 }
 
-func uploadChunk(client *http.Client, uploadURL string, data []byte, offset int64, headers map[string]string) error {
-	req, err := http.NewRequest("PATCH", uploadURL, bytes.NewReader(data))
+func uploadChunk(ctx context.Context, client *http.Client, uploadURL string, data []byte, offset int64, headers map[string]string, checksumAlgorithm string) error {
+	req, err := http.NewRequestWithContext(ctx, "PATCH", uploadURL, bytes.NewReader(data))
 	if err != nil {
 		return err
 	}
@@ -749,6 +1271,9 @@ func uploadChunk(client *http.Client, uploadURL string, data []byte, offset int6
 	req.Header.Set("Content-Type", "application/offset+octet-stream")
 	req.Header.Set("Content-Length", strconv.Itoa(len(data)))
 	req.Header.Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	if checksumAlgorithm != "" {
+		req.Header.Set("Upload-Checksum", checksumHeader(checksumAlgorithm, data))
+	}
 
 	for key, value := range headers {
 		req.Header.Set(key, value)
@@ -760,13 +1285,27 @@ func uploadChunk(client *http.Client, uploadURL string, data []byte, offset int6
 	}
 	defer resp.Body.Close()
 
+	// Non-standard HTTP code '460 Checksum Mismatch'
+	if resp.StatusCode == 460 {
+		return newHTTPError(PhasePatch, resp, ErrChecksumMismatch)
+	}
+
 	if resp.StatusCode != http.StatusNoContent {
-		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return newHTTPError(PhasePatch, resp, nil)
 	}
 
 	return nil
 }
 
+// encodeMetadata encodes metadata as an Upload-Metadata header, sorted by key
+func encodeMetadata(metadata map[string]string) string {
+	pairs := make([]string, 0, len(metadata))
+	for _, key := range slices.Sorted(maps.Keys(metadata)) {
+		pairs = append(pairs, key+" "+encodeBase64(metadata[key]))
+	}
+	return strings.Join(pairs, ",")
+}
+
 func encodeBase64(s string) string {
 	const chars = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
 	var result strings.Builder
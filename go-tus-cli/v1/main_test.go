@@ -15,11 +15,46 @@ import (
 	"time"
 )
 
+// TestMain keeps the state files written by tests out of the user's state directory
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "tusc-state-*")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to create state directory: %v\n", err)
+		os.Exit(1)
+	}
+	os.Setenv("XDG_STATE_HOME", dir)
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
 // MockTUSServer creates a mock TUS server for testing
 type MockTUSServer struct {
 	server      *httptest.Server
 	uploads     map[string]*MockUpload
 	uploadCount int
+
+	// ChecksumAlgorithms is advertised in Tus-Checksum-Algorithm
+	ChecksumAlgorithms string
+	// CorruptPatches makes the next N PATCH bodies arrive damaged
+	CorruptPatches int
+	// ChecksumHeaders records the Upload-Checksum header of every PATCH
+	ChecksumHeaders []string
+	// CreationWithUpload advertises and accepts data in the creation request
+	CreationWithUpload bool
+	// Patches counts the PATCH requests
+	Patches int
+	// PatchStatuses answers the next PATCH requests with these statuses
+	// without storing anything
+	PatchStatuses []int
+	// Patched, if set, is called with the new offset after every stored PATCH
+	Patched func(offset int64)
+	// Token, if set, rejects PATCH requests without it as bearer token with 401
+	Token string
+	// Verify, if set, rejects requests it returns an error for with 403, like
+	// a gateway checking signatures
+	Verify func(r *http.Request) error
 }
 
 type MockUpload struct {
@@ -28,18 +63,29 @@ type MockUpload struct {
 	Offset   int64
 	Data     []byte
 	Metadata map[string]string
+	// RawMetadata is the Upload-Metadata header sent on creation
+	RawMetadata string
 }
 
 func NewMockTUSServer() *MockTUSServer {
 	mock := &MockTUSServer{
-		uploads: make(map[string]*MockUpload),
+		uploads:            make(map[string]*MockUpload),
+		ChecksumAlgorithms: "sha1,md5,crc32,sha256",
 	}
 
 	handler := http.NewServeMux()
 	handler.HandleFunc("/files/", mock.handleUpload)
 	handler.HandleFunc("/files", mock.handleCreate)
 
-	mock.server = httptest.NewServer(handler)
+	mock.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if mock.Verify != nil {
+			if err := mock.Verify(r); err != nil {
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			}
+		}
+		handler.ServeHTTP(w, r)
+	}))
 	return mock
 }
 
@@ -55,7 +101,12 @@ func (m *MockTUSServer) handleCreate(w http.ResponseWriter, r *http.Request) {
 	if r.Method == "OPTIONS" {
 		w.Header().Set("Tus-Resumable", "1.0.0")
 		w.Header().Set("Tus-Version", "1.0.0")
-		w.Header().Set("Tus-Extension", "creation,termination")
+		extensions := "creation,creation-defer-length,termination,checksum"
+		if m.CreationWithUpload {
+			extensions += ",creation-with-upload"
+		}
+		w.Header().Set("Tus-Extension", extensions)
+		w.Header().Set("Tus-Checksum-Algorithm", m.ChecksumAlgorithms)
 		w.WriteHeader(http.StatusOK)
 		return
 	}
@@ -65,16 +116,21 @@ func (m *MockTUSServer) handleCreate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	uploadLengthStr := r.Header.Get("Upload-Length")
-	if uploadLengthStr == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		return
-	}
+	// Deferred uploads keep Size at -1 until a PATCH sends Upload-Length
+	uploadLength := int64(-1)
+	if r.Header.Get("Upload-Defer-Length") != "1" {
+		uploadLengthStr := r.Header.Get("Upload-Length")
+		if uploadLengthStr == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
 
-	uploadLength, err := strconv.ParseInt(uploadLengthStr, 10, 64)
-	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		return
+		var err error
+		uploadLength, err = strconv.ParseInt(uploadLengthStr, 10, 64)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
 	}
 
 	m.uploadCount++
@@ -84,8 +140,30 @@ func (m *MockTUSServer) handleCreate(w http.ResponseWriter, r *http.Request) {
 		ID:       uploadID,
 		Size:     uploadLength,
 		Offset:   0,
-		Data:     make([]byte, uploadLength),
+		Data:     make([]byte, max(uploadLength, 0)),
 		Metadata: make(map[string]string),
+
+		RawMetadata: r.Header.Get("Upload-Metadata"),
+	}
+
+	if m.CreationWithUpload && r.Header.Get("Content-Type") == "application/offset+octet-stream" {
+		data, err := io.ReadAll(r.Body)
+		if err != nil || int64(len(data)) > uploadLength {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		if header := r.Header.Get("Upload-Checksum"); header != "" {
+			parts := strings.SplitN(header, " ", 2)
+			if len(parts) != 2 || checksumHeader(parts[0], data) != header {
+				w.WriteHeader(460)
+				return
+			}
+		}
+
+		copy(upload.Data, data)
+		upload.Offset = int64(len(data))
+		w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
 	}
 
 	m.uploads[uploadID] = upload
@@ -107,10 +185,30 @@ func (m *MockTUSServer) handleUpload(w http.ResponseWriter, r *http.Request) {
 	case "HEAD":
 		w.Header().Set("Tus-Resumable", "1.0.0")
 		w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
-		w.Header().Set("Upload-Length", strconv.FormatInt(upload.Size, 10))
+		if upload.Size < 0 {
+			w.Header().Set("Upload-Defer-Length", "1")
+		} else {
+			w.Header().Set("Upload-Length", strconv.FormatInt(upload.Size, 10))
+		}
+		if upload.RawMetadata != "" {
+			w.Header().Set("Upload-Metadata", upload.RawMetadata)
+		}
 		w.WriteHeader(http.StatusOK)
 
 	case "PATCH":
+		m.Patches++
+		if m.Token != "" && r.Header.Get("Authorization") != "Bearer "+m.Token {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if len(m.PatchStatuses) > 0 {
+			status := m.PatchStatuses[0]
+			m.PatchStatuses = m.PatchStatuses[1:]
+			w.WriteHeader(status)
+			fmt.Fprintf(w, "injected %d", status)
+			return
+		}
+
 		offsetStr := r.Header.Get("Upload-Offset")
 		if offsetStr == "" {
 			w.WriteHeader(http.StatusBadRequest)
@@ -123,24 +221,53 @@ func (m *MockTUSServer) handleUpload(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		if length := r.Header.Get("Upload-Length"); length != "" && upload.Size < 0 {
+			upload.Size, _ = strconv.ParseInt(length, 10, 64)
+		}
+
 		data, err := io.ReadAll(r.Body)
 		if err != nil {
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
 
-		if offset+int64(len(data)) > upload.Size {
+		end := offset + int64(len(data))
+		if upload.Size >= 0 && end > upload.Size {
 			w.WriteHeader(http.StatusRequestEntityTooLarge)
 			return
 		}
+		if end > int64(len(upload.Data)) {
+			upload.Data = append(upload.Data, make([]byte, end-int64(len(upload.Data)))...)
+		}
+
+		if m.CorruptPatches > 0 && len(data) > 0 {
+			m.CorruptPatches--
+			data[0] ^= 0xff
+		}
+
+		if header := r.Header.Get("Upload-Checksum"); header != "" {
+			m.ChecksumHeaders = append(m.ChecksumHeaders, header)
+			parts := strings.SplitN(header, " ", 2)
+			if len(parts) != 2 || checksumHeader(parts[0], data) != header {
+				w.WriteHeader(460)
+				return
+			}
+		}
 
 		copy(upload.Data[offset:], data)
 		upload.Offset += int64(len(data))
+		if m.Patched != nil {
+			m.Patched(upload.Offset)
+		}
 
 		w.Header().Set("Tus-Resumable", "1.0.0")
 		w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
 		w.WriteHeader(http.StatusNoContent)
 
+	case "DELETE":
+		delete(m.uploads, uploadID)
+		w.WriteHeader(http.StatusNoContent)
+
 	default:
 		w.WriteHeader(http.StatusMethodNotAllowed)
 	}
@@ -304,13 +431,13 @@ func TestUploadStateOperations(t *testing.T) {
 	}
 
 	// Test save state
-	err := saveState(testFile, state)
+	err := saveState(calculateFileHash(testFile), state)
 	if err != nil {
 		t.Fatalf("Failed to save state: %v", err)
 	}
 
 	// Test load state
-	loadedState, err := loadState(testFile)
+	loadedState, err := loadState(calculateFileHash(testFile))
 	if err != nil {
 		t.Fatalf("Failed to load state: %v", err)
 	}
@@ -326,8 +453,8 @@ func TestUploadStateOperations(t *testing.T) {
 	}
 
 	// Test clear state
-	clearState(testFile)
-	stateFile := getStateFile(testFile)
+	clearState(calculateFileHash(testFile))
+	stateFile := getStateFile(calculateFileHash(testFile))
 	if _, err := os.Stat(stateFile); !os.IsNotExist(err) {
 		t.Error("State file should have been deleted")
 	}
@@ -464,7 +591,7 @@ func TestResumeUpload(t *testing.T) {
 		ChunkSize: config.ChunkSize,
 		Headers:   config.Headers,
 	}
-	err = saveState(testFile, state)
+	err = saveState(calculateFileHash(testFile), state)
 	if err != nil {
 		t.Fatalf("Failed to save state: %v", err)
 	}
@@ -636,7 +763,7 @@ func createTestFileWithCleanup(t *testing.T, size int64, content []byte) (string
 	return filename, func() {
 		os.Remove(filename)
 		// Also clean up any state files
-		clearState(filename)
+		clearState(calculateFileHash(filename))
 	}
 }
 
@@ -667,8 +794,8 @@ func TestConcurrentStateAccess(t *testing.T) {
 	defer os.Remove(testFile)
 
 	// Test concurrent state operations within the same process
-	// Since each process has its own PID-based state file, we test concurrent access
-	// to the same process's state file
+	// Processes are kept apart by the upload lock, so we test concurrent access
+	// to the one state file of the upload
 	const numGoroutines = 10
 	const numOperations = 20 // Reduced for faster testing
 
@@ -683,11 +810,11 @@ func TestConcurrentStateAccess(t *testing.T) {
 	}
 
 	// Save initial state
-	err = saveState(testFile, initialState)
+	err = saveState(calculateFileHash(testFile), initialState)
 	if err != nil {
 		t.Fatalf("Failed to save initial state: %v", err)
 	}
-	defer clearState(testFile)
+	defer clearState(calculateFileHash(testFile))
 
 	// Run concurrent operations
 	var wg sync.WaitGroup
@@ -708,7 +835,7 @@ func TestConcurrentStateAccess(t *testing.T) {
 					ChunkSize: 1024,
 					Headers:   map[string]string{"goroutine": fmt.Sprintf("%d", goroutineID)},
 				}
-				err := saveState(testFile, newState)
+				err := saveState(calculateFileHash(testFile), newState)
 				if err != nil {
 					errors <- fmt.Errorf("goroutine %d: failed to save state: %v", goroutineID, err)
 					return
@@ -717,7 +844,7 @@ func TestConcurrentStateAccess(t *testing.T) {
 				// Occasionally read state to test concurrent read/write
 				// Note: reads may fail during concurrent writes, which is expected behavior
 				if j%10 == 0 {
-					_, err := loadState(testFile)
+					_, err := loadState(calculateFileHash(testFile))
 					if err != nil {
 						// This is expected during concurrent writes, so we don't treat it as an error
 						// Just log it for debugging if needed
@@ -738,7 +865,7 @@ func TestConcurrentStateAccess(t *testing.T) {
 	}
 
 	// Verify final state can be read
-	finalState, err := loadState(testFile)
+	finalState, err := loadState(calculateFileHash(testFile))
 	if err != nil {
 		t.Errorf("Failed to read final state: %v", err)
 	} else if finalState.FileSize != int64(len(testData)) {
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigFile is the tusc config file with its named profiles, shared with
+// tusc v2
+type ConfigFile struct {
+	DefaultProfile string             `yaml:"default_profile"` // Used when -profile is not set
+	Profiles       map[string]Profile `yaml:"profiles"`
+}
+
+// Profile holds defaults for the options. Environment variables and flags
+// override it.
+type Profile struct {
+	Endpoint      string            `yaml:"endpoint"`
+	Headers       map[string]string `yaml:"headers"`
+	ChunkSize     int64             `yaml:"chunk_size"` // Megabytes, like -c
+	Retries       *int              `yaml:"retries"`
+	RetryDelay    string            `yaml:"retry_delay"`
+	RetryMaxDelay string            `yaml:"retry_max_delay"`
+	RetryMaxTime  string            `yaml:"retry_max_time"`
+	RetryBudget   string            `yaml:"retry_budget"`
+	LimitRate     string            `yaml:"limit_rate"`
+	TLS           ProfileTLS        `yaml:"tls"`
+	Auth          ProfileAuth       `yaml:"auth"`
+	Signing       ProfileSigning    `yaml:"signing"`
+	Metadata      map[string]string `yaml:"metadata"` // Sent with every upload
+
+	// Only used by tusc v2
+	AdaptiveChunks bool `yaml:"adaptive_chunks"`
+	Jobs           int  `yaml:"jobs"`
+	Parallel       int  `yaml:"parallel"`
+}
+
+// ProfileTLS holds the TLS settings of a profile
+type ProfileTLS struct {
+	CAFile             string `yaml:"ca_file"`
+	CertFile           string `yaml:"cert_file"`
+	KeyFile            string `yaml:"key_file"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+}
+
+// ProfileAuth holds the credentials of a profile, at most one kind of them
+type ProfileAuth struct {
+	BearerToken  string `yaml:"bearer_token"`
+	User         string `yaml:"user"` // user:password for basic auth
+	TokenURL     string `yaml:"oauth2_token_url"`
+	ClientID     string `yaml:"oauth2_client_id"`
+	ClientSecret string `yaml:"oauth2_client_secret"`
+	Scope        string `yaml:"oauth2_scope"`
+
+	CredentialHelper string `yaml:"credential_helper"` // Command, e.g. "my-vault-cli get"
+	Netrc            bool   `yaml:"netrc"`
+	NetrcFile        string `yaml:"netrc_file"`
+}
+
+// ProfileSigning holds the request signing settings of a profile
+type ProfileSigning struct {
+	Scheme  string `yaml:"scheme"` // hmac-sha256 or aws-sigv4
+	KeyID   string `yaml:"key_id"`
+	Secret  string `yaml:"secret"`
+	Region  string `yaml:"region"`
+	Service string `yaml:"service"`
+}
+
+// configFilePath returns where the config file is read from,
+// $XDG_CONFIG_HOME/tusc/config.yaml or ~/.config/tusc/config.yaml
+func configFilePath() string {
+	if dir := os.Getenv("XDG_CONFIG_HOME"); filepath.IsAbs(dir) {
+		return filepath.Join(dir, "tusc", "config.yaml")
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		return filepath.Join(home, ".config", "tusc", "config.yaml")
+	}
+	return ""
+}
+
+// loadConfigFile reads the config file at path. A missing file is an empty
+// config, unknown keys are errors so typos don't go unnoticed.
+func loadConfigFile(path string) (*ConfigFile, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &ConfigFile{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open config file: %v", err)
+	}
+	defer file.Close()
+
+	var config ConfigFile
+	decoder := yaml.NewDecoder(file)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&config); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("invalid config file %s: %v", path, err)
+	}
+	return &config, nil
+}
+
+// lookupFlag returns the value of flag name in args. The profile is applied
+// before the environment and the flags, so -profile and -config are needed
+// before the flag package parses them.
+func lookupFlag(args []string, name string) string {
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		for _, prefix := range []string{"-", "--"} {
+			if arg == prefix+name && i+1 < len(args) {
+				return args[i+1]
+			}
+			if value, ok := strings.CutPrefix(arg, prefix+name+"="); ok {
+				return value
+			}
+		}
+	}
+	return ""
+}
+
+// loadProfile returns the profile named by -profile or TUSC_PROFILE, or the
+// config file's default profile. It returns nil when none is set.
+func loadProfile(args []string) (*Profile, error) {
+	path := lookupFlag(args, "config")
+	if path == "" {
+		path = os.Getenv("TUSC_CONFIG")
+	}
+	if path == "" {
+		path = configFilePath()
+	}
+	if path == "" {
+		return nil, nil
+	}
+
+	config, err := loadConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	name := lookupFlag(args, "profile")
+	if name == "" {
+		name = os.Getenv("TUSC_PROFILE")
+	}
+	if name == "" {
+		name = config.DefaultProfile
+	}
+	if name == "" {
+		return nil, nil
+	}
+
+	profile, ok := config.Profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("profile %q not found in %s", name, path)
+	}
+	return &profile, nil
+}
+
+// applyProfile sets what the profile has a value for in config
+func applyProfile(config *Config, profile *Profile) error {
+	if profile.Endpoint != "" {
+		config.TusdEndpoint = profile.Endpoint
+	}
+	if profile.ChunkSize != 0 {
+		config.ChunkSize = profile.ChunkSize
+	}
+	if len(profile.Headers) > 0 {
+		config.Headers = make(map[string]string)
+		for key, value := range profile.Headers {
+			config.Headers[key] = value
+		}
+	}
+	if profile.LimitRate != "" {
+		config.LimitRate = profile.LimitRate
+	}
+	if profile.Retries != nil {
+		config.Retries = *profile.Retries
+	}
+	if profile.RetryBudget != "" {
+		config.RetryBudget = profile.RetryBudget
+	}
+	for _, delay := range []struct {
+		name  string
+		spec  string
+		value *time.Duration
+	}{
+		{"retry_delay", profile.RetryDelay, &config.RetryDelay},
+		{"retry_max_delay", profile.RetryMaxDelay, &config.RetryMaxDelay},
+		{"retry_max_time", profile.RetryMaxTime, &config.RetryMaxTime},
+	} {
+		if delay.spec == "" {
+			continue
+		}
+		d, err := time.ParseDuration(delay.spec)
+		if err != nil {
+			return fmt.Errorf("invalid %s in profile: %v", delay.name, err)
+		}
+		*delay.value = d
+	}
+
+	config.CACert = profile.TLS.CAFile
+	config.Cert = profile.TLS.CertFile
+	config.Key = profile.TLS.KeyFile
+	config.Insecure = profile.TLS.InsecureSkipVerify
+	config.Sign = profile.Signing.Scheme
+	config.SignKeyID = profile.Signing.KeyID
+	config.SignSecret = profile.Signing.Secret
+	config.SignRegion = profile.Signing.Region
+	config.SignService = profile.Signing.Service
+	config.Metadata = profile.Metadata
+	return nil
+}
+
+// applyProfileAuth sets the credentials of the profile unless the
+// environment or a flag set any, whatever their kind. It runs after the
+// flags are parsed.
+func applyProfileAuth(config *Config, auth ProfileAuth) {
+	if config.BearerToken != "" || config.User != "" || config.OAuth2TokenURL != "" ||
+		config.OAuth2ClientID != "" || config.OAuth2ClientSecret != "" || config.OAuth2Scope != "" ||
+		config.CredentialHelper != "" || config.Netrc || config.NetrcFile != "" {
+		return
+	}
+
+	config.BearerToken = auth.BearerToken
+	config.User = auth.User
+	config.OAuth2TokenURL = auth.TokenURL
+	config.OAuth2ClientID = auth.ClientID
+	config.OAuth2ClientSecret = auth.ClientSecret
+	config.OAuth2Scope = auth.Scope
+	config.CredentialHelper = auth.CredentialHelper
+	config.Netrc = auth.Netrc
+	config.NetrcFile = auth.NetrcFile
+}
+
+// loadTLSConfig builds the TLS settings of -cacert, -cert, -key and
+// -insecure. It returns nil when none is set, keeping Go's defaults.
+func loadTLSConfig(caFile, certFile, keyFile string, insecure bool) (*tls.Config, error) {
+	if caFile == "" && certFile == "" && keyFile == "" && !insecure {
+		return nil, nil
+	}
+
+	config := &tls.Config{InsecureSkipVerify: insecure}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %v", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", caFile)
+		}
+	}
+
+	if certFile != "" || keyFile != "" {
+		if certFile == "" || keyFile == "" {
+			return nil, fmt.Errorf("a client certificate needs both -cert and -key")
+		}
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// defaultTransport returns Go's default transport with the TLS settings of
+// config, for requests that don't need a tuned connection pool
+func defaultTransport(config Config) http.RoundTripper {
+	if config.TLS == nil {
+		return http.DefaultTransport
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config.TLS
+	return transport
+}
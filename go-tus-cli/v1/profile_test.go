@@ -0,0 +1,108 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testConfigFile = `default_profile: local
+profiles:
+  local:
+    endpoint: http://localhost:1080/files/
+  staging:
+    endpoint: https://staging.example.com/files/
+    chunk_size: 8
+    retries: 0
+    retry_max_time: 10m
+    jobs: 4
+    headers:
+      Authorization: Bearer abc
+      X-Tags: red, green
+    metadata:
+      project: demo
+      name: ignored
+`
+
+func TestLookupFlag(t *testing.T) {
+	tests := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"-profile", "staging", "file.bin"}, "staging"},
+		{[]string{"-t", "http://x", "--profile=staging", "file.bin"}, "staging"},
+		{[]string{"-profile"}, ""},
+		{[]string{"--", "-profile", "staging"}, ""},
+		{[]string{"file.bin"}, ""},
+	}
+
+	for _, test := range tests {
+		if value := lookupFlag(test.args, "profile"); value != test.expected {
+			t.Errorf("lookupFlag(%q) = %q, expected %q", test.args, value, test.expected)
+		}
+	}
+}
+
+func TestLoadProfile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	os.WriteFile(path, []byte(testConfigFile), 0600)
+	t.Setenv("TUSC_CONFIG", path)
+	t.Setenv("TUSC_PROFILE", "")
+
+	profile, err := loadProfile(nil)
+	if err != nil || profile == nil || profile.Endpoint != "http://localhost:1080/files/" {
+		t.Fatalf("Expected the default profile, got %+v, %v", profile, err)
+	}
+
+	t.Setenv("TUSC_PROFILE", "staging")
+	profile, err = loadProfile(nil)
+	if err != nil || profile == nil || profile.ChunkSize != 8 || profile.Headers["X-Tags"] != "red, green" {
+		t.Fatalf("Expected the staging profile, got %+v, %v", profile, err)
+	}
+
+	if _, err := loadProfile([]string{"-profile", "production"}); err == nil {
+		t.Error("Expected an unknown profile to be rejected")
+	}
+
+	os.WriteFile(path, []byte("profiles:\n  x:\n    endpiont: http://example.com\n"), 0600)
+	if _, err := loadProfile(nil); err == nil {
+		t.Error("Expected an unknown key to be rejected")
+	}
+}
+
+func TestProfilePrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	os.WriteFile(path, []byte(testConfigFile), 0600)
+	t.Setenv("TUSC_CONFIG", path)
+	t.Setenv("TUSC_PROFILE", "staging")
+	t.Setenv("TUSC_CHUNK_SIZE", "16")
+	t.Setenv("TUSC_HEADERS", "Authorization:Bearer env")
+
+	config := Config{Retries: DefaultRetries}
+	profile, err := loadProfile(nil)
+	if err != nil {
+		t.Fatalf("Failed to load profile: %v", err)
+	}
+	if err := applyProfile(&config, profile); err != nil {
+		t.Fatalf("Failed to apply profile: %v", err)
+	}
+	loadConfigFromEnv(&config)
+
+	if config.TusdEndpoint != "https://staging.example.com/files/" || config.Retries != 0 || config.RetryMaxTime.Minutes() != 10 {
+		t.Errorf("Expected the settings of the profile, got %+v", config)
+	}
+	if config.ChunkSize != 16 {
+		t.Errorf("Expected the env chunk size, got %d", config.ChunkSize)
+	}
+	if config.Headers["Authorization"] != "Bearer env" || config.Headers["X-Tags"] != "red, green" {
+		t.Errorf("Unexpected headers: %v", config.Headers)
+	}
+
+	metadata := config.uploadMetadata("/tmp/report.pdf")
+	if metadata["project"] != "demo" || metadata["name"] != "report.pdf" {
+		t.Errorf("Expected profile metadata below the name, got %v", metadata)
+	}
+	if header := encodeMetadata(metadata); header != "name cmVwb3J0LnBkZg==,project ZGVtbw==" {
+		t.Errorf("Unexpected Upload-Metadata header %q", header)
+	}
+}
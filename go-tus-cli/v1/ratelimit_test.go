@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRateLimit(t *testing.T) {
+	schedule, err := parseRateLimit("08:00-18:00=5MB/s,22:00-06:00=20M,*=unlimited")
+	if err != nil {
+		t.Fatalf("parseRateLimit failed: %v", err)
+	}
+
+	at := func(clock string) time.Time {
+		parsed, _ := time.Parse("15:04", clock)
+		return parsed
+	}
+
+	if rate := schedule.RateAt(at("09:30")); rate != 5*1024*1024 {
+		t.Errorf("Expected 5MB/s during work hours, got %d", rate)
+	}
+	if rate := schedule.RateAt(at("02:00")); rate != 20*1024*1024 {
+		t.Errorf("Expected the overnight window to wrap past midnight, got %d", rate)
+	}
+	if rate := schedule.RateAt(at("19:00")); rate != 0 {
+		t.Errorf("Expected no limit outside the windows, got %d", rate)
+	}
+	if rate := schedule.MinRate(); rate != 5*1024*1024 {
+		t.Errorf("Expected the lowest rate to be 5MB/s, got %d", rate)
+	}
+
+	for _, invalid := range []string{"fast", "0", "5Mbps", "08:00-18=5MB", "18:00-18:00=1K"} {
+		if _, err := parseRateLimit(invalid); err == nil {
+			t.Errorf("Expected %q to be rejected", invalid)
+		}
+	}
+}
+
+func TestLimitedTransferTime(t *testing.T) {
+	limiter := NewRateLimiter(&RateSchedule{Default: 1024 * 1024})
+	if d := limitedTransferTime(limiter, 32*1024*1024); d != 32*time.Second {
+		t.Errorf("Expected 32s for 32MB at 1MB/s, got %v", d)
+	}
+
+	unlimited := NewRateLimiter(&RateSchedule{})
+	if d := limitedTransferTime(unlimited, 32*1024*1024); d != 0 {
+		t.Errorf("Expected no extra time without a limit, got %v", d)
+	}
+}
+
+func TestRateLimiterWaitNCancelled(t *testing.T) {
+	limiter := NewRateLimiter(&RateSchedule{Default: 1000})
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// A wait of 100s ends with the context, and doesn't block other callers
+	done := make(chan error, 1)
+	go func() { done <- limiter.WaitN(ctx, 100*1000) }()
+	time.Sleep(50 * time.Millisecond)
+
+	cancelled, cancelNow := context.WithCancel(context.Background())
+	cancelNow()
+	start := time.Now()
+	if err := limiter.WaitN(cancelled, 1); !errors.Is(err, context.Canceled) || time.Since(start) > time.Second {
+		t.Errorf("Expected another caller to be cancelled right away, got %v after %v", err, time.Since(start))
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected the wait to be cancelled, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the wait to end with its context")
+	}
+}
+
+func TestRateLimiterFollowsSchedule(t *testing.T) {
+	now := time.Date(2024, 1, 1, 17, 59, 58, 0, time.Local)
+	var slept time.Duration
+
+	schedule, _ := parseRateLimit("08:00-18:00=1K,*=unlimited")
+	limiter := NewRateLimiter(schedule)
+	limiter.now = func() time.Time { return now }
+	limiter.sleep = func(ctx context.Context, d time.Duration) error {
+		now = now.Add(d)
+		slept += d
+		return ctx.Err()
+	}
+
+	// A chunk that would take 100s during work hours is released at 18:00
+	limiter.WaitN(context.Background(), 100*1024)
+	if slept < time.Second || slept > 3*time.Second {
+		t.Errorf("Expected the limit to apply until 18:00 only, waited %v", slept)
+	}
+}
+
+func TestRateLimitedUpload(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+
+	mockServer := NewMockTUSServer()
+	defer mockServer.Close()
+
+	testContent := []byte(strings.Repeat("x", 2*MinChunkSize))
+	testFile := createTestFile(t, int64(len(testContent)), testContent)
+	defer os.Remove(testFile)
+
+	config := Config{
+		TusdEndpoint: mockServer.URL(),
+		FilePath:     testFile,
+		ChunkSize:    MinChunkSize,
+		Headers:      make(map[string]string),
+		RateLimit:    NewRateLimiter(&RateSchedule{Default: 256 * 1024}),
+	}
+
+	// 128KB at 256KB/s take 0.5s
+	start := time.Now()
+	if err := uploadFile(config); err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Errorf("Expected the upload to be limited, took only %v", elapsed)
+	}
+
+	for _, upload := range mockServer.GetUploads() {
+		if upload.Offset != int64(len(testContent)) {
+			t.Errorf("Expected a complete upload, got offset %d", upload.Offset)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetrierNext(t *testing.T) {
+	retrier := newRetrier(&RetryPolicy{Retries: 3, BaseDelay: time.Second, MaxDelay: 3 * time.Second})
+	retrier.jitter = func(n int64) int64 { return n - 1 }
+
+	unavailable := &HTTPError{Phase: PhasePatch, StatusCode: http.StatusServiceUnavailable}
+	for _, expected := range []time.Duration{time.Second, 2 * time.Second, 3 * time.Second} {
+		if delay, ok := retrier.Next(unavailable); !ok || delay != expected {
+			t.Errorf("Expected to wait up to %v, got %v (%v)", expected, delay, ok)
+		}
+	}
+	if _, ok := retrier.Next(unavailable); ok {
+		t.Error("Expected the budget of 3 retries to be used up")
+	}
+
+	// Retry-After lengthens the wait, other classes have their own budget
+	locked := &HTTPError{Phase: PhasePatch, StatusCode: http.StatusTooManyRequests, Header: http.Header{"Retry-After": {"90"}}}
+	retrier = newRetrier(&RetryPolicy{Retries: 1, BaseDelay: time.Second, MaxDelay: time.Second})
+	if delay, ok := retrier.Next(locked); !ok || delay != 90*time.Second {
+		t.Errorf("Expected to wait the 90s the server asked for, got %v (%v)", delay, ok)
+	}
+	if delay, ok := retrier.Next(&HTTPError{Phase: PhasePatch, StatusCode: http.StatusConflict}); !ok || delay != 0 {
+		t.Errorf("Expected a conflict to be retried right away, got %v (%v)", delay, ok)
+	}
+}
+
+func TestRetrierMaxElapsed(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	retrier := newRetrier(&RetryPolicy{Retries: 10, BaseDelay: 10 * time.Second, MaxDelay: time.Minute, MaxElapsed: 15 * time.Second})
+	retrier.now = func() time.Time { return now }
+	retrier.jitter = func(n int64) int64 { return n - 1 }
+
+	unavailable := &HTTPError{Phase: PhasePatch, StatusCode: http.StatusBadGateway}
+	delay, ok := retrier.Next(unavailable)
+	if !ok {
+		t.Fatal("Expected the first failure to be retried")
+	}
+	now = now.Add(delay)
+
+	if _, ok := retrier.Next(unavailable); ok {
+		t.Error("Expected a 20s wait to exceed the 15s limit")
+	}
+}
+
+func TestBuildRetryPolicy(t *testing.T) {
+	t.Setenv("TUSC_RETRIES", "7")
+	t.Setenv("TUSC_RETRY_MAX_TIME", "10m")
+	t.Setenv("TUSC_RETRY_BUDGET", "resync=20")
+
+	config := Config{Retries: DefaultRetries, RetryDelay: DefaultRetryDelay, RetryMaxDelay: DefaultRetryMaxDelay}
+	loadConfigFromEnv(&config)
+
+	policy, err := buildRetryPolicy(config)
+	if err != nil {
+		t.Fatalf("buildRetryPolicy failed: %v", err)
+	}
+	if policy.Retries != 7 || policy.MaxElapsed != 10*time.Minute || policy.BaseDelay != DefaultRetryDelay {
+		t.Errorf("Unexpected policy: %+v", policy)
+	}
+	if policy.Budgets[Resync] != 20 || policy.Budgets[Resend] != maxChecksumResends {
+		t.Errorf("Unexpected budgets: %v", policy.Budgets)
+	}
+
+	config.RetryBudget = "restart=1"
+	if _, err := buildRetryPolicy(config); err == nil {
+		t.Error("Expected an unknown error class to be rejected")
+	}
+}
+
+func TestUploadRetryBudget(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+
+	mockServer := NewMockTUSServer()
+	defer mockServer.Close()
+	mockServer.PatchStatuses = []int{503, 503}
+
+	testContent := []byte(strings.Repeat("x", MinChunkSize))
+	testFile := createTestFile(t, int64(len(testContent)), testContent)
+	defer os.Remove(testFile)
+
+	config := Config{
+		TusdEndpoint: mockServer.URL(),
+		FilePath:     testFile,
+		ChunkSize:    MinChunkSize,
+		Headers:      make(map[string]string),
+		Retry:        &RetryPolicy{Retries: 1, MaxDelay: 10 * time.Millisecond},
+	}
+	if err := uploadFile(config); err == nil {
+		t.Fatal("Expected one retry not to get past two failures")
+	}
+
+	mockServer.PatchStatuses = []int{503, 503}
+	config.Retry.Budgets = map[RetryAction]int{Backoff: 2}
+	if err := uploadFile(config); err != nil {
+		t.Fatalf("Expected a backoff budget of 2 to get past two failures: %v", err)
+	}
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// signedMethods counts the requests a verifier accepted by method
+type signedMethods struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func (s *signedMethods) add(method string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.counts == nil {
+		s.counts = make(map[string]int)
+	}
+	s.counts[method]++
+}
+
+func (s *signedMethods) count(method string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.counts[method]
+}
+
+// readBody returns the request body and puts it back for the handler
+func readBody(r *http.Request) []byte {
+	body, _ := io.ReadAll(r.Body)
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return body
+}
+
+// verifyHMAC checks Signature headers like a gateway
+func verifyHMAC(keyID, secret string, signed *signedMethods) func(r *http.Request) error {
+	return func(r *http.Request) error {
+		params := make(map[string]string)
+		for _, param := range strings.Split(r.Header.Get("Signature"), ",") {
+			key, value, _ := strings.Cut(param, "=")
+			params[key] = strings.Trim(value, `"`)
+		}
+		if params["keyId"] != keyID || params["algorithm"] != "hmac-sha256" {
+			return fmt.Errorf("unexpected key %q or algorithm %q", params["keyId"], params["algorithm"])
+		}
+		if date, err := http.ParseTime(r.Header.Get("Date")); err != nil || time.Since(date).Abs() > 5*time.Minute {
+			return fmt.Errorf("missing or stale date %q", r.Header.Get("Date"))
+		}
+
+		headers := strings.Fields(params["headers"])
+		if body := readBody(r); len(body) > 0 {
+			digest := sha256.Sum256(body)
+			if !slices.Contains(headers, "digest") || r.Header.Get("Digest") != "SHA-256="+base64.StdEncoding.EncodeToString(digest[:]) {
+				return fmt.Errorf("body digest doesn't match")
+			}
+		}
+		if r.Method == http.MethodPatch && !slices.Contains(headers, "upload-offset") {
+			return fmt.Errorf("offset isn't signed")
+		}
+
+		lines := make([]string, len(headers))
+		for i, name := range headers {
+			switch name {
+			case "(request-target)":
+				lines[i] = name + ": " + strings.ToLower(r.Method) + " " + r.RequestURI
+			case "host":
+				lines[i] = name + ": " + r.Host
+			default:
+				lines[i] = name + ": " + r.Header.Get(name)
+			}
+		}
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(strings.Join(lines, "\n")))
+		if signature, _ := base64.StdEncoding.DecodeString(params["signature"]); !hmac.Equal(signature, mac.Sum(nil)) {
+			return fmt.Errorf("signature doesn't match")
+		}
+		signed.add(r.Method)
+		return nil
+	}
+}
+
+// awsEscape percent-encodes all but the unreserved characters, apart from
+// uriEncode to check it
+func awsEscape(s string) string {
+	return strings.NewReplacer("+", "%20", "*", "%2A", "%7E", "~").Replace(url.QueryEscape(s))
+}
+
+// verifySigV4 checks AWS Signature Version 4 like API Gateway
+func verifySigV4(secret string, signed *signedMethods) func(r *http.Request) error {
+	return func(r *http.Request) error {
+		params := make(map[string]string)
+		for _, param := range strings.Split(strings.TrimPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 "), ", ") {
+			key, value, _ := strings.Cut(param, "=")
+			params[key] = value
+		}
+		_, scope, _ := strings.Cut(params["Credential"], "/")
+		amzDate := r.Header.Get("X-Amz-Date")
+		if date, err := time.Parse("20060102T150405Z", amzDate); err != nil || time.Since(date).Abs() > 5*time.Minute ||
+			scope != date.Format("20060102")+"/eu-west-1/execute-api/aws4_request" {
+			return fmt.Errorf("missing or stale date %q for scope %q", amzDate, scope)
+		}
+
+		names := strings.Split(params["SignedHeaders"], ";")
+		if r.Method == http.MethodPatch && !slices.Contains(names, "upload-offset") {
+			return fmt.Errorf("offset isn't signed")
+		}
+		var canonicalHeaders strings.Builder
+		for _, name := range names {
+			value := r.Host
+			if name != "host" {
+				value = strings.Join(strings.Fields(strings.Join(r.Header.Values(name), ",")), " ")
+			}
+			canonicalHeaders.WriteString(name + ":" + value + "\n")
+		}
+
+		// The path as received, encoded once more, and the query sorted
+		rawPath, rawQuery, _ := strings.Cut(r.RequestURI, "?")
+		segments := strings.Split(rawPath, "/")
+		for i, segment := range segments {
+			segments[i] = awsEscape(segment)
+		}
+		query, _ := url.ParseQuery(rawQuery)
+		var pairs []string
+		for key, values := range query {
+			for _, value := range values {
+				pairs = append(pairs, awsEscape(key)+"="+awsEscape(value))
+			}
+		}
+		slices.Sort(pairs)
+
+		canonicalRequest := strings.Join([]string{
+			r.Method,
+			strings.Join(segments, "/"),
+			strings.Join(pairs, "&"),
+			canonicalHeaders.String(),
+			params["SignedHeaders"],
+			hexSHA256(readBody(r)),
+		}, "\n")
+		key := []byte("AWS4" + secret)
+		for _, part := range strings.Split(scope, "/") {
+			key = hmacSHA256(key, part)
+		}
+		expected := hmacSHA256(key, "AWS4-HMAC-SHA256\n"+amzDate+"\n"+scope+"\n"+hexSHA256([]byte(canonicalRequest)))
+		if signature, _ := hex.DecodeString(params["Signature"]); !hmac.Equal(signature, expected) {
+			return fmt.Errorf("signature doesn't match")
+		}
+		signed.add(r.Method)
+		return nil
+	}
+}
+
+func TestSigV4TestVector(t *testing.T) {
+	// get-vanilla of the AWS Signature Version 4 test suite
+	signer := &SigV4Signer{
+		AccessKeyID:     "AKIDEXAMPLE",
+		SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+		Region:          "us-east-1",
+		Service:         "service",
+		now:             func() time.Time { return time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC) },
+	}
+	req, _ := http.NewRequest(http.MethodGet, "https://example.amazonaws.com/", nil)
+	if err := signer.Sign(req, nil); err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+
+	expected := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+		"SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if got := req.Header.Get("Authorization"); got != expected {
+		t.Errorf("Unexpected Authorization header:\n%s\nexpected:\n%s", got, expected)
+	}
+}
+
+func TestSigV4EscapedPath(t *testing.T) {
+	// API Gateway signs the path the way it arrives, encoded once more
+	var signed signedMethods
+	verify := verifySigV4("s3cret", &signed)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := verify(r); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+		}
+	}))
+	defer server.Close()
+
+	signer := &SigV4Signer{AccessKeyID: "uploader", SecretAccessKey: "s3cret", Region: "eu-west-1", Service: "execute-api", now: time.Now}
+	client := signHTTPClient(&http.Client{}, signer)
+	for _, path := range []string{"/files/a+b", "/files/a b", "/files/a%2Bb%20c", "/files/100%25 done?name=a+b%20c"} {
+		resp, err := client.Get(server.URL + path)
+		if err != nil {
+			t.Fatalf("Request to %s failed: %v", path, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("Expected %s to be signed correctly, got %d: %s", path, resp.StatusCode, body)
+		}
+	}
+}
+
+func TestSignedRequests(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	t.Setenv("AWS_SESSION_TOKEN", "")
+
+	tests := []struct {
+		scheme string
+		verify func(signed *signedMethods) func(r *http.Request) error
+	}{
+		{"hmac-sha256", func(signed *signedMethods) func(r *http.Request) error {
+			return verifyHMAC("uploader", "s3cret", signed)
+		}},
+		{"aws-sigv4", func(signed *signedMethods) func(r *http.Request) error {
+			return verifySigV4("s3cret", signed)
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.scheme, func(t *testing.T) {
+			var signed signedMethods
+			mockServer := NewMockTUSServer()
+			defer mockServer.Close()
+			mockServer.Verify = test.verify(&signed)
+
+			testContent := make([]byte, 2*MinChunkSize+100)
+			testFile := createTestFile(t, int64(len(testContent)), testContent)
+			defer os.Remove(testFile)
+			defer clearState(calculateFileHash(testFile))
+
+			newConfig := func(secret string) Config {
+				signer, err := newSigner(test.scheme, SignerOptions{KeyID: "uploader", Secret: secret, Region: "eu-west-1"})
+				if err != nil {
+					t.Fatalf("Failed to create signer: %v", err)
+				}
+				return Config{
+					TusdEndpoint: mockServer.URL(),
+					FilePath:     testFile,
+					ChunkSize:    MinChunkSize,
+					Headers:      make(map[string]string),
+					Signer:       signer,
+				}
+			}
+
+			if err := uploadFile(newConfig("wrong")); err == nil {
+				t.Error("Expected the gateway to reject a wrong signature")
+			}
+			if err := uploadFile(newConfig("s3cret")); err != nil {
+				t.Fatalf("Signed upload failed: %v", err)
+			}
+			for id := range mockServer.uploads {
+				if err := deleteUpload(newConfig("s3cret"), mockServer.server.URL+"/files/"+id); err != nil {
+					t.Errorf("Signed termination failed: %v", err)
+				}
+			}
+
+			for method, expected := range map[string]int{"POST": 1, "PATCH": 3, "DELETE": 1} {
+				if n := signed.count(method); n != expected {
+					t.Errorf("Expected %d signed %s requests, got %d", expected, method, n)
+				}
+			}
+		})
+	}
+}
+
+func TestNewSigner(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_REGION", "")
+	t.Setenv("AWS_DEFAULT_REGION", "eu-central-1")
+
+	if signer, err := newSigner("", SignerOptions{}); signer != nil || err != nil {
+		t.Errorf("Expected no signer, got %v, %v", signer, err)
+	}
+	if _, err := newSigner("rsa", SignerOptions{}); err == nil || !strings.Contains(err.Error(), "aws-sigv4, hmac-sha256") {
+		t.Errorf("Expected the supported schemes to be listed, got %v", err)
+	}
+	if _, err := newSigner("hmac-sha256", SignerOptions{KeyID: "uploader"}); err == nil {
+		t.Error("Expected hmac-sha256 without a secret to be rejected")
+	}
+
+	signer, err := newSigner("aws-sigv4", SignerOptions{Region: "us-east-1"})
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+	sigv4 := signer.(*SigV4Signer)
+	if sigv4.AccessKeyID != "AKIDEXAMPLE" || sigv4.Region != "us-east-1" || sigv4.Service != "execute-api" {
+		t.Errorf("Unexpected signer %+v", sigv4)
+	}
+	if signer, _ := newSigner("aws-sigv4", SignerOptions{}); signer.(*SigV4Signer).Region != "eu-central-1" {
+		t.Errorf("Expected the region of AWS_DEFAULT_REGION")
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	ExitInterrupted   = 3               // Exit code when a signal stopped the upload
+	CheckpointTimeout = 5 * time.Second // How long to ask the server for the offset after a signal
+)
+
+// errInterrupted is returned by uploads stopped by SIGINT or SIGTERM
+var errInterrupted = errors.New("upload interrupted")
+
+// PauseGate holds uploads between chunks while paused. A nil gate is never paused.
+type PauseGate struct {
+	mu     sync.Mutex
+	resume chan struct{} // closed on resume, nil while running
+}
+
+// Pause reports whether the gate was running
+func (g *PauseGate) Pause() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.resume != nil {
+		return false
+	}
+	g.resume = make(chan struct{})
+	return true
+}
+
+// Resume reports whether the gate was paused
+func (g *PauseGate) Resume() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.resume == nil {
+		return false
+	}
+	close(g.resume)
+	g.resume = nil
+	return true
+}
+
+// Wait blocks while the gate is paused or until ctx is done
+func (g *PauseGate) Wait(ctx context.Context) error {
+	if g == nil {
+		return ctx.Err()
+	}
+
+	g.mu.Lock()
+	resume := g.resume
+	g.mu.Unlock()
+
+	if resume != nil {
+		select {
+		case <-resume:
+		case <-ctx.Done():
+		}
+	}
+	return ctx.Err()
+}
+
+// runContext returns the context uploads are cancelled with
+func (c Config) runContext() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
+// watchSignals cancels the context of config on an interrupt signal and pauses
+// or resumes the upload on the pause and resume signals. A second interrupt
+// kills the process right away. The returned function stops watching.
+func watchSignals(config *Config) func() {
+	ctx, cancel := context.WithCancel(config.runContext())
+	config.ctx = ctx
+	config.pause = &PauseGate{}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, slices.Concat(interruptSignals, pauseSignals, resumeSignals)...)
+
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case sig := <-signals:
+				switch {
+				case slices.Contains(pauseSignals, sig):
+					if config.pause.Pause() {
+						fmt.Fprintf(config.output(), "\nPaused, send SIGUSR2 to resume\n")
+					}
+				case slices.Contains(resumeSignals, sig):
+					if config.pause.Resume() {
+						fmt.Fprintf(config.output(), "Resumed\n")
+					}
+				default:
+					fmt.Fprintf(config.output(), "\nInterrupted, stopping the upload...\n")
+					signal.Reset(interruptSignals...)
+					cancel()
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(signals)
+		close(done)
+		cancel()
+	}
+}
+
+// confirmedOffset asks the server how much of an interrupted upload it stored
+func confirmedOffset(client *http.Client, uploadURL string, headers map[string]string) (int64, error) {
+	checkClient := *client
+	checkClient.Timeout = CheckpointTimeout
+	return getUploadOffset(&checkClient, uploadURL, headers)
+}
+
+// exitUploadError exits after a failed upload, with ExitInterrupted if a
+// signal stopped it
+func exitUploadError(config Config, err error) {
+	config.emit(Event{Event: "failed", Error: err.Error()})
+	if errors.Is(err, errInterrupted) {
+		fmt.Fprintf(os.Stderr, "Upload interrupted\n")
+		os.Exit(ExitInterrupted)
+	}
+	fmt.Fprintf(os.Stderr, "Upload failed: %v\n", err)
+	os.Exit(1)
+}
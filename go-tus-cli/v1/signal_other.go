@@ -0,0 +1,13 @@
+//go:build !unix && !windows
+
+package main
+
+import "os"
+
+// Other platforms only stop on an interrupt
+
+var (
+	interruptSignals = []os.Signal{os.Interrupt}
+	pauseSignals     []os.Signal
+	resumeSignals    []os.Signal
+)
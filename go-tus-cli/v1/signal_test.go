@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestPauseGate(t *testing.T) {
+	var none *PauseGate
+	if err := none.Wait(context.Background()); err != nil {
+		t.Errorf("Expected a nil gate never to hold, got %v", err)
+	}
+
+	gate := &PauseGate{}
+	if !gate.Pause() || gate.Pause() {
+		t.Error("Expected only the first Pause to pause the gate")
+	}
+
+	waited := make(chan error, 1)
+	go func() { waited <- gate.Wait(context.Background()) }()
+	select {
+	case err := <-waited:
+		t.Fatalf("Expected Wait to block while paused, got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	gate.Resume()
+	select {
+	case err := <-waited:
+		if err != nil {
+			t.Errorf("Expected Wait to return after Resume, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected Wait to return after Resume")
+	}
+
+	// An interrupt ends the pause
+	gate.Pause()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := gate.Wait(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected a cancelled Wait to fail, got %v", err)
+	}
+}
+
+func TestUploadInterruptCheckpoints(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+
+	mockServer := NewMockTUSServer()
+	defer mockServer.Close()
+
+	testContent := make([]byte, 4*MinChunkSize)
+	rand.Read(testContent)
+	testFile := createTestFile(t, int64(len(testContent)), testContent)
+	defer os.Remove(testFile)
+
+	// SIGINT arrives while the second chunk is acknowledged
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mockServer.Patched = func(offset int64) {
+		if offset >= 2*MinChunkSize {
+			cancel()
+		}
+	}
+
+	config := Config{
+		TusdEndpoint: mockServer.URL(),
+		FilePath:     testFile,
+		ChunkSize:    MinChunkSize,
+		Headers:      make(map[string]string),
+		ctx:          ctx,
+	}
+	if err := uploadFile(config); !errors.Is(err, errInterrupted) {
+		t.Fatalf("Expected the upload to be interrupted, got %v", err)
+	}
+
+	state, err := loadState(calculateFileHash(testFile))
+	if err != nil {
+		t.Fatalf("Expected the state to be kept: %v", err)
+	}
+	if state.Offset != 2*MinChunkSize {
+		t.Errorf("Expected the confirmed offset %d in the state, got %d", 2*MinChunkSize, state.Offset)
+	}
+
+	// The next run sends only the rest
+	config.ctx = nil
+	if err := uploadFile(config); err != nil {
+		t.Fatalf("Resumed upload failed: %v", err)
+	}
+	if mockServer.Patches != 4 {
+		t.Errorf("Expected 4 PATCH requests in total, got %d", mockServer.Patches)
+	}
+	for _, upload := range mockServer.GetUploads() {
+		if !bytes.Equal(upload.Data, testContent) {
+			t.Error("Uploaded data doesn't match")
+		}
+	}
+}
@@ -0,0 +1,14 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+var (
+	interruptSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	pauseSignals     = []os.Signal{syscall.SIGUSR1}
+	resumeSignals    = []os.Signal{syscall.SIGUSR2}
+)
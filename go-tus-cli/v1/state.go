@@ -0,0 +1,30 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// stateDir returns the per-user directory state files are kept in,
+// $XDG_STATE_HOME/tusc/v1 or ~/.local/state/tusc/v1, so an upload started in
+// one directory is resumed from any other
+func stateDir() string {
+	if dir := os.Getenv("XDG_STATE_HOME"); filepath.IsAbs(dir) {
+		return filepath.Join(dir, "tusc", "v1")
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		return filepath.Join(home, ".local", "state", "tusc", "v1")
+	}
+	return "."
+}
+
+// globStateFiles matches pattern in the state directory and, for state
+// written by older versions, in the working directory
+func globStateFiles(pattern string) []string {
+	matches, _ := filepath.Glob(filepath.Join(stateDir(), pattern))
+	if stateDir() != "." {
+		legacy, _ := filepath.Glob(pattern)
+		matches = append(matches, legacy...)
+	}
+	return matches
+}
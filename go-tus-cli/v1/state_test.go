@@ -0,0 +1,86 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStateFilesInStateDir(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+
+	testContent := []byte("state outside the working directory")
+	testFile := createTestFile(t, int64(len(testContent)), testContent)
+	defer os.Remove(testFile)
+
+	key := calculateFileHash(testFile)
+	state := &UploadState{URL: "http://localhost:1080/files/abc", FileSize: int64(len(testContent))}
+	if err := saveState(key, state); err != nil {
+		t.Fatalf("Failed to save state: %v", err)
+	}
+	defer clearState(key)
+
+	if dir := filepath.Dir(getStateFile(key)); dir != stateDir() {
+		t.Errorf("Expected state in %s, got %s", stateDir(), dir)
+	}
+
+	// Resuming from another directory must find the same state
+	wd, _ := os.Getwd()
+	os.Chdir(t.TempDir())
+	defer os.Chdir(wd)
+
+	loaded, err := loadState(key)
+	if err != nil || loaded.URL != state.URL {
+		t.Errorf("Expected state to be found from another directory, got %v (%v)", loaded, err)
+	}
+}
+
+func TestLegacyStateFilesFound(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+
+	wd, _ := os.Getwd()
+	os.Chdir(t.TempDir())
+	defer os.Chdir(wd)
+
+	os.WriteFile(".tusc_state_legacy_1.json", []byte(`{"url":"http://localhost:1080/files/old"}`), 0644)
+
+	if files := findStateFilesByURL("http://localhost:1080/files/old"); len(files) != 1 {
+		t.Errorf("Expected the state file in the working directory to be found, got %v", files)
+	}
+}
+
+func TestMigrateLegacyStates(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+
+	testContent := []byte("upload started by an older version")
+	testFile := createTestFile(t, int64(len(testContent)), testContent)
+	defer os.Remove(testFile)
+
+	// Two runs of an older version left a state file each
+	key := calculateFileHash(testFile)
+	os.MkdirAll(stateDir(), 0700)
+	for pid, offset := range map[string]string{"100": "4", "200": "12"} {
+		data := `{"url":"http://localhost:1080/files/` + pid + `","offset":` + offset + `}`
+		os.WriteFile(filepath.Join(stateDir(), ".tusc_state_"+key+"_"+pid+".json"), []byte(data), 0644)
+	}
+
+	migrateLegacyStates(io.Discard, key, testFile)
+
+	state, err := loadState(key)
+	if err != nil {
+		t.Fatalf("Expected the migrated state, got: %v", err)
+	}
+	if state.URL != "http://localhost:1080/files/200" || state.Offset != 12 {
+		t.Errorf("Expected the state furthest along, got %s at %d", state.URL, state.Offset)
+	}
+	if files := legacyStateFiles(key, testFile); len(files) != 0 {
+		t.Errorf("Legacy state files should have been removed, found %v", files)
+	}
+
+	// Completion leaves nothing behind
+	clearState(key)
+	if files := findStateFiles(key, testFile); len(files) != 0 {
+		t.Errorf("State files should have been removed, found %v", files)
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// UploadInfo is everything a HEAD request reports about an upload
+type UploadInfo struct {
+	URL            string            `json:"url"`
+	Offset         int64             `json:"offset"`
+	Length         int64             `json:"length"` // -1 while the length is deferred
+	DeferredLength bool              `json:"deferred_length"`
+	Expires        *time.Time        `json:"expires,omitempty"`
+	Concat         string            `json:"concat,omitempty"` // "partial" or "final"
+	Parts          []string          `json:"parts,omitempty"`  // Partial uploads of a final upload
+	Metadata       map[string]string `json:"metadata,omitempty"`
+}
+
+// getUploadInfo sends a HEAD request for uploadURL and parses every upload
+// header of the response
+func getUploadInfo(client *http.Client, uploadURL string, headers map[string]string) (*UploadInfo, error) {
+	req, err := http.NewRequest("HEAD", uploadURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Tus-Resumable", "1.0.0")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, newHTTPError(PhaseHead, resp, nil)
+	}
+
+	info := &UploadInfo{URL: uploadURL, Length: -1}
+
+	offsetStr := resp.Header.Get("Upload-Offset")
+	if offsetStr == "" {
+		return nil, fmt.Errorf("no Upload-Offset header in response")
+	}
+	info.Offset, err = strconv.ParseInt(offsetStr, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Upload-Offset header: %s", offsetStr)
+	}
+
+	if lengthStr := resp.Header.Get("Upload-Length"); lengthStr != "" {
+		info.Length, err = strconv.ParseInt(lengthStr, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Upload-Length header: %s", lengthStr)
+		}
+	}
+	info.DeferredLength = resp.Header.Get("Upload-Defer-Length") == "1"
+
+	if expires := resp.Header.Get("Upload-Expires"); expires != "" {
+		if t, err := http.ParseTime(expires); err == nil {
+			info.Expires = &t
+		}
+	}
+
+	if concat := resp.Header.Get("Upload-Concat"); concat != "" {
+		kind, parts, _ := strings.Cut(concat, ";")
+		info.Concat = kind
+		info.Parts = strings.Fields(parts)
+	}
+
+	if metadata := resp.Header.Get("Upload-Metadata"); metadata != "" {
+		info.Metadata, err = decodeMetadata(metadata)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Upload-Metadata header: %v", err)
+		}
+	}
+
+	return info, nil
+}
+
+// decodeMetadata decodes an Upload-Metadata header. Keys may come without a value.
+func decodeMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	for _, item := range strings.Split(header, ",") {
+		fields := strings.Fields(item)
+		switch len(fields) {
+		case 0:
+			continue
+		case 1:
+			metadata[fields[0]] = ""
+		case 2:
+			value, err := base64.StdEncoding.DecodeString(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("bad value for %q: %v", fields[0], err)
+			}
+			metadata[fields[0]] = string(value)
+		default:
+			return nil, fmt.Errorf("bad metadata item %q", item)
+		}
+	}
+	return metadata, nil
+}
+
+// showUploadStatus prints the server side state of every upload of target,
+// an upload URL or the local file being uploaded
+func showUploadStatus(config Config, target string, asJSON bool) error {
+	urls, _, err := findUploadURLs(target)
+	if err != nil {
+		return err
+	}
+
+	client := authHTTPClient(signHTTPClient(&http.Client{Timeout: 30 * time.Second, Transport: defaultTransport(config)}, config.Signer), config.Auth)
+	var infos []*UploadInfo
+	for _, uploadURL := range urls {
+		info, err := getUploadInfo(client, uploadURL, config.Headers)
+		if err != nil {
+			return fmt.Errorf("failed to get status of %s: %v", uploadURL, err)
+		}
+		infos = append(infos, info)
+	}
+
+	if asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if len(infos) == 1 {
+			return encoder.Encode(infos[0])
+		}
+		return encoder.Encode(infos)
+	}
+
+	for _, info := range infos {
+		printUploadInfo(config.output(), info)
+	}
+	return nil
+}
+
+// printUploadInfo prints an upload's state to out in the style of the upload
+// output
+func printUploadInfo(out io.Writer, info *UploadInfo) {
+	fmt.Fprintf(out, "➤ %s\n", info.URL)
+
+	switch {
+	case info.Length >= 0:
+		percent := 100.0
+		if info.Length > 0 {
+			percent = float64(info.Offset) / float64(info.Length) * 100
+		}
+		fmt.Fprintf(out, "↳ offset: %s of %s (%.1f%%)\n", formatBytes(info.Offset), formatBytes(info.Length), percent)
+	case info.DeferredLength:
+		fmt.Fprintf(out, "↳ offset: %s, length deferred\n", formatBytes(info.Offset))
+	default:
+		fmt.Fprintf(out, "↳ offset: %s\n", formatBytes(info.Offset))
+	}
+
+	if info.Expires != nil {
+		fmt.Fprintf(out, "↳ expires: %s\n", info.Expires.Local().Format(time.RFC1123))
+	}
+
+	if info.Concat != "" {
+		fmt.Fprintf(out, "↳ concat: %s\n", info.Concat)
+		for _, part := range info.Parts {
+			fmt.Fprintf(out, "  ↳ %s\n", part)
+		}
+	}
+
+	keys := make([]string, 0, len(info.Metadata))
+	for key := range info.Metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(out, "↳ %s: %s\n", key, info.Metadata[key])
+	}
+}
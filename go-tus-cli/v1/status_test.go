@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestGetUploadInfo(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Tus-Resumable", "1.0.0")
+		w.Header().Set("Upload-Offset", "70")
+		w.Header().Set("Upload-Defer-Length", "1")
+		w.Header().Set("Upload-Expires", "Wed, 25 Jun 2031 16:00:00 GMT")
+		w.Header().Set("Upload-Concat", "final;/files/a /files/b")
+		w.Header().Set("Upload-Metadata", "name cmVwb3J0LnBkZg==,is_confidential")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	info, err := getUploadInfo(server.Client(), server.URL+"/files/final", nil)
+	if err != nil {
+		t.Fatalf("getUploadInfo failed: %v", err)
+	}
+
+	if info.Offset != 70 || info.Length != -1 || !info.DeferredLength {
+		t.Errorf("Unexpected offset/length: %+v", info)
+	}
+	if info.Expires == nil || !info.Expires.Equal(time.Date(2031, 6, 25, 16, 0, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected expiry: %v", info.Expires)
+	}
+	if info.Concat != "final" || len(info.Parts) != 2 || info.Parts[1] != "/files/b" {
+		t.Errorf("Unexpected concat parts: %s %v", info.Concat, info.Parts)
+	}
+	if info.Metadata["name"] != "report.pdf" {
+		t.Errorf("Unexpected metadata: %v", info.Metadata)
+	}
+	if _, ok := info.Metadata["is_confidential"]; !ok {
+		t.Errorf("Expected key without value in metadata: %v", info.Metadata)
+	}
+}
+
+func TestUploadStatusByFile(t *testing.T) {
+	mockServer := NewMockTUSServer()
+	defer mockServer.Close()
+
+	testContent := []byte("upload to inspect")
+	testFile := createTestFile(t, int64(len(testContent)), testContent)
+	defer os.Remove(testFile)
+	defer clearState(calculateFileHash(testFile))
+
+	client := mockServer.server.Client()
+	uploadURL, err := createUpload(client, mockServer.URL(), int64(len(testContent)), map[string]string{"name": "inspect.txt"}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create upload: %v", err)
+	}
+
+	state := &UploadState{URL: uploadURL, FileSize: int64(len(testContent)), Endpoint: mockServer.URL()}
+	if err := saveState(calculateFileHash(testFile), state); err != nil {
+		t.Fatalf("Failed to save state: %v", err)
+	}
+
+	urls, _, err := findUploadURLs(testFile)
+	if err != nil || len(urls) != 1 || urls[0] != uploadURL {
+		t.Fatalf("Expected upload URL from state, got %v (%v)", urls, err)
+	}
+
+	info, err := getUploadInfo(client, urls[0], nil)
+	if err != nil {
+		t.Fatalf("getUploadInfo failed: %v", err)
+	}
+	if info.Offset != 0 || info.Length != int64(len(testContent)) {
+		t.Errorf("Unexpected offset/length: %d/%d", info.Offset, info.Length)
+	}
+	if info.Metadata["name"] != "inspect.txt" {
+		t.Errorf("Unexpected metadata: %v", info.Metadata)
+	}
+}
+
+func TestUploadStatusWithoutState(t *testing.T) {
+	testContent := []byte("never uploaded")
+	testFile := createTestFile(t, int64(len(testContent)), testContent)
+	defer os.Remove(testFile)
+
+	if err := showUploadStatus(Config{}, testFile, false); err == nil {
+		t.Error("Expected an error for a file without upload state")
+	}
+}
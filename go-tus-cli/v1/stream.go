@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// deferredLength as upload size creates an upload with Upload-Defer-Length
+const deferredLength = -1
+
+// uploadStdin uploads standard input under config.Name
+func uploadStdin(config Config) error {
+	if info, err := os.Stdin.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		return fmt.Errorf("refusing to read upload data from a terminal, pipe it into tusc instead")
+	}
+
+	timeout := calculateTimeout(config.ChunkSize, config.ChunkSize)
+	if config.RateLimit != nil {
+		timeout += limitedTransferTime(config.RateLimit, config.ChunkSize)
+	}
+
+	client := authHTTPClient(signHTTPClient(limitHTTPClient(&http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			MaxIdleConns:        10,
+			MaxIdleConnsPerHost: 2,
+			IdleConnTimeout:     90 * time.Second,
+			TLSClientConfig:     config.TLS,
+		},
+	}, config.RateLimit), config.Signer), config.Auth)
+
+	_, err := uploadStream(client, config, os.Stdin)
+	return err
+}
+
+// uploadStream uploads everything read from src as one upload. The length is
+// deferred (creation-defer-length) and sent with the last chunk once src
+// reaches EOF, so src is read exactly once and never seeked.
+func uploadStream(client *http.Client, config Config, src io.Reader) (string, error) {
+	name := config.Name
+	if name == "" {
+		name = "stdin"
+	}
+
+	options, err := serverOptions(client, config.TusdEndpoint, config.Headers)
+	if err != nil {
+		return "", fmt.Errorf("failed to query server options: %v", err)
+	}
+	if !headerListContains(options.Get("Tus-Extension"), "creation-defer-length") {
+		return "", fmt.Errorf("server does not support the creation-defer-length extension")
+	}
+
+	if config.Checksum != "" {
+		wanted, err := parseChecksumAlgorithms(config.Checksum)
+		if err != nil {
+			return "", err
+		}
+		config.ChecksumAlgorithm = negotiateChecksum(options, wanted)
+	}
+
+	var uploadURL string
+	err = withRetry(config, func() (err error) {
+		uploadURL, err = createUpload(client, config.TusdEndpoint, deferredLength, config.uploadMetadata(name), config.Headers)
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to create upload: %v", err)
+	}
+	fmt.Fprintf(config.output(), "Created new upload: %s\n", uploadURL)
+	config.emit(Event{Event: "created", UploadURL: uploadURL, Metadata: config.uploadMetadata(name)})
+
+	reader := bufio.NewReader(src)
+	buffer := make([]byte, config.ChunkSize)
+	start := time.Now()
+	lastProgressTime := start
+	var offset, lastOffset int64
+
+	for {
+		n, err := io.ReadFull(reader, buffer)
+		final := errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+		if err != nil && !final {
+			return "", fmt.Errorf("failed to read input at offset %d: %v", offset, err)
+		}
+		if !final {
+			// A full chunk may still be the last one
+			_, err := reader.Peek(1)
+			final = errors.Is(err, io.EOF)
+		}
+
+		// The last chunk announces the upload length
+		headers := config.Headers
+		if final {
+			headers = make(map[string]string, len(config.Headers)+1)
+			for key, value := range config.Headers {
+				headers[key] = value
+			}
+			headers["Upload-Length"] = strconv.FormatInt(offset+int64(n), 10)
+		}
+
+		if err := sendChunk(client, config, uploadURL, buffer[:n], offset, headers); err != nil {
+			return "", err
+		}
+		offset += int64(n)
+
+		now := time.Now()
+		if now.Sub(lastProgressTime) >= time.Second {
+			speed := float64(offset-lastOffset) / now.Sub(lastProgressTime).Seconds()
+			if config.events != nil {
+				config.emit(Event{Event: "progress", UploadURL: uploadURL, Offset: offset, Rate: int64(speed)})
+			} else {
+				fmt.Fprintf(config.output(), "\rUploading: %s at %s/s", formatBytes(offset), formatBytes(int64(speed)))
+			}
+			lastProgressTime = now
+			lastOffset = offset
+		}
+
+		if final {
+			break
+		}
+	}
+
+	fmt.Fprintf(config.output(), "\n➤ %s uploaded (%s) 🐈\n", name, formatBytes(offset))
+	fmt.Fprintf(config.output(), "↳ %s\n", uploadURL)
+
+	config.emit(Event{
+		Event:      "completed",
+		UploadURL:  uploadURL,
+		Size:       offset,
+		DurationMs: time.Since(start).Milliseconds(),
+		Metadata:   config.uploadMetadata(name),
+	})
+	return uploadURL, nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"net/http"
+	"testing"
+)
+
+func TestUploadStream(t *testing.T) {
+	chunkSize := int64(1024)
+	sizes := []int64{0, 100, 2 * chunkSize, 3*chunkSize + 5}
+
+	for _, size := range sizes {
+		mockServer := NewMockTUSServer()
+
+		data := make([]byte, size)
+		rand.Read(data)
+
+		config := Config{
+			TusdEndpoint: mockServer.URL(),
+			ChunkSize:    chunkSize,
+			Headers:      make(map[string]string),
+			Name:         "backup.sql",
+			Checksum:     "auto",
+		}
+
+		uploadURL, err := uploadStream(&http.Client{}, config, bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("Stream upload of %d bytes failed: %v", size, err)
+		}
+		if uploadURL != mockServer.URL()+"/upload_1" {
+			t.Errorf("Unexpected upload URL %s", uploadURL)
+		}
+
+		expectedPatches := int((size + chunkSize - 1) / chunkSize)
+		if expectedPatches == 0 {
+			expectedPatches = 1
+		}
+		if mockServer.Patches != expectedPatches {
+			t.Errorf("Size %d: expected %d PATCH requests, got %d", size, expectedPatches, mockServer.Patches)
+		}
+
+		upload := mockServer.GetUpload("upload_1")
+		if upload.Size != size || upload.Offset != size {
+			t.Errorf("Size %d: server has size %d, offset %d", size, upload.Size, upload.Offset)
+		}
+		if !bytes.Equal(upload.Data, data) {
+			t.Errorf("Size %d: uploaded data doesn't match input", size)
+		}
+
+		mockServer.Close()
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// findStateFiles returns the state file of filePath's upload with key and
+// those older versions left, see migrateLegacyStates
+func findStateFiles(key, filePath string) []string {
+	files := legacyStateFiles(key, filePath)
+	if _, err := os.Stat(getStateFile(key)); err == nil {
+		files = append([]string{getStateFile(key)}, files...)
+	}
+	return files
+}
+
+// findStateFilesByURL returns the state files that reference uploadURL
+func findStateFilesByURL(uploadURL string) []string {
+	var files []string
+	for _, stateFile := range globStateFiles(".tusc_state_*.json") {
+		if state, err := readStateFile(stateFile); err == nil && state.URL == uploadURL {
+			files = append(files, stateFile)
+		}
+	}
+	return files
+}
+
+// findUploadURLs returns the upload URLs for target and the state files
+// referencing them. target is either an upload URL or the local file being
+// uploaded, whose URLs are read from its state files.
+func findUploadURLs(target string) ([]string, []string, error) {
+	if strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://") {
+		return []string{target}, findStateFilesByURL(target), nil
+	}
+
+	if _, err := os.Stat(target); err != nil {
+		return nil, nil, fmt.Errorf("file not found: %s", target)
+	}
+	return fileUploadURLs(calculateFileHash(target), target)
+}
+
+// fileUploadURLs returns the upload URLs of filePath's upload with key and
+// the state files they are read from
+func fileUploadURLs(key, filePath string) ([]string, []string, error) {
+	stateFiles := findStateFiles(key, filePath)
+	var urls []string
+	seen := make(map[string]bool)
+	for _, stateFile := range stateFiles {
+		if state, err := readStateFile(stateFile); err == nil && state.URL != "" && !seen[state.URL] {
+			seen[state.URL] = true
+			urls = append(urls, state.URL)
+		}
+	}
+
+	if len(urls) == 0 {
+		return nil, nil, fmt.Errorf("no upload state found for %s", filePath)
+	}
+	return urls, stateFiles, nil
+}
+
+// deleteUpload terminates an upload on the server and removes its state
+// files. target is either an upload URL or the local file being uploaded.
+func deleteUpload(config Config, target string) error {
+	var urls, stateFiles []string
+	var err error
+	if _, statErr := os.Stat(target); statErr == nil {
+		// Never delete an upload another process is still sending
+		key := calculateFileHash(target)
+		lock, lockErr := lockUpload(config, key, target)
+		if lockErr != nil {
+			return lockErr
+		}
+		defer lock.Unlock()
+
+		urls, stateFiles, err = fileUploadURLs(key, target)
+	} else {
+		urls, stateFiles, err = findUploadURLs(target)
+	}
+	if err != nil {
+		return err
+	}
+
+	client := authHTTPClient(signHTTPClient(&http.Client{Timeout: 30 * time.Second, Transport: defaultTransport(config)}, config.Signer), config.Auth)
+	for _, uploadURL := range urls {
+		if err := terminateUpload(client, config.output(), uploadURL, config.Headers); err != nil {
+			return fmt.Errorf("failed to delete upload %s: %v", uploadURL, err)
+		}
+	}
+
+	for _, stateFile := range stateFiles {
+		removeStateFile(stateFile)
+	}
+
+	return nil
+}
+
+// terminateUpload sends a termination extension DELETE for uploadURL and
+// reports it to out. An upload the server no longer knows is not an error.
+func terminateUpload(client *http.Client, out io.Writer, uploadURL string, headers map[string]string) error {
+	req, err := http.NewRequest("DELETE", uploadURL, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Tus-Resumable", "1.0.0")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNoContent:
+		fmt.Fprintf(out, "➤ Deleted upload\n↳ %s\n", uploadURL)
+	case http.StatusNotFound, http.StatusGone:
+		fmt.Fprintf(out, "➤ Upload already gone\n↳ %s\n", uploadURL)
+	default:
+		return newHTTPError(PhaseTerminate, resp, nil)
+	}
+
+	return nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestDeleteUploadByFile(t *testing.T) {
+	mockServer := NewMockTUSServer()
+	defer mockServer.Close()
+
+	testContent := []byte("upload that will be abandoned")
+	testFile := createTestFile(t, int64(len(testContent)), testContent)
+	defer os.Remove(testFile)
+
+	config := Config{
+		TusdEndpoint: mockServer.URL(),
+		ChunkSize:    1024,
+		Headers:      make(map[string]string),
+	}
+
+	client := mockServer.server.Client()
+	uploadURL, err := createUpload(client, mockServer.URL(), int64(len(testContent)), map[string]string{"name": "abandoned.txt"}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create upload: %v", err)
+	}
+
+	state := &UploadState{URL: uploadURL, FileSize: int64(len(testContent)), Endpoint: mockServer.URL()}
+	if err := saveState(calculateFileHash(testFile), state); err != nil {
+		t.Fatalf("Failed to save state: %v", err)
+	}
+
+	if err := deleteUpload(config, testFile); err != nil {
+		t.Fatalf("deleteUpload failed: %v", err)
+	}
+
+	if len(mockServer.GetUploads()) != 0 {
+		t.Error("Upload should have been deleted on the server")
+	}
+	if files := findStateFiles(calculateFileHash(testFile), testFile); len(files) != 0 {
+		t.Errorf("State files should have been removed, found %v", files)
+	}
+}
+
+func TestDeleteUploadByURL(t *testing.T) {
+	mockServer := NewMockTUSServer()
+	defer mockServer.Close()
+
+	testContent := []byte("upload deleted by URL")
+	testFile := createTestFile(t, int64(len(testContent)), testContent)
+	defer os.Remove(testFile)
+
+	client := mockServer.server.Client()
+	uploadURL, err := createUpload(client, mockServer.URL(), int64(len(testContent)), map[string]string{"name": "by-url.txt"}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create upload: %v", err)
+	}
+
+	state := &UploadState{URL: uploadURL, FileSize: int64(len(testContent)), Endpoint: mockServer.URL()}
+	if err := saveState(calculateFileHash(testFile), state); err != nil {
+		t.Fatalf("Failed to save state: %v", err)
+	}
+
+	config := Config{TusdEndpoint: mockServer.URL(), Headers: make(map[string]string)}
+	if err := deleteUpload(config, uploadURL); err != nil {
+		t.Fatalf("deleteUpload failed: %v", err)
+	}
+
+	if len(mockServer.GetUploads()) != 0 {
+		t.Error("Upload should have been deleted on the server")
+	}
+	if files := findStateFilesByURL(uploadURL); len(files) != 0 {
+		t.Errorf("State files should have been removed, found %v", files)
+	}
+
+	// The upload is gone now, deleting it again still succeeds
+	if err := deleteUpload(config, uploadURL); err != nil {
+		t.Errorf("Deleting a missing upload should succeed, got: %v", err)
+	}
+}
+
+func TestDeleteUploadWithoutState(t *testing.T) {
+	testContent := []byte("never uploaded")
+	testFile := createTestFile(t, int64(len(testContent)), testContent)
+	defer os.Remove(testFile)
+
+	config := Config{TusdEndpoint: "http://localhost:1/files", Headers: make(map[string]string)}
+	if err := deleteUpload(config, testFile); err == nil {
+		t.Error("Expected error for a file without upload state")
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"runtime"
+	"slices"
+	"strings"
+)
+
+const (
+	// VaultIterations is the PBKDF2 work factor for vault passphrases
+	VaultIterations = 600000
+
+	// MinVaultKeySize is the shortest vault key file accepted, in bytes
+	MinVaultKeySize = 32
+)
+
+// Key derivations of the header vault
+const (
+	vaultPassphrase = "pbkdf2-sha256"
+	vaultKeyFile    = "hkdf-sha256"
+)
+
+// sensitiveHeaders carry credentials. They, and the headers named with one of
+// sensitiveSuffixes, are never written to state files in plain text.
+var sensitiveHeaders = []string{"authorization", "proxy-authorization", "cookie", "signature"}
+
+// sensitiveSuffixes mark custom credential headers like X-Api-Key or
+// X-Goog-Auth-Token
+var sensitiveSuffixes = []string{"api-key", "apikey", "-token", "-secret", "-password", "-credential", "-credentials"}
+
+// isSensitiveHeader reports whether header name may carry credentials
+func isSensitiveHeader(name string) bool {
+	name = strings.ToLower(name)
+	if slices.Contains(sensitiveHeaders, name) {
+		return true
+	}
+	for _, suffix := range sensitiveSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitHeaders separates the headers that may be saved as they are from
+// those carrying credentials
+func splitHeaders(headers map[string]string) (public, sensitive map[string]string) {
+	public = make(map[string]string)
+	sensitive = make(map[string]string)
+	for name, value := range headers {
+		if isSensitiveHeader(name) {
+			sensitive[name] = value
+		} else {
+			public[name] = value
+		}
+	}
+	return public, sensitive
+}
+
+// scrubStateFile rewrites a state file of an earlier version that holds
+// credentials in plain text or that others can read, without them and with
+// mode 0600. state is the file as read, its credentials are dropped too.
+func scrubStateFile(stateFile string, state *UploadState) error {
+	info, err := os.Stat(stateFile)
+	if err != nil {
+		return err
+	}
+	public, sensitive := splitHeaders(state.Headers)
+	exposed := runtime.GOOS != "windows" && info.Mode().Perm()&0077 != 0
+	if len(sensitive) == 0 && !exposed {
+		return nil
+	}
+
+	state.Headers = public
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(stateFile, data, 0600)
+}
+
+// SealedHeaders are the sensitive headers of an upload encrypted with
+// AES-256-GCM, under a key derived from the vault passphrase or key file
+type SealedHeaders struct {
+	KDF        string `json:"kdf"`
+	Iterations int    `json:"iterations,omitempty"` // PBKDF2 only
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Data       []byte `json:"data"`
+}
+
+// HeaderVault seals sensitive headers into state files, so an upload can be
+// resumed with them later. The key is derived once per run.
+type HeaderVault struct {
+	kdf        string
+	secret     []byte
+	iterations int
+
+	salt []byte
+	key  []byte
+}
+
+// loadHeaderVault builds the vault of TUSC_VAULT_PASSPHRASE or
+// -vault-key-file, nil when neither is set
+func loadHeaderVault(passphrase, keyFile string) (*HeaderVault, error) {
+	switch {
+	case passphrase != "" && keyFile != "":
+		return nil, fmt.Errorf("TUSC_VAULT_PASSPHRASE and -vault-key-file can't be used together")
+	case passphrase != "":
+		return newHeaderVault(vaultPassphrase, []byte(passphrase), VaultIterations)
+	case keyFile != "":
+		key, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read vault key file: %v", err)
+		}
+		if len(key) < MinVaultKeySize {
+			return nil, fmt.Errorf("vault key file %s is shorter than %d bytes", keyFile, MinVaultKeySize)
+		}
+		return newHeaderVault(vaultKeyFile, key, 0)
+	}
+	return nil, nil
+}
+
+func newHeaderVault(kdf string, secret []byte, iterations int) (*HeaderVault, error) {
+	vault := &HeaderVault{kdf: kdf, secret: secret, iterations: iterations, salt: make([]byte, 16)}
+	if _, err := rand.Read(vault.salt); err != nil {
+		return nil, fmt.Errorf("failed to generate vault salt: %v", err)
+	}
+
+	key, err := vault.deriveKey(vault.salt, iterations)
+	if err != nil {
+		return nil, err
+	}
+	vault.key = key
+	return vault, nil
+}
+
+func (v *HeaderVault) deriveKey(salt []byte, iterations int) ([]byte, error) {
+	if v.kdf == vaultPassphrase {
+		return pbkdf2.Key(sha256.New, string(v.secret), salt, iterations, 32)
+	}
+	return hkdf.Key(sha256.New, v.secret, salt, "tusc header vault", 32)
+}
+
+// Seal encrypts the sensitive headers of headers. It returns nil for a nil
+// vault or when there are none.
+func (v *HeaderVault) Seal(headers map[string]string) (*SealedHeaders, error) {
+	_, sensitive := splitHeaders(headers)
+	if v == nil || len(sensitive) == 0 {
+		return nil, nil
+	}
+
+	plaintext, _ := json.Marshal(sensitive)
+	aead := newVaultCipher(v.key)
+	sealed := &SealedHeaders{
+		KDF:        v.kdf,
+		Iterations: v.iterations,
+		Salt:       v.salt,
+		Nonce:      make([]byte, aead.NonceSize()),
+	}
+	if _, err := rand.Read(sealed.Nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate vault nonce: %v", err)
+	}
+	sealed.Data = aead.Seal(nil, sealed.Nonce, plaintext, []byte(sealed.KDF))
+	return sealed, nil
+}
+
+// Open decrypts headers sealed by this run or an earlier one
+func (v *HeaderVault) Open(sealed *SealedHeaders) (map[string]string, error) {
+	if sealed.KDF != v.kdf {
+		return nil, fmt.Errorf("headers were sealed with a %s, not a %s", vaultSecretName(sealed.KDF), vaultSecretName(v.kdf))
+	}
+
+	key := v.key
+	if string(sealed.Salt) != string(v.salt) || sealed.Iterations != v.iterations {
+		var err error
+		if key, err = v.deriveKey(sealed.Salt, sealed.Iterations); err != nil {
+			return nil, err
+		}
+	}
+
+	aead := newVaultCipher(key)
+	if len(sealed.Nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("invalid nonce of sealed headers")
+	}
+	plaintext, err := aead.Open(nil, sealed.Nonce, sealed.Data, []byte(sealed.KDF))
+	if err != nil {
+		return nil, fmt.Errorf("wrong %s or corrupted state file", vaultSecretName(v.kdf))
+	}
+
+	var headers map[string]string
+	if err := json.Unmarshal(plaintext, &headers); err != nil {
+		return nil, fmt.Errorf("invalid sealed headers: %v", err)
+	}
+	return headers, nil
+}
+
+func newVaultCipher(key []byte) cipher.AEAD {
+	// Keys are always 32 bytes, AES-256 can't fail
+	block, _ := aes.NewCipher(key)
+	aead, _ := cipher.NewGCM(block)
+	return aead
+}
+
+func vaultSecretName(kdf string) string {
+	if kdf == vaultKeyFile {
+		return "vault key file"
+	}
+	return "vault passphrase"
+}
+
+// restoreHeaders adds the headers sealed in state that config doesn't set
+// itself, so a resumed upload keeps its credentials
+func (c *Config) restoreHeaders(state *UploadState) {
+	if state.Vault == nil {
+		return
+	}
+	if c.Vault == nil {
+		fmt.Fprintf(c.output(), "Warning: state file has sealed headers, set TUSC_VAULT_PASSPHRASE or -vault-key-file to use them\n")
+		return
+	}
+
+	headers, err := c.Vault.Open(state.Vault)
+	if err != nil {
+		fmt.Fprintf(c.output(), "Warning: failed to open sealed headers: %v\n", err)
+		return
+	}
+	if c.Headers == nil {
+		c.Headers = make(map[string]string)
+	}
+	set := make(map[string]bool)
+	for name := range c.Headers {
+		set[http.CanonicalHeaderKey(name)] = true
+	}
+	for name, value := range headers {
+		if !set[http.CanonicalHeaderKey(name)] {
+			c.Headers[name] = value
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestSaveStateLeavesOutCredentials(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+
+	testFile := createTestFile(t, 16, []byte("state of secrets"))
+	defer os.Remove(testFile)
+	defer clearState(calculateFileHash(testFile))
+
+	headers := map[string]string{
+		"Authorization": "Bearer s3cret",
+		"Cookie":        "session=s3cret",
+		"X-Api-Key":     "s3cret",
+		"X-Request-Id":  "42",
+	}
+	if err := saveState(calculateFileHash(testFile), &UploadState{URL: "http://localhost:1080/files/abc", FileSize: 16, Headers: headers}); err != nil {
+		t.Fatalf("Failed to save state: %v", err)
+	}
+	if len(headers) != 4 {
+		t.Errorf("Expected the caller's headers to be left alone, got %v", headers)
+	}
+
+	data, err := os.ReadFile(getStateFile(calculateFileHash(testFile)))
+	if err != nil {
+		t.Fatalf("Failed to read state file: %v", err)
+	}
+	if strings.Contains(string(data), "s3cret") || !strings.Contains(string(data), "X-Request-Id") {
+		t.Errorf("Expected only the non-sensitive headers in the state file, got %s", data)
+	}
+
+	if runtime.GOOS != "windows" {
+		info, _ := os.Stat(getStateFile(calculateFileHash(testFile)))
+		if mode := info.Mode().Perm(); mode != 0600 {
+			t.Errorf("Expected state file mode 0600, got %o", mode)
+		}
+	}
+}
+
+func TestIsSensitiveHeader(t *testing.T) {
+	for name, sensitive := range map[string]bool{
+		"Authorization":        true,
+		"Proxy-Authorization":  true,
+		"Cookie":               true,
+		"X-Api-Key":            true,
+		"Apikey":               true,
+		"X-Auth-Token":         true,
+		"X-Amz-Security-Token": true,
+		"X-Client-Secret":      true,
+		"Idempotency-Key":      false,
+		"X-Request-Key-Id":     false,
+		"X-Request-Id":         false,
+		"Upload-Metadata":      false,
+	} {
+		if got := isSensitiveHeader(name); got != sensitive {
+			t.Errorf("isSensitiveHeader(%q) = %v, expected %v", name, got, sensitive)
+		}
+	}
+}
+
+func TestLoadStateScrubsPlaintextCredentials(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+
+	// Earlier versions saved every header, readable by others
+	key := "0123456789abcdef"
+	stateFile := getStateFile(key)
+	os.MkdirAll(filepath.Dir(stateFile), 0700)
+	data := `{"version": 1, "url": "http://localhost:1080/files/abc", "offset": 512, "file_size": 1024,
+		"headers": {"Authorization": "Bearer s3cret", "X-Request-Id": "42"}}`
+	if err := os.WriteFile(stateFile, []byte(data), 0644); err != nil {
+		t.Fatalf("Failed to write state file: %v", err)
+	}
+
+	state, err := loadState(key)
+	if err != nil {
+		t.Fatalf("Failed to load state: %v", err)
+	}
+	if state.Offset != 512 || len(state.Headers) != 1 || state.Headers["X-Request-Id"] != "42" {
+		t.Errorf("Expected the state without its credentials, got %+v", state)
+	}
+
+	scrubbed, err := os.ReadFile(stateFile)
+	if err != nil {
+		t.Fatalf("Failed to read state file: %v", err)
+	}
+	if strings.Contains(string(scrubbed), "s3cret") || !strings.Contains(string(scrubbed), "X-Request-Id") {
+		t.Errorf("Expected the credentials to be removed from the state file, got %s", scrubbed)
+	}
+	if runtime.GOOS != "windows" {
+		info, _ := os.Stat(stateFile)
+		if mode := info.Mode().Perm(); mode != 0600 {
+			t.Errorf("Expected state file mode 0600, got %o", mode)
+		}
+	}
+}
+
+func TestHeaderVault(t *testing.T) {
+	headers := map[string]string{"Authorization": "Bearer s3cret", "X-Request-Id": "42"}
+
+	vault, err := newHeaderVault(vaultPassphrase, []byte("correct horse"), 1000)
+	if err != nil {
+		t.Fatalf("Failed to create vault: %v", err)
+	}
+	sealed, err := vault.Seal(headers)
+	if err != nil || sealed == nil || strings.Contains(string(sealed.Data), "s3cret") {
+		t.Fatalf("Expected the headers to be sealed, got %+v, %v", sealed, err)
+	}
+	if none, _ := (*HeaderVault)(nil).Seal(headers); none != nil {
+		t.Error("Expected nothing to be sealed without a vault")
+	}
+	if none, _ := vault.Seal(map[string]string{"X-Request-Id": "42"}); none != nil {
+		t.Error("Expected nothing to be sealed without sensitive headers")
+	}
+
+	// A later run derives its key with another salt
+	later, _ := newHeaderVault(vaultPassphrase, []byte("correct horse"), 1000)
+	opened, err := later.Open(sealed)
+	if err != nil {
+		t.Fatalf("Failed to open sealed headers: %v", err)
+	}
+	if len(opened) != 1 || opened["Authorization"] != "Bearer s3cret" {
+		t.Errorf("Expected only the sensitive headers, got %v", opened)
+	}
+
+	wrong, _ := newHeaderVault(vaultPassphrase, []byte("battery staple"), 1000)
+	if _, err := wrong.Open(sealed); err == nil {
+		t.Error("Expected a wrong passphrase to be rejected")
+	}
+	keyVault, _ := newHeaderVault(vaultKeyFile, []byte(strings.Repeat("k", 32)), 0)
+	if _, err := keyVault.Open(sealed); err == nil || !strings.Contains(err.Error(), "passphrase") {
+		t.Errorf("Expected a key file vault to be rejected, got %v", err)
+	}
+}
+
+func TestLoadHeaderVault(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "vault.key")
+	os.WriteFile(keyFile, []byte(strings.Repeat("k", 32)), 0600)
+	shortKeyFile := filepath.Join(dir, "short.key")
+	os.WriteFile(shortKeyFile, []byte("k"), 0600)
+
+	if vault, err := loadHeaderVault("", ""); vault != nil || err != nil {
+		t.Errorf("Expected no vault, got %v, %v", vault, err)
+	}
+	if vault, err := loadHeaderVault("", keyFile); vault == nil || err != nil {
+		t.Errorf("Expected a key file vault, got %v", err)
+	}
+	if _, err := loadHeaderVault("", shortKeyFile); err == nil {
+		t.Error("Expected a short key file to be rejected")
+	}
+	if _, err := loadHeaderVault("pass", keyFile); err == nil {
+		t.Error("Expected a passphrase and a key file to be rejected together")
+	}
+}
+
+func TestRestoreHeaders(t *testing.T) {
+	vault, _ := newHeaderVault(vaultKeyFile, []byte(strings.Repeat("k", 32)), 0)
+	sealed, _ := vault.Seal(map[string]string{"Authorization": "Bearer old", "Cookie": "a=b"})
+	state := &UploadState{Vault: sealed}
+
+	// Headers given in this run win over the sealed ones
+	config := &Config{Headers: map[string]string{"authorization": "Bearer new"}, Vault: vault}
+	config.restoreHeaders(state)
+	if len(config.Headers) != 2 || config.Headers["authorization"] != "Bearer new" || config.Headers["Cookie"] != "a=b" {
+		t.Errorf("Unexpected restored headers: %v", config.Headers)
+	}
+
+	config = &Config{}
+	config.restoreHeaders(state)
+	if len(config.Headers) != 0 {
+		t.Errorf("Expected no headers without the vault, got %v", config.Headers)
+	}
+}